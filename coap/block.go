@@ -0,0 +1,120 @@
+package coap
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// A Block describes the value of a Block1 or Block2 option
+// (RFC 7959, Section 2.2).
+type Block struct {
+	Num  uint32 // Relative number of the block
+	More bool   // More blocks follow
+	Size int    // Block size: a power of two between 16 and 1024
+}
+
+// ParseBlock decodes a Block1 or Block2 option value
+func ParseBlock(v coapmsg.OptionValue) Block {
+	n := v.AsUInt32()
+	return Block{
+		Num:  n >> 4,
+		More: n&0x08 != 0,
+		Size: 1 << (n&0x07 + 4),
+	}
+}
+
+// Value encodes the block as option value
+func (b Block) Value() (uint32, error) {
+	szx, err := blockSZX(b.Size)
+	if err != nil {
+		return 0, err
+	}
+	if b.Num >= 1<<20 {
+		return 0, fmt.Errorf("coap: block number %d out of range", b.Num)
+	}
+	v := b.Num<<4 | szx
+	if b.More {
+		v |= 0x08
+	}
+	return v, nil
+}
+
+// blockSZX returns the size exponent of a block size
+func blockSZX(size int) (uint32, error) {
+	for szx := uint32(0); szx < 7; szx++ {
+		if 1<<(szx+4) == size {
+			return szx, nil
+		}
+	}
+	return 0, fmt.Errorf("coap: invalid block size %d", size)
+}
+
+// newRequestTag returns a fresh Request-Tag (RFC 9175, Section 3)
+// to distinguish concurrent block-wise operations on the same resource.
+func newRequestTag() []byte {
+	tag := make([]byte, 4)
+	if _, err := rand.Read(tag); err != nil {
+		panic(err)
+	}
+	return tag
+}
+
+// cloneRequest returns a shallow copy of req with its own options and
+// the given payload as body.
+func cloneRequest(req *Request, body []byte) *Request {
+	r := new(Request)
+	*r = *req
+	r.Options = req.Options.Clone()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return r
+}
+
+// sendBlock1 uploads the body block-wise using the Block1 option.
+//
+// All blocks of the upload carry the same, freshly generated Request-Tag
+// so that the server can tell concurrent uploads to the same resource
+// apart. When the server asks for a smaller block size the remaining
+// blocks are sent with that size.
+func (c *Client) sendBlock1(req *Request, body []byte) (*Response, error) {
+	tmpl := cloneRequest(req, nil)
+	tmpl.Options.Set(coapmsg.RequestTag, newRequestTag())
+	size := c.Block1Size
+
+	for offset := 0; ; {
+		end := offset + size
+		if end > len(body) {
+			end = len(body)
+		}
+		block := Block{Num: uint32(offset / size), More: end < len(body), Size: size}
+		v, err := block.Value()
+		if err != nil {
+			return nil, err
+		}
+
+		blockReq := cloneRequest(tmpl, nil)
+		blockReq.Options.Set(coapmsg.Block1, v)
+		if offset == 0 {
+			blockReq.Options.Set(coapmsg.Size1, uint32(len(body)))
+		}
+
+		res, err := c.sendWithEcho(blockReq, body[offset:end])
+		if err != nil {
+			return nil, err
+		}
+		if !block.More || res.Code != coapmsg.Continue {
+			return res, nil
+		}
+		res.Body.Close()
+
+		if ack := res.Options.Get(coapmsg.Block1); ack.IsSet() {
+			if s := ParseBlock(ack).Size; s < size {
+				size = s
+			}
+		}
+		offset = end
+	}
+}
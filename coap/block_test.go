@@ -0,0 +1,107 @@
+package coap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+func TestBlockValue(t *testing.T) {
+	b := Block{Num: 5, More: true, Size: 64}
+	v, err := b.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 5<<4|0x08|2 {
+		t.Errorf("Unexpected block value %#x", v)
+	}
+	var opts coapmsg.CoapOptions = make(coapmsg.CoapOptions)
+	opts.Set(coapmsg.Block1, v)
+	if got := ParseBlock(opts.Get(coapmsg.Block1)); got != b {
+		t.Errorf("Expected %+v but got %+v", b, got)
+	}
+
+	if _, err := (Block{Size: 2048}).Value(); err == nil {
+		t.Error("Expected error for invalid block size")
+	}
+}
+
+// block1Server reassembles Block1 uploads per Request-Tag
+type block1Server struct {
+	maxSize  int
+	uploads  map[string][]byte
+	complete map[string]string
+}
+
+func (s *block1Server) ServeCOAP(w ResponseWriter, r *Request) {
+	tag := string(r.Options.Get(coapmsg.RequestTag).AsBytes())
+	block := ParseBlock(r.Options.Get(coapmsg.Block1))
+	body, _ := ioutil.ReadAll(r.Body)
+	if int(block.Num)*block.Size != len(s.uploads[tag]) {
+		w.WriteCode(coapmsg.RequestEntityIncomplete)
+		return
+	}
+	s.uploads[tag] = append(s.uploads[tag], body...)
+
+	if block.Size > s.maxSize {
+		block.Size = s.maxSize
+	}
+	v, _ := block.Value()
+	w.Options().Set(coapmsg.Block1, v)
+	if block.More {
+		w.WriteCode(coapmsg.Continue)
+		return
+	}
+	s.complete[tag] = string(s.uploads[tag])
+	w.WriteCode(coapmsg.Changed)
+}
+
+func TestClientBlock1Upload(t *testing.T) {
+	srv := &block1Server{maxSize: 32, uploads: map[string][]byte{}, complete: map[string]string{}}
+	tr := &handlerTransport{handler: srv}
+	client := &Client{Transport: tr, Block1Size: 64}
+
+	bodies := []string{strings.Repeat("a", 150), strings.Repeat("b", 100)}
+	for _, body := range bodies {
+		res, err := client.Post("coap+uart://any/fw", coapmsg.AppOctets, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Code != coapmsg.Changed {
+			t.Fatal("Expected 2.04 Changed but got", res.Status)
+		}
+	}
+
+	if len(srv.complete) != 2 {
+		t.Fatal("Expected two uploads with distinct Request-Tags, got", len(srv.complete))
+	}
+	for _, got := range srv.complete {
+		if got != bodies[0] && got != bodies[1] {
+			t.Errorf("Unexpected upload %q", got)
+		}
+	}
+	// 64 bytes first, then the server reduced the size to 32:
+	// 150 bytes = 64 + 3*32 - 10 -> 4 blocks, 100 bytes = 64 + 32 + 4 -> 3 blocks
+	if len(tr.reqs) != 7 {
+		t.Error("Expected 7 block requests but got", len(tr.reqs))
+	}
+	if tr.reqs[0].Options.Get(coapmsg.Size1).IsNotSet() {
+		t.Error("Expected Size1 option on first block")
+	}
+}
+
+func TestClientSmallBodyNotBlockwise(t *testing.T) {
+	tr := &handlerTransport{handler: HandlerFunc(func(w ResponseWriter, r *Request) {})}
+	client := &Client{Transport: tr, Block1Size: 64}
+	_, err := client.Post("coap+uart://any/fw", coapmsg.AppOctets, bytes.NewBufferString("small"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := tr.reqs[0].Options
+	if opts.Get(coapmsg.Block1).IsSet() || opts.Get(coapmsg.RequestTag).IsSet() {
+		t.Error("Small bodies must be sent in a single message")
+	}
+}
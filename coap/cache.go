@@ -0,0 +1,313 @@
+package coap
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// DefaultMaxAge is the freshness of a response without Max-Age option
+// (RFC 7252, Section 5.10.5)
+const DefaultMaxAge = 60 * time.Second
+
+// DefaultMaxCacheEntries is the number of responses a CachingTransport
+// keeps when MaxEntries is 0
+const DefaultMaxCacheEntries = 1024
+
+// CachingTransport is a RoundTripper that caches 2.05 Content responses
+// to GET requests (RFC 7252, Section 5.6).
+//
+// Cached responses are served while they are fresh according to their
+// Max-Age option. Stale responses with an ETag are revalidated: the
+// request is sent with the ETag and a 2.03 Valid response renews the
+// cached entry. Concurrent requests for the same resource result in a
+// single request to the underlying Transport.
+//
+// Successful unsafe requests (POST, PUT, DELETE) invalidate all entries
+// of the requested URL. Observe requests and requests that carry their
+// own ETag are passed through uncached.
+//
+// Stale responses without ETag are removed when they are looked up. When
+// the cache is full, stale responses and then the responses closest to
+// expiry are evicted.
+type CachingTransport struct {
+	// Transport is used to send requests, if nil DefaultTransport is used
+	Transport RoundTripper
+
+	// MaxEntries limits the number of cached responses, if 0
+	// DefaultMaxCacheEntries is used
+	MaxEntries int
+
+	mu       sync.Mutex
+	entries  map[string]*cacheEntry
+	inflight map[string]*cacheCall
+	now      func() time.Time
+}
+
+type cacheEntry struct {
+	uri     string
+	code    coapmsg.COAPCode
+	options coapmsg.CoapOptions
+	payload []byte
+	expires time.Time
+}
+
+// cacheCall is a request in flight that concurrent requests wait for
+type cacheCall struct {
+	done chan struct{}
+}
+
+// NewCachingTransport returns a CachingTransport sending requests with rt
+func NewCachingTransport(rt RoundTripper) *CachingTransport {
+	return &CachingTransport{
+		Transport: rt,
+	}
+}
+
+func (t *CachingTransport) transport() RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return DefaultTransport
+}
+
+func (t *CachingTransport) clock() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+// Flush removes all entries from the cache
+func (t *CachingTransport) Flush() {
+	t.mu.Lock()
+	t.entries = nil
+	t.mu.Unlock()
+}
+
+func (t *CachingTransport) RoundTrip(req *Request) (*Response, error) {
+	if req.Method != "GET" {
+		return t.roundTripUnsafe(req)
+	}
+	if req.Options.Get(coapmsg.Observe).IsSet() || req.Options.Get(coapmsg.ETag).IsSet() {
+		return t.transport().RoundTrip(req)
+	}
+
+	key := cacheKey(req)
+	for {
+		t.mu.Lock()
+		entry := t.entries[key]
+		if entry != nil && t.clock().Before(entry.expires) {
+			t.mu.Unlock()
+			req.closeBody()
+			return entry.response(req, t.clock()), nil
+		}
+		if entry != nil && entry.options.Get(coapmsg.ETag).IsNotSet() {
+			// Stale entries can only be revalidated with an ETag
+			delete(t.entries, key)
+			entry = nil
+		}
+		call := t.inflight[key]
+		if call == nil {
+			call = &cacheCall{done: make(chan struct{})}
+			if t.inflight == nil {
+				t.inflight = make(map[string]*cacheCall)
+			}
+			t.inflight[key] = call
+			t.mu.Unlock()
+
+			res, err := t.fetch(req, key, entry)
+
+			t.mu.Lock()
+			delete(t.inflight, key)
+			t.mu.Unlock()
+			close(call.done)
+			return res, err
+		}
+		t.mu.Unlock()
+
+		select {
+		case <-call.done:
+		case <-req.Context().Done():
+			req.closeBody()
+			return nil, req.Context().Err()
+		}
+		// The other request may not have produced a cacheable response.
+		// In that case we become the one sending the request.
+	}
+}
+
+// fetch sends the request, revalidating a stale entry if it has an ETag
+func (t *CachingTransport) fetch(req *Request, key string, stale *cacheEntry) (*Response, error) {
+	etag := coapmsg.NilOption
+	if stale != nil {
+		etag = stale.options.Get(coapmsg.ETag)
+	}
+	if etag.IsSet() {
+		r := new(Request)
+		*r = *req
+		r.Options = req.Options.Clone()
+		r.Options.Set(coapmsg.ETag, etag.AsBytes())
+		req = r
+	}
+
+	res, err := t.transport().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	now := t.clock()
+	switch {
+	case res.Code == coapmsg.Valid && etag.IsSet():
+		res.Body.Close()
+		// A 2.03 response updates the options of the stored response
+		entry := *stale
+		entry.options = stale.options.Clone()
+		for id, values := range res.Options {
+			entry.options[id] = values
+		}
+		entry.expires = now.Add(maxAge(res.Options))
+		t.store(key, &entry)
+		return entry.response(req, now), nil
+
+	case res.Code == coapmsg.Content:
+		payload, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		entry := &cacheEntry{
+			uri:     cacheURI(req),
+			code:    res.Code,
+			options: res.Options.Clone(),
+			payload: payload,
+			expires: now.Add(maxAge(res.Options)),
+		}
+		t.store(key, entry)
+		res.Body = ioutil.NopCloser(bytes.NewReader(payload))
+		return res, nil
+
+	default:
+		t.mu.Lock()
+		delete(t.entries, key)
+		t.mu.Unlock()
+		return res, nil
+	}
+}
+
+func (t *CachingTransport) store(key string, entry *cacheEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.entries == nil {
+		t.entries = make(map[string]*cacheEntry)
+	}
+	if _, ok := t.entries[key]; !ok && len(t.entries) >= t.maxEntries() {
+		t.evict()
+	}
+	t.entries[key] = entry
+}
+
+func (t *CachingTransport) maxEntries() int {
+	if t.MaxEntries > 0 {
+		return t.MaxEntries
+	}
+	return DefaultMaxCacheEntries
+}
+
+// evict makes room for an entry, must be called with t.mu held.
+// Stale entries are removed first, otherwise the entry closest to expiry.
+func (t *CachingTransport) evict() {
+	now := t.clock()
+	var oldest string
+	for key, entry := range t.entries {
+		if !now.Before(entry.expires) {
+			delete(t.entries, key)
+			continue
+		}
+		if oldest == "" || entry.expires.Before(t.entries[oldest].expires) {
+			oldest = key
+		}
+	}
+	if len(t.entries) >= t.maxEntries() {
+		delete(t.entries, oldest)
+	}
+}
+
+// roundTripUnsafe forwards the request and invalidates all entries of
+// the URL when it succeeded (RFC 7252, Section 5.9.1)
+func (t *CachingTransport) roundTripUnsafe(req *Request) (*Response, error) {
+	uri := cacheURI(req)
+	res, err := t.transport().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.Code.IsSuccess() {
+		t.mu.Lock()
+		for key, entry := range t.entries {
+			if entry.uri == uri {
+				delete(t.entries, key)
+			}
+		}
+		t.mu.Unlock()
+	}
+	return res, nil
+}
+
+// response builds a response from the entry.
+// The Max-Age option is set to the remaining freshness.
+func (e *cacheEntry) response(req *Request, now time.Time) *Response {
+	opts := e.options.Clone()
+	if remaining := e.expires.Sub(now); remaining > 0 {
+		opts.Set(coapmsg.MaxAge, uint32(remaining/time.Second))
+	}
+	return &Response{
+		StatusCode: e.code.Number(),
+		Code:       e.code,
+		Status:     e.code.Dotted() + " " + e.code.String(),
+		Body:       ioutil.NopCloser(bytes.NewReader(e.payload)),
+		Options:    opts,
+		Request:    req,
+	}
+}
+
+// maxAge returns the freshness lifetime of a response
+func maxAge(opts coapmsg.CoapOptions) time.Duration {
+	v := opts.Get(coapmsg.MaxAge)
+	if v.IsNotSet() {
+		return DefaultMaxAge
+	}
+	return time.Duration(v.AsUInt32()) * time.Second
+}
+
+// cacheURI identifies the resource of a request
+func cacheURI(req *Request) string {
+	return req.URL.Scheme + "://" + req.URL.Host + req.URL.EscapedPath() + "?" + req.URL.RawQuery
+}
+
+// cacheKey is built from the method, the URL and all request options
+// that are part of the cache key (RFC 7252, Section 5.4.2)
+func cacheKey(req *Request) string {
+	ids := make([]int, 0, len(req.Options))
+	for id := range req.Options {
+		if id.NoCacheKey() {
+			continue
+		}
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+
+	key := []string{req.Method, cacheURI(req)}
+	for _, id := range ids {
+		for _, v := range req.Options[coapmsg.OptionId(id)] {
+			key = append(key, strconv.Itoa(id)+"="+hex.EncodeToString(v.AsBytes()))
+		}
+	}
+	return strings.Join(key, " ")
+}
@@ -0,0 +1,232 @@
+package coap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// countingTransport answers requests like a sleepy node with a resource
+// that has an ETag and a short Max-Age
+type countingTransport struct {
+	requests int32
+	valid    int32
+	delay    time.Duration
+	etag     []byte
+	maxAge   uint32
+	err      error // returned for all requests if set
+}
+
+func (t *countingTransport) RoundTrip(req *Request) (*Response, error) {
+	req.closeBody()
+	atomic.AddInt32(&t.requests, 1)
+	time.Sleep(t.delay)
+	if t.err != nil {
+		return nil, t.err
+	}
+
+	msg := coapmsg.NewMessage()
+	msg.Code = coapmsg.Content
+	msg.Options().Set(coapmsg.MaxAge, t.maxAge)
+	if t.etag != nil {
+		msg.Options().Set(coapmsg.ETag, t.etag)
+		if bytes.Equal(req.Options.Get(coapmsg.ETag).AsBytes(), t.etag) {
+			atomic.AddInt32(&t.valid, 1)
+			msg.Code = coapmsg.Valid
+			return buildResponse(req, &msg), nil
+		}
+	}
+	if req.Method != "GET" {
+		msg.Code = coapmsg.Changed
+	}
+	msg.Payload = []byte("22.5 C")
+	return buildResponse(req, &msg), nil
+}
+
+func cacheGet(t *testing.T, rt RoundTripper, url string) *Response {
+	req, err := NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.Code != coapmsg.Content || string(body) != "22.5 C" {
+		t.Errorf("Unexpected response %s %q", res.Status, body)
+	}
+	return res
+}
+
+func TestCachingTransportMaxAge(t *testing.T) {
+	backend := &countingTransport{maxAge: 10}
+	now := time.Unix(1000, 0)
+	cache := NewCachingTransport(backend)
+	cache.now = func() time.Time { return now }
+
+	cacheGet(t, cache, "coap+uart://any/temp")
+	now = now.Add(4 * time.Second)
+	res := cacheGet(t, cache, "coap+uart://any/temp")
+	if backend.requests != 1 {
+		t.Error("Expected fresh response to be served from cache, requests:", backend.requests)
+	}
+	if age := res.Options.Get(coapmsg.MaxAge).AsUInt32(); age != 6 {
+		t.Error("Expected remaining Max-Age 6 but got", age)
+	}
+
+	cacheGet(t, cache, "coap+uart://any/temp?unit=F")
+	if backend.requests != 2 {
+		t.Error("Expected different query to be a different cache entry")
+	}
+
+	now = now.Add(7 * time.Second)
+	cacheGet(t, cache, "coap+uart://any/temp")
+	if backend.requests != 3 {
+		t.Error("Expected stale response to be fetched again")
+	}
+}
+
+func TestCachingTransportETagRevalidation(t *testing.T) {
+	backend := &countingTransport{maxAge: 1, etag: []byte{0xab}}
+	now := time.Unix(1000, 0)
+	cache := NewCachingTransport(backend)
+	cache.now = func() time.Time { return now }
+
+	cacheGet(t, cache, "coap+uart://any/temp")
+	now = now.Add(2 * time.Second)
+	res := cacheGet(t, cache, "coap+uart://any/temp")
+	if backend.requests != 2 || backend.valid != 1 {
+		t.Errorf("Expected revalidation with 2.03, requests: %d, valid: %d", backend.requests, backend.valid)
+	}
+	if !bytes.Equal(res.Options.Get(coapmsg.ETag).AsBytes(), backend.etag) {
+		t.Error("Expected ETag in revalidated response")
+	}
+
+	cacheGet(t, cache, "coap+uart://any/temp")
+	if backend.requests != 2 {
+		t.Error("Expected revalidated response to be fresh again")
+	}
+}
+
+func TestCachingTransportCacheKey(t *testing.T) {
+	backend := &countingTransport{maxAge: 60}
+	cache := NewCachingTransport(backend)
+
+	req, _ := NewRequest("GET", "coap+uart://any/temp", nil)
+	req.Options.Set(coapmsg.Size2, 0) // NoCacheKey
+	if _, err := cache.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	cacheGet(t, cache, "coap+uart://any/temp")
+	if backend.requests != 1 {
+		t.Error("NoCacheKey options must not be part of the cache key")
+	}
+
+	req, _ = NewRequest("GET", "coap+uart://any/temp", nil)
+	req.Options.Set(coapmsg.Accept, coapmsg.AppJSON)
+	if _, err := cache.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if backend.requests != 2 {
+		t.Error("Accept option must be part of the cache key")
+	}
+
+	req, _ = NewRequest("PUT", "coap+uart://any/temp", nil)
+	if _, err := cache.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	cacheGet(t, cache, "coap+uart://any/temp")
+	if backend.requests != 4 {
+		t.Error("Expected PUT to invalidate the cached response, requests:", backend.requests)
+	}
+}
+
+func TestCachingTransportStaleEntries(t *testing.T) {
+	backend := &countingTransport{maxAge: 1}
+	now := time.Unix(1000, 0)
+	cache := NewCachingTransport(backend)
+	cache.now = func() time.Time { return now }
+
+	cacheGet(t, cache, "coap+uart://any/temp")
+	now = now.Add(2 * time.Second)
+	backend.err = ErrTimeout
+	req, _ := NewRequest("GET", "coap+uart://any/temp", nil)
+	if _, err := cache.RoundTrip(req); err != ErrTimeout {
+		t.Fatal("Expected ErrTimeout but got", err)
+	}
+	if len(cache.entries) != 0 {
+		t.Error("Expected stale entry without ETag to be removed on lookup")
+	}
+}
+
+func TestCachingTransportMaxEntries(t *testing.T) {
+	backend := &countingTransport{maxAge: 60}
+	now := time.Unix(1000, 0)
+	cache := NewCachingTransport(backend)
+	cache.now = func() time.Time { return now }
+	cache.MaxEntries = 2
+
+	cacheGet(t, cache, "coap+uart://any/a")
+	now = now.Add(time.Second)
+	cacheGet(t, cache, "coap+uart://any/b")
+	cacheGet(t, cache, "coap+uart://any/c")
+	if len(cache.entries) != 2 {
+		t.Fatal("Expected 2 cached responses but got", len(cache.entries))
+	}
+	cacheGet(t, cache, "coap+uart://any/b")
+	cacheGet(t, cache, "coap+uart://any/c")
+	if backend.requests != 3 {
+		t.Error("Expected newer responses to stay cached, requests:", backend.requests)
+	}
+	cacheGet(t, cache, "coap+uart://any/a")
+	if backend.requests != 4 {
+		t.Error("Expected response closest to expiry to be evicted, requests:", backend.requests)
+	}
+
+	// Stale responses are evicted before fresh ones
+	backend.maxAge = 1
+	now = now.Add(time.Second)
+	cacheGet(t, cache, "coap+uart://any/d")
+	now = now.Add(2 * time.Second)
+	backend.maxAge = 60
+	cacheGet(t, cache, "coap+uart://any/e")
+	if _, ok := cache.entries[cacheKey(mustRequest(t, "coap+uart://any/d"))]; ok {
+		t.Error("Expected stale response to be evicted")
+	}
+	if len(cache.entries) != 2 {
+		t.Error("Expected 2 cached responses but got", len(cache.entries))
+	}
+}
+
+func mustRequest(t *testing.T, url string) *Request {
+	req, err := NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestCachingTransportConcurrentRequests(t *testing.T) {
+	backend := &countingTransport{maxAge: 60, delay: 50 * time.Millisecond}
+	cache := NewCachingTransport(backend)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cacheGet(t, cache, "coap+uart://any/temp")
+		}()
+	}
+	wg.Wait()
+
+	if backend.requests != 1 {
+		t.Error("Expected concurrent requests to be sent once but got", backend.requests)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/url"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -81,8 +83,30 @@ type Client struct {
 	// The default client has a value of 1 as proposed by the RFC.
 	// For an UART connection only 1 parallel request is supported.
 	MaxParallelRequests int32
-	runningRequests     int32
-	mu                  sync.Mutex
+
+	// Block1Size enables block-wise uploads (RFC 7959). Request
+	// bodies larger than Block1Size are sent in blocks of that size,
+	// which must be a power of two between 16 and 1024.
+	// 0 = bodies are always sent in a single message.
+	Block1Size int
+
+	// Proxy specifies a function to return a forward proxy for a
+	// given Request. If the function returns a non-nil URL, the
+	// request is sent to the proxy with the original URL in the
+	// Proxy-Uri option (RFC 7252, Section 5.7.2).
+	//
+	// If Proxy is nil or returns a nil *URL, no proxy is used.
+	Proxy func(*Request) (*url.URL, error)
+
+	runningRequests int32
+	mu              sync.Mutex
+	echoes          map[string]echoValue // by endpoint, guarded by mu
+}
+
+// echoValue is an Echo option value received from an endpoint
+type echoValue struct {
+	value    []byte
+	received time.Time
 }
 
 const NSTART = 5                                    // Default in CoAP Spec is 1. But we do support more.
@@ -116,19 +140,19 @@ func CancelObserve(res *Response) (*Response, error) {
 	return DefaultClient.CancelObserve(res)
 }
 
-func Post(url string, bodyType uint16, body io.Reader) (*Response, error) {
+func Post(url string, bodyType coapmsg.MediaType, body io.Reader) (*Response, error) {
 	return DefaultClient.Post(url, bodyType, body)
 }
 
 func (c *Client) Do(req *Request) (res *Response, err error) {
 	c.mu.Lock()
-	if c.runningRequests >= c.MaxParallelRequests && c.MaxParallelRequests != 0 {
+	if atomic.LoadInt32(&c.runningRequests) >= c.MaxParallelRequests && c.MaxParallelRequests != 0 {
 		c.mu.Unlock()
 		return nil, errors.New(fmt.Sprint("MaxParallelRequests exhausted: ", c.MaxParallelRequests))
 	}
 	atomic.AddInt32(&c.runningRequests, 1)
 	c.mu.Unlock()
-	res, err = c.send(req)
+	res, err = c.do(req)
 
 	atomic.AddInt32(&c.runningRequests, -1)
 
@@ -193,7 +217,7 @@ func (c *Client) CancelObserve(response *Response) (*Response, error) {
 // request.
 //
 // To set custom headers, use NewRequest and Client.Do.
-func (c *Client) Post(url string, bodyType uint16, body io.Reader) (*Response, error) {
+func (c *Client) Post(url string, bodyType coapmsg.MediaType, body io.Reader) (*Response, error) {
 	req, err := NewRequest("POST", url, body)
 	if err != nil {
 		return nil, err
@@ -205,6 +229,117 @@ func (c *Client) Post(url string, bodyType uint16, body io.Reader) (*Response, e
 	return c.Do(req)
 }
 
+// do sends the request, block-wise if the body exceeds Block1Size
+func (c *Client) do(req *Request) (*Response, error) {
+	if c.Proxy != nil {
+		proxyURL, err := c.Proxy(req)
+		if err != nil {
+			req.closeBody()
+			return nil, err
+		}
+		if proxyURL != nil {
+			req = proxyRequest(req, proxyURL)
+		}
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.closeBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Block1Size > 0 && len(body) > c.Block1Size {
+		return c.sendBlock1(req, body)
+	}
+	return c.sendWithEcho(req, body)
+}
+
+// proxyRequest returns a shallow copy of req that is sent to the proxy
+// and carries the original request URL in the Proxy-Uri option.
+//
+// The Response.Request of proxied requests is the rewritten request.
+func proxyRequest(req *Request, proxyURL *url.URL) *Request {
+	r := new(Request)
+	*r = *req
+	r.Options = req.Options.Clone()
+	// The Proxy-Uri option must not be combined with Uri-* options
+	for _, id := range []coapmsg.OptionId{coapmsg.URIHost, coapmsg.URIPort, coapmsg.URIPath, coapmsg.URIQuery, coapmsg.ProxyScheme} {
+		r.Options.Del(id)
+	}
+	r.Options.Set(coapmsg.ProxyURI, req.URL.String())
+	r.URL = &url.URL{Scheme: proxyURL.Scheme, Host: proxyURL.Host}
+	return r
+}
+
+// ProxyURL returns a proxy function (for use in a Client)
+// that always returns the same URL.
+func ProxyURL(fixedURL *url.URL) func(*Request) (*url.URL, error) {
+	return func(*Request) (*url.URL, error) {
+		return fixedURL, nil
+	}
+}
+
+// sendWithEcho sends the request and repeats it once when the server
+// demands a freshness proof with a 4.01 response carrying an Echo
+// option (RFC 9175, Section 2.4). The repeated request contains the
+// Echo value received from the server.
+//
+// The received Echo value is kept per endpoint for DefaultEchoFreshness
+// and sent with further unsafe requests, so they need no extra round trip.
+func (c *Client) sendWithEcho(req *Request, body []byte) (*Response, error) {
+	first := cloneRequest(req, body)
+	if code, ok := coapmsg.MethodCode(req.Method); !(ok && code.IsSafe()) && first.Options.Get(coapmsg.Echo).IsNotSet() {
+		if echo := c.cachedEcho(req.URL); echo != nil {
+			first.Options.Set(coapmsg.Echo, echo)
+		}
+	}
+	res, err := c.send(first)
+	if err != nil {
+		return nil, err
+	}
+	echo := res.Options.Get(coapmsg.Echo)
+	if res.Code != coapmsg.Unauthorized || echo.IsNotSet() {
+		return res, nil
+	}
+	res.Body.Close()
+	c.storeEcho(req.URL, echo.AsBytes())
+
+	log.WithField("URL", req.URL.String()).Debug("Repeat request with Echo option")
+	retry := cloneRequest(req, body)
+	retry.Options.Set(coapmsg.Echo, echo.AsBytes())
+	return c.send(retry)
+}
+
+// cachedEcho returns the Echo value received from the endpoint of u
+// or nil if there is none within DefaultEchoFreshness
+func (c *Client) cachedEcho(u *url.URL) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := u.Scheme + "://" + u.Host
+	e, ok := c.echoes[key]
+	if !ok {
+		return nil
+	}
+	if time.Since(e.received) > DefaultEchoFreshness {
+		delete(c.echoes, key)
+		return nil
+	}
+	return e.value
+}
+
+func (c *Client) storeEcho(u *url.URL, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.echoes == nil {
+		c.echoes = make(map[string]echoValue)
+	}
+	c.echoes[u.Scheme+"://"+u.Host] = echoValue{value: value, received: time.Now()}
+}
+
 func (c *Client) send(req *Request) (*Response, error) {
 
 	resp, err := send(req, c.transport(), c.deadline())
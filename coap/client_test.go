@@ -2,7 +2,10 @@ package coap
 
 import (
 	"errors"
+	"net/url"
 	"testing"
+
+	"github.com/trusch/coap-go/coapmsg"
 )
 
 type recordingTransport struct {
@@ -34,3 +37,39 @@ func TestGetRequestFormat(t *testing.T) {
 		t.Errorf("expected non-nil request Options")
 	}
 }
+
+func TestClientProxy(t *testing.T) {
+	tr := &handlerTransport{handler: HandlerFunc(func(w ResponseWriter, r *Request) {})}
+	proxyURL, _ := url.Parse("coap+uart://gateway")
+	client := &Client{Transport: tr, Proxy: ProxyURL(proxyURL)}
+
+	req, _ := NewRequest("GET", "coap+uart://bus1/temp?unit=C", nil)
+	req.Options.Set(coapmsg.URIHost, "bus1")
+	req.Options.Set(coapmsg.Accept, coapmsg.AppJSON)
+	if _, err := client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := tr.reqs[0]
+	if sent.URL.String() != "coap+uart://gateway" {
+		t.Error("Expected request to be sent to the proxy but got", sent.URL)
+	}
+	if uri := sent.Options.Get(coapmsg.ProxyURI).AsString(); uri != "coap+uart://bus1/temp?unit=C" {
+		t.Error("Unexpected Proxy-Uri", uri)
+	}
+	if sent.Options.Get(coapmsg.URIHost).IsSet() {
+		t.Error("Uri-Host must not be combined with Proxy-Uri")
+	}
+	if sent.Options.Get(coapmsg.Accept).IsNotSet() {
+		t.Error("Expected other options to be kept")
+	}
+	if req.Options.Get(coapmsg.ProxyURI).IsSet() || req.URL.Host != "bus1" {
+		t.Error("The original request must not be modified")
+	}
+
+	client.Proxy = func(*Request) (*url.URL, error) { return nil, nil }
+	client.Get("coap+uart://bus1/temp")
+	if sent := tr.reqs[1]; sent.URL.Host != "bus1" || sent.Options.Get(coapmsg.ProxyURI).IsSet() {
+		t.Error("Expected direct request when Proxy returns nil")
+	}
+}
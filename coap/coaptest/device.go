@@ -0,0 +1,260 @@
+// Package coaptest provides utilities to test the UART transport
+// against a virtual serial device, similar to net/http/httptest.
+//
+// The device is a pseudo terminal, so the transport uses the same code
+// paths as with real hardware: opening the port, framing, detection of
+// removed devices and reconnecting.
+package coaptest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// Host is the host of the device in coap+uart URLs
+const Host = "coaptest"
+
+// ErrUnplugged is returned when the device is not plugged in
+var ErrUnplugged = errors.New("coaptest: device unplugged")
+
+// A Device is a CoAP device on a virtual serial port. The device side
+// is either scripted with ReadMessage and WriteMessage or answers all
+// requests with a handler, see Serve.
+type Device struct {
+	// Path of the serial port. It is a symbolic link to the pseudo
+	// terminal and stays the same when the device is plugged in again.
+	Path string
+
+	// Connector opens the serial port of the device for Host
+	Connector *coap.UartConnector
+
+	// Framer used by the device, must match the connector. SLIP if nil.
+	Framer coap.Framer
+
+	dir string
+	ids *coap.MessageIds // Of messages sent by the device
+
+	mu      sync.Mutex // Guards the fields below
+	master  *os.File
+	slave   *os.File // Held open, else the master fails before the transport opens the port
+	reader  coap.PacketReader
+	handler coap.Handler
+}
+
+// NewDevice creates a plugged in device. The caller should call Close
+// when finished.
+func NewDevice() (*Device, error) {
+	dir, err := os.MkdirTemp("", "coaptest")
+	if err != nil {
+		return nil, err
+	}
+	d := &Device{
+		Path:      filepath.Join(dir, "tty"+Host),
+		Connector: coap.NewUartConnecter(),
+		dir:       dir,
+		ids:       coap.NewMessageIds(0),
+	}
+	d.Connector.Alias(Host, d.Path)
+	if err := d.Plug(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return d, nil
+}
+
+// URL returns the coap+uart URL of path on the device
+func (d *Device) URL(path string) string {
+	return coap.UartScheme + "://" + Host + path
+}
+
+// Client returns a client with a UART transport using the connector
+// of the device
+func (d *Device) Client() *coap.Client {
+	transport := coap.NewTransportUart()
+	transport.Connecter = d.Connector
+	return &coap.Client{Transport: transport}
+}
+
+func (d *Device) framer() coap.Framer {
+	if d.Framer == nil {
+		return coap.SLIPFramer{}
+	}
+	return d.Framer
+}
+
+// Plug creates a new pseudo terminal at Path, like plugging in a USB
+// device again after Unplug
+func (d *Device) Plug() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.master != nil {
+		return errors.New("coaptest: device already plugged in")
+	}
+	master, slave, err := openPty()
+	if err != nil {
+		return err
+	}
+	os.Remove(d.Path)
+	if err := os.Symlink(slave.Name(), d.Path); err != nil {
+		master.Close()
+		slave.Close()
+		return err
+	}
+	d.master, d.slave, d.reader = master, slave, nil
+	if d.handler != nil {
+		go d.serve(master, d.packetReader(), d.handler)
+	}
+	return nil
+}
+
+// Unplug removes the pseudo terminal. Reads of the transport fail and
+// Path disappears like the device node of a removed USB device.
+func (d *Device) Unplug() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.master == nil {
+		return ErrUnplugged
+	}
+	os.Remove(d.Path)
+	err := d.master.Close()
+	d.slave.Close()
+	d.master, d.slave, d.reader = nil, nil, nil
+	return err
+}
+
+// Close unplugs the device and closes all connections of the connector
+func (d *Device) Close() error {
+	d.Connector.Close()
+	d.Unplug()
+	return os.RemoveAll(d.dir)
+}
+
+// Serve answers all requests with h until the device is closed, also
+// after it was plugged in again. ReadMessage must not be used while
+// serving.
+func (d *Device) Serve(h coap.Handler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handler = h
+	if d.master != nil {
+		go d.serve(d.master, d.packetReader(), h)
+	}
+}
+
+// packetReader returns the reader of the current terminal, it is created
+// on first use so Framer can be set after NewDevice. Must be called with
+// d.mu held.
+func (d *Device) packetReader() coap.PacketReader {
+	if d.reader == nil {
+		d.reader = d.framer().NewReader(d.master)
+	}
+	return d.reader
+}
+
+func (d *Device) serve(master *os.File, reader coap.PacketReader, h coap.Handler) {
+	for {
+		packet, err := readPacket(reader)
+		if err != nil {
+			return // Unplugged
+		}
+		msg, err := coapmsg.ParseMessage(packet)
+		if err != nil {
+			continue // Garbage on the line, e.g. from WriteRaw
+		}
+		if msg.Type == coapmsg.Acknowledgement || msg.Type == coapmsg.Reset {
+			continue
+		}
+		res := coap.ServeMessageFrom(h, &msg, d.Path)
+		if res.Type == coapmsg.NonConfirmable {
+			res.MessageID = d.nextMessageId()
+		}
+		if err := d.write(master, res.MustMarshalBinary()); err != nil {
+			return
+		}
+	}
+}
+
+func (d *Device) nextMessageId() uint16 {
+	id, _ := d.ids.Next(context.Background()) // Never fails without deadline
+	return uint16(id)
+}
+
+// ReadMessage returns the next message sent by the transport
+func (d *Device) ReadMessage(timeout time.Duration) (*coapmsg.Message, error) {
+	d.mu.Lock()
+	master := d.master
+	if master == nil {
+		d.mu.Unlock()
+		return nil, ErrUnplugged
+	}
+	reader := d.packetReader()
+	d.mu.Unlock()
+	master.SetReadDeadline(time.Now().Add(timeout))
+	defer master.SetReadDeadline(time.Time{})
+	return readMessage(reader)
+}
+
+func readMessage(reader coap.PacketReader) (*coapmsg.Message, error) {
+	packet, err := readPacket(reader)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := coapmsg.ParseMessage(packet)
+	if err != nil {
+		return nil, fmt.Errorf("coaptest: invalid message % x: %w", packet, err)
+	}
+	return &msg, nil
+}
+
+func readPacket(reader coap.PacketReader) ([]byte, error) {
+	var packet []byte
+	for {
+		p, isPrefix, err := reader.ReadPacket()
+		if err != nil {
+			return nil, err
+		}
+		packet = append(packet, p...)
+		if !isPrefix {
+			return packet, nil
+		}
+	}
+}
+
+// WriteMessage sends msg to the transport
+func (d *Device) WriteMessage(msg *coapmsg.Message) error {
+	bin, err := msg.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	master := d.master
+	d.mu.Unlock()
+	if master == nil {
+		return ErrUnplugged
+	}
+	return d.write(master, bin)
+}
+
+func (d *Device) write(master *os.File, p []byte) error {
+	return d.framer().NewWriter(master).WritePacket(p)
+}
+
+// WriteRaw writes p without framing, e.g. to simulate line noise
+func (d *Device) WriteRaw(p []byte) error {
+	d.mu.Lock()
+	master := d.master
+	d.mu.Unlock()
+	if master == nil {
+		return ErrUnplugged
+	}
+	_, err := master.Write(p)
+	return err
+}
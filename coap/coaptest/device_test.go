@@ -0,0 +1,129 @@
+package coaptest
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+func newTestDevice(t *testing.T) *Device {
+	t.Helper()
+	d, err := NewDevice()
+	if err != nil {
+		t.Skip("No pseudo terminal available:", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func get(t *testing.T, client *coap.Client, url string) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := coap.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// hello answers with line endings that a terminal not in raw mode
+// would translate
+var hello = coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+	w.Write([]byte("hello " + r.URL.Path + "\r\n"))
+})
+
+func TestDeviceServe(t *testing.T) {
+	d := newTestDevice(t)
+	d.Serve(hello)
+
+	client := d.Client()
+	for _, path := range []string{"/a", "/b"} {
+		if body := get(t, client, d.URL(path)); body != "hello "+path+"\r\n" {
+			t.Errorf("Unexpected response %q", body)
+		}
+	}
+}
+
+func TestDeviceScripted(t *testing.T) {
+	d := newTestDevice(t)
+	d.Framer = coap.HDLCFramer{}
+	d.Connector.Framer = coap.HDLCFramer{}
+
+	go func() {
+		req, err := d.ReadMessage(5 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		// Line noise before the answer must be dropped
+		d.WriteRaw([]byte{0x7e, 0x01, 0x02, 0x03, 0x7e})
+
+		res := coapmsg.NewAck(req.MessageID)
+		res.Code = coapmsg.Content
+		res.Token = req.Token
+		res.Payload = []byte("scripted")
+		if err := d.WriteMessage(&res); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	before := coap.ReadMetrics().FramesDropped
+	if body := get(t, d.Client(), d.URL("/x")); body != "scripted" {
+		t.Errorf("Unexpected response %q", body)
+	}
+	if coap.ReadMetrics().FramesDropped == before {
+		t.Error("Expected line noise to be dropped")
+	}
+}
+
+func TestDeviceReplug(t *testing.T) {
+	d := newTestDevice(t)
+	d.Connector.ReconnectInterval = 20 * time.Millisecond
+	events := make(chan coap.ConnectionEvent, 10)
+	d.Connector.Subscribe(events)
+	d.Serve(hello)
+
+	client := d.Client()
+	if body := get(t, client, d.URL("/before")); body != "hello /before\r\n" {
+		t.Errorf("Unexpected response %q", body)
+	}
+	expectState(t, events, coap.StateConnected)
+
+	if err := d.Unplug(); err != nil {
+		t.Fatal(err)
+	}
+	expectState(t, events, coap.StateDisconnected)
+	if err := d.Plug(); err != nil {
+		t.Fatal(err)
+	}
+	expectState(t, events, coap.StateConnected)
+
+	if body := get(t, client, d.URL("/after")); body != "hello /after\r\n" {
+		t.Errorf("Unexpected response %q", body)
+	}
+}
+
+func expectState(t *testing.T, events <-chan coap.ConnectionEvent, state coap.ConnectionState) {
+	t.Helper()
+	select {
+	case ev := <-events:
+		if ev.State != state {
+			t.Fatalf("Expected %s but got %s", state, ev.State)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected %s event", state)
+	}
+}
@@ -0,0 +1,96 @@
+package coaptest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// HandlerTransport is a RoundTripper that passes requests directly to a
+// server handler, e.g. to test a client against a handler without a
+// device. Each request is converted into a confirmable or, if
+// Request.Confirmable is false, non-confirmable message and served with
+// coap.ServeMessageFrom.
+type HandlerTransport struct {
+	Handler coap.Handler
+
+	// RemoteAddr is passed to the handler as Request.RemoteAddr. If
+	// empty, Host is used.
+	RemoteAddr string
+
+	// Err is returned for all requests if set, e.g. to simulate a device
+	// that does not answer
+	Err error
+
+	mu   sync.Mutex
+	sent []*coapmsg.Message
+}
+
+func (t *HandlerTransport) RoundTrip(req *coap.Request) (*coap.Response, error) {
+	var payload []byte
+	if req.Body != nil {
+		var err error
+		payload, err = ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if t.Err != nil {
+		return nil, t.Err
+	}
+	code, ok := coapmsg.MethodCode(req.Method)
+	if !ok {
+		return nil, fmt.Errorf("coaptest: unknown method %q", req.Method)
+	}
+
+	msg := coapmsg.NewMessage()
+	msg.Type = coapmsg.NonConfirmable
+	if req.Confirmable {
+		msg.Type = coapmsg.Confirmable
+	}
+	msg.Code = code
+	msg.Token = req.Token
+	for id, vals := range req.Options {
+		msg.Options()[id] = vals
+	}
+	msg.SetPathString(req.URL.Path)
+	if req.URL.Path == "" {
+		msg.Options().Del(coapmsg.URIPath)
+	}
+	if req.URL.RawQuery != "" {
+		for _, q := range strings.Split(req.URL.RawQuery, "&") {
+			msg.Options().Add(coapmsg.URIQuery, q)
+		}
+	}
+	msg.Payload = payload
+
+	t.mu.Lock()
+	t.sent = append(t.sent, &msg)
+	t.mu.Unlock()
+
+	remoteAddr := t.RemoteAddr
+	if remoteAddr == "" {
+		remoteAddr = Host
+	}
+	res := coap.ServeMessageFrom(t.Handler, &msg, remoteAddr)
+	return &coap.Response{
+		StatusCode: res.Code.Number(),
+		Code:       res.Code,
+		Status:     res.Code.Dotted() + " " + res.Code.String(),
+		Body:       ioutil.NopCloser(bytes.NewReader(res.Payload)),
+		Options:    res.Options(),
+		Request:    req,
+	}, nil
+}
+
+// Sent returns the messages of all requests served so far
+func (t *HandlerTransport) Sent() []*coapmsg.Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*coapmsg.Message(nil), t.sent...)
+}
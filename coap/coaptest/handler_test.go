@@ -0,0 +1,40 @@
+package coaptest
+
+import (
+	"testing"
+
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+func TestHandlerTransport(t *testing.T) {
+	tr := &HandlerTransport{Handler: hello}
+
+	req, err := coap.NewRequest("GET", "coap+uart://any/a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	req.Method = "FETCH"
+	req.Confirmable = false
+	if _, err := tr.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	sent := tr.Sent()
+	if sent[0].Code != coapmsg.GET || sent[0].Type != coapmsg.Confirmable {
+		t.Errorf("Expected confirmable GET but got %s %s", sent[0].Type, sent[0].Code)
+	}
+	if sent[1].Code != coapmsg.FETCH || sent[1].Type != coapmsg.NonConfirmable {
+		t.Errorf("Expected non-confirmable FETCH but got %s %s", sent[1].Type, sent[1].Code)
+	}
+
+	req.Method = "LIST"
+	if _, err := tr.RoundTrip(req); err == nil {
+		t.Error("Expected error for unknown method")
+	}
+	if len(tr.Sent()) != 2 {
+		t.Error("Request with unknown method must not be served")
+	}
+}
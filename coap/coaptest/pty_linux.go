@@ -0,0 +1,91 @@
+//go:build linux
+
+package coaptest
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// openPty opens a new pseudo terminal and returns the master and the
+// slave in raw mode
+func openPty() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var n uint32
+	err = ioctl(master, func(fd uintptr) error {
+		var unlock int32
+		if err := ioctlPtr(fd, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+			return fmt.Errorf("unlockpt: %w", err)
+		}
+		if err := ioctlPtr(fd, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+			return fmt.Errorf("ptsname: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	if err := makeRaw(slave); err != nil {
+		master.Close()
+		slave.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+// makeRaw disables all processing of the terminal like cfmakeraw(3),
+// otherwise e.g. line feeds would be translated
+func makeRaw(f *os.File) error {
+	return ioctl(f, func(fd uintptr) error {
+		var t syscall.Termios
+		if err := ioctlPtr(fd, syscall.TCGETS, unsafe.Pointer(&t)); err != nil {
+			return fmt.Errorf("tcgetattr: %w", err)
+		}
+		t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+			syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+		t.Oflag &^= syscall.OPOST
+		t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+		t.Cflag &^= syscall.CSIZE | syscall.PARENB
+		t.Cflag |= syscall.CS8
+		t.Cc[syscall.VMIN] = 1
+		t.Cc[syscall.VTIME] = 0
+		if err := ioctlPtr(fd, syscall.TCSETS, unsafe.Pointer(&t)); err != nil {
+			return fmt.Errorf("tcsetattr: %w", err)
+		}
+		return nil
+	})
+}
+
+// ioctl calls fn with the file descriptor of f. Unlike f.Fd it keeps the
+// file in non-blocking mode, so deadlines keep working.
+func ioctl(f *os.File, fn func(fd uintptr) error) error {
+	raw, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var fnErr error
+	if err := raw.Control(func(fd uintptr) { fnErr = fn(fd) }); err != nil {
+		return err
+	}
+	return fnErr
+}
+
+func ioctlPtr(fd uintptr, req uint, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(req), uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package coaptest
+
+import (
+	"errors"
+	"os"
+)
+
+func openPty() (master, slave *os.File, err error) {
+	return nil, nil, errors.New("coaptest: pseudo terminals are only supported on linux")
+}
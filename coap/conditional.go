@@ -0,0 +1,124 @@
+package coap
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// PutIfMatch issues a PUT that is only performed by the server when the
+// current representation has the given ETag (RFC 7252, Section 5.10.8.1).
+// A nil etag matches any existing representation.
+//
+// The server answers with 4.12 Precondition Failed when the resource
+// was modified in the meantime.
+func PutIfMatch(url string, etag []byte, bodyType coapmsg.MediaType, body io.Reader) (*Response, error) {
+	return DefaultClient.PutIfMatch(url, etag, bodyType, body)
+}
+
+// CreateIfNoneMatch issues a PUT that is only performed by the server
+// when the resource does not exist yet (RFC 7252, Section 5.10.8.2).
+func CreateIfNoneMatch(url string, bodyType coapmsg.MediaType, body io.Reader) (*Response, error) {
+	return DefaultClient.CreateIfNoneMatch(url, bodyType, body)
+}
+
+// PutIfMatch issues a PUT with If-Match option. See PutIfMatch.
+func (c *Client) PutIfMatch(url string, etag []byte, bodyType coapmsg.MediaType, body io.Reader) (*Response, error) {
+	req, err := NewRequest("PUT", url, body)
+	if err != nil {
+		return nil, err
+	}
+	if etag == nil {
+		etag = []byte{}
+	}
+	if err = req.Options.Set(coapmsg.IfMatch, etag); err != nil {
+		return nil, err
+	}
+	if err = req.Options.Set(coapmsg.ContentFormat, bodyType); err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// CreateIfNoneMatch issues a PUT with If-None-Match option. See CreateIfNoneMatch.
+func (c *Client) CreateIfNoneMatch(url string, bodyType coapmsg.MediaType, body io.Reader) (*Response, error) {
+	req, err := NewRequest("PUT", url, body)
+	if err != nil {
+		return nil, err
+	}
+	if err = req.Options.Set(coapmsg.IfNoneMatch, []byte{}); err != nil {
+		return nil, err
+	}
+	if err = req.Options.Set(coapmsg.ContentFormat, bodyType); err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// PreconditionHandler is a middleware that evaluates the conditional
+// request options If-Match and If-None-Match (RFC 7252, Section 5.10.8)
+// against the current ETag of the requested resource and answers with
+// 4.12 Precondition Failed if they are not met.
+//
+// GET requests carrying the current ETag are answered with 2.03 Valid
+// (RFC 7252, Section 5.10.6) and all other GET responses of existing
+// resources get the current ETag.
+type PreconditionHandler struct {
+	Next Handler
+
+	// ETag returns the current entity tag of the requested resource
+	// and whether the resource exists.
+	ETag func(r *Request) (etag []byte, exists bool)
+}
+
+// NewPreconditionHandler returns a PreconditionHandler
+func NewPreconditionHandler(next Handler, etag func(r *Request) ([]byte, bool)) *PreconditionHandler {
+	return &PreconditionHandler{
+		Next: next,
+		ETag: etag,
+	}
+}
+
+func (h *PreconditionHandler) ServeCOAP(w ResponseWriter, r *Request) {
+	etag, exists := h.ETag(r)
+
+	if !preconditionsMet(r.Options, etag, exists) {
+		log.WithField("URL", r.URL.String()).Debug("Precondition failed")
+		w.WriteCode(coapmsg.PreconditionFailed)
+		return
+	}
+
+	if r.Method == "GET" && exists {
+		w.Options().Set(coapmsg.ETag, etag)
+		for _, v := range r.Options[coapmsg.ETag] {
+			if bytes.Equal(v.AsBytes(), etag) {
+				w.WriteCode(coapmsg.Valid)
+				return
+			}
+		}
+	}
+
+	h.Next.ServeCOAP(w, r)
+}
+
+func preconditionsMet(opts coapmsg.CoapOptions, etag []byte, exists bool) bool {
+	if opts.Get(coapmsg.IfNoneMatch).IsSet() && exists {
+		return false
+	}
+
+	ifMatch := opts[coapmsg.IfMatch]
+	if len(ifMatch) == 0 {
+		return true
+	}
+	if !exists {
+		return false
+	}
+	for _, v := range ifMatch {
+		// An empty value matches any existing representation
+		if v.Len() == 0 || bytes.Equal(v.AsBytes(), etag) {
+			return true
+		}
+	}
+	return false
+}
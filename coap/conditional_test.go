@@ -0,0 +1,117 @@
+package coap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strconv"
+	"testing"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// configStore is a single resource with a version based ETag
+type configStore struct {
+	value   []byte
+	version int
+}
+
+func (s *configStore) etag(r *Request) ([]byte, bool) {
+	if s.value == nil {
+		return nil, false
+	}
+	return []byte(strconv.Itoa(s.version)), true
+}
+
+func (s *configStore) ServeCOAP(w ResponseWriter, r *Request) {
+	switch r.Method {
+	case "GET":
+		if s.value == nil {
+			w.WriteCode(coapmsg.NotFound)
+			return
+		}
+		w.Write(s.value)
+	case "PUT":
+		created := s.value == nil
+		s.value, _ = ioutil.ReadAll(r.Body)
+		s.version++
+		if created {
+			w.WriteCode(coapmsg.Created)
+		} else {
+			w.WriteCode(coapmsg.Changed)
+		}
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	store := &configStore{}
+	tr := &handlerTransport{handler: NewPreconditionHandler(store, store.etag)}
+	client := &Client{Transport: tr}
+	url := "coap+uart://any/config"
+	text := coapmsg.TextPlain
+
+	if _, err := client.PutIfMatch(url, nil, text, bytes.NewBufferString("a")); err != nil {
+		t.Fatal(err)
+	}
+	if store.value != nil {
+		t.Fatal("If-Match must fail for a resource that does not exist")
+	}
+
+	res, err := client.CreateIfNoneMatch(url, text, bytes.NewBufferString("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != coapmsg.Created {
+		t.Fatal("Expected 2.01 Created but got", res.Status)
+	}
+
+	res, _ = client.CreateIfNoneMatch(url, text, bytes.NewBufferString("b"))
+	if res.Code != coapmsg.PreconditionFailed {
+		t.Error("Expected 4.12 for If-None-Match on existing resource but got", res.Status)
+	}
+
+	res, _ = client.Get(url)
+	etag := res.Options.Get(coapmsg.ETag).AsBytes()
+	if res.Code != coapmsg.Content || len(etag) == 0 {
+		t.Fatalf("Expected 2.05 with ETag but got %s (ETag %x)", res.Status, etag)
+	}
+
+	res, _ = client.PutIfMatch(url, etag, text, bytes.NewBufferString("c"))
+	if res.Code != coapmsg.Changed {
+		t.Error("Expected 2.04 for matching If-Match but got", res.Status)
+	}
+
+	// A concurrent writer modified the resource, the old ETag is stale
+	res, _ = client.PutIfMatch(url, etag, text, bytes.NewBufferString("d"))
+	if res.Code != coapmsg.PreconditionFailed {
+		t.Error("Expected 4.12 for stale If-Match but got", res.Status)
+	}
+	if string(store.value) != "c" {
+		t.Errorf("Expected value %q but got %q", "c", store.value)
+	}
+
+	res, _ = client.PutIfMatch(url, nil, text, bytes.NewBufferString("e"))
+	if res.Code != coapmsg.Changed {
+		t.Error("Expected 2.04 for empty If-Match on existing resource but got", res.Status)
+	}
+}
+
+func TestPreconditionHandlerValidatesETag(t *testing.T) {
+	store := &configStore{value: []byte("a"), version: 7}
+	h := NewPreconditionHandler(store, store.etag)
+
+	req, _ := NewRequest("GET", "coap://dev/config", nil)
+	req.Options.Add(coapmsg.ETag, []byte("6"))
+	req.Options.Add(coapmsg.ETag, []byte("7"))
+	w := newResponseWriter()
+	h.ServeCOAP(w, req)
+
+	if w.msg.Code != coapmsg.Valid {
+		t.Error("Expected 2.03 Valid but got", w.msg.Code)
+	}
+	if string(w.msg.Options().Get(coapmsg.ETag).AsBytes()) != "7" {
+		t.Error("Expected current ETag in 2.03 response")
+	}
+	if len(w.msg.Payload) != 0 {
+		t.Error("2.03 response must not have a payload")
+	}
+}
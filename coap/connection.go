@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 
 	"time"
@@ -27,12 +28,82 @@ type Connection interface {
 	//resetDeadline()
 }
 
+// ConnectionState is the state of a connection, see ConnectionEvent
+type ConnectionState uint8
+
+const (
+	StateConnected    ConnectionState = iota // Ready to send and receive
+	StateDisconnected                        // Device lost, waiting for it to reappear
+	StateClosed                              // Closed, will not reconnect
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// ConnectionEvent reports a state change of a connection
+type ConnectionEvent struct {
+	Host  string // Host of the request URL, e.g. ttyUSB0 or any
+	Port  string // Name of the port, e.g. /dev/ttyUSB0
+	State ConnectionState
+	Err   error // Cause of a disconnect
+}
+
+// InteractionStore keeps the interactions of a connection and must be
+// safe for concurrent use, see Interactions
 type InteractionStore interface {
 	FindInteraction(token Token, msgId MessageId) *Interaction
 	AddInteraction(ia *Interaction)
 	RemoveInteraction(ia *Interaction)
 }
 
+// MessageTracker is implemented by interaction stores that match ACK and
+// RST messages by the message ID of the last message sent, like
+// Interactions. Received messages of stores without it are matched with
+// FindInteraction.
+type MessageTracker interface {
+	MatchInteraction(msg *coapmsg.Message) *Interaction
+	TryAddInteraction(ia *Interaction) bool
+	TrackMessage(ia *Interaction, msgId MessageId, typ coapmsg.COAPType)
+}
+
+// matchInteraction returns the interaction of a received message
+func matchInteraction(store InteractionStore, msg *coapmsg.Message) *Interaction {
+	if t, ok := store.(MessageTracker); ok {
+		return t.MatchInteraction(msg)
+	}
+	return store.FindInteraction(Token(msg.Token), MessageId(msg.MessageID))
+}
+
+// tryAddInteraction adds ia unless its token is used by another
+// interaction of the store
+func tryAddInteraction(store InteractionStore, ia *Interaction) bool {
+	if t, ok := store.(MessageTracker); ok {
+		return t.TryAddInteraction(ia)
+	}
+	if len(ia.req.Token) > 0 && store.FindInteraction(Token(ia.req.Token), 0) != nil {
+		return false
+	}
+	store.AddInteraction(ia)
+	return true
+}
+
+// trackMessage registers the last message sent within ia, if the store
+// supports it
+func trackMessage(store InteractionStore, ia *Interaction, msgId MessageId, typ coapmsg.COAPType) {
+	if t, ok := store.(MessageTracker); ok {
+		t.TrackMessage(ia, msgId, typ)
+	}
+}
+
 // Implemented by connections
 type PacketReader interface {
 	ReadPacket() (p []byte, isPrefix bool, err error)
@@ -46,11 +117,13 @@ type PacketWriter interface {
 type incomingPacketHandler struct {
 }
 
-func deleteConnection(a []Connection, i int) []Connection {
-	copy(a[i:], a[i+1:])
-	a[len(a)-1] = nil // or the zero value of T
-	a = a[:len(a)-1]
-	return a
+// requestServer is implemented by connections that serve requests of
+// the peer which belong to no interaction, see UartConnector.Handler
+type requestServer interface {
+	// requestHandler returns the handler, nil if requests are rejected,
+	// and the address of the peer
+	requestHandler() (h Handler, remoteAddr string)
+	nextMessageId(ctx context.Context) (MessageId, error)
 }
 
 func sendMessage(conn Connection, msg *coapmsg.Message) error {
@@ -61,6 +134,8 @@ func sendMessage(conn Connection, msg *coapmsg.Message) error {
 	if err != nil {
 		return err
 	}
+	tapPacket(PacketOutgoing, bin)
+	countMetric(&metrics.MessagesSent)
 	return nil
 }
 
@@ -73,6 +148,10 @@ func receiveLoop(ctx context.Context, conn Connection) {
 		}
 		msg, err := readMessage(ctx, conn)
 
+		if errors.Is(err, ErrConnectionClosed) {
+			log.WithError(err).Info("Connection closed. Stopped receive loop.")
+			return
+		}
 		if err != nil {
 			// Do not close the connection, this might happen during reopening of the serial port
 			// TODO: An "Access is denied." error indicates that the UARt is not reachable. So reopening would be an option
@@ -82,8 +161,17 @@ func receiveLoop(ctx context.Context, conn Connection) {
 			continue
 		}
 
-		ia := conn.FindInteraction(Token(msg.Token), MessageId(msg.MessageID))
-		if ia == nil {
+		ia := matchInteraction(conn, msg)
+		if ia == nil && msg.Code.IsRequest() && serveRequest(conn, msg) {
+			continue
+		}
+		if ia == nil && (msg.Type == coapmsg.Acknowledgement || msg.Type == coapmsg.Reset) {
+			// Rejecting an ACK or RST is effected by silently ignoring it
+			// (RFC 7252, Section 4.2 and 4.3)
+			log.WithField("messageId", msg.MessageID).
+				WithField("type", msg.Type.String()).
+				Info("Dropped unexpected message")
+		} else if ia == nil {
 			log.WithError(err).
 				WithField("token", msg.Token).
 				WithField("messageId", msg.MessageID).
@@ -101,18 +189,49 @@ func receiveLoop(ctx context.Context, conn Connection) {
 	}
 }
 
+// serveRequest answers a request of the peer with the handler of conn.
+// The handler runs in the background, so it may send requests on conn
+// itself. Returns false if conn has no handler.
+func serveRequest(conn Connection, msg *coapmsg.Message) bool {
+	s, ok := conn.(requestServer)
+	if !ok {
+		return false
+	}
+	h, remoteAddr := s.requestHandler()
+	if h == nil {
+		return false
+	}
+	go func() {
+		res := ServeMessageFrom(h, msg, remoteAddr)
+		if res.Type == coapmsg.NonConfirmable {
+			msgId, err := s.nextMessageId(context.Background())
+			if err != nil {
+				log.WithError(err).Warn("Failed to allocate message ID for response")
+				return
+			}
+			res.MessageID = uint16(msgId)
+		}
+		if err := sendMessage(conn, res); err != nil {
+			log.WithError(err).WithField("RemoteAddr", remoteAddr).Warn("Failed to send response")
+		}
+	}()
+	return true
+}
+
 func readMessage(ctx context.Context, reader PacketReader) (*coapmsg.Message, error) {
 	packet, err := readPacket(ctx, reader)
 
 	if err != nil {
 		return nil, err
 	}
+	tapPacket(PacketIncoming, packet)
 
 	msg, err := coapmsg.ParseMessage(packet)
 	if err != nil {
-		return nil, wrapError(err, "Failed to parse CoAP message")
+		return nil, fmt.Errorf("Failed to parse CoAP message: %w", err)
 	}
 	logMsg(&msg, "Received")
+	countMetric(&metrics.MessagesReceived)
 
 	return &msg, nil
 }
@@ -137,7 +256,7 @@ func readPacket(ctx context.Context, reader PacketReader) ([]byte, error) {
 
 		select {
 		case <-ctx.Done():
-			return nil, errors.New("coap: Timeout while readPacket")
+			return nil, fmt.Errorf("coap: readPacket: %w", ErrTimeout)
 		default:
 		}
 
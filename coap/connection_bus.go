@@ -0,0 +1,381 @@
+package coap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// DefaultTurnTimeout is the time a bus waits for the answer of an
+// addressed device before another device may be addressed
+const DefaultTurnTimeout = time.Second
+
+// splitBusAddress splits a host like "ttyS2:17" into the port host and
+// the address of a device on an RS-485 bus
+func splitBusAddress(host string) (string, byte, bool) {
+	i := strings.LastIndexByte(host, ':')
+	if i <= 0 {
+		return host, 0, false
+	}
+	addr, err := strconv.ParseUint(host[i+1:], 10, 8)
+	if err != nil {
+		return host, 0, false
+	}
+	return host[:i], byte(addr), true
+}
+
+// rs485Bus multiplexes the devices on a half-duplex multi-drop bus over
+// a single serial connection. Every frame starts with the address of the
+// device, followed by the CoAP message. Devices answer with their own
+// address.
+//
+// Only one device is addressed at a time: after a request the bus waits
+// for an answer of the device or the turn timeout before the next frame
+// is sent. Before sending, the bus must have been idle for the guard time
+// since the last frame in either direction, so that answers of slow
+// devices are not garbled.
+type rs485Bus struct {
+	conn        *serialConnection
+	turnTimeout time.Duration
+	guardTime   time.Duration
+
+	turn chan struct{} // holds a token while a frame is sent or answered
+
+	mu        sync.Mutex // Guards the fields below
+	endpoints map[byte]*busEndpoint
+	awaiting  int // address of the device that may answer or -1
+	turnSeq   uint64
+	turnTimer *time.Timer
+	lastRx    time.Time
+	lastTx    time.Time
+}
+
+func newRS485Bus(conn *serialConnection, turnTimeout, guardTime time.Duration) *rs485Bus {
+	if turnTimeout <= 0 {
+		turnTimeout = DefaultTurnTimeout
+	}
+	b := &rs485Bus{
+		conn:        conn,
+		turnTimeout: turnTimeout,
+		guardTime:   guardTime,
+		turn:        make(chan struct{}, 1),
+		endpoints:   make(map[byte]*busEndpoint),
+		awaiting:    -1,
+	}
+	conn.loop = b.receiveLoop
+	conn.inUse = b.inUse
+	return b
+}
+
+// guard returns the idle time required before sending. Defaults to
+// 3.5 characters like Modbus RTU.
+func (b *rs485Bus) guard() time.Duration {
+	if b.guardTime > 0 {
+		return b.guardTime
+	}
+	b.conn.mu.Lock()
+	baud := b.conn.mode.Baud
+	b.conn.mu.Unlock()
+	if baud <= 0 {
+		return 0
+	}
+	// 11 bits per character with start, parity and stop bit
+	return time.Duration(int64(time.Second) * 35 * 11 / 10 / int64(baud))
+}
+
+// endpoint returns the open connection to the device with addr
+func (b *rs485Bus) endpoint(addr byte) (*busEndpoint, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ep, ok := b.endpoints[addr]; ok {
+		return ep, nil
+	}
+	ep := &busEndpoint{
+		bus:     b,
+		addr:    addr,
+		packets: make(chan []byte, 8),
+		done:    make(chan struct{}),
+	}
+	if err := ep.Open(); err != nil {
+		return nil, err
+	}
+	b.endpoints[addr] = ep
+	return ep, nil
+}
+
+func (b *rs485Bus) removeEndpoint(ep *busEndpoint) {
+	b.mu.Lock()
+	if b.endpoints[ep.addr] == ep {
+		delete(b.endpoints, ep.addr)
+	}
+	empty := len(b.endpoints) == 0
+	b.mu.Unlock()
+
+	if empty && !b.conn.Closed() {
+		log.WithField("port", b.conn.portName).Info("Closing RS-485 bus without devices")
+		b.conn.Close()
+	}
+}
+
+// inUse reports whether any device has active interactions
+func (b *rs485Bus) inUse() bool {
+	for _, ep := range b.allEndpoints() {
+		if ep.count() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// closeIdle closes the connections to devices without interactions
+func (b *rs485Bus) closeIdle() {
+	for _, ep := range b.allEndpoints() {
+		if ep.count() == 0 {
+			ep.Close()
+		}
+	}
+}
+
+// addresses returns the addresses of the connected devices
+func (b *rs485Bus) addresses() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	addrs := make([]byte, 0, len(b.endpoints))
+	for addr := range b.endpoints {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+func (b *rs485Bus) allEndpoints() []*busEndpoint {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	eps := make([]*busEndpoint, 0, len(b.endpoints))
+	for _, ep := range b.endpoints {
+		eps = append(eps, ep)
+	}
+	return eps
+}
+
+// write sends p to the device with addr when it is the turn of the
+// caller. Requests keep the turn until the device answers, see
+// turnTimeoutOf.
+func (b *rs485Bus) write(addr byte, p []byte) error {
+	b.turn <- struct{}{}
+
+	b.mu.Lock()
+	last := b.lastRx
+	if b.lastTx.After(last) {
+		last = b.lastTx
+	}
+	b.mu.Unlock()
+	if wait := b.guard() - time.Since(last); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	frame := append([]byte{addr}, p...)
+	err := b.conn.WritePacket(frame)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastTx = time.Now()
+	timeout := b.turnTimeoutOf(p)
+	if err != nil || timeout == 0 {
+		<-b.turn
+		return err
+	}
+	b.turnSeq++
+	seq := b.turnSeq
+	b.awaiting = int(addr)
+	b.turnTimer = time.AfterFunc(timeout, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.turnSeq == seq && b.awaiting >= 0 {
+			log.WithField("address", addr).Debug("No answer on RS-485 bus, passing the turn")
+			b.endTurn()
+		}
+	})
+	return nil
+}
+
+// endTurn must be called with b.mu held
+func (b *rs485Bus) endTurn() {
+	if b.awaiting < 0 {
+		return
+	}
+	b.awaiting = -1
+	b.turnTimer.Stop()
+	<-b.turn
+}
+
+// turnTimeoutOf returns the time the device may take to answer the CoAP
+// message p before the next frame is sent, 0 if it must not answer.
+// Confirmable messages are answered reliably. The answer to a
+// non-confirmable request is optional, so the turn is only kept for a
+// quarter of the turn timeout, long enough for a prompt NON response.
+func (b *rs485Bus) turnTimeoutOf(p []byte) time.Duration {
+	if len(p) < 2 {
+		return 0
+	}
+	typ, code := coapmsg.COAPType(p[0]>>4&0x3), coapmsg.COAPCode(p[1])
+	switch {
+	case typ == coapmsg.Confirmable:
+		return b.turnTimeout
+	case typ == coapmsg.NonConfirmable && code.IsRequest():
+		return b.turnTimeout / 4
+	}
+	return 0
+}
+
+// receiveLoop dispatches received frames to the endpoint of the address
+func (b *rs485Bus) receiveLoop(ctx context.Context, conn Connection) {
+	for {
+		if ctx.Err() != nil {
+			log.WithError(ctx.Err()).Info("Context done. Stopped bus receive loop.")
+			return
+		}
+		packet, err := readPacket(ctx, conn)
+		if errors.Is(err, ErrConnectionClosed) {
+			log.WithError(err).Info("Connection closed. Stopped bus receive loop.")
+			return
+		}
+		if err != nil {
+			log.WithError(err).Warn("Failed to receive frame from bus")
+			continue
+		}
+		if len(packet) < 2 {
+			continue
+		}
+
+		addr := packet[0]
+		b.mu.Lock()
+		b.lastRx = time.Now()
+		if b.awaiting == int(addr) {
+			b.endTurn()
+		}
+		ep := b.endpoints[addr]
+		b.mu.Unlock()
+
+		if ep == nil {
+			log.WithField("address", addr).Debug("Dropped frame from unknown device on RS-485 bus")
+			continue
+		}
+		ep.deliver(packet[1:])
+	}
+}
+
+// stateChanged keeps the endpoints in sync with the serial connection
+func (b *rs485Bus) stateChanged(ev ConnectionEvent) {
+	switch ev.State {
+	case StateDisconnected:
+		b.mu.Lock()
+		b.endTurn()
+		b.mu.Unlock()
+		for _, ep := range b.allEndpoints() {
+			ep.failInteractions(ErrConnectionClosed)
+		}
+	case StateConnected:
+		for _, ep := range b.allEndpoints() {
+			reobserve(ep, &ep.Interactions)
+		}
+	case StateClosed:
+		for _, ep := range b.allEndpoints() {
+			ep.Close()
+		}
+	}
+}
+
+// busEndpoint is the connection to a single device on an RS-485 bus.
+// Each device has its own interactions.
+type busEndpoint struct {
+	Interactions
+	bus     *rs485Bus
+	addr    byte
+	packets chan []byte
+
+	closeOnce         sync.Once
+	done              chan struct{}
+	cancelReceiveLoop context.CancelFunc
+}
+
+func (ep *busEndpoint) nextMessageId(ctx context.Context) (MessageId, error) {
+	ep.bus.conn.mu.Lock()
+	key := fmt.Sprintf("%s:%d", ep.bus.conn.portName, ep.addr)
+	ep.bus.conn.mu.Unlock()
+	return ep.bus.conn.ids(key).Next(ctx)
+}
+
+func (ep *busEndpoint) requestHandler() (Handler, string) {
+	ep.bus.conn.mu.Lock()
+	defer ep.bus.conn.mu.Unlock()
+	return ep.bus.conn.handler, fmt.Sprintf("%s:%d", ep.bus.conn.portName, ep.addr)
+}
+
+func (ep *busEndpoint) Open() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	ep.cancelReceiveLoop = cancel
+	go receiveLoop(ctx, ep)
+	return nil
+}
+
+// deliver passes p to the endpoint without blocking the receive loop of
+// the bus, which is shared by all devices. Packets are dropped while the
+// endpoint does not keep up.
+func (ep *busEndpoint) deliver(p []byte) {
+	select {
+	case <-ep.done:
+		return
+	default:
+	}
+	select {
+	case ep.packets <- p:
+	default:
+		countMetric(&metrics.BusPacketsDropped)
+		log.WithField("address", ep.addr).Warn("Dropped frame on RS-485 bus, device connection does not keep up")
+	}
+}
+
+func (ep *busEndpoint) ReadPacket() ([]byte, bool, error) {
+	select {
+	case p := <-ep.packets:
+		return p, false, nil
+	case <-ep.done:
+		return nil, false, ErrConnectionClosed
+	}
+}
+
+func (ep *busEndpoint) WritePacket(p []byte) error {
+	if ep.Closed() {
+		return ErrConnectionClosed
+	}
+	return ep.bus.write(ep.addr, p)
+}
+
+// Close closes the connection to the device and its interactions. The
+// bus is closed with its last device.
+func (ep *busEndpoint) Close() error {
+	ep.closeOnce.Do(func() {
+		close(ep.done)
+		ep.cancelReceiveLoop()
+		ep.closeInteractions(ErrConnectionClosed)
+		ep.bus.removeEndpoint(ep)
+	})
+	return nil
+}
+
+func (ep *busEndpoint) Closed() bool {
+	select {
+	case <-ep.done:
+		return true
+	default:
+		return ep.bus.conn.Closed()
+	}
+}
@@ -0,0 +1,302 @@
+package coap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Lobaro/slip"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+func TestSplitBusAddress(t *testing.T) {
+	tests := []struct {
+		host, port string
+		addr       byte
+		ok         bool
+	}{
+		{"ttyS2:17", "ttyS2", 17, true},
+		{"COM3:0", "COM3", 0, true},
+		{"usb-0403-6001:5", "usb-0403-6001", 5, true},
+		{"ttyS2", "ttyS2", 0, false},
+		{"ttyS2:256", "ttyS2:256", 0, false},
+		{"ttyS2:x", "ttyS2:x", 0, false},
+		{":1", ":1", 0, false},
+	}
+	for _, test := range tests {
+		port, addr, ok := splitBusAddress(test.host)
+		if port != test.port || addr != test.addr || ok != test.ok {
+			t.Errorf("%s: expected %s %d %v but got %s %d %v", test.host, test.port, test.addr, test.ok, port, addr, ok)
+		}
+	}
+}
+
+// serveBus answers GET requests of the devices with the given addresses
+// after delay. It fails when a device is addressed while another one
+// has not answered yet.
+func serveBus(device net.Conn, delay time.Duration, addrs ...byte) <-chan error {
+	errs := make(chan error, 1)
+	known := map[byte]bool{}
+	for _, a := range addrs {
+		known[a] = true
+	}
+	go func() {
+		defer close(errs)
+		reader := slip.NewReader(device)
+		writer := slip.NewWriter(device)
+		var mu sync.Mutex
+		answering := false
+		fail := func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		}
+		for {
+			p, _, err := reader.ReadPacket()
+			if err != nil {
+				return
+			}
+			addr := p[0]
+			if !known[addr] {
+				continue
+			}
+			req, err := coapmsg.ParseMessage(p[1:])
+			if err != nil {
+				fail(err)
+				return
+			}
+			if req.Type == coapmsg.Acknowledgement || req.Type == coapmsg.Reset {
+				continue
+			}
+
+			mu.Lock()
+			if answering {
+				fail(fmt.Errorf("device %d addressed while another device answers", addr))
+			}
+			answering = true
+			mu.Unlock()
+			go func() {
+				time.Sleep(delay)
+				res := coapmsg.NewAck(req.MessageID)
+				res.Code = coapmsg.Content
+				res.Token = req.Token
+				res.Payload = []byte(fmt.Sprintf("device %d", addr))
+				// Frames of devices without connection are ignored
+				writer.WritePacket(append([]byte{99}, res.MustMarshalBinary()...))
+				mu.Lock()
+				answering = false
+				mu.Unlock()
+				writer.WritePacket(append([]byte{addr}, res.MustMarshalBinary()...))
+			}()
+		}
+	}()
+	return errs
+}
+
+func TestRS485Bus(t *testing.T) {
+	devices := withFakePorts(t)
+	c := NewUartConnecter()
+	c.ReconnectInterval = time.Hour
+	trans := NewTransportUart()
+	trans.Connecter = c
+
+	first, err := c.ConnectMode("ttyBus:1", Mode{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := serveBus(<-devices, 20*time.Millisecond, 1, 2, 3)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		addr := i%3 + 1
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, err := NewRequest("GET", fmt.Sprintf("coap+uart://ttyBus:%d/name", addr), nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			res, err := trans.RoundTrip(req)
+			if err != nil {
+				t.Error(addr, err)
+				return
+			}
+			body, _ := ioutil.ReadAll(res.Body)
+			if expected := fmt.Sprintf("device %d", addr); string(body) != expected {
+				t.Errorf("Expected %q but got %q", expected, body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	second, err := c.ConnectMode("ttyBus:2", Mode{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Error("Expected a connection per device")
+	}
+	if _, err := c.ConnectMode("ttyBus", Mode{}); err == nil {
+		t.Error("Expected error for unaddressed access to the bus")
+	}
+
+	for _, addr := range []int{1, 2, 3} {
+		conn, _ := c.ConnectMode(fmt.Sprintf("ttyBus:%d", addr), Mode{})
+		conn.Close()
+	}
+	if !c.buses[0].conn.Closed() {
+		t.Error("Expected bus to be closed with its last device")
+	}
+	if err := <-errs; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRS485BusTurnTimeout(t *testing.T) {
+	devices := withFakePorts(t)
+	c := NewUartConnecter()
+	c.ReconnectInterval = time.Hour
+	c.TurnTimeout = 30 * time.Millisecond
+
+	conn, err := c.ConnectMode("ttyBus:7", Mode{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	device := <-devices
+	go func() {
+		reader := slip.NewReader(device)
+		for {
+			if _, _, err := reader.ReadPacket(); err != nil {
+				return
+			}
+		}
+	}()
+
+	req := coapmsg.NewMessage()
+	req.Type = coapmsg.Confirmable
+	req.Code = coapmsg.GET
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := sendMessage(conn, &req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("Expected to wait for the turn timeout, took %v", elapsed)
+	}
+}
+
+func TestRS485BusNonConfirmable(t *testing.T) {
+	devices := withFakePorts(t)
+	c := NewUartConnecter()
+	c.ReconnectInterval = time.Hour
+	c.TurnTimeout = 200 * time.Millisecond
+
+	conn, err := c.ConnectMode("ttyBus:7", Mode{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	device := <-devices
+	go func() {
+		reader := slip.NewReader(device)
+		for {
+			if _, _, err := reader.ReadPacket(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Non-confirmable requests keep the turn for a quarter of the turn
+	// timeout, so a NON response does not collide with the next frame
+	req := coapmsg.NewMessage()
+	req.Type = coapmsg.NonConfirmable
+	req.Code = coapmsg.GET
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := sendMessage(conn, &req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < c.TurnTimeout/2 || elapsed >= 2*c.TurnTimeout {
+		t.Errorf("Expected to wait for a quarter of the turn timeout per request, took %v", elapsed)
+	}
+
+	// Responses do not keep the turn, once the last request passed it
+	time.Sleep(c.TurnTimeout / 4)
+	res := coapmsg.NewMessage()
+	res.Type = coapmsg.NonConfirmable
+	res.Code = coapmsg.Content
+	start = time.Now()
+	for i := 0; i < 3; i++ {
+		if err := sendMessage(conn, &res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed >= c.TurnTimeout/4 {
+		t.Errorf("Expected responses not to wait for the turn timeout, took %v", elapsed)
+	}
+}
+
+func TestRS485BusGuardTimeAfterTransmit(t *testing.T) {
+	devices := withFakePorts(t)
+	c := NewUartConnecter()
+	c.ReconnectInterval = time.Hour
+	c.GuardTime = 30 * time.Millisecond
+
+	conn, err := c.ConnectMode("ttyBus:7", Mode{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	device := <-devices
+	go func() {
+		reader := slip.NewReader(device)
+		for {
+			if _, _, err := reader.ReadPacket(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ack := coapmsg.NewAck(1)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := sendMessage(conn, &ack); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 2*c.GuardTime {
+		t.Errorf("Expected to wait for the guard time after each frame, took %v", elapsed)
+	}
+}
+
+func TestBusEndpointDeliverDoesNotBlock(t *testing.T) {
+	ep := &busEndpoint{
+		addr:    1,
+		packets: make(chan []byte, 1),
+		done:    make(chan struct{}),
+	}
+	before := ReadMetrics().BusPacketsDropped
+	ep.deliver([]byte("a"))
+	ep.deliver([]byte("b"))
+	if dropped := ReadMetrics().BusPacketsDropped - before; dropped != 1 {
+		t.Errorf("Expected 1 dropped packet but got %d", dropped)
+	}
+	if p := <-ep.packets; string(p) != "a" {
+		t.Errorf("Expected first packet but got %q", p)
+	}
+
+	// Closed endpoints drop packets silently
+	close(ep.done)
+	ep.deliver([]byte("c"))
+	if dropped := ReadMetrics().BusPacketsDropped - before; dropped != 1 {
+		t.Errorf("Expected no more dropped packets but got %d", dropped)
+	}
+}
@@ -2,137 +2,422 @@ package coap
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"os"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/Lobaro/slip"
 	"go.bug.st/serial.v1"
+	"go.bug.st/serial.v1/enumerator"
 )
 
+// DefaultReconnectInterval is the interval to check whether a serial
+// device was removed or reappeared
+const DefaultReconnectInterval = time.Second
+
+// closeTimeout is the maximum time Close waits for the receive loop
+const closeTimeout = 5 * time.Second
+
 type serialConnection struct {
 	Interactions
-	mode     *serial.Mode
-	portName string
-	reader   PacketReader
-	writer   PacketWriter
-	open     bool
-
-	// Use reader and writer to interact with the port
-	port serial.Port
+	host string // host of the request URL, e.g. "any"
+
+	// identity of the USB device, used to find it again under another
+	// port name after it was plugged in again
+	identity *enumerator.PortDetails
+
+	reconnectInterval time.Duration
+	events            func(ConnectionEvent) // may be nil
+
+	// handler serves requests of the device, see UartConnector.Handler
+	handler Handler
+
+	// loop handles received packets, receiveLoop if nil. A bus replaces
+	// it to dispatch packets by device address, see rs485Bus.
+	loop func(ctx context.Context, conn Connection)
+
+	// idleTimeout closes the connection when it was not used for this
+	// time, 0 keeps it open
+	idleTimeout time.Duration
+	// inUse reports whether the connection has active interactions,
+	// replaced by a bus to check its devices
+	inUse func() bool
+
+	// messageIds of the port, shared by all connections of a connector
+	// so IDs are not reused after reconnecting
+	messageIds        *hostMessageIds
+	messageIdLifetime time.Duration
+
+	mu          sync.Mutex // Guards the fields below
+	state       ConnectionState
+	mode        Mode
+	portName    string
+	port        serial.Port
+	reader      PacketReader
+	writer      PacketWriter
+	packetStart time.Time // Start of the packet that is currently received
+	lastActive  time.Time // Last packet sent or received
 
 	cancelReceiveLoop context.CancelFunc
+	cancelSupervisor  context.CancelFunc
+
+	readMu  sync.Mutex // Serializes reads
+	writeMu sync.Mutex // Serializes writes
 
-	readMu  sync.Mutex // Guards the reader
-	writeMu sync.Mutex // Guards the writer
+	wg sync.WaitGroup // Receive loop and supervisor
 }
 
-var ERR_CONNECTION_CLOSED = errors.New("Connection is closed")
+// Deprecated: Use ErrConnectionClosed
+var ERR_CONNECTION_CLOSED = ErrConnectionClosed
 
-func newSerialConnection(portName string, mode *serial.Mode) *serialConnection {
-	if mode == nil {
-		panic("serial config must not be nil")
-	}
+func newSerialConnection(portName string, mode Mode) *serialConnection {
 	return &serialConnection{
-		portName: portName,
-		mode:     mode,
+		portName:   portName,
+		mode:       mode,
+		state:      StateClosed,
+		messageIds: &hostMessageIds{},
 	}
 }
 
+// ids returns the message IDs of the endpoint key, e.g. the port name
+func (c *serialConnection) ids(key string) *MessageIds {
+	return c.messageIds.get(key, c.messageIdLifetime)
+}
+
+func (c *serialConnection) nextMessageId(ctx context.Context) (MessageId, error) {
+	c.mu.Lock()
+	portName := c.portName
+	c.mu.Unlock()
+	return c.ids(portName).Next(ctx)
+}
+
+func (c *serialConnection) requestHandler() (Handler, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.handler, c.portName
+}
+
+// setPort must be called with c.mu held
 func (c *serialConnection) setPort(port serial.Port) {
 	c.port = port
-	c.reader = slip.NewReader(port)
-	c.writer = slip.NewWriter(port)
+	c.lastActive = time.Now()
+	framer := c.framer()
+	c.reader = framer.NewReader(port)
+	c.writer = framer.NewWriter(port)
+	c.packetStart = time.Time{}
+}
+
+// framer must be called with c.mu held
+func (c *serialConnection) framer() Framer {
+	if c.mode.Framer == nil {
+		return SLIPFramer{}
+	}
+	return c.mode.Framer
+}
+
+// setMode changes the serial parameters of an open connection
+func (c *serialConnection) setMode(mode Mode) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if mode == c.mode {
+		return nil
+	}
+	log.WithField("port", c.portName).
+		WithField("baud", mode.Baud).
+		Info("Reconfigure serial port")
+	if c.port != nil {
+		if err := c.port.SetMode(newSerialMode(mode)); err != nil {
+			return fmt.Errorf("Failed to configure serial port: %w", err)
+		}
+	}
+	framingChanged := mode.Framer != c.mode.Framer
+	c.mode = mode
+	if framingChanged && c.port != nil {
+		c.setPort(c.port)
+	}
+	return nil
+}
+
+func (c *serialConnection) info() ConnectionInfo {
+	interactions := c.count()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ConnectionInfo{
+		Host:         c.host,
+		Port:         c.portName,
+		State:        c.state,
+		Mode:         c.mode,
+		Interactions: interactions,
+		LastActive:   c.lastActive,
+	}
+}
+
+// State returns the current state of the connection
+func (c *serialConnection) State() ConnectionState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
 }
 
 func (c *serialConnection) Open() error {
-	// TODO: not sure what happens when we reopen a closed connection
-	oldName := c.portName
-	port, newPortName, err := openComPort(c.portName, c.mode)
-	c.portName = newPortName
-	log.WithField("originalPort", oldName).
+	c.mu.Lock()
+	port, err := openComPort(c.portName, newSerialMode(c.mode))
+	log.WithField("host", c.host).
 		WithField("port", c.portName).
-		WithField("baud", c.mode.BaudRate).
+		WithField("baud", c.mode.Baud).
 		Info("Opening serial port ...")
 
 	if err != nil {
-		return wrapError(err, "Failed to open serial port")
+		c.mu.Unlock()
+		return fmt.Errorf("Failed to open serial port: %w", err)
 	}
 
 	c.setPort(port)
-	c.open = true // Now we can actually send and receive data
+	c.state = StateConnected // Now we can actually send and receive data
+	c.startReceiveLoop()
+	supervisorCtx, cancelSupervisor := context.WithCancel(context.Background())
+	c.cancelSupervisor = cancelSupervisor
+	portName := c.portName
+	c.wg.Add(1)
+	c.mu.Unlock()
+
+	if c.identity == nil {
+		c.identity = portDetails(portName)
+	}
+	go func() {
+		defer c.wg.Done()
+		c.supervise(supervisorCtx)
+	}()
+	c.emit(StateConnected, nil)
+	return nil
+}
 
+// startReceiveLoop must be called with c.mu held
+func (c *serialConnection) startReceiveLoop() {
 	receiveLoopCtx, cancelReceiveLoop := context.WithCancel(context.Background())
 	c.cancelReceiveLoop = cancelReceiveLoop
-	go receiveLoop(receiveLoopCtx, c)
-	go c.keepAlive()
-	return nil
+	loop := c.loop
+	if loop == nil {
+		loop = receiveLoop
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		loop(receiveLoopCtx, c)
+	}()
+}
+
+// idle reports whether the connection was not used for the idle timeout
+func (c *serialConnection) idle() bool {
+	if c.idleTimeout <= 0 {
+		return false
+	}
+	inUse := c.inUse
+	if inUse == nil {
+		inUse = func() bool { return c.count() > 0 }
+	}
+	if inUse() {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Since(c.lastActive) > c.idleTimeout
+}
+
+func (c *serialConnection) emit(state ConnectionState, err error) {
+	if c.events == nil {
+		return
+	}
+	c.mu.Lock()
+	portName := c.portName
+	c.mu.Unlock()
+	c.events(ConnectionEvent{Host: c.host, Port: portName, State: state, Err: err})
 }
 
-func (c *serialConnection) keepAlive() {
+// supervise detects removal of the device and reconnects when it
+// reappears until the connection is closed. Idle connections are closed.
+func (c *serialConnection) supervise(ctx context.Context) {
+	interval := c.reconnectInterval
+	if interval <= 0 {
+		interval = DefaultReconnectInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
 	for {
-		time.Sleep(30 * time.Second)
-		if c.Closed() {
-			log.Info("Serial port closed. Stop keep alive.")
+		select {
+		case <-ctx.Done():
 			return
+		case <-ticker.C:
 		}
 
-		err := c.reopenSerialPort()
-		if err != nil {
-			log.WithError(err).Error("Failed to reopen serial port. Closing connection.")
-
-			err := c.Close()
-			if err != nil {
-				log.WithError(err).Error("Failed to close connection.")
-			}
-
+		if c.idle() {
+			log.WithField("port", c.portName).Info("Closing idle serial connection")
+			c.close()
 			return
 		}
+		switch c.State() {
+		case StateConnected:
+			c.mu.Lock()
+			portName := c.portName
+			c.mu.Unlock()
+			if !portExists(portName) {
+				c.disconnect(ErrDeviceRemoved)
+			}
+		case StateDisconnected:
+			c.reconnect()
+		}
+	}
+}
+
+// disconnect closes the port after the device was removed or failed.
+// Pending interactions fail with ErrConnectionClosed, observations are
+// kept and registered again after reconnecting.
+func (c *serialConnection) disconnect(cause error) {
+	c.mu.Lock()
+	if c.state != StateConnected {
+		c.mu.Unlock()
+		return
+	}
+	c.state = StateDisconnected
+	c.cancelReceiveLoop()
+	port, portName := c.port, c.portName
+	c.mu.Unlock()
 
+	log.WithError(cause).WithField("port", portName).Warn("Serial device lost, waiting for it to reappear")
+	if err := port.Close(); err != nil {
+		log.WithError(err).Debug("Failed to close lost serial port")
 	}
+	c.failInteractions(ErrConnectionClosed)
+	c.emit(StateDisconnected, cause)
 }
 
-func (c *serialConnection) reopenSerialPort() error {
-	c.readMu.Lock()
-	c.writeMu.Lock()
-	defer c.readMu.Unlock()
-	defer c.writeMu.Unlock()
+// reconnect reopens the port if the device is present again
+func (c *serialConnection) reconnect() {
+	c.mu.Lock()
+	portName, mode := c.portName, c.mode
+	c.mu.Unlock()
 
-	log.WithField("port", c.portName).Info("Reopen serial port")
-	// Close and reopen serial port
-	err := c.port.Close()
+	name, ok := c.locate(portName)
+	if !ok {
+		return
+	}
+	port, err := openComPort(name, newSerialMode(mode))
 	if err != nil {
-		return err
+		log.WithError(err).WithField("port", name).Debug("Failed to reopen serial port")
+		return
 	}
 
-	port, _, err := openComPort(c.portName, c.mode)
+	c.mu.Lock()
+	if c.state != StateDisconnected {
+		// Closed in the meantime
+		c.mu.Unlock()
+		port.Close()
+		return
+	}
+	c.portName = name
+	c.setPort(port)
+	c.state = StateConnected
+	c.startReceiveLoop()
+	c.mu.Unlock()
+
+	log.WithField("port", name).Info("Serial device reconnected")
+	c.emit(StateConnected, nil)
+	reobserve(c, &c.Interactions)
+}
+
+// locate returns the port name of the device. After a replug, USB
+// devices might show up under another name.
+func (c *serialConnection) locate(portName string) (string, bool) {
+	if portExists(portName) {
+		return portName, true
+	}
+	ports, err := listPorts()
 	if err != nil {
-		return err
+		return "", false
 	}
+	if id := c.identity; id != nil && id.SerialNumber != "" {
+		for _, p := range ports {
+			if p.IsUSB && p.SerialNumber == id.SerialNumber && strings.EqualFold(p.VID, id.VID) && strings.EqualFold(p.PID, id.PID) {
+				return p.Name, true
+			}
+		}
+	}
+	if match, ok := portMatcher(c.host); ok && c.host != HostAny {
+		for _, p := range ports {
+			if match(p) {
+				return p.Name, true
+			}
+		}
+	}
+	return "", false
+}
 
-	c.setPort(port)
+// idConnection is a connection that allocates message IDs
+type idConnection interface {
+	Connection
+	messageIdSource
+}
 
-	return nil
+// reobserve registers all observations of the connection again, so the
+// server continues to send notifications with the same token
+func reobserve(conn idConnection, ias *Interactions) {
+	for _, ia := range ias.observingInteractions() {
+		msg := ia.req
+		ctx, cancel := context.WithTimeout(context.Background(), ackTimeout())
+		msgId, err := conn.nextMessageId(ctx)
+		cancel()
+		if err != nil {
+			log.WithError(err).WithField("token", ia.Token()).Warn("Failed to re-register observation")
+			continue
+		}
+		msg.MessageID = uint16(msgId)
+		trackMessage(conn, ia, msgId, msg.Type)
+		if err := sendMessage(conn, &msg); err != nil {
+			log.WithError(err).WithField("token", ia.Token()).Warn("Failed to re-register observation")
+			continue
+		}
+		log.WithField("token", ia.Token()).Info("Re-registered observation")
+	}
 }
 
 func (c *serialConnection) ReadPacket() (p []byte, isPrefix bool, err error) {
 	c.readMu.Lock()
 	defer c.readMu.Unlock()
 
-	if c.Closed() {
+	c.mu.Lock()
+	state, reader := c.state, c.reader
+	c.mu.Unlock()
+	if state != StateConnected {
 		err = ERR_CONNECTION_CLOSED
 		return
 	}
 
-	p, isPrefix, err = c.reader.ReadPacket()
+	p, isPrefix, err = reader.ReadPacket()
+	if err != nil {
+		// Reading from a serial port only fails when the device is gone
+		// or the port was closed
+		c.disconnect(err)
+		return nil, false, fmt.Errorf("coap: read failed: %v: %w", err, ErrConnectionClosed)
+	}
 
-	// if !isPrefix {
-	// 	log.Info("Flush on ReadPacket")
-	// 	err = c.port.Flush()
-	// 	if err != nil {
-	// 		return
-	// 	}
-	// }
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastActive = time.Now()
+	// Drop packets that are not completed within the read timeout,
+	// e.g. after a device reset in the middle of a packet. The COBS and
+	// HDLC readers keep the partial frame and return no data.
+	if !isPrefix {
+		c.packetStart = time.Time{}
+	} else if c.packetStart.IsZero() {
+		c.packetStart = time.Now()
+	} else if c.mode.ReadTimeout > 0 && time.Since(c.packetStart) > c.mode.ReadTimeout {
+		c.reader = c.framer().NewReader(c.port)
+		c.packetStart = time.Time{}
+		return nil, false, fmt.Errorf("coap: incomplete packet after %v: %w", c.mode.ReadTimeout, ErrTimeout)
+	}
 
 	return
 }
@@ -142,114 +427,109 @@ func (c *serialConnection) WritePacket(p []byte) (err error) {
 	c.writeMu.Lock()
 	defer c.writeMu.Unlock()
 
-	if c.Closed() {
+	c.mu.Lock()
+	state, writer := c.state, c.writer
+	c.mu.Unlock()
+	if state != StateConnected {
 		err = ERR_CONNECTION_CLOSED
 		return
 	}
 
 	// We must NOT flush before writing, since it would cancel ongoing receiving at least on windows
-	if err != nil {
-		return
+	if err = writer.WritePacket(p); err != nil {
+		c.disconnect(err)
+		return fmt.Errorf("coap: write failed: %v: %w", err, ErrConnectionClosed)
 	}
-	err = c.writer.WritePacket(p)
+	c.mu.Lock()
+	c.lastActive = time.Now()
+	c.mu.Unlock()
+	return nil
+}
 
-	return
+// Close closes the port and all interactions, including observations.
+// It returns after the receive loop and the supervisor stopped.
+func (c *serialConnection) Close() error {
+	err := c.close()
+
+	stopped := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(closeTimeout):
+		// Some serial drivers do not interrupt a pending read on close
+		log.WithField("host", c.host).Warn("Receive loop did not stop after closing the serial port")
+	}
+	return err
 }
 
-func (c *serialConnection) Close() (err error) {
-	c.open = false
+// close closes the connection without waiting for its goroutines
+func (c *serialConnection) close() (err error) {
+	c.mu.Lock()
+	state := c.state
+	c.state = StateClosed
+	if c.cancelReceiveLoop != nil {
+		c.cancelReceiveLoop()
+	}
+	if c.cancelSupervisor != nil {
+		c.cancelSupervisor()
+	}
+	port := c.port
+	c.mu.Unlock()
 
-	c.cancelReceiveLoop()
-	if c.port != nil {
-		err = c.port.Close()
+	if port != nil && state == StateConnected {
+		err = port.Close()
+	}
+	if state != StateClosed {
+		c.closeInteractions(ErrConnectionClosed)
+		c.emit(StateClosed, nil)
 	}
 	return
 }
 
 func (c *serialConnection) Closed() bool {
-	return !c.open
-}
-
-// Last successful "any" port. Will be tried first before iterating
-var lastAny = ""
-
-// When portName is "any" the first available port is opened
-// the new port name is returned as newPortName
-func openComPort(portName string, mode *serial.Mode) (port serial.Port, newPortName string, err error) {
-	// Search for serial port. Not needed for bugst/serial
-	//
-	// if serialCfg.Name == "any" {
-	// 	if lastAny != "" {
-	// 		serialCfg.Name = lastAny
-	// 		port, err = serial.OpenPort(serialCfg)
-	// 		if err == nil {
-	// 			return
-	// 		}
-	// 	}
-	// 	if isWindows() {
-	// 		for i := 0; i < 99; i++ {
-	// 			serialCfg.Name = fmt.Sprintf("COM%d", i)
-	// 			port, err = serial.OpenPort(serialCfg)
-	// 			if err == nil {
-	// 				lastAny = serialCfg.Name
-	// 				//logrus.WithField("comport", serialCfg.Name).Info("Resolved host 'any'")
-	// 				return
-	// 			}
-	//
-	// 		}
-	// 	} else {
-	// 		for i := 0; i < 99; i++ {
-	// 			serialCfg.Name = fmt.Sprintf("/dev/tty%d", i)
-	// 			port, err = serial.OpenPort(serialCfg)
-	// 			if err == nil {
-	// 				lastAny = serialCfg.Name
-	// 				//logrus.WithField("comport", serialCfg.Name).Info("Resolved host 'any'")
-	// 				return
-	// 			}
-	// 		}
-	// 		for i := 0; i < 99; i++ {
-	// 			serialCfg.Name = fmt.Sprintf("/dev/ttyS%d", i)
-	// 			port, err = serial.OpenPort(serialCfg)
-	// 			if err == nil {
-	// 				lastAny = serialCfg.Name
-	// 				//logrus.WithField("comport", serialCfg.Name).Info("Resolved host 'any'")
-	// 				return
-	// 			}
-	// 		}
-	// 		for i := 0; i < 10; i++ {
-	// 			serialCfg.Name = fmt.Sprintf("/dev/ttyUSB%d", i)
-	// 			port, err = serial.OpenPort(serialCfg)
-	// 			if err == nil {
-	// 				lastAny = serialCfg.Name
-	// 				//logrus.WithField("comport", serialCfg.Name).Info("Resolved host 'any'")
-	// 				return
-	// 			}
-	// 		}
-	// 	}
-	//
-	// 	err = errors.New(fmt.Sprint("coap: Failed to find usable serial port: ", err.Error()))
-	// 	return
-	// }
-	if portName == "any" {
-		portNames, err := serial.GetPortsList()
-		if err != nil {
-			return nil, portName, err
-		}
+	return c.State() == StateClosed
+}
+
+// openPort opens a serial port, replaced in tests
+var openPort = serial.Open
 
-		if len(portNames) > 0 {
-			newPortName = portNames[0]
+// openComPort opens the serial port with the given name. Hosts like
+// "any" are resolved to port names by the connector, see HostAny.
+func openComPort(portName string, mode *serial.Mode) (serial.Port, error) {
+	return openPort(portName, mode)
+}
+
+// portExists reports whether the serial device is present
+func portExists(portName string) bool {
+	if !isWindows() {
+		_, err := os.Stat(portName)
+		return err == nil
+	}
+	ports, err := listPorts()
+	if err != nil {
+		return true // unknown, opening the port will tell
+	}
+	for _, p := range ports {
+		if p.Name == portName {
+			return true
 		}
-	} else {
-		newPortName = portName
 	}
+	return false
+}
 
-	port, err = serial.Open(newPortName, mode)
+// portDetails returns the USB identity of a port or nil
+func portDetails(portName string) *enumerator.PortDetails {
+	ports, err := listPorts()
 	if err != nil {
-		return nil, newPortName, err
+		return nil
 	}
-	// err = port.Flush()
-	// if err != nil {
-	// 	return nil, err
-	// }
-	return
+	for _, p := range ports {
+		if p.Name == portName && p.IsUSB {
+			return p
+		}
+	}
+	return nil
 }
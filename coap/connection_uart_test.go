@@ -0,0 +1,302 @@
+package coap
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Lobaro/slip"
+	"github.com/trusch/coap-go/coapmsg"
+	"go.bug.st/serial.v1"
+)
+
+// fakePort is a serial port backed by an in-memory pipe
+type fakePort struct {
+	serial.Port
+	conn net.Conn
+}
+
+func (p fakePort) Read(b []byte) (int, error)      { return p.conn.Read(b) }
+func (p fakePort) Write(b []byte) (int, error)     { return p.conn.Write(b) }
+func (p fakePort) Close() error                    { return p.conn.Close() }
+func (p fakePort) SetMode(mode *serial.Mode) error { return nil }
+
+// withFakePorts replaces openPort, the device side of every opened port
+// is sent to the returned channel
+func withFakePorts(t *testing.T) <-chan net.Conn {
+	devices := make(chan net.Conn, 4)
+	openPort = func(name string, mode *serial.Mode) (serial.Port, error) {
+		host, device := net.Pipe()
+		devices <- device
+		return fakePort{conn: host}, nil
+	}
+	t.Cleanup(func() { openPort = serial.Open })
+	return devices
+}
+
+func expectEvent(t *testing.T, events <-chan ConnectionEvent, state ConnectionState) ConnectionEvent {
+	t.Helper()
+	select {
+	case ev := <-events:
+		if ev.State != state {
+			t.Fatalf("Expected %s event but got %s", state, ev.State)
+		}
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected %s event", state)
+	}
+	return ConnectionEvent{}
+}
+
+func readDeviceMessage(t *testing.T, device net.Conn) coapmsg.Message {
+	t.Helper()
+	device.SetReadDeadline(time.Now().Add(2 * time.Second))
+	p, _, err := slip.NewReader(device).ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := coapmsg.ParseMessage(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestSerialConnectionHotPlug(t *testing.T) {
+	devices := withFakePorts(t)
+	dev := filepath.Join(t.TempDir(), "ttyUSB0")
+	if err := os.WriteFile(dev, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan ConnectionEvent, 10)
+	conn := newSerialConnection(dev, Mode{Baud: 115200})
+	conn.reconnectInterval = 10 * time.Millisecond
+	conn.events = func(ev ConnectionEvent) { events <- ev }
+	if err := conn.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	expectEvent(t, events, StateConnected)
+	device := <-devices
+
+	// An observation that must survive the reconnect
+	observe := coapmsg.NewMessage()
+	observe.Type = coapmsg.Confirmable
+	observe.Code = coapmsg.GET
+	observe.Token = []byte("ob")
+	observe.SetPathString("/sensor")
+	observe.Options().Set(coapmsg.Observe, 0)
+	observer := startInteraction(conn, &observe)
+	observer.NotificationCh = make(chan *coapmsg.Message)
+
+	// A request waiting for its response while the device is unplugged
+	req := coapmsg.NewMessage()
+	req.Type = coapmsg.Confirmable
+	req.Code = coapmsg.GET
+	req.Token = []byte("rq")
+	req.MessageID = 1
+	req.SetPathString("/info")
+	pending := startInteraction(conn, &req)
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := pending.RoundTrip(context.Background(), &req)
+		errCh <- err
+	}()
+	if msg := readDeviceMessage(t, device); string(msg.Token) != "rq" {
+		t.Fatalf("Unexpected request token %q", msg.Token)
+	}
+
+	// Unplug
+	os.Remove(dev)
+	device.Close()
+	ev := expectEvent(t, events, StateDisconnected)
+	if ev.Port != dev || ev.Err == nil {
+		t.Errorf("Unexpected disconnect event %+v", ev)
+	}
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, ErrConnectionClosed) {
+			t.Errorf("Expected ErrConnectionClosed but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected pending round trip to fail")
+	}
+	if err := conn.WritePacket([]byte{0x40}); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("Expected write to fail with ErrConnectionClosed but got %v", err)
+	}
+	if conn.Closed() {
+		t.Error("Disconnected connection must not report closed")
+	}
+
+	// Plug in again
+	if err := os.WriteFile(dev, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, events, StateConnected)
+	device = <-devices
+	msg := readDeviceMessage(t, device)
+	if string(msg.Token) != "ob" || msg.Options().Get(coapmsg.Observe).IsNotSet() {
+		t.Errorf("Expected observe request to be sent again, got token %q", msg.Token)
+	}
+
+	conn.Close()
+	expectEvent(t, events, StateClosed)
+}
+
+func TestUartConnectorSubscribe(t *testing.T) {
+	withFakePorts(t)
+	dev := filepath.Join(t.TempDir(), "ttyACM0")
+	if err := os.WriteFile(dev, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewUartConnecter()
+	events := make(chan ConnectionEvent, 10)
+	c.Subscribe(events)
+	conn, err := c.ConnectMode(dev, Mode{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev := expectEvent(t, events, StateConnected); ev.Host != dev {
+		t.Errorf("Unexpected host %q", ev.Host)
+	}
+
+	c.Unsubscribe(events)
+	conn.Close()
+	select {
+	case ev := <-events:
+		t.Errorf("Unexpected event after unsubscribe %+v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestUartConnectorLifecycle(t *testing.T) {
+	withFakePorts(t)
+	dir := t.TempDir()
+	c := NewUartConnecter()
+	c.ReconnectInterval = time.Hour
+
+	var conns []Connection
+	for _, name := range []string{"ttyUSB0", "ttyUSB1"} {
+		dev := filepath.Join(dir, name)
+		if err := os.WriteFile(dev, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		c.Alias(name, dev)
+		conn, err := c.ConnectMode(name, Mode{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+	if infos := c.Connections(); len(infos) != 2 || infos[0].State != StateConnected || infos[0].Mode.Baud != 115200 {
+		t.Fatalf("Unexpected connections %+v", infos)
+	}
+
+	req := coapmsg.NewMessage()
+	req.Type = coapmsg.Confirmable
+	req.Code = coapmsg.GET
+	req.Token = []byte("rq")
+	ia := startInteraction(conns[0], &req)
+
+	c.CloseIdleConnections()
+	if !conns[1].Closed() || conns[0].Closed() {
+		t.Fatal("Expected only the idle connection to be closed")
+	}
+	infos := c.Connections()
+	if len(infos) != 1 || infos[0].Interactions != 1 {
+		t.Fatalf("Unexpected connections %+v", infos)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := ia.readMessage(context.Background())
+		errCh <- err
+	}()
+	start := time.Now()
+	if err := c.Close(); err != nil {
+		t.Error(err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("Expected receive loop and supervisor to stop on close")
+	}
+	if err := <-errCh; !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("Expected ErrConnectionClosed but got %v", err)
+	}
+	if len(c.Connections()) != 0 {
+		t.Error("Expected no connections after close")
+	}
+}
+
+func TestUartConnectorIdleTimeout(t *testing.T) {
+	withFakePorts(t)
+	dev := filepath.Join(t.TempDir(), "ttyUSB0")
+	if err := os.WriteFile(dev, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := NewUartConnecter()
+	c.ReconnectInterval = 10 * time.Millisecond
+	c.IdleTimeout = 50 * time.Millisecond
+	events := make(chan ConnectionEvent, 10)
+	c.Subscribe(events)
+	c.Alias("ttyUSB0", dev)
+
+	conn, err := c.ConnectMode("ttyUSB0", Mode{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, events, StateConnected)
+	expectEvent(t, events, StateClosed)
+	if !conn.Closed() {
+		t.Error("Expected idle connection to be closed")
+	}
+}
+
+func TestUartConnectorHandler(t *testing.T) {
+	devices := withFakePorts(t)
+	dev := filepath.Join(t.TempDir(), "ttyUSB0")
+	if err := os.WriteFile(dev, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := NewUartConnecter()
+	c.ReconnectInterval = time.Hour
+	c.Alias("ttyUSB0", dev)
+	remoteAddrs := make(chan string, 1)
+	c.Handler = HandlerFunc(func(w ResponseWriter, r *Request) {
+		remoteAddrs <- r.RemoteAddr
+		w.Write([]byte("on"))
+	})
+	if _, err := c.ConnectMode("ttyUSB0", Mode{}); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	device := <-devices
+	writer := slip.NewWriter(device)
+
+	req := coapmsg.NewMessage()
+	req.Type = coapmsg.Confirmable
+	req.Code = coapmsg.GET
+	req.MessageID = 7
+	req.Token = []byte("sv")
+	req.SetPathString("/3/0/1")
+	go writer.WritePacket(req.MustMarshalBinary())
+
+	res := readDeviceMessage(t, device)
+	if res.Type != coapmsg.Acknowledgement || res.MessageID != 7 || res.Code != coapmsg.Content || string(res.Payload) != "on" {
+		t.Fatalf("Expected piggybacked response but got %s %s %q", res.Type, res.Code.Dotted(), res.Payload)
+	}
+	if addr := <-remoteAddrs; addr != dev {
+		t.Errorf("Expected RemoteAddr %q but got %q", dev, addr)
+	}
+
+	req.Type = coapmsg.NonConfirmable
+	go writer.WritePacket(req.MustMarshalBinary())
+	if res := readDeviceMessage(t, device); res.Type != coapmsg.NonConfirmable || string(res.Token) != "sv" {
+		t.Errorf("Expected NON response but got %s", res.Type)
+	}
+}
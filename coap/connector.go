@@ -3,3 +3,11 @@ package coap
 type SerialConnecter interface {
 	Connect(host string) (Connection, error)
 }
+
+// ModeConnecter is implemented by connectors that accept serial
+// parameters per request, e.g. from coap+uart://ttyUSB1?baud=9600.
+// Unset fields of mode fall back to the configuration of the connector.
+type ModeConnecter interface {
+	SerialConnecter
+	ConnectMode(host string, mode Mode) (Connection, error)
+}
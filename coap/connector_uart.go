@@ -1,36 +1,179 @@
 package coap
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/trusch/coap-go/coapmsg"
 	"go.bug.st/serial.v1"
+	"go.bug.st/serial.v1/enumerator"
 )
 
+// DefaultSize is the number of data bits when no size is configured
+const DefaultSize = 8
+
+// Mode holds the parameters of a serial port. Unset (zero) fields fall
+// back to the next less specific configuration.
+type Mode struct {
+	Baud        int           // BaudRate
+	ReadTimeout time.Duration // Maximum time to receive a packet once its first bytes arrived
+	Size        byte          // Size is the number of data bits
+	Parity      Parity
+	StopBits    StopBits
+	Framer      Framer // Packet framing, nil means SLIP
+}
+
+// merge returns m with all unset fields taken from parent
+func (m Mode) merge(parent Mode) Mode {
+	if m.Baud == 0 {
+		m.Baud = parent.Baud
+	}
+	if m.ReadTimeout == 0 {
+		m.ReadTimeout = parent.ReadTimeout
+	}
+	if m.Size == 0 {
+		m.Size = parent.Size
+	}
+	if m.Parity == 0 {
+		m.Parity = parent.Parity
+	}
+	if m.StopBits == 0 {
+		m.StopBits = parent.StopBits
+	}
+	if m.Framer == nil {
+		m.Framer = parent.Framer
+	}
+	return m
+}
+
 type UartConnector struct {
 	connectMutex sync.Mutex
 	connections  []Connection
+	buses        []*rs485Bus
+	profiles     map[string]Mode   // by host
+	aliases      map[string]string // port names by host
+	lastPorts    map[string]string // port selected by host, see HostAny
+
+	// probePort replaces the CoAP ping on a port in tests
+	probePort func(portName string, mode Mode) bool
+
+	messageIds hostMessageIds // By port name, or port name and bus address
 
-	// UART parameters. In future we might want to configure this per port.
+	subscribersMu sync.Mutex
+	subscribers   []chan<- ConnectionEvent
+
+	// Default UART parameters for all ports, see Configure for
+	// parameters of a single port.
 	Baud        int           // BaudRate
-	ReadTimeout time.Duration // Total timeout
+	ReadTimeout time.Duration // Maximum time to receive a packet once its first bytes arrived. 0 means no limit.
 	Size        byte          // Size is the number of data bits. If 0, DefaultSize is used.
 	Parity      Parity        // Parity is the bit to use and defaults to ParityNone (no parity bit).
 	StopBits    StopBits      // Number of stop bits to use. Default is 1 (1 stop bit).
+	Framer      Framer        // Framer delimits packets on the wire. If nil, SLIPFramer is used.
+
+	// Probe enables probing of ports for hosts like "any": the first
+	// port answering a CoAP ping within ProbeTimeout is selected.
+	Probe        bool
+	ProbeTimeout time.Duration // If 0, DefaultProbeTimeout is used.
+
+	// ReconnectInterval is the interval to check for removed devices and
+	// to retry opening them. If 0, DefaultReconnectInterval is used.
+	ReconnectInterval time.Duration
+
+	// Timing of RS-485 buses, see ConnectMode. TurnTimeout is the time
+	// to wait for the answer of a device, if 0 DefaultTurnTimeout is used.
+	// Non-confirmable requests wait for a quarter of it. GuardTime is the
+	// minimum idle time of the bus before sending, if 0 the time of 3.5
+	// characters is used.
+	TurnTimeout time.Duration
+	GuardTime   time.Duration
+
+	// IdleTimeout closes connections without interactions that did not
+	// send or receive for this time. If 0, they stay open until Close.
+	IdleTimeout time.Duration
+
+	// MessageIdLifetime is the time before a message ID is used again
+	// for the same device. If 0, EXCHANGE_LIFETIME is used.
+	MessageIdLifetime time.Duration
+
+	// Handler serves requests that devices send on their own, e.g. the
+	// Device Management requests of a LwM2M server. The port name, or
+	// port name and bus address like "/dev/ttyS2:17", is passed as
+	// Request.RemoteAddr. If nil, such requests are answered with RST.
+	Handler Handler
 }
 
 func NewUartConnecter() *UartConnector {
 	return &UartConnector{
 		connectMutex: sync.Mutex{},
 		connections:  make([]Connection, 0),
+		profiles:     make(map[string]Mode),
+		lastPorts:    make(map[string]string),
 		Baud:         115200,
 		Parity:       ParityNone,
-		Size:         0,                      // TODO: Unused?
-		ReadTimeout:  time.Millisecond * 500, // TODO: Unused?
+		Size:         DefaultSize,
 		StopBits:     Stop1,
 	}
 }
 
+// Configure sets the serial parameters of a single port, e.g.
+// connector.Configure("ttyUSB1", Mode{Baud: 9600}). Unset fields use
+// the defaults of the connector. The host is the host of the request
+// URL. Open connections are reconfigured on their next request.
+func (c *UartConnector) Configure(host string, mode Mode) {
+	c.connectMutex.Lock()
+	defer c.connectMutex.Unlock()
+	if c.profiles == nil {
+		c.profiles = make(map[string]Mode)
+	}
+	c.profiles[host] = mode
+}
+
+// Alias maps host to the serial port with the given name, e.g. for
+// paths that can not be part of a URL like
+// connector.Alias("modem", "/dev/serial/by-id/usb-FTDI_FT232R-if00-port0").
+func (c *UartConnector) Alias(host, portName string) {
+	c.connectMutex.Lock()
+	defer c.connectMutex.Unlock()
+	if c.aliases == nil {
+		c.aliases = make(map[string]string)
+	}
+	c.aliases[host] = portName
+}
+
+// resolveHost returns the port name of host. Hosts that select a port
+// by its properties return a matcher instead, see portMatcher.
+// Must be called with c.connectMutex held.
+func (c *UartConnector) resolveHost(host string) (string, func(*enumerator.PortDetails) bool, bool) {
+	if portName, ok := c.aliases[host]; ok {
+		return portName, nil, false
+	}
+	if match, ok := portMatcher(host); ok {
+		return host, match, true
+	}
+	if isWindows() {
+		return host, nil, false
+	}
+	return "/dev/" + host, nil, false
+}
+
+// mode returns the serial parameters for host. The override takes
+// precedence over the profile of the host and the connector defaults.
+func (c *UartConnector) mode(host string, override Mode) Mode {
+	defaults := Mode{
+		Baud:        c.Baud,
+		ReadTimeout: c.ReadTimeout,
+		Size:        c.Size,
+		Parity:      c.Parity,
+		StopBits:    c.StopBits,
+		Framer:      c.Framer,
+	}
+	return override.merge(c.profiles[host].merge(defaults))
+}
+
 func serialStopBits(bits StopBits) serial.StopBits {
 	switch bits {
 	case Stop1:
@@ -61,45 +204,67 @@ func serialParity(parity Parity) serial.Parity {
 	return serial.NoParity
 }
 
-func (c *UartConnector) newSerialMode() *serial.Mode {
+func newSerialMode(mode Mode) *serial.Mode {
+	size := int(mode.Size)
+	if size == 0 {
+		size = DefaultSize
+	}
 	return &serial.Mode{
-		BaudRate: c.Baud,
-		Parity:   serialParity(c.Parity),
-		StopBits: serialStopBits(c.StopBits),
-		DataBits: 8,
+		BaudRate: mode.Baud,
+		Parity:   serialParity(mode.Parity),
+		StopBits: serialStopBits(mode.StopBits),
+		DataBits: size,
 	}
 }
 
 func (c *UartConnector) Connect(host string) (Connection, error) {
+	return c.ConnectMode(host, Mode{})
+}
+
+// ConnectMode opens or reuses the connection to host with the given
+// serial parameters, see ModeConnecter
+//
+// A host with an address like ttyS2:17 selects device 17 on an RS-485
+// bus at ttyS2. All devices on the bus share the serial connection, the
+// serial parameters are configured for the port host, e.g. ttyS2.
+func (c *UartConnector) ConnectMode(host string, override Mode) (Connection, error) {
 	c.connectMutex.Lock()
 	defer c.connectMutex.Unlock()
 
-	serialMode := c.newSerialMode()
-	portName := ""
-	if host == "any" {
-		portName = host
-	} else if !isWindows() {
-		portName = "/dev/" + host
-	} else {
-		portName = host
+	if portHost, addr, ok := splitBusAddress(host); ok {
+		return c.connectBus(portHost, addr, override)
+	}
+	for _, b := range c.buses {
+		if !b.conn.Closed() && b.conn.host == host {
+			return nil, fmt.Errorf("coap: serial port %s is used as RS-485 bus, address a device like %s:1", host, host)
+		}
 	}
 
+	mode := c.mode(host, override)
+	portName, match, byProperties := c.resolveHost(host)
+
 	// can recycle connection?
-	for i, con := range c.connections {
-		if con.Closed() {
-			c.connections = deleteConnection(c.connections, i)
-			continue
-		}
+	if sc := c.reusableConnection(host, portName); sc != nil {
+		return sc, sc.setMode(mode)
+	}
 
-		if c, ok := con.(*serialConnection); (ok && c.portName == portName) || portName == "any" {
-			// TODO: Should we force a reopen or flush here? It already happened that we received old garbage.
-			log.WithField("Port", c.portName).Info("Reuseing Serial Port")
-			return c, nil
+	if byProperties {
+		var err error
+		portName, err = c.selectPort(host, match, mode)
+		if err != nil {
+			return nil, err
+		}
+		// Another request may have opened the port while probing
+		if sc := c.reusableConnection(host, portName); sc != nil {
+			return sc, sc.setMode(mode)
+		}
+		if c.portInUse(portName) {
+			return nil, fmt.Errorf("coap: serial port %s is in use", portName)
 		}
 	}
 
 	// Else open a new connection
-	conn := newSerialConnection(portName, serialMode)
+	conn := c.newConnection(host, portName, mode)
 	c.connections = append(c.connections, conn)
 	err := conn.Open()
 	if err != nil {
@@ -108,3 +273,310 @@ func (c *UartConnector) Connect(host string) (Connection, error) {
 
 	return conn, nil
 }
+
+// reusableConnection returns the open connection to host or portName,
+// nil if there is none. Must be called with c.connectMutex held.
+func (c *UartConnector) reusableConnection(host, portName string) *serialConnection {
+	c.prune()
+	for _, con := range c.connections {
+		if sc, ok := con.(*serialConnection); ok && (sc.info().Port == portName || sc.host == host || host == HostAny) {
+			// TODO: Should we force a reopen or flush here? It already happened that we received old garbage.
+			log.WithField("Port", sc.info().Port).Info("Reuseing Serial Port")
+			return sc
+		}
+	}
+	return nil
+}
+
+func (c *UartConnector) newConnection(host, portName string, mode Mode) *serialConnection {
+	conn := newSerialConnection(portName, mode)
+	conn.host = host
+	conn.reconnectInterval = c.ReconnectInterval
+	conn.idleTimeout = c.IdleTimeout
+	conn.events = c.publish
+	conn.messageIds = &c.messageIds
+	conn.messageIdLifetime = c.MessageIdLifetime
+	conn.handler = c.Handler
+	return conn
+}
+
+// connectBus opens or reuses the bus at host and returns the connection
+// to the device with addr. Must be called with c.connectMutex held.
+func (c *UartConnector) connectBus(host string, addr byte, override Mode) (Connection, error) {
+	mode := c.mode(host, override)
+
+	if b := c.openBus(host); b != nil {
+		if err := b.conn.setMode(mode); err != nil {
+			return nil, err
+		}
+		return b.endpoint(addr)
+	}
+	for _, con := range c.connections {
+		if sc, ok := con.(*serialConnection); ok && !sc.Closed() && sc.host == host {
+			return nil, fmt.Errorf("coap: serial port %s is in use without bus addresses", host)
+		}
+	}
+
+	portName, match, byProperties := c.resolveHost(host)
+	if byProperties {
+		var err error
+		portName, err = c.selectPort(host, match, mode)
+		if err != nil {
+			return nil, err
+		}
+		// Another request may have opened the bus while probing
+		if b := c.openBus(host); b != nil {
+			if err := b.conn.setMode(mode); err != nil {
+				return nil, err
+			}
+			return b.endpoint(addr)
+		}
+		if c.portInUse(portName) {
+			return nil, fmt.Errorf("coap: serial port %s is in use", portName)
+		}
+	}
+
+	conn := c.newConnection(host, portName, mode)
+	b := newRS485Bus(conn, c.TurnTimeout, c.GuardTime)
+	conn.events = func(ev ConnectionEvent) {
+		b.stateChanged(ev)
+		c.publish(ev)
+	}
+	if err := conn.Open(); err != nil {
+		return nil, err
+	}
+	c.buses = append(c.buses, b)
+	log.WithField("port", portName).WithField("address", addr).Info("Opened RS-485 bus")
+	return b.endpoint(addr)
+}
+
+// openBus returns the open bus at host, nil if there is none. Must be
+// called with c.connectMutex held.
+func (c *UartConnector) openBus(host string) *rs485Bus {
+	c.prune()
+	for _, b := range c.buses {
+		if b.conn.host == host {
+			return b
+		}
+	}
+	return nil
+}
+
+// prune removes closed connections. Must be called with c.connectMutex
+// held.
+func (c *UartConnector) prune() {
+	connections := c.connections[:0]
+	for _, con := range c.connections {
+		if !con.Closed() {
+			connections = append(connections, con)
+		}
+	}
+	for i := len(connections); i < len(c.connections); i++ {
+		c.connections[i] = nil
+	}
+	c.connections = connections
+
+	buses := c.buses[:0]
+	for _, b := range c.buses {
+		if !b.conn.Closed() {
+			buses = append(buses, b)
+		}
+	}
+	for i := len(buses); i < len(c.buses); i++ {
+		c.buses[i] = nil
+	}
+	c.buses = buses
+}
+
+// ConnectionInfo describes a connection of the connector, see Connections
+type ConnectionInfo struct {
+	Host         string // Host of the request URL, e.g. ttyUSB0 or any
+	Port         string // Name of the port, e.g. /dev/ttyUSB0
+	State        ConnectionState
+	Mode         Mode
+	Interactions int       // Active interactions including observations
+	LastActive   time.Time // Last packet sent or received
+	Addresses    []byte    // Connected devices of an RS-485 bus
+}
+
+// Connections returns a snapshot of the open connections
+func (c *UartConnector) Connections() []ConnectionInfo {
+	c.connectMutex.Lock()
+	defer c.connectMutex.Unlock()
+	c.prune()
+
+	infos := make([]ConnectionInfo, 0, len(c.connections)+len(c.buses))
+	for _, con := range c.connections {
+		if sc, ok := con.(*serialConnection); ok {
+			infos = append(infos, sc.info())
+		}
+	}
+	for _, b := range c.buses {
+		info := b.conn.info()
+		info.Addresses = b.addresses()
+		for _, ep := range b.allEndpoints() {
+			info.Interactions += ep.count()
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// CloseIdleConnections closes all connections without interactions,
+// also connections to devices on an RS-485 bus
+func (c *UartConnector) CloseIdleConnections() {
+	c.connectMutex.Lock()
+	defer c.connectMutex.Unlock()
+
+	for _, con := range c.connections {
+		if sc, ok := con.(*serialConnection); ok && sc.count() == 0 {
+			log.WithField("port", sc.info().Port).Info("Closing idle serial connection")
+			sc.Close()
+		}
+	}
+	for _, b := range c.buses {
+		b.closeIdle()
+	}
+	c.prune()
+}
+
+// Close closes all connections and waits for their goroutines to stop,
+// pending requests fail with ErrConnectionClosed. The connector opens
+// new connections when it is used again.
+func (c *UartConnector) Close() error {
+	c.connectMutex.Lock()
+	defer c.connectMutex.Unlock()
+
+	var firstErr error
+	for _, con := range c.connections {
+		if err := con.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, b := range c.buses {
+		if err := b.conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	c.prune()
+	return firstErr
+}
+
+// Subscribe relays state changes of all connections to ch, e.g. when a
+// USB adapter is unplugged and plugged in again. Events are dropped when
+// ch is not ready to receive, so use a buffered channel.
+func (c *UartConnector) Subscribe(ch chan<- ConnectionEvent) {
+	c.subscribersMu.Lock()
+	defer c.subscribersMu.Unlock()
+	c.subscribers = append(c.subscribers, ch)
+}
+
+// Unsubscribe stops relaying events to ch
+func (c *UartConnector) Unsubscribe(ch chan<- ConnectionEvent) {
+	c.subscribersMu.Lock()
+	defer c.subscribersMu.Unlock()
+	for i, sub := range c.subscribers {
+		if sub == ch {
+			c.subscribers = append(c.subscribers[:i], c.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (c *UartConnector) publish(ev ConnectionEvent) {
+	c.subscribersMu.Lock()
+	defer c.subscribersMu.Unlock()
+	for _, ch := range c.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			log.WithField("state", ev.State).Warn("Dropped connection event, subscriber not ready")
+		}
+	}
+}
+
+// Serial parameters that can be set in the query of a coap+uart URL.
+// They are removed from the Uri-Query options of the request.
+const (
+	QueryBaud        = "baud"        // e.g. baud=9600
+	QueryDataBits    = "databits"    // 5 to 8
+	QueryParity      = "parity"      // N, O, E, M or S
+	QueryStopBits    = "stopbits"    // 1, 1.5 or 2
+	QueryReadTimeout = "readtimeout" // Go duration, e.g. readtimeout=200ms
+	QueryFraming     = "framing"     // slip, cobs or hdlc
+)
+
+// takeSerialMode removes the serial parameters from the Uri-Query
+// options and returns them as Mode
+func takeSerialMode(opts coapmsg.CoapOptions) (Mode, error) {
+	var mode Mode
+	var rest []interface{}
+	for _, q := range opts[coapmsg.URIQuery] {
+		query := q.AsString()
+		name, value := query, ""
+		if i := strings.IndexByte(query, '='); i >= 0 {
+			name, value = query[:i], query[i+1:]
+		}
+
+		var err error
+		switch name {
+		case QueryBaud:
+			mode.Baud, err = strconv.Atoi(value)
+			if err == nil && mode.Baud <= 0 {
+				err = strconv.ErrRange
+			}
+		case QueryDataBits:
+			var size int
+			size, err = strconv.Atoi(value)
+			if err == nil && (size < 5 || size > 8) {
+				err = strconv.ErrRange
+			}
+			mode.Size = byte(size)
+		case QueryParity:
+			switch p := Parity(strings.ToUpper(value + " ")[0]); p {
+			case ParityNone, ParityOdd, ParityEven, ParityMark, ParitySpace:
+				mode.Parity = p
+			default:
+				err = strconv.ErrSyntax
+			}
+		case QueryStopBits:
+			switch value {
+			case "1":
+				mode.StopBits = Stop1
+			case "1.5":
+				mode.StopBits = Stop1Half
+			case "2":
+				mode.StopBits = Stop2
+			default:
+				err = strconv.ErrSyntax
+			}
+		case QueryReadTimeout:
+			mode.ReadTimeout, err = time.ParseDuration(value)
+		case QueryFraming:
+			switch strings.ToLower(value) {
+			case "slip":
+				mode.Framer = SLIPFramer{}
+			case "cobs":
+				mode.Framer = COBSFramer{}
+			case "hdlc":
+				mode.Framer = HDLCFramer{}
+			default:
+				err = strconv.ErrSyntax
+			}
+		default:
+			rest = append(rest, query)
+			continue
+		}
+		if err != nil {
+			return Mode{}, fmt.Errorf("coap: invalid serial parameter %q: %w", query, err)
+		}
+	}
+
+	opts.Del(coapmsg.URIQuery)
+	for _, q := range rest {
+		if err := opts.Add(coapmsg.URIQuery, q); err != nil {
+			return Mode{}, err
+		}
+	}
+	return mode, nil
+}
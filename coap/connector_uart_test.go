@@ -0,0 +1,86 @@
+package coap
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+func TestTakeSerialMode(t *testing.T) {
+	opts := coapmsg.CoapOptions{}
+	for _, q := range []string{"a=1", "baud=9600", "parity=e", "databits=7", "stopbits=2", "readtimeout=200ms", "framing=cobs", "b"} {
+		opts.Add(coapmsg.URIQuery, q)
+	}
+
+	mode, err := takeSerialMode(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Mode{Baud: 9600, Parity: ParityEven, Size: 7, StopBits: Stop2, ReadTimeout: 200 * time.Millisecond, Framer: COBSFramer{}}
+	if mode != expected {
+		t.Errorf("Expected %+v but got %+v", expected, mode)
+	}
+
+	query := opts[coapmsg.URIQuery]
+	if len(query) != 2 || query[0].AsString() != "a=1" || query[1].AsString() != "b" {
+		t.Errorf("Expected serial parameters to be removed from Uri-Query, got %v", query)
+	}
+
+	for _, q := range []string{"baud=fast", "databits=9", "parity=X", "stopbits=3", "readtimeout=1", "framing=ppp"} {
+		opts := coapmsg.CoapOptions{}
+		opts.Add(coapmsg.URIQuery, q)
+		if _, err := takeSerialMode(opts); err == nil {
+			t.Errorf("Expected error for %q", q)
+		}
+	}
+}
+
+func TestUartConnectorMode(t *testing.T) {
+	c := NewUartConnecter()
+	c.Configure("ttyUSB1", Mode{Baud: 9600, Parity: ParityEven})
+
+	if m := c.mode("ttyUSB0", Mode{}); m.Baud != 115200 || m.Parity != ParityNone || m.Size != DefaultSize || m.StopBits != Stop1 {
+		t.Errorf("Expected defaults for unconfigured port, got %+v", m)
+	}
+	if m := c.mode("ttyUSB1", Mode{}); m.Baud != 9600 || m.Parity != ParityEven || m.StopBits != Stop1 {
+		t.Errorf("Expected profile of ttyUSB1, got %+v", m)
+	}
+	if m := c.mode("ttyUSB1", Mode{Baud: 19200}); m.Baud != 19200 || m.Parity != ParityEven {
+		t.Errorf("Expected URL override, got %+v", m)
+	}
+
+	if m := newSerialMode(Mode{Baud: 9600}); m.DataBits != DefaultSize {
+		t.Errorf("Expected %d data bits but got %d", DefaultSize, m.DataBits)
+	}
+	if m := newSerialMode(Mode{Baud: 9600, Size: 7}); m.DataBits != 7 {
+		t.Errorf("Expected 7 data bits but got %d", m.DataBits)
+	}
+}
+
+// partialReader returns the start of a packet that never completes
+type partialReader struct{}
+
+func (partialReader) ReadPacket() ([]byte, bool, error) {
+	time.Sleep(5 * time.Millisecond)
+	return []byte{0x40}, true, nil
+}
+
+func TestSerialConnectionReadTimeout(t *testing.T) {
+	conn := newSerialConnection("ttyTest", Mode{ReadTimeout: 20 * time.Millisecond})
+	conn.reader = partialReader{}
+	conn.state = StateConnected
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		_, isPrefix, err := conn.ReadPacket()
+		if err != nil {
+			if !errors.Is(err, ErrTimeout) || isPrefix {
+				t.Fatal("Unexpected error", err)
+			}
+			return
+		}
+	}
+	t.Fatal("Expected timeout for incomplete packet")
+}
@@ -0,0 +1,132 @@
+package coap
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// DefaultEchoFreshness is the time an Echo value issued by an EchoHandler
+// is accepted as proof of freshness.
+const DefaultEchoFreshness = 30 * time.Second
+
+const (
+	echoTimeLen = 8
+	echoMACLen  = 8
+)
+
+// EchoHandler is a middleware that demands a proof of freshness
+// (RFC 9175, Section 2) for unsafe requests (POST, PUT, DELETE, PATCH, iPATCH).
+//
+// An unsafe request is only passed to Next if it carries an Echo option
+// that was issued to the same endpoint (Request.RemoteAddr) within the
+// Freshness period. Otherwise it is answered with 4.01 Unauthorized and
+// a new Echo value, which a Client automatically repeats the request with.
+// Safe requests (GET, FETCH) are always passed to Next. Unsafe requests without
+// RemoteAddr are answered with 5.00 Internal Server Error, since their
+// freshness can not be bound to an endpoint, see ServeMessageFrom.
+//
+// Echo values are self-contained: They consist of the issuing time and a
+// MAC over time and endpoint, so no state is kept per endpoint.
+// The random MAC key is created by NewEchoHandler or on first use.
+type EchoHandler struct {
+	Next Handler
+
+	// Freshness is the time an issued Echo value is accepted.
+	// If zero, DefaultEchoFreshness is used.
+	Freshness time.Duration
+
+	keyOnce sync.Once
+	key     []byte
+	now     func() time.Time
+}
+
+// NewEchoHandler returns an EchoHandler with a random MAC key
+func NewEchoHandler(next Handler) *EchoHandler {
+	return &EchoHandler{
+		Next: next,
+		key:  newEchoKey(),
+		now:  time.Now,
+	}
+}
+
+func newEchoKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func (h *EchoHandler) ServeCOAP(w ResponseWriter, r *Request) {
+	if code, ok := coapmsg.MethodCode(r.Method); ok && code.IsSafe() {
+		h.Next.ServeCOAP(w, r)
+		return
+	}
+	if r.RemoteAddr == "" {
+		log.Error("EchoHandler: Request.RemoteAddr is not set, rejecting unsafe request")
+		w.WriteCode(coapmsg.InternalServerError)
+		return
+	}
+	if h.verify(r) {
+		h.Next.ServeCOAP(w, r)
+		return
+	}
+
+	log.WithField("RemoteAddr", r.RemoteAddr).Debug("Request freshness unverified, sending Echo challenge")
+	w.Options().Set(coapmsg.Echo, h.issue(r.RemoteAddr))
+	w.WriteCode(coapmsg.Unauthorized)
+}
+
+func (h *EchoHandler) freshness() time.Duration {
+	if h.Freshness > 0 {
+		return h.Freshness
+	}
+	return DefaultEchoFreshness
+}
+
+func (h *EchoHandler) clock() time.Time {
+	if h.now != nil {
+		return h.now()
+	}
+	return time.Now()
+}
+
+// issue creates an Echo value for the endpoint
+func (h *EchoHandler) issue(remoteAddr string) []byte {
+	v := make([]byte, echoTimeLen, echoTimeLen+echoMACLen)
+	binary.BigEndian.PutUint64(v, uint64(h.clock().UnixNano()))
+	return append(v, h.mac(v, remoteAddr)...)
+}
+
+// verify checks that the request carries a fresh Echo value
+// that was issued to the sending endpoint
+func (h *EchoHandler) verify(r *Request) bool {
+	v := r.Options.Get(coapmsg.Echo).AsBytes()
+	if len(v) != echoTimeLen+echoMACLen {
+		return false
+	}
+	if !hmac.Equal(v[echoTimeLen:], h.mac(v[:echoTimeLen], r.RemoteAddr)) {
+		return false
+	}
+	issued := time.Unix(0, int64(binary.BigEndian.Uint64(v)))
+	age := h.clock().Sub(issued)
+	return age >= 0 && age <= h.freshness()
+}
+
+func (h *EchoHandler) mac(ts []byte, remoteAddr string) []byte {
+	h.keyOnce.Do(func() {
+		if h.key == nil {
+			h.key = newEchoKey()
+		}
+	})
+	m := hmac.New(sha256.New, h.key)
+	m.Write(ts)
+	m.Write([]byte(remoteAddr))
+	return m.Sum(nil)[:echoMACLen]
+}
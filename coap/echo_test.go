@@ -0,0 +1,253 @@
+package coap
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// handlerTransport passes client requests directly to a handler. Other
+// packages use coaptest.HandlerTransport, which can not be imported here.
+type handlerTransport struct {
+	handler    Handler
+	remoteAddr string
+
+	mu   sync.Mutex
+	reqs []*Request
+}
+
+func (t *handlerTransport) RoundTrip(req *Request) (*Response, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	req.closeBody()
+	if err != nil {
+		return nil, err
+	}
+	sreq := cloneRequest(req, body)
+	sreq.RemoteAddr = t.remoteAddr
+
+	t.mu.Lock()
+	t.reqs = append(t.reqs, cloneRequest(req, body))
+	t.mu.Unlock()
+
+	w := newResponseWriter()
+	t.handler.ServeCOAP(w, sreq)
+	if !w.codeWritten {
+		w.WriteCode(coapmsg.Content)
+	}
+	return buildResponse(req, &w.msg), nil
+}
+
+func TestClientRepeatsRequestWithEcho(t *testing.T) {
+	var payloads []string
+	h := NewEchoHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		payloads = append(payloads, string(body))
+		w.WriteCode(coapmsg.Changed)
+	}))
+	tr := &handlerTransport{handler: h, remoteAddr: "dev1"}
+	client := &Client{Transport: tr}
+
+	res, err := client.Post("coap+uart://any/led", coapmsg.TextPlain, bytes.NewBufferString("on"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != coapmsg.Changed {
+		t.Fatal("Expected 2.04 Changed but got", res.Status)
+	}
+	if len(tr.reqs) != 2 {
+		t.Fatal("Expected 2 requests but got", len(tr.reqs))
+	}
+	if tr.reqs[0].Options.Get(coapmsg.Echo).IsSet() {
+		t.Error("First request must not carry an Echo option")
+	}
+	if tr.reqs[1].Options.Get(coapmsg.Echo).IsNotSet() {
+		t.Error("Repeated request must carry the Echo option")
+	}
+	if len(payloads) != 1 || payloads[0] != "on" {
+		t.Errorf("Expected handler to get payload once, got %q", payloads)
+	}
+}
+
+func TestClientDoesNotRepeatWithoutEcho(t *testing.T) {
+	h := HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.WriteCode(coapmsg.Unauthorized)
+	})
+	tr := &handlerTransport{handler: h}
+	client := &Client{Transport: tr}
+
+	res, err := client.Post("coap+uart://any/led", coapmsg.TextPlain, bytes.NewBufferString("on"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != coapmsg.Unauthorized {
+		t.Error("Expected 4.01 Unauthorized but got", res.Status)
+	}
+	if len(tr.reqs) != 1 {
+		t.Error("Expected a single request but got", len(tr.reqs))
+	}
+}
+
+func TestClientCachesEcho(t *testing.T) {
+	h := NewEchoHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.WriteCode(coapmsg.Changed)
+	}))
+	tr := &handlerTransport{handler: h, remoteAddr: "dev1"}
+	client := &Client{Transport: tr}
+
+	for i := 0; i < 2; i++ {
+		res, err := client.Post("coap+uart://any/led", coapmsg.TextPlain, bytes.NewBufferString("on"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Code != coapmsg.Changed {
+			t.Fatal("Expected 2.04 Changed but got", res.Status)
+		}
+	}
+	if len(tr.reqs) != 3 {
+		t.Fatal("Expected only the first request to be repeated but got requests:", len(tr.reqs))
+	}
+	if tr.reqs[2].Options.Get(coapmsg.Echo).IsNotSet() {
+		t.Error("Expected second request to carry the cached Echo value")
+	}
+
+	if _, err := client.Get("coap+uart://any/led"); err != nil {
+		t.Fatal(err)
+	}
+	if tr.reqs[3].Options.Get(coapmsg.Echo).IsSet() {
+		t.Error("Safe request must not carry an Echo option")
+	}
+}
+
+func TestEchoHandler(t *testing.T) {
+	now := time.Unix(1000, 0)
+	calls := 0
+	h := NewEchoHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+		calls++
+	}))
+	h.now = func() time.Time { return now }
+
+	serve := func(method, remoteAddr string, echo []byte) *responseWriter {
+		req, _ := NewRequest("GET", "coap://dev/x", nil)
+		req.Method = method
+		req.RemoteAddr = remoteAddr
+		if echo != nil {
+			req.Options.Set(coapmsg.Echo, echo)
+		}
+		w := newResponseWriter()
+		h.ServeCOAP(w, req)
+		return w
+	}
+
+	if serve("GET", "a", nil); calls != 1 {
+		t.Error("Expected GET to pass without Echo")
+	}
+	if serve("FETCH", "a", nil); calls != 2 {
+		t.Error("Expected FETCH to pass without Echo")
+	}
+	calls = 1
+
+	w := serve("PUT", "a", nil)
+	if calls != 1 || w.msg.Code != coapmsg.Unauthorized {
+		t.Fatal("Expected unverified PUT to be answered with 4.01, got", w.msg.Code)
+	}
+	echo := w.msg.Options().Get(coapmsg.Echo).AsBytes()
+	if len(echo) == 0 || len(echo) > 40 {
+		t.Fatalf("Invalid Echo value %x", echo)
+	}
+
+	if serve("PUT", "b", echo); calls != 1 {
+		t.Error("Echo value must only be accepted from the endpoint it was issued to")
+	}
+	if serve("PUT", "a", append([]byte{}, echo[:len(echo)-1]...)); calls != 1 {
+		t.Error("Truncated Echo value must not be accepted")
+	}
+	if serve("PUT", "a", echo); calls != 2 {
+		t.Error("Expected PUT with fresh Echo to pass")
+	}
+
+	now = now.Add(DefaultEchoFreshness + time.Second)
+	if w := serve("DELETE", "a", echo); calls != 2 || w.msg.Code != coapmsg.Unauthorized {
+		t.Error("Expected stale Echo value to be rejected")
+	}
+}
+
+func TestEchoHandlerServeMessageFrom(t *testing.T) {
+	calls := 0
+	h := NewEchoHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+		calls++
+		w.WriteCode(coapmsg.Changed)
+	}))
+	put := func(echo []byte) *coapmsg.Message {
+		req := coapmsg.NewMessage()
+		req.Type = coapmsg.Confirmable
+		req.Code = coapmsg.PUT
+		req.SetPathString("/led")
+		if echo != nil {
+			req.Options().Set(coapmsg.Echo, echo)
+		}
+		return &req
+	}
+
+	res := ServeMessageFrom(h, put(nil), "10.0.0.1:5683")
+	echo := res.Options().Get(coapmsg.Echo).AsBytes()
+	if res.Code != coapmsg.Unauthorized || len(echo) == 0 {
+		t.Fatal("Expected 4.01 with Echo but got", res.Code)
+	}
+
+	// Replayed by another endpoint
+	if res := ServeMessageFrom(h, put(echo), "10.0.0.2:5683"); res.Code != coapmsg.Unauthorized || calls != 0 {
+		t.Error("Expected Echo value of another endpoint to be rejected but got", res.Code)
+	}
+	if res := ServeMessageFrom(h, put(echo), "10.0.0.1:5683"); res.Code != coapmsg.Changed || calls != 1 {
+		t.Error("Expected Echo value to be accepted from its endpoint but got", res.Code)
+	}
+
+	// Without the address of the endpoint freshness can not be verified
+	if res := ServeMessage(h, put(echo)); res.Code != coapmsg.InternalServerError || calls != 1 {
+		t.Error("Expected request without RemoteAddr to be rejected but got", res.Code)
+	}
+}
+
+func TestEchoHandlerWithoutConstructor(t *testing.T) {
+	calls := 0
+	h := &EchoHandler{Next: HandlerFunc(func(w ResponseWriter, r *Request) {
+		calls++
+		w.WriteCode(coapmsg.Changed)
+	})}
+	put := func(echo []byte) *Request {
+		req, _ := NewRequest("PUT", "coap://dev/led", nil)
+		req.RemoteAddr = "a"
+		if echo != nil {
+			req.Options.Set(coapmsg.Echo, echo)
+		}
+		return req
+	}
+
+	// Forged with the empty key of an uninitialized handler
+	forged := make([]byte, echoTimeLen, echoTimeLen+echoMACLen)
+	binary.BigEndian.PutUint64(forged, uint64(time.Now().UnixNano()))
+	m := hmac.New(sha256.New, nil)
+	m.Write(forged)
+	m.Write([]byte("a"))
+	forged = append(forged, m.Sum(nil)[:echoMACLen]...)
+
+	w := newResponseWriter()
+	h.ServeCOAP(w, put(forged))
+	if calls != 0 || w.msg.Code != coapmsg.Unauthorized {
+		t.Fatal("Expected Echo value of the empty key to be rejected but got", w.msg.Code)
+	}
+
+	echo := w.msg.Options().Get(coapmsg.Echo).AsBytes()
+	w = newResponseWriter()
+	h.ServeCOAP(w, put(echo))
+	if calls != 1 || w.msg.Code != coapmsg.Changed {
+		t.Error("Expected issued Echo value to be accepted but got", w.msg.Code)
+	}
+}
@@ -1,6 +1,46 @@
 package coap
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// Errors returned by the Client and Transport. Returned errors usually
+// wrap one of them with more context, use errors.Is to check for them.
+var (
+	// ErrTimeout is returned when the peer did not answer in time
+	ErrTimeout = errors.New("coap: timeout")
+
+	// ErrReset is returned when the peer rejected a message with RST
+	ErrReset = errors.New("coap: reset by peer")
+
+	// ErrConnectionClosed is returned when the connection was closed
+	// before or during an interaction
+	ErrConnectionClosed = errors.New("coap: connection closed")
+
+	// ErrDeviceRemoved is the cause of a disconnect when the serial
+	// device disappeared, e.g. an unplugged USB adapter
+	ErrDeviceRemoved = errors.New("coap: device removed")
+
+	// ErrNoSuchInteraction is returned when the interaction a message
+	// belongs to does not exist (anymore)
+	ErrNoSuchInteraction = errors.New("coap: no such interaction")
+
+	// ErrTokenInUse is returned when no token could be generated that is
+	// not used by another interaction on the connection
+	ErrTokenInUse = errors.New("coap: token in use")
+)
+
+// A ResponseError reports a 4.xx or 5.xx response code.
+// See Response.Err.
+type ResponseError struct {
+	Code coapmsg.COAPCode
+}
+
+func (e *ResponseError) Error() string {
+	return "coap: error response " + e.Code.Dotted() + " " + e.Code.String()
+}
 
 type coapError struct {
 	err     string
@@ -16,7 +56,9 @@ func (e *coapError) Timeout() bool {
 func (e *coapError) Temporary() bool {
 	return true
 }
-
-func wrapError(err error, msg string) error {
-	return errors.New(msg + ": " + err.Error())
+func (e *coapError) Unwrap() error {
+	if e.timeout {
+		return ErrTimeout
+	}
+	return nil
 }
@@ -0,0 +1,153 @@
+package coap
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+func TestRoundTripReset(t *testing.T) {
+	trans := NewTransportUart()
+	testCon := NewTestConnector()
+	trans.Connecter = testCon
+
+	req, err := NewRequest("GET", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		msg, err := testCon.WaitForSendMessage(5 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := testCon.FakeReceiveMessage(coapmsg.NewRst(msg.MessageID)); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	before := ReadMetrics()
+	ctx, cancel := context.WithTimeout(req.Context(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err = trans.RoundTrip(req.WithContext(ctx))
+	if !errors.Is(err, ErrReset) {
+		t.Error("Expected ErrReset but got", err)
+	}
+	if time.Since(start) > ackTimeout()/2 {
+		t.Error("RST must fail the round trip without waiting for the timeout")
+	}
+	if n := ReadMetrics().ResetsReceived - before.ResetsReceived; n != 1 {
+		t.Error("Expected 1 reset in metrics but got", n)
+	}
+	if len(testCon.conn.interactions) != 0 {
+		t.Error("Expected interaction to be removed")
+	}
+	ValidateRemainingBytes(t, testCon)
+}
+
+func TestObserveCanceledByReset(t *testing.T) {
+	trans := NewTransportUart()
+	testCon := NewTestConnector()
+	trans.Connecter = testCon
+
+	req, err := NewRequest("GET", "coap+uart://any/o", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Options.Set(coapmsg.Observe, 0)
+
+	go func() {
+		msg, err := testCon.WaitForSendMessage(5 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		ack.Options().Set(coapmsg.Observe, 1)
+		if err := testCon.FakeReceiveMessage(ack); err != nil {
+			t.Error(err)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		if err := testCon.FakeReceiveMessage(coapmsg.NewRst(msg.MessageID)); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	before := ReadMetrics()
+	res, err := trans.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case next, ok := <-res.Next():
+		if ok {
+			t.Error("Expected no further notification but got", next.Status)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Observation was not canceled by RST")
+	}
+
+	m := ReadMetrics()
+	if n := m.ObservationsCanceled - before.ObservationsCanceled; n != 1 {
+		t.Error("Expected 1 canceled observation in metrics but got", n)
+	}
+	if n := m.ResetsReceived - before.ResetsReceived; n != 1 {
+		t.Error("Expected 1 reset in metrics but got", n)
+	}
+	ValidateRemainingBytes(t, testCon)
+}
+
+func TestRoundTripTimeout(t *testing.T) {
+	trans := NewTransportUart()
+	testCon := NewTestConnector()
+	trans.Connecter = testCon
+
+	req, err := NewRequest("GET", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), 100*time.Millisecond)
+	defer cancel()
+	_, err = trans.RoundTrip(req.WithContext(ctx))
+	if !errors.Is(err, ErrTimeout) {
+		t.Error("Expected ErrTimeout but got", err)
+	}
+	if _, err := testCon.GetSendMessage(); err != nil {
+		t.Error(err)
+	}
+	ValidateRemainingBytes(t, testCon)
+}
+
+func TestResponseErr(t *testing.T) {
+	res := &Response{Code: coapmsg.NotFound}
+	err := res.Err()
+	var resErr *ResponseError
+	if !errors.As(err, &resErr) || resErr.Code != coapmsg.NotFound {
+		t.Error("Expected *ResponseError with code 4.04 but got", err)
+	}
+	if err.Error() != "coap: error response 4.04 NotFound" {
+		t.Error("Unexpected error message", err.Error())
+	}
+
+	res = &Response{Code: coapmsg.Content}
+	if res.Err() != nil {
+		t.Error("Expected no error for 2.05 but got", res.Err())
+	}
+}
+
+func TestCancelTimerBodyTimeout(t *testing.T) {
+	var err error = &coapError{err: "read failed", timeout: true}
+	if !errors.Is(err, ErrTimeout) {
+		t.Error("Expected timeout error to match ErrTimeout")
+	}
+}
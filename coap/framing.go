@@ -0,0 +1,227 @@
+package coap
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/Lobaro/slip"
+)
+
+// A Framer delimits CoAP messages on a serial byte stream. It creates
+// the packet reader and writer of a serial port, see Mode.Framer.
+//
+// Framers are compared to detect mode changes and must be comparable,
+// e.g. structs without slices or maps.
+type Framer interface {
+	NewReader(r io.Reader) PacketReader
+	NewWriter(w io.Writer) PacketWriter
+}
+
+// SLIPFramer frames packets with SLIP (RFC 1055). It is the default and
+// has no integrity check.
+type SLIPFramer struct{}
+
+func (SLIPFramer) NewReader(r io.Reader) PacketReader {
+	return slip.NewReader(r)
+}
+
+func (SLIPFramer) NewWriter(w io.Writer) PacketWriter {
+	return slip.NewWriter(w)
+}
+
+// COBSFramer frames packets with Consistent Overhead Byte Stuffing,
+// each packet is terminated by a zero byte.
+type COBSFramer struct{}
+
+func (COBSFramer) NewReader(r io.Reader) PacketReader {
+	return &cobsReader{r: bufio.NewReader(r)}
+}
+
+func (COBSFramer) NewWriter(w io.Writer) PacketWriter {
+	return &cobsWriter{w: w}
+}
+
+type cobsReader struct {
+	r     *bufio.Reader
+	frame []byte // received part of the current frame
+}
+
+// ReadPacket returns the next valid packet, invalid frames are dropped.
+// When the received bytes end within a frame, the frame is kept and
+// isPrefix is returned without data, so a read timeout can drop it.
+func (r *cobsReader) ReadPacket() ([]byte, bool, error) {
+	for {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			return nil, false, err
+		}
+		if b != 0 {
+			r.frame = append(r.frame, b)
+			if r.r.Buffered() == 0 {
+				return nil, true, nil
+			}
+			continue
+		}
+		frame := r.frame
+		r.frame = nil
+		if len(frame) == 0 {
+			continue
+		}
+		p, ok := cobsDecode(frame)
+		if !ok {
+			dropFrame("COBS", "invalid encoding")
+			continue
+		}
+		return p, false, nil
+	}
+}
+
+type cobsWriter struct {
+	w io.Writer
+}
+
+func (w *cobsWriter) WritePacket(p []byte) error {
+	_, err := w.w.Write(append(cobsEncode(p), 0))
+	return err
+}
+
+// cobsEncode returns the COBS encoding of p without the trailing zero
+func cobsEncode(p []byte) []byte {
+	out := make([]byte, 1, len(p)+len(p)/254+2)
+	code := 0 // index of the current code byte
+	for _, b := range p {
+		if b != 0 {
+			out = append(out, b)
+		}
+		if b == 0 || len(out)-code == 0xff {
+			out[code] = byte(len(out) - code)
+			code = len(out)
+			out = append(out, 0)
+		}
+	}
+	out[code] = byte(len(out) - code)
+	return out
+}
+
+// cobsDecode decodes a COBS frame without the trailing zero
+func cobsDecode(frame []byte) ([]byte, bool) {
+	out := make([]byte, 0, len(frame))
+	for i := 0; i < len(frame); {
+		code := int(frame[i])
+		if code == 0 || i+code > len(frame) {
+			return nil, false
+		}
+		out = append(out, frame[i+1:i+code]...)
+		i += code
+		if code < 0xff && i < len(frame) {
+			out = append(out, 0)
+		}
+	}
+	return out, true
+}
+
+// HDLCFramer frames packets like HDLC in asynchronous mode (RFC 1662):
+// flag delimited, byte stuffed and protected by a 16 bit FCS
+// (CRC-16/X.25). Frames with invalid FCS are dropped.
+type HDLCFramer struct{}
+
+const (
+	hdlcFlag   = 0x7e
+	hdlcEscape = 0x7d
+	hdlcXor    = 0x20
+
+	fcsInit = 0xffff
+	fcsGood = 0xf0b8 // FCS over data and its FCS (RFC 1662, Appendix C.2)
+)
+
+func (HDLCFramer) NewReader(r io.Reader) PacketReader {
+	return &hdlcReader{r: bufio.NewReader(r)}
+}
+
+func (HDLCFramer) NewWriter(w io.Writer) PacketWriter {
+	return &hdlcWriter{w: w}
+}
+
+type hdlcReader struct {
+	r       *bufio.Reader
+	frame   []byte // received part of the current frame, unescaped
+	escaped bool
+}
+
+// ReadPacket returns the next valid packet, invalid frames are dropped.
+// When the received bytes end within a frame, the frame is kept and
+// isPrefix is returned without data, so a read timeout can drop it.
+func (r *hdlcReader) ReadPacket() ([]byte, bool, error) {
+	for {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			return nil, false, err
+		}
+		switch {
+		case b == hdlcFlag:
+			if len(r.frame) == 0 {
+				r.escaped = false
+				continue // flags between frames
+			}
+			frame, escaped := r.frame, r.escaped
+			r.frame, r.escaped = nil, false
+			if escaped || len(frame) < 3 || fcs16(fcsInit, frame) != fcsGood {
+				dropFrame("HDLC", "invalid FCS")
+				continue
+			}
+			return frame[:len(frame)-2], false, nil
+		case b == hdlcEscape:
+			r.escaped = true
+		case r.escaped:
+			r.frame = append(r.frame, b^hdlcXor)
+			r.escaped = false
+		default:
+			r.frame = append(r.frame, b)
+		}
+		if r.r.Buffered() == 0 {
+			return nil, true, nil
+		}
+	}
+}
+
+type hdlcWriter struct {
+	w io.Writer
+}
+
+func (w *hdlcWriter) WritePacket(p []byte) error {
+	fcs := fcs16(fcsInit, p) ^ 0xffff
+	data := append(append([]byte{}, p...), byte(fcs), byte(fcs>>8))
+
+	out := make([]byte, 0, len(data)+len(data)/8+2)
+	out = append(out, hdlcFlag)
+	for _, b := range data {
+		if b == hdlcFlag || b == hdlcEscape || b < 0x20 {
+			out = append(out, hdlcEscape, b^hdlcXor)
+		} else {
+			out = append(out, b)
+		}
+	}
+	out = append(out, hdlcFlag)
+	_, err := w.w.Write(out)
+	return err
+}
+
+// fcs16 computes the CRC-16/X.25 of p (RFC 1662, Appendix C.2)
+func fcs16(fcs uint16, p []byte) uint16 {
+	for _, b := range p {
+		fcs ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if fcs&1 != 0 {
+				fcs = fcs>>1 ^ 0x8408
+			} else {
+				fcs >>= 1
+			}
+		}
+	}
+	return fcs
+}
+
+func dropFrame(framing, reason string) {
+	countMetric(&metrics.FramesDropped)
+	log.WithField("framing", framing).Warn("Dropped received frame: " + reason)
+}
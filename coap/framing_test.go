@@ -0,0 +1,150 @@
+package coap
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+var framers = map[string]Framer{
+	"slip": SLIPFramer{},
+	"cobs": COBSFramer{},
+	"hdlc": HDLCFramer{},
+}
+
+func TestFramerRoundTrip(t *testing.T) {
+	long := make([]byte, 600)
+	for i := range long {
+		long[i] = byte(i)
+	}
+	packets := [][]byte{
+		{0x40, 0x01, 0x00, 0x01},
+		{0x00},
+		{0x00, 0x00, 0x7e, 0x7d, 0xc0, 0xdb, 0x11},
+		bytes.Repeat([]byte{0xaa}, 254),
+		long,
+	}
+
+	for name, framer := range framers {
+		var buf bytes.Buffer
+		w := framer.NewWriter(&buf)
+		for _, p := range packets {
+			if err := w.WritePacket(p); err != nil {
+				t.Fatal(name, err)
+			}
+		}
+		r := framer.NewReader(&buf)
+		for _, expected := range packets {
+			var packet []byte
+			for {
+				p, isPrefix, err := r.ReadPacket()
+				if err != nil {
+					t.Fatal(name, err)
+				}
+				packet = append(packet, p...)
+				if !isPrefix {
+					break
+				}
+			}
+			if !bytes.Equal(packet, expected) {
+				t.Errorf("%s: expected % x but got % x", name, expected, packet)
+			}
+		}
+	}
+}
+
+func TestFCS16(t *testing.T) {
+	// Check value of CRC-16/X.25
+	if fcs := fcs16(fcsInit, []byte("123456789")) ^ 0xffff; fcs != 0x906e {
+		t.Errorf("Expected 0x906e but got %#04x", fcs)
+	}
+}
+
+func TestCOBSEncode(t *testing.T) {
+	tests := map[string][]byte{
+		"\x00":         {0x01, 0x01},
+		"\x00\x00":     {0x01, 0x01, 0x01},
+		"\x11\x22\x00": {0x03, 0x11, 0x22, 0x01},
+		"\x11\x00\x33": {0x02, 0x11, 0x02, 0x33},
+	}
+	for in, expected := range tests {
+		if enc := cobsEncode([]byte(in)); !bytes.Equal(enc, expected) {
+			t.Errorf("%x: expected % x but got % x", in, expected, enc)
+		}
+	}
+}
+
+func TestFramerDropsCorruptFrames(t *testing.T) {
+	for _, name := range []string{"cobs", "hdlc"} {
+		framer := framers[name]
+		var buf bytes.Buffer
+		w := framer.NewWriter(&buf)
+		w.WritePacket([]byte{0x40, 0x01, 0x12, 0x34})
+		w.WritePacket([]byte{0x40, 0x01, 0x56, 0x78})
+
+		// Corrupt the first frame
+		wire := buf.Bytes()
+		if name == "cobs" {
+			wire[0] = 0x09 // code beyond the end of the frame
+		} else {
+			wire[3] ^= 0x01
+		}
+
+		before := ReadMetrics().FramesDropped
+		p, _, err := framer.NewReader(bytes.NewReader(wire)).ReadPacket()
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if !bytes.Equal(p, []byte{0x40, 0x01, 0x56, 0x78}) {
+			t.Errorf("%s: expected second packet but got % x", name, p)
+		}
+		if dropped := ReadMetrics().FramesDropped - before; dropped != 1 {
+			t.Errorf("%s: expected 1 dropped frame but got %d", name, dropped)
+		}
+	}
+}
+
+// chunkReader returns one chunk per Read, like a serial port returns the
+// bytes received so far
+type chunkReader struct {
+	chunks [][]byte
+}
+
+func (r *chunkReader) Read(b []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
+
+func TestFramerPartialFrames(t *testing.T) {
+	packet := []byte{0x40, 0x01, 0x00, 0x7e, 0x12, 0x34}
+	for _, name := range []string{"cobs", "hdlc"} {
+		framer := framers[name]
+		var buf bytes.Buffer
+		framer.NewWriter(&buf).WritePacket(packet)
+		wire := buf.Bytes()
+		r := framer.NewReader(&chunkReader{chunks: [][]byte{wire[:4], wire[4:]}})
+
+		p, isPrefix, err := r.ReadPacket()
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if !isPrefix {
+			t.Errorf("%s: expected partial read after the first chunk", name)
+		}
+		got := append([]byte{}, p...)
+		for isPrefix {
+			p, isPrefix, err = r.ReadPacket()
+			if err != nil {
+				t.Fatal(name, err)
+			}
+			got = append(got, p...)
+		}
+		if !bytes.Equal(got, packet) {
+			t.Errorf("%s: expected complete packet but got % x", name, got)
+		}
+	}
+}
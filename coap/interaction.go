@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 
 	"time"
 
@@ -25,6 +26,7 @@ type MessageId uint16
 type Interaction struct {
 	req           coapmsg.Message // initial request message
 	lastMessageId MessageId       // Last message Id, used to match ACK's
+	lastType      coapmsg.COAPType
 	conn          Connection
 	receiveCh     chan *coapmsg.Message
 
@@ -35,42 +37,193 @@ type Interaction struct {
 	// to the underlying transport where they can be converted into response structs
 	NotificationCh chan *coapmsg.Message
 
+	obsMu      sync.Mutex // Guards NotificationCh, StopListenForNotifications and roundTrips
+	roundTrips int        // Running round trips, e.g. to cancel the observation
+
+	closeMu     sync.Mutex // Guards closed and err
 	closed      bool
+	err         error         // returned by pending reads after the interaction was closed
+	done        chan struct{} // closed with the interaction
 	roundTripMu sync.Mutex
 }
 
+// Interactions is an InteractionStore that is safe for concurrent use.
+//
+// Interactions are indexed by token to match responses and
+// notifications, and by the message ID and type of their last message
+// to match ACK and RST messages (RFC 7252, Section 5.3.2 and 4.4).
 type Interactions struct {
-	interactions []*Interaction
+	mu           sync.RWMutex
+	interactions map[*Interaction]struct{}
+	byToken      map[string]*Interaction
+	byMessageId  map[exchangeKey]*Interaction
+}
+
+var _ MessageTracker = &Interactions{}
+
+// exchangeKey identifies a message that is answered by an ACK or RST
+type exchangeKey struct {
+	id  MessageId
+	typ coapmsg.COAPType // CON or NON
 }
 
 func (ias *Interactions) AddInteraction(ia *Interaction) {
-	ias.interactions = append(ias.interactions, ia)
+	ias.mu.Lock()
+	defer ias.mu.Unlock()
+	ias.addInteraction(ia)
 }
 
-func (ias *Interactions) RemoveInteraction(interaction *Interaction) {
-	for i, ia := range ias.interactions {
-		if ia == interaction {
-			copy(ias.interactions[i:], ias.interactions[i+1:])
-			ias.interactions[len(ias.interactions)-1] = nil // or the zero value of T
-			ias.interactions = ias.interactions[:len(ias.interactions)-1]
-			return
+// TryAddInteraction adds ia unless its token is used by another
+// interaction. Empty tokens are never in use.
+func (ias *Interactions) TryAddInteraction(ia *Interaction) bool {
+	ias.mu.Lock()
+	defer ias.mu.Unlock()
+	if len(ia.req.Token) > 0 {
+		if _, ok := ias.byToken[string(ia.req.Token)]; ok {
+			return false
 		}
 	}
+	ias.addInteraction(ia)
+	return true
 }
 
-func (ias *Interactions) FindInteraction(token Token, msgId MessageId) *Interaction {
-	for _, ia := range ias.interactions {
-		if ia.Token().Equals(token) {
-			return ia
+// addInteraction must be called with ias.mu held
+func (ias *Interactions) addInteraction(ia *Interaction) {
+	if ias.interactions == nil {
+		ias.interactions = make(map[*Interaction]struct{})
+		ias.byToken = make(map[string]*Interaction)
+		ias.byMessageId = make(map[exchangeKey]*Interaction)
+	}
+	ias.interactions[ia] = struct{}{}
+	if len(ia.req.Token) > 0 {
+		if other, ok := ias.byToken[string(ia.req.Token)]; ok && other != ia {
+			log.WithField("token", ia.Token()).Warn("Token of new interaction is already in use")
 		}
-		// For empty tokens the message Id must match
-		// An ACK is sent by the server to confirm a CON but carries no token
-		// TODO: Check also message type?
-		if len(token) == 0 && ia.lastMessageId == msgId {
+		ias.byToken[string(ia.req.Token)] = ia
+	}
+	ias.trackMessage(ia, MessageId(ia.req.MessageID), ia.req.Type)
+}
+
+func (ias *Interactions) RemoveInteraction(ia *Interaction) {
+	ias.mu.Lock()
+	defer ias.mu.Unlock()
+	if _, ok := ias.interactions[ia]; !ok {
+		return
+	}
+	delete(ias.interactions, ia)
+	if ias.byToken[string(ia.req.Token)] == ia {
+		delete(ias.byToken, string(ia.req.Token))
+	}
+	key := exchangeKey{ia.lastMessageId, ia.lastType}
+	if ias.byMessageId[key] == ia {
+		delete(ias.byMessageId, key)
+	}
+}
+
+// TrackMessage registers the last message sent within the interaction,
+// so that ACK and RST messages are matched by its message ID
+func (ias *Interactions) TrackMessage(ia *Interaction, msgId MessageId, typ coapmsg.COAPType) {
+	ias.mu.Lock()
+	defer ias.mu.Unlock()
+	if _, ok := ias.interactions[ia]; !ok {
+		return
+	}
+	ias.trackMessage(ia, msgId, typ)
+}
+
+// trackMessage must be called with ias.mu held
+func (ias *Interactions) trackMessage(ia *Interaction, msgId MessageId, typ coapmsg.COAPType) {
+	old := exchangeKey{ia.lastMessageId, ia.lastType}
+	if ias.byMessageId[old] == ia {
+		delete(ias.byMessageId, old)
+	}
+	ia.lastMessageId, ia.lastType = msgId, typ
+	if typ == coapmsg.Confirmable || typ == coapmsg.NonConfirmable {
+		ias.byMessageId[exchangeKey{msgId, typ}] = ia
+	}
+}
+
+// MatchInteraction returns the interaction of a received message. ACK
+// messages are matched by message ID to a CON, RST messages to a CON or
+// NON, all other messages by token.
+func (ias *Interactions) MatchInteraction(msg *coapmsg.Message) *Interaction {
+	ias.mu.RLock()
+	defer ias.mu.RUnlock()
+	id := MessageId(msg.MessageID)
+	switch msg.Type {
+	case coapmsg.Acknowledgement:
+		return ias.byMessageId[exchangeKey{id, coapmsg.Confirmable}]
+	case coapmsg.Reset:
+		if ia, ok := ias.byMessageId[exchangeKey{id, coapmsg.Confirmable}]; ok {
 			return ia
 		}
+		return ias.byMessageId[exchangeKey{id, coapmsg.NonConfirmable}]
 	}
-	return nil
+	if len(msg.Token) == 0 {
+		return nil
+	}
+	return ias.byToken[string(msg.Token)]
+}
+
+// FindInteraction returns the interaction with token. For empty tokens
+// the message ID of the last message of the interaction must match.
+func (ias *Interactions) FindInteraction(token Token, msgId MessageId) *Interaction {
+	ias.mu.RLock()
+	defer ias.mu.RUnlock()
+	if len(token) > 0 {
+		return ias.byToken[string(token)]
+	}
+	if ia, ok := ias.byMessageId[exchangeKey{msgId, coapmsg.Confirmable}]; ok {
+		return ia
+	}
+	return ias.byMessageId[exchangeKey{msgId, coapmsg.NonConfirmable}]
+}
+
+// all returns a snapshot of the interactions
+func (ias *Interactions) all() []*Interaction {
+	ias.mu.RLock()
+	defer ias.mu.RUnlock()
+	all := make([]*Interaction, 0, len(ias.interactions))
+	for ia := range ias.interactions {
+		all = append(all, ia)
+	}
+	return all
+}
+
+// failInteractions closes all interactions that do not observe a
+// resource. Pending round trips return err.
+func (ias *Interactions) failInteractions(err error) {
+	for _, ia := range ias.all() {
+		if !ia.IsObserving() {
+			ia.fail(err)
+		}
+	}
+}
+
+// closeInteractions closes all interactions including observations.
+// Pending round trips return err.
+func (ias *Interactions) closeInteractions(err error) {
+	for _, ia := range ias.all() {
+		ia.fail(err)
+	}
+}
+
+// count returns the number of active interactions
+func (ias *Interactions) count() int {
+	ias.mu.RLock()
+	defer ias.mu.RUnlock()
+	return len(ias.interactions)
+}
+
+// observingInteractions returns the interactions observing a resource
+func (ias *Interactions) observingInteractions() []*Interaction {
+	var observing []*Interaction
+	for _, ia := range ias.all() {
+		if ia.IsObserving() {
+			observing = append(observing, ia)
+		}
+	}
+	return observing
 }
 
 func (ia *Interaction) Token() Token {
@@ -78,28 +231,69 @@ func (ia *Interaction) Token() Token {
 }
 
 func (ia *Interaction) Close() {
-	if ia.closed {
+	if !ia.close(nil) {
 		logrus.WithField("token", ia.Token()).Warn("Interaction already closed")
-		return
+	}
+}
+
+// fail closes the interaction, pending reads return err
+func (ia *Interaction) fail(err error) {
+	ia.close(err)
+}
+
+// close reports whether the interaction was closed by this call
+func (ia *Interaction) close(err error) bool {
+	ia.closeMu.Lock()
+	if ia.closed {
+		ia.closeMu.Unlock()
+		return false
 	}
 	log.WithField("token", ia.Token()).Info("Closing interaction")
 	ia.closed = true
+	ia.err = err
+	if ia.done != nil {
+		close(ia.done)
+	}
+	ia.closeMu.Unlock()
 
-	if ia.StopListenForNotifications != nil {
-		ia.StopListenForNotifications()
+	ia.stopListening()
+	ia.conn.RemoveInteraction(ia)
+	return true
+}
+
+// closeErr returns the error of reads after the interaction was closed
+func (ia *Interaction) closeErr() error {
+	ia.closeMu.Lock()
+	defer ia.closeMu.Unlock()
+	if ia.err != nil {
+		return ia.err
 	}
+	return ErrNoSuchInteraction
+}
 
-	if ia.receiveCh != nil {
-		close(ia.receiveCh)
+func (ia *Interaction) stopListening() {
+	ia.obsMu.Lock()
+	stop := ia.StopListenForNotifications
+	ia.obsMu.Unlock()
+	if stop != nil {
+		stop()
 	}
+}
 
-	ia.conn.RemoveInteraction(ia)
+// notifications returns the channel of notifications, nil if the
+// interaction does not observe a resource
+func (ia *Interaction) notifications() chan *coapmsg.Message {
+	ia.obsMu.Lock()
+	defer ia.obsMu.Unlock()
+	return ia.NotificationCh
 }
 
 func (ia *Interaction) HandleMessage(msg *coapmsg.Message) {
 	log.Info("Interaction handle message...")
 	select {
 	case ia.receiveCh <- msg:
+	case <-ia.done:
+		log.WithField("token", ia.Token()).Debug("Interaction closed. Discarding message.")
 	case <-time.After(3 * time.Second):
 		// TODO: We should avoid this. find the reason why it happens and maybe buffer the channel
 		log.Error("Interaction did not handled incomming message. Discarding.")
@@ -108,28 +302,53 @@ func (ia *Interaction) HandleMessage(msg *coapmsg.Message) {
 
 }
 
-var READ_MESSAGE_CTX_DONE = errors.New("Read timeout")
-var READ_MESSAGE_CHAN_CLOSED = errors.New("Receive channel closed")
+// Deprecated: Use ErrTimeout
+var READ_MESSAGE_CTX_DONE = ErrTimeout
+
+// Deprecated: Use ErrNoSuchInteraction
+var READ_MESSAGE_CHAN_CLOSED = ErrNoSuchInteraction
 
 func (ia *Interaction) readMessage(ctx context.Context) (*coapmsg.Message, error) {
 	select {
-	case msg, ok := <-ia.receiveCh:
-		if !ok {
-			return msg, READ_MESSAGE_CHAN_CLOSED
-		}
+	case msg := <-ia.receiveCh:
 		return msg, nil
+	case <-ia.done:
+		return nil, ia.closeErr()
 	case <-ctx.Done():
-		return nil, READ_MESSAGE_CTX_DONE
+		if ctx.Err() == context.Canceled {
+			return nil, ctx.Err()
+		}
+		return nil, ErrTimeout
 	}
 }
 
 func (ia *Interaction) IsObserving() bool {
-	return ia.NotificationCh != nil
+	return ia.notifications() != nil
+}
+
+// closeIfIdle closes the interaction when it neither observes a resource
+// nor a round trip is running, e.g. to cancel the observation
+func (ia *Interaction) closeIfIdle() {
+	ia.obsMu.Lock()
+	idle := ia.NotificationCh == nil && ia.roundTrips == 0
+	ia.obsMu.Unlock()
+	if idle {
+		ia.close(nil)
+	}
 }
 
 var ERROR_READ_ACK = "Failed to read ACK"
 
 func (ia *Interaction) RoundTrip(ctx context.Context, reqMsg *coapmsg.Message) (resMsg *coapmsg.Message, err error) {
+	ia.obsMu.Lock()
+	ia.roundTrips++
+	ia.obsMu.Unlock()
+	defer func() {
+		ia.obsMu.Lock()
+		ia.roundTrips--
+		ia.obsMu.Unlock()
+	}()
+
 	ia.roundTripMu.Lock()
 	defer ia.roundTripMu.Unlock()
 
@@ -140,16 +359,14 @@ func (ia *Interaction) RoundTrip(ctx context.Context, reqMsg *coapmsg.Message) (
 	// We are still able to handle interactions for other tokens in parallel
 	//
 	// Throws without nil check when requesting unknown resource
-	if ia.StopListenForNotifications != nil {
-		ia.StopListenForNotifications()
-	}
+	ia.stopListening()
 
-	ia.lastMessageId = MessageId(reqMsg.MessageID)
+	trackMessage(ia.conn, ia, MessageId(reqMsg.MessageID), reqMsg.Type)
 
 	// send the request
 	err = sendMessage(ia.conn, reqMsg)
 	if err != nil {
-		return nil, wrapError(err, "Failed to send message")
+		return nil, fmt.Errorf("Failed to send message: %w", err)
 	}
 
 	if reqMsg.Type == coapmsg.Confirmable {
@@ -159,16 +376,22 @@ func (ia *Interaction) RoundTrip(ctx context.Context, reqMsg *coapmsg.Message) (
 		withAckTimeout, _ := context.WithTimeout(ctx, ackTimeout())
 		resMsg, err = ia.readMessage(withAckTimeout)
 		if err != nil {
-			return nil, wrapError(err, ERROR_READ_ACK)
+			return nil, fmt.Errorf("%s: %w", ERROR_READ_ACK, err)
 		}
 		if err = validateMessageId(reqMsg, resMsg); err != nil {
-			return nil, wrapError(err, ERROR_READ_ACK)
+			return nil, fmt.Errorf("%s: %w", ERROR_READ_ACK, err)
+		}
+		if resMsg.Type == coapmsg.Reset {
+			// The server rejected the request, e.g. because it can not
+			// process it or does not know an observation we try to cancel.
+			// Any observation on this interaction was already stopped above.
+			countMetric(&metrics.ResetsReceived)
+			return nil, fmt.Errorf("%s: %w", ERROR_READ_ACK, ErrReset)
 		}
 		if resMsg.Type != coapmsg.Acknowledgement {
-			return nil, errors.New("Expected ACK response but got " + reqMsg.Type.String())
+			return nil, errors.New("Expected ACK response but got " + resMsg.Type.String())
 		}
 
-		// TODO: Handle Types: RST correctly - now we just don't care
 		if resMsg.Type == coapmsg.Acknowledgement && resMsg.Code == coapmsg.Empty {
 			// Handle postponed (non-piggyback) response
 
@@ -198,7 +421,7 @@ func (ia *Interaction) RoundTrip(ctx context.Context, reqMsg *coapmsg.Message) (
 			withTimeout, _ := context.WithTimeout(ctx, POSTPONED_RESPONSE_TIMEOUT)
 			resMsg, err = ia.readMessage(withTimeout)
 			if err != nil {
-				return nil, wrapError(err, "Failed to read postponed response")
+				return nil, fmt.Errorf("Failed to read postponed response: %w", err)
 			}
 			// The messageId from resMsg needs to be confirmed
 			if resMsg.Type != coapmsg.Confirmable && resMsg.Type != coapmsg.NonConfirmable {
@@ -226,15 +449,28 @@ func (ia *Interaction) RoundTrip(ctx context.Context, reqMsg *coapmsg.Message) (
 	} else if reqMsg.Type == coapmsg.NonConfirmable {
 		// Handle NON request
 		withAckTimeout, _ := context.WithTimeout(ctx, ackTimeout())
-		resMsg, err := ia.readMessage(withAckTimeout)
+		resMsg, err = ia.readMessage(withAckTimeout)
 		if err != nil {
-			return nil, wrapError(err, "Failed to read NON response")
+			return nil, fmt.Errorf("Failed to read NON response: %w", err)
 		}
-		if err = validateMessageId(reqMsg, resMsg); err != nil {
-			return nil, wrapError(err, "Failed to read NON response")
+		// The response has its own message ID and is matched by token,
+		// only a RST refers to the message ID of the request
+		if resMsg.Type == coapmsg.Reset {
+			if err = validateMessageId(reqMsg, resMsg); err != nil {
+				return nil, fmt.Errorf("Failed to read NON response: %w", err)
+			}
+			countMetric(&metrics.ResetsReceived)
+			return nil, fmt.Errorf("Failed to read NON response: %w", ErrReset)
 		}
-		if resMsg.Type != coapmsg.NonConfirmable {
-			return nil, errors.New("Expected NON response but got " + reqMsg.Type.String())
+		if resMsg.Type != coapmsg.NonConfirmable && resMsg.Type != coapmsg.Confirmable {
+			return nil, errors.New("Expected NON response but got " + resMsg.Type.String())
+		}
+		if resMsg.Type == coapmsg.Confirmable {
+			// Allowed in reply to a NON request (RFC 7252, Section 5.2.3)
+			ack := coapmsg.NewAck(resMsg.MessageID)
+			if err := sendMessage(ia.conn, &ack); err != nil {
+				return nil, err
+			}
 		}
 
 	} else {
@@ -246,7 +482,9 @@ func (ia *Interaction) RoundTrip(ctx context.Context, reqMsg *coapmsg.Message) (
 	// An observe request must set the observe option to 0
 	// the server has to response with the observe option set
 	if reqMsg.Options().Get(coapmsg.Observe).AsUInt8() == 0 && resMsg.Options().Get(coapmsg.Observe).IsSet() {
+		ia.obsMu.Lock()
 		ia.NotificationCh = make(chan *coapmsg.Message, 0)
+		ia.obsMu.Unlock()
 		go ia.waitForNotify(ctx)
 	}
 
@@ -275,9 +513,13 @@ func (ia *Interaction) sendCancelObserve() {
 
 // waitForNotify will actively handle notification messages
 func (ia *Interaction) waitForNotify(ctx context.Context) {
+	notificationCh := ia.notifications()
 	defer func() {
+		ia.obsMu.Lock()
 		close(ia.NotificationCh)
 		ia.NotificationCh = nil
+		ia.StopListenForNotifications = nil
+		ia.obsMu.Unlock()
 	}()
 	withCancel, cancel := context.WithCancel(ctx)
 
@@ -285,6 +527,7 @@ func (ia *Interaction) waitForNotify(ctx context.Context) {
 
 	cancelDone := make(chan struct{})
 	defer close(cancelDone)
+	ia.obsMu.Lock()
 	ia.StopListenForNotifications = func() {
 		cancel()
 		// We must actively wait for the cancel to be done,
@@ -292,11 +535,12 @@ func (ia *Interaction) waitForNotify(ctx context.Context) {
 		<-cancelDone
 		logWithToken.Info("Stopped to listen for notifications")
 	}
+	ia.obsMu.Unlock()
 
 	for {
 		resMsg, err := ia.readMessage(withCancel)
 		if err != nil {
-			if err != READ_MESSAGE_CTX_DONE {
+			if err != context.Canceled && !errors.Is(err, ErrTimeout) {
 				logWithToken.WithError(err).Error("Stopped observer unexpected")
 			} else {
 				logWithToken.Info("Stopped observer")
@@ -304,8 +548,17 @@ func (ia *Interaction) waitForNotify(ctx context.Context) {
 			return
 		}
 
+		// A RST matching our last message ID rejects the observation,
+		// the server will not send further notifications
+		if resMsg.Type == coapmsg.Reset {
+			countMetric(&metrics.ResetsReceived)
+			countMetric(&metrics.ObservationsCanceled)
+			logWithToken.WithField("messageId", resMsg.MessageID).Info("Observation canceled by RST")
+			return
+		}
+
 		select {
-		case ia.NotificationCh <- resMsg:
+		case notificationCh <- resMsg:
 			// TODO: Should we really only send the ACK when the notification is handled?
 			// As it is now, the user might miss a few notifications but can
 			// than still attach to the Next channel in the response
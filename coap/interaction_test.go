@@ -0,0 +1,324 @@
+package coap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Lobaro/slip"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+func newTestInteraction(ias *Interactions, token string, msgId uint16, typ coapmsg.COAPType) *Interaction {
+	req := coapmsg.NewMessage()
+	req.Type = typ
+	req.Code = coapmsg.GET
+	req.Token = []byte(token)
+	req.MessageID = msgId
+	ia := &Interaction{req: req, done: make(chan struct{})}
+	ias.AddInteraction(ia)
+	return ia
+}
+
+func TestInteractionsMatch(t *testing.T) {
+	ias := &Interactions{}
+	con := newTestInteraction(ias, "con", 1, coapmsg.Confirmable)
+	non := newTestInteraction(ias, "non", 2, coapmsg.NonConfirmable)
+
+	ack := coapmsg.NewAck(1)
+	if ias.MatchInteraction(&ack) != con {
+		t.Error("Expected empty ACK to match CON by message ID")
+	}
+	ack = coapmsg.NewAck(2)
+	if ias.MatchInteraction(&ack) != nil {
+		t.Error("ACK must not match a NON")
+	}
+	// A piggybacked response with a wrong message ID must not match
+	ack = coapmsg.NewAck(7)
+	ack.Token = []byte("con")
+	if ias.MatchInteraction(&ack) != nil {
+		t.Error("ACK must be matched by message ID only")
+	}
+	rst := coapmsg.NewRst(2)
+	if ias.MatchInteraction(&rst) != non {
+		t.Error("Expected RST to match NON by message ID")
+	}
+
+	// Separate responses and notifications carry a new message ID
+	notify := coapmsg.NewMessage()
+	notify.Type = coapmsg.Confirmable
+	notify.Code = coapmsg.Content
+	notify.MessageID = 1
+	notify.Token = []byte("non")
+	if ias.MatchInteraction(&notify) != non {
+		t.Error("Expected notification to match by token")
+	}
+	notify.Token = nil
+	if ias.MatchInteraction(&notify) != nil {
+		t.Error("Message without token must not match by message ID")
+	}
+
+	// Only the last message of an interaction is acknowledged
+	ias.TrackMessage(con, 3, coapmsg.Confirmable)
+	if ack := coapmsg.NewAck(1); ias.MatchInteraction(&ack) != nil {
+		t.Error("Expected old message ID to be released")
+	}
+	if ack := coapmsg.NewAck(3); ias.MatchInteraction(&ack) != con {
+		t.Error("Expected ACK to match new message ID")
+	}
+	if ias.FindInteraction(Token("con"), 0) != con || ias.FindInteraction(nil, 3) != con {
+		t.Error("Expected FindInteraction by token and message ID")
+	}
+
+	ias.RemoveInteraction(con)
+	if ack := coapmsg.NewAck(3); ias.MatchInteraction(&ack) != nil || ias.FindInteraction(Token("con"), 0) != nil {
+		t.Error("Expected removed interaction not to match")
+	}
+	if ias.count() != 1 {
+		t.Errorf("Expected 1 interaction but got %d", ias.count())
+	}
+}
+
+// serveObservable answers requests like a device. Observations are
+// answered with two NON notifications.
+func serveObservable(device net.Conn) {
+	reader := slip.NewReader(device)
+	writer := slip.NewWriter(device)
+	var writeMu sync.Mutex
+	write := func(msg coapmsg.Message) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		writer.WritePacket(msg.MustMarshalBinary())
+	}
+	var msgId uint16 = 0x8000
+	var idMu sync.Mutex
+	nextId := func() uint16 {
+		idMu.Lock()
+		defer idMu.Unlock()
+		msgId++
+		return msgId
+	}
+
+	for {
+		p, _, err := reader.ReadPacket()
+		if err != nil {
+			return
+		}
+		req, err := coapmsg.ParseMessage(p)
+		if err != nil || req.Type != coapmsg.Confirmable {
+			continue
+		}
+		res := coapmsg.NewAck(req.MessageID)
+		res.Code = coapmsg.Content
+		res.Token = req.Token
+		res.Payload = []byte(req.PathString())
+		observe := req.Options().Get(coapmsg.Observe)
+		if observe.IsNotSet() || observe.AsUInt8() != 0 {
+			go write(res)
+			continue
+		}
+		res.Options().Add(coapmsg.Observe, 1)
+		go func() {
+			write(res)
+			for seq := 2; seq <= 3; seq++ {
+				time.Sleep(5 * time.Millisecond)
+				notify := coapmsg.NewMessage()
+				notify.Type = coapmsg.NonConfirmable
+				notify.Code = coapmsg.Content
+				notify.MessageID = nextId()
+				notify.Token = req.Token
+				notify.Options().Add(coapmsg.Observe, seq)
+				notify.Payload = []byte(fmt.Sprint(seq))
+				write(notify)
+			}
+		}()
+	}
+}
+
+// TestInteractionsConcurrent runs requests and observations in parallel,
+// run with -race
+func TestInteractionsConcurrent(t *testing.T) {
+	devices := withFakePorts(t)
+	dev := filepath.Join(t.TempDir(), "ttyRace")
+	if err := os.WriteFile(dev, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := NewUartConnecter()
+	c.ReconnectInterval = time.Hour
+	c.Alias("ttyRace", dev)
+	defer c.Close()
+	trans := NewTransportUart()
+	trans.Connecter = c
+	client := &Client{Transport: trans}
+
+	conn, err := c.Connect("ttyRace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serveObservable(<-devices)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			path := fmt.Sprintf("/r%d", i)
+			res, err := client.Get("coap+uart://ttyRace" + path)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if body, _ := ioutil.ReadAll(res.Body); string(body) != path[1:] {
+				t.Errorf("Expected %s but got %s", path[1:], body)
+			}
+		}(i)
+	}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := client.Observe(fmt.Sprintf("coap+uart://ttyRace/o%d", i))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for seq := 2; seq <= 3; seq++ {
+				select {
+				case res = <-res.Next():
+					if body, _ := ioutil.ReadAll(res.Body); string(body) != fmt.Sprint(seq) {
+						t.Errorf("Expected notification %d but got %s", seq, body)
+					}
+				case <-time.After(2 * time.Second):
+					t.Error("Timeout while waiting for notification")
+					return
+				}
+			}
+			if _, err := client.CancelObserve(res); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(time.Second)
+	for conn.(*serialConnection).count() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := conn.(*serialConnection).count(); n != 0 {
+		t.Errorf("Expected all interactions to be removed, %d left", n)
+	}
+}
+
+func TestInteractionRoundTripNon(t *testing.T) {
+	devices := withFakePorts(t)
+	dev := filepath.Join(t.TempDir(), "ttyNon")
+	if err := os.WriteFile(dev, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	conn := newSerialConnection(dev, Mode{})
+	conn.reconnectInterval = time.Hour
+	if err := conn.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	device := <-devices
+	go func() {
+		reader := slip.NewReader(device)
+		writer := slip.NewWriter(device)
+		for {
+			p, _, err := reader.ReadPacket()
+			if err != nil {
+				return
+			}
+			req, err := coapmsg.ParseMessage(p)
+			if err != nil {
+				return
+			}
+			// A NON response carries its own message ID
+			res := coapmsg.NewMessage()
+			res.Type = coapmsg.NonConfirmable
+			res.Code = coapmsg.Content
+			res.MessageID = req.MessageID + 100
+			res.Token = req.Token
+			res.Payload = []byte("22.5 C")
+			if string(req.Token) == "rst" {
+				res = coapmsg.NewRst(req.MessageID)
+			}
+			writer.WritePacket(res.MustMarshalBinary())
+		}
+	}()
+
+	req := coapmsg.NewMessage()
+	req.Type = coapmsg.NonConfirmable
+	req.Code = coapmsg.GET
+	req.MessageID = 1
+	req.Token = []byte("non")
+	req.SetPathString("/temperature")
+	res, err := startInteraction(conn, &req).RoundTrip(context.Background(), &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Payload) != "22.5 C" {
+		t.Errorf("Expected response payload but got %q", res.Payload)
+	}
+
+	req.MessageID = 2
+	req.Token = []byte("rst")
+	if _, err := startInteraction(conn, &req).RoundTrip(context.Background(), &req); !errors.Is(err, ErrReset) {
+		t.Errorf("Expected ErrReset but got %v", err)
+	}
+}
+
+// legacyStore implements only the InteractionStore methods, like
+// connections written before MessageTracker
+type legacyStore struct {
+	mu           sync.Mutex
+	interactions []*Interaction
+}
+
+func (s *legacyStore) FindInteraction(token Token, msgId MessageId) *Interaction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ia := range s.interactions {
+		if string(ia.req.Token) == string(token) {
+			return ia
+		}
+	}
+	return nil
+}
+
+func (s *legacyStore) AddInteraction(ia *Interaction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interactions = append(s.interactions, ia)
+}
+
+func (s *legacyStore) RemoveInteraction(ia *Interaction) {}
+
+func TestInteractionStoreWithoutMessageTracker(t *testing.T) {
+	store := &legacyStore{}
+	ia := &Interaction{req: coapmsg.NewMessage(), done: make(chan struct{})}
+	ia.req.Token = []byte("tok")
+	if !tryAddInteraction(store, ia) {
+		t.Fatal("Expected interaction to be added")
+	}
+	if tryAddInteraction(store, &Interaction{req: ia.req}) {
+		t.Error("Expected token in use to be rejected")
+	}
+	trackMessage(store, ia, 7, coapmsg.Confirmable)
+
+	res := coapmsg.NewMessage()
+	res.Type = coapmsg.NonConfirmable
+	res.Code = coapmsg.Content
+	res.Token = []byte("tok")
+	if matchInteraction(store, &res) != ia {
+		t.Error("Expected response to be matched with FindInteraction")
+	}
+}
@@ -0,0 +1,176 @@
+package coap
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Derived transmission parameters, see RFC 7252, Section 4.8.2
+const (
+	MAX_TRANSMIT_SPAN = time.Duration(float64(ACK_TIMEOUT) * float64(1<<MAX_RETRANSMIT-1) * ACK_RANDOM_FACTOR)
+	MAX_LATENCY       = 100 * time.Second
+	PROCESSING_DELAY  = ACK_TIMEOUT
+
+	// EXCHANGE_LIFETIME is the time after which a message ID can be
+	// reused safely, the peer may still detect duplicates before
+	EXCHANGE_LIFETIME = MAX_TRANSMIT_SPAN + 2*MAX_LATENCY + PROCESSING_DELAY
+)
+
+// messageIdSpace is the number of distinct message IDs
+const messageIdSpace = 1 << 16
+
+// MessageIds allocates the message IDs of one endpoint. It starts at a
+// random value, so a restarted process does not send IDs the peer still
+// remembers as duplicates, and does not hand out an ID again within its
+// lifetime. When all IDs are in use, Next blocks until the oldest one
+// expires.
+//
+// Transports and connections allocate IDs per endpoint, servers like
+// coaptest.Device can use it for their own messages.
+type MessageIds struct {
+	lifetime time.Duration
+	limit    int // Maximum number of IDs in use, messageIdSpace if 0
+
+	mu    sync.Mutex
+	next  uint16
+	used  map[uint16]struct{}
+	queue []allocatedId // Ordered by allocation time
+}
+
+type allocatedId struct {
+	id MessageId
+	at time.Time
+}
+
+// NewMessageIds returns an allocator that does not reuse an ID within
+// lifetime, EXCHANGE_LIFETIME if 0
+func NewMessageIds(lifetime time.Duration) *MessageIds {
+	if lifetime == 0 {
+		lifetime = EXCHANGE_LIFETIME
+	}
+	return &MessageIds{
+		lifetime: lifetime,
+		next:     randomMessageId(),
+		used:     make(map[uint16]struct{}),
+	}
+}
+
+// randomMessageId returns the start value of an allocator. It is read
+// from crypto/rand, since the unseeded math/rand returns the same value
+// after every restart.
+func randomMessageId() uint16 {
+	var b [2]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("coap: failed to read random message ID: " + err.Error())
+	}
+	return binary.BigEndian.Uint16(b[:])
+}
+
+// Next returns a message ID that is not in use. It blocks while all
+// IDs are in use until one expires or ctx is done.
+func (m *MessageIds) Next(ctx context.Context) (MessageId, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for {
+		now := time.Now()
+		m.expire(now)
+		if len(m.queue) < m.max() {
+			break
+		}
+		// Backpressure: The peer would see a message ID again that it
+		// might still remember
+		wait := m.queue[0].at.Add(m.lifetime).Sub(now)
+		m.mu.Unlock()
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			m.mu.Lock()
+			return 0, fmt.Errorf("%w: no message ID available within %s", ErrTimeout, m.lifetime)
+		}
+		m.mu.Lock()
+	}
+
+	for {
+		m.next++
+		if _, ok := m.used[m.next]; !ok {
+			break
+		}
+	}
+	id := MessageId(m.next)
+	m.used[m.next] = struct{}{}
+	m.queue = append(m.queue, allocatedId{id: id, at: time.Now()})
+	return id, nil
+}
+
+// InUse returns the number of IDs within their lifetime
+func (m *MessageIds) InUse() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(time.Now())
+	return len(m.queue)
+}
+
+func (m *MessageIds) max() int {
+	if m.limit <= 0 || m.limit > messageIdSpace {
+		return messageIdSpace
+	}
+	return m.limit
+}
+
+// expire releases IDs older than the lifetime, must be called with m.mu held
+func (m *MessageIds) expire(now time.Time) {
+	n := 0
+	for n < len(m.queue) && now.Sub(m.queue[n].at) >= m.lifetime {
+		delete(m.used, uint16(m.queue[n].id))
+		n++
+	}
+	if n > 0 {
+		m.queue = append(m.queue[:0], m.queue[n:]...)
+	}
+}
+
+// hostMessageIds keeps the message IDs of each endpoint, so they
+// survive a new connection to the same endpoint
+type hostMessageIds struct {
+	mu     sync.Mutex
+	byHost map[string]*MessageIds
+}
+
+// get returns the IDs of host, new allocators use lifetime
+func (h *hostMessageIds) get(host string, lifetime time.Duration) *MessageIds {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ids, ok := h.byHost[host]
+	if !ok {
+		ids = NewMessageIds(lifetime)
+		if h.byHost == nil {
+			h.byHost = make(map[string]*MessageIds)
+		}
+		h.byHost[host] = ids
+	}
+	return ids
+}
+
+// prune removes the allocators without IDs in use. Their endpoints get
+// a new allocator with a random start on the next request.
+func (h *hostMessageIds) prune() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for host, ids := range h.byHost {
+		if ids.InUse() == 0 {
+			delete(h.byHost, host)
+		}
+	}
+}
+
+// messageIdSource is implemented by connections that allocate the
+// message IDs of their endpoint
+type messageIdSource interface {
+	nextMessageId(ctx context.Context) (MessageId, error)
+}
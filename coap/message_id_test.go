@@ -0,0 +1,138 @@
+package coap
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMessageIdsNoReuse(t *testing.T) {
+	ids := NewMessageIds(time.Hour)
+	seen := make(map[MessageId]bool)
+	for i := 0; i < messageIdSpace; i++ {
+		id, err := ids.Next(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[id] {
+			t.Fatalf("Message ID %d used twice within its lifetime", id)
+		}
+		seen[id] = true
+	}
+
+	// All IDs are in use, the next request must wait
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := ids.Next(ctx); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected ErrTimeout but got %v", err)
+	}
+}
+
+func TestMessageIdsRandomStart(t *testing.T) {
+	first, _ := NewMessageIds(0).Next(context.Background())
+	for i := 0; i < 4; i++ {
+		if id, _ := NewMessageIds(0).Next(context.Background()); id != first {
+			return
+		}
+	}
+	t.Error("Expected allocators to start at random message IDs but all started at", first)
+}
+
+func TestMessageIdsExpire(t *testing.T) {
+	lifetime := 50 * time.Millisecond
+	ids := NewMessageIds(lifetime)
+	ids.limit = 2
+
+	first, _ := ids.Next(context.Background())
+	ids.Next(context.Background())
+	if ids.InUse() != 2 {
+		t.Errorf("Expected 2 IDs in use but got %d", ids.InUse())
+	}
+
+	start := time.Now()
+	third, err := ids.Next(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < lifetime/2 {
+		t.Errorf("Expected to wait for the oldest ID to expire, waited %s", waited)
+	}
+	if third != first+2 {
+		t.Errorf("Expected message ID %d but got %d", first+2, third)
+	}
+
+	time.Sleep(lifetime)
+	if ids.InUse() != 0 {
+		t.Errorf("Expected all IDs to expire but %d in use", ids.InUse())
+	}
+}
+
+func TestTransportUartMessageIdsPerHost(t *testing.T) {
+	trans := NewTransportUart()
+	next := func(url string) MessageId {
+		req, err := NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Connections that do not allocate IDs use the IDs of the host
+		id, err := trans.nextMessageId(req, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	a := next("coap+uart://ttyA/x")
+	next("coap+uart://ttyB/x")
+	if id := next("coap+uart://ttyA/y"); id != a+1 {
+		t.Errorf("Expected message ID %d on ttyA but got %d", a+1, id)
+	}
+	if len(trans.messageIds.byHost) != 2 {
+		t.Errorf("Expected message IDs of 2 hosts but got %d", len(trans.messageIds.byHost))
+	}
+	if lifetime := trans.messageIds.byHost["ttyA"].lifetime; lifetime != EXCHANGE_LIFETIME {
+		t.Errorf("Expected lifetime %s but got %s", EXCHANGE_LIFETIME, lifetime)
+	}
+}
+
+func TestUartConnectorMessageIds(t *testing.T) {
+	c := NewUartConnecter()
+	c.MessageIdLifetime = time.Hour
+	next := func(conn messageIdSource) MessageId {
+		id, err := conn.nextMessageId(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	// A new connection to the same port continues with the IDs of the port
+	first := next(c.newConnection("ttyA", "/dev/ttyA", Mode{}))
+	if id := next(c.newConnection("ttyA", "/dev/ttyA", Mode{})); id != first+1 {
+		t.Errorf("Expected message ID %d after reconnect but got %d", first+1, id)
+	}
+	// Probes use the IDs of the port as well
+	if id, _ := c.messageIds.get("/dev/ttyA", 0).Next(context.Background()); id != first+2 {
+		t.Errorf("Expected message ID %d for probe but got %d", first+2, id)
+	}
+	if ids := c.messageIds.get("/dev/ttyA", 0); ids.lifetime != time.Hour {
+		t.Errorf("Expected lifetime of connector but got %s", ids.lifetime)
+	}
+}
+
+func TestHostMessageIdsPrune(t *testing.T) {
+	var ids hostMessageIds
+	lifetime := 20 * time.Millisecond
+	ids.get("a", lifetime).Next(context.Background())
+	time.Sleep(lifetime)
+	ids.get("b", lifetime).Next(context.Background())
+
+	ids.prune()
+	if _, ok := ids.byHost["a"]; ok {
+		t.Error("Expected allocator without IDs in use to be removed")
+	}
+	if _, ok := ids.byHost["b"]; !ok {
+		t.Error("Expected allocator with IDs in use to be kept")
+	}
+}
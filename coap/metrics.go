@@ -0,0 +1,46 @@
+package coap
+
+import (
+	"sync/atomic"
+)
+
+// Metrics is a snapshot of counters of all transports.
+// See ReadMetrics.
+type Metrics struct {
+	MessagesSent     uint64 // CoAP messages written to any connection
+	MessagesReceived uint64 // CoAP messages read from any connection
+
+	// ResetsReceived counts RST messages received as answer to a
+	// request or notification acknowledgement
+	ResetsReceived uint64
+
+	// ObservationsCanceled counts observations that were ended by
+	// a RST from the server
+	ObservationsCanceled uint64
+
+	// FramesDropped counts received serial frames with invalid encoding
+	// or checksum, see Framer
+	FramesDropped uint64
+
+	// BusPacketsDropped counts frames of RS-485 bus devices that were
+	// dropped because the connection to the device did not read them
+	BusPacketsDropped uint64
+}
+
+var metrics Metrics
+
+// ReadMetrics returns the current value of all counters
+func ReadMetrics() Metrics {
+	return Metrics{
+		MessagesSent:         atomic.LoadUint64(&metrics.MessagesSent),
+		MessagesReceived:     atomic.LoadUint64(&metrics.MessagesReceived),
+		ResetsReceived:       atomic.LoadUint64(&metrics.ResetsReceived),
+		ObservationsCanceled: atomic.LoadUint64(&metrics.ObservationsCanceled),
+		FramesDropped:        atomic.LoadUint64(&metrics.FramesDropped),
+		BusPacketsDropped:    atomic.LoadUint64(&metrics.BusPacketsDropped),
+	}
+}
+
+func countMetric(counter *uint64) {
+	atomic.AddUint64(counter, 1)
+}
@@ -0,0 +1,294 @@
+package coap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+const UdpScheme = "coap"
+
+// DEFAULT_LEISURE is the time a server may wait before it answers a
+// multicast request (RFC 7252, Section 8.2). Clients collect responses
+// for at least that long.
+const DEFAULT_LEISURE = 5 * time.Second
+
+// MulticastRoundTripper is implemented by transports that are able to
+// send a request to a group of endpoints and collect all responses.
+type MulticastRoundTripper interface {
+	// RoundTripMulticast sends the request as a non-confirmable
+	// message and returns a channel that receives every response.
+	//
+	// The channel is closed when the request context is done or,
+	// for contexts without a deadline, after the Leisure period.
+	RoundTripMulticast(*Request) (<-chan *Response, error)
+}
+
+// TransportMulticast sends requests via UDP to a multicast group
+// (RFC 7252, Section 8 and RFC 7390), e.g.
+// coap://[ff02::1%25eth0]/.well-known/core
+//
+// Each request uses its own socket, responses are sent as unicast
+// messages by the group members and annotated with the address of the
+// responding endpoint.
+//
+// Each group has its own message IDs like the endpoints of
+// TransportUart, see MessageIds.
+type TransportMulticast struct {
+	messageIds hostMessageIds // By group address
+
+	TokenGenerator TokenGenerator
+
+	// Leisure specifies how long responses are collected for requests
+	// without a context deadline. 0 = DEFAULT_LEISURE
+	Leisure time.Duration
+
+	// MessageIdLifetime is the time before a message ID is used again
+	// for the same group, EXCHANGE_LIFETIME if 0
+	MessageIdLifetime time.Duration
+}
+
+func NewTransportMulticast() *TransportMulticast {
+	return &TransportMulticast{
+		TokenGenerator: NewRandomTokenGenerator(),
+	}
+}
+
+func (t *TransportMulticast) RoundTripMulticast(req *Request) (<-chan *Response, error) {
+	if req == nil {
+		return nil, errors.New("coap: Got nil request")
+	}
+	if req.URL == nil {
+		return nil, errors.New("coap: Missing request URL")
+	}
+	if req.URL.Scheme != UdpScheme {
+		return nil, errors.New(fmt.Sprint("coap: Invalid URL scheme, expected "+UdpScheme+" but got: ", req.URL.Scheme))
+	}
+	if req.Confirmable {
+		// Multicast requests must not be confirmable (RFC 7252, Section 8.1)
+		return nil, errors.New("coap: multicast request must be non-confirmable")
+	}
+
+	if len(req.Token) == 0 {
+		req.Token = t.TokenGenerator.NextToken()
+	}
+	msgId, err := t.messageIds.get(req.URL.Host, t.MessageIdLifetime).Next(req.Context())
+	if err != nil {
+		req.closeBody()
+		return nil, fmt.Errorf("coap: message IDs of %s exhausted: %w", req.URL.Host, err)
+	}
+	reqMsg, err := buildRequestMessage(req, uint16(msgId))
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", canonicalAddr(req.URL))
+	if err != nil {
+		return nil, fmt.Errorf("coap: failed to resolve multicast address: %w", err)
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	bin := reqMsg.MustMarshalBinary()
+	if _, err := conn.WriteTo(bin, addr); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("coap: failed to send multicast request: %w", err)
+	}
+	tapPacket(PacketOutgoing, bin)
+	countMetric(&metrics.MessagesSent)
+
+	ctx := req.Context()
+	var cancel context.CancelFunc = nop
+	if _, ok := ctx.Deadline(); !ok {
+		ctx, cancel = context.WithTimeout(ctx, t.leisure())
+	}
+
+	resCh := make(chan *Response)
+	go func() {
+		defer cancel()
+		defer close(resCh)
+		collectResponses(ctx, conn, req, reqMsg, resCh)
+	}()
+	return resCh, nil
+}
+
+func (t *TransportMulticast) leisure() time.Duration {
+	if t.Leisure > 0 {
+		return t.Leisure
+	}
+	return DEFAULT_LEISURE
+}
+
+// collectResponses reads responses matching the token of reqMsg from
+// conn until ctx is done. Confirmable responses are acknowledged.
+// Takes care of closing conn.
+func collectResponses(ctx context.Context, conn *net.UDPConn, req *Request, reqMsg *coapmsg.Message, resCh chan<- *Response) {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stop:
+		}
+		conn.Close()
+	}()
+
+	for {
+		// Parsed messages refer to the buffer, so each datagram needs its own
+		buf := make([]byte, 1500)
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.WithError(err).Warn("Failed to read multicast response")
+			}
+			return
+		}
+		tapPacket(PacketIncoming, buf[:n])
+		msg, err := coapmsg.ParseMessage(buf[:n])
+		if err != nil {
+			log.WithError(err).WithField("RemoteAddr", from.String()).Warn("Failed to parse multicast response")
+			continue
+		}
+		countMetric(&metrics.MessagesReceived)
+
+		if err := validateToken(reqMsg, &msg); err != nil || !msg.Code.IsResponse() {
+			if msg.Type == coapmsg.Confirmable {
+				rst := coapmsg.NewRst(msg.MessageID)
+				sendTo(conn, &rst, from)
+			}
+			continue
+		}
+		if msg.Type == coapmsg.Confirmable {
+			ack := coapmsg.NewAck(msg.MessageID)
+			sendTo(conn, &ack, from)
+		}
+
+		res := buildResponse(req, &msg)
+		res.RemoteAddr = from.String()
+		select {
+		case resCh <- res:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func sendTo(conn net.PacketConn, msg *coapmsg.Message, addr net.Addr) {
+	bin := msg.MustMarshalBinary()
+	if _, err := conn.WriteTo(bin, addr); err != nil {
+		log.WithError(err).WithField("RemoteAddr", addr.String()).Warn("Failed to send message")
+		return
+	}
+	tapPacket(PacketOutgoing, bin)
+	countMetric(&metrics.MessagesSent)
+}
+
+// Multicast sends a GET request to all members of the multicast group
+// addressed by url and returns a channel that receives the response of
+// every endpoint. Response.RemoteAddr identifies the responding endpoint.
+//
+// Responses are collected until ctx is done. If ctx has no deadline,
+// the channel is closed after the Leisure period of the transport.
+// The Client's Transport must implement MulticastRoundTripper.
+func (c *Client) Multicast(ctx context.Context, url string) (<-chan *Response, error) {
+	rt, ok := c.transport().(MulticastRoundTripper)
+	if !ok {
+		return nil, errors.New("coap: Client.Transport does not support multicast")
+	}
+	req, err := NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Confirmable = false
+	return rt.RoundTripMulticast(req.WithContext(ctx))
+}
+
+// Multicast sends a GET request to a multicast group with the
+// DefaultClient. See Client.Multicast.
+func Multicast(ctx context.Context, url string) (<-chan *Response, error) {
+	return DefaultClient.Multicast(ctx, url)
+}
+
+// ServeMulticastMessage handles a request message that was received on
+// a multicast address and returns the response message or nil when no
+// response must be sent.
+//
+// Error responses (4.xx and 5.xx) are suppressed, since the requesting
+// client can not make use of them (RFC 7252, Section 8.2).
+func ServeMulticastMessage(h Handler, reqMsg *coapmsg.Message) *coapmsg.Message {
+	return serveMulticastMessage(h, reqMsg, "")
+}
+
+func serveMulticastMessage(h Handler, reqMsg *coapmsg.Message, remoteAddr string) *coapmsg.Message {
+	if reqMsg.Code == coapmsg.Empty {
+		// A CoAP ping must not be sent to a multicast address
+		return nil
+	}
+	res := ServeMessageFrom(h, reqMsg, remoteAddr)
+	if res.Code.IsError() {
+		return nil
+	}
+	return res
+}
+
+// LeisureDelay returns a random delay within the leisure period that a
+// server should wait before it answers a multicast request, to avoid
+// that all group members respond at the same time.
+func LeisureDelay(leisure time.Duration) time.Duration {
+	if leisure <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(leisure)))
+}
+
+// ServeMulticast reads requests from conn, which is typically bound to
+// a multicast group with net.ListenMulticastUDP, and answers them with
+// h after a random delay within leisure. Error responses are
+// suppressed, see ServeMulticastMessage.
+//
+// ServeMulticast returns when reading from conn fails, e.g. because
+// conn was closed.
+func ServeMulticast(conn net.PacketConn, h Handler, leisure time.Duration) error {
+	var ids hostMessageIds // By client address
+	lastPrune := time.Now()
+	for {
+		// Forget clients whose message IDs expired, the map would grow
+		// with every client otherwise
+		if time.Since(lastPrune) > EXCHANGE_LIFETIME {
+			ids.prune()
+			lastPrune = time.Now()
+		}
+
+		// The request is handled after the leisure delay, so each
+		// datagram needs its own buffer
+		buf := make([]byte, 1500)
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		tapPacket(PacketIncoming, buf[:n])
+		reqMsg, err := coapmsg.ParseMessage(buf[:n])
+		if err != nil {
+			log.WithError(err).WithField("RemoteAddr", from.String()).Warn("Failed to parse multicast request")
+			continue
+		}
+		countMetric(&metrics.MessagesReceived)
+
+		go func(reqMsg coapmsg.Message, from net.Addr) {
+			time.Sleep(LeisureDelay(leisure))
+			res := serveMulticastMessage(h, &reqMsg, from.String())
+			if res == nil {
+				return
+			}
+			msgId, _ := ids.get(from.String(), 0).Next(context.Background()) // Never fails without deadline
+			res.MessageID = uint16(msgId)
+			sendTo(conn, res, from)
+		}(reqMsg, from)
+	}
+}
@@ -0,0 +1,238 @@
+package coap
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// listenUDP opens a socket on the loopback interface that stands in for
+// a member of a multicast group
+func listenUDP(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestClientMulticastCollectsResponses(t *testing.T) {
+	group := listenUDP(t)
+	defer group.Close()
+	other := listenUDP(t)
+	defer other.Close()
+	bodies := map[string]string{
+		group.LocalAddr().String(): "AAAA",
+		other.LocalAddr().String(): "BBBB",
+	}
+
+	// The request is received once, but answered by two endpoints
+	go func() {
+		buf := make([]byte, 1500)
+		n, from, err := group.ReadFrom(buf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		reqMsg, err := coapmsg.ParseMessage(buf[:n])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if reqMsg.Type != coapmsg.NonConfirmable {
+			t.Error("Expected NON request but got", reqMsg.Type)
+		}
+		for i, conn := range []*net.UDPConn{group, other} {
+			body := bodies[conn.LocalAddr().String()]
+			res := ServeMulticastMessage(HandlerFunc(func(w ResponseWriter, r *Request) {
+				w.Write([]byte(body))
+			}), &reqMsg)
+			res.MessageID = uint16(i)
+			conn.WriteTo(res.MustMarshalBinary(), from)
+		}
+	}()
+
+	client := &Client{Transport: &Transport{TransMulticast: NewTransportMulticast()}}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	resCh, err := client.Multicast(ctx, "coap://"+group.LocalAddr().String()+"/.well-known/core")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Collect all responses before reading the bodies, a later datagram
+	// must not change earlier responses
+	var responses []*Response
+	for res := range resCh {
+		responses = append(responses, res)
+	}
+	remotes := map[string]bool{}
+	for _, res := range responses {
+		body, _ := ioutil.ReadAll(res.Body)
+		if res.Code != coapmsg.Content || string(body) != bodies[res.RemoteAddr] {
+			t.Error("Unexpected response", res.RemoteAddr, res.Status, string(body))
+		}
+		remotes[res.RemoteAddr] = true
+	}
+	if len(remotes) != 2 {
+		t.Error("Expected responses of both endpoints but got", remotes)
+	}
+}
+
+func TestServeMulticastQueuedRequests(t *testing.T) {
+	conn := listenUDP(t)
+	defer conn.Close()
+	go ServeMulticast(conn, HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.RemoteAddr == "" {
+			t.Error("Expected RemoteAddr of the client")
+		}
+		w.Write([]byte(r.URL.Path))
+	}), 50*time.Millisecond)
+
+	trans := NewTransportMulticast()
+	trans.Leisure = 300 * time.Millisecond
+	client := &Client{Transport: &Transport{TransMulticast: trans}}
+
+	// Both requests arrive before the first one is answered
+	paths := []string{"/aaaa", "/bbbb"}
+	resChs := make([]<-chan *Response, len(paths))
+	for i, path := range paths {
+		resCh, err := client.Multicast(context.Background(), "coap://"+conn.LocalAddr().String()+path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resChs[i] = resCh
+	}
+	var wg sync.WaitGroup
+	for i, resCh := range resChs {
+		wg.Add(1)
+		go func(path string, resCh <-chan *Response) {
+			defer wg.Done()
+			count := 0
+			for res := range resCh {
+				count++
+				if body, _ := ioutil.ReadAll(res.Body); string(body) != path {
+					t.Errorf("Expected %s but got %s", path, body)
+				}
+			}
+			if count != 1 {
+				t.Errorf("%s: Expected 1 response but got %d", path, count)
+			}
+		}(paths[i], resCh)
+	}
+	wg.Wait()
+}
+
+func TestServeMulticastSuppressesErrors(t *testing.T) {
+	conn := listenUDP(t)
+	defer conn.Close()
+
+	mux := HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path != "/found" {
+			w.WriteCode(coapmsg.NotFound)
+			return
+		}
+		w.Write([]byte("here"))
+	})
+	go ServeMulticast(conn, mux, 10*time.Millisecond)
+
+	trans := NewTransportMulticast()
+	trans.Leisure = 200 * time.Millisecond
+	client := &Client{Transport: &Transport{TransMulticast: trans}}
+
+	for path, expected := range map[string]int{"/found": 1, "/missing": 0} {
+		resCh, err := client.Multicast(context.Background(), "coap://"+conn.LocalAddr().String()+path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		count := 0
+		for range resCh {
+			count++
+		}
+		if count != expected {
+			t.Errorf("%s: Expected %d responses but got %d", path, expected, count)
+		}
+	}
+}
+
+func TestServeMulticastMessage(t *testing.T) {
+	ping := coapmsg.NewMessage()
+	ping.Type = coapmsg.Confirmable
+	if res := ServeMulticastMessage(HandlerFunc(func(w ResponseWriter, r *Request) {}), &ping); res != nil {
+		t.Error("Expected no response to a multicast ping but got", res.Type)
+	}
+
+	reqMsg := coapmsg.NewMessage()
+	reqMsg.Type = coapmsg.NonConfirmable
+	reqMsg.Code = coapmsg.GET
+	res := ServeMulticastMessage(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.WriteCode(coapmsg.InternalServerError)
+	}), &reqMsg)
+	if res != nil {
+		t.Error("Expected error response to be suppressed but got", res.Code.Dotted())
+	}
+}
+
+func TestMulticastRequiresNonConfirmable(t *testing.T) {
+	req, err := NewRequest("GET", "coap://[ff02::1]/.well-known/core", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewTransportMulticast().RoundTripMulticast(req); err == nil {
+		t.Error("Expected error for confirmable multicast request")
+	}
+}
+
+func TestLeisureDelay(t *testing.T) {
+	if d := LeisureDelay(0); d != 0 {
+		t.Error("Expected no delay but got", d)
+	}
+	for i := 0; i < 100; i++ {
+		if d := LeisureDelay(time.Second); d < 0 || d >= time.Second {
+			t.Fatal("Delay not within leisure", d)
+		}
+	}
+}
+
+func TestTransportMulticastMessageIds(t *testing.T) {
+	group := listenUDP(t)
+	defer group.Close()
+
+	trans := NewTransportMulticast()
+	var ids []uint16
+	for i := 0; i < 2; i++ {
+		req, err := NewRequest("GET", "coap://"+group.LocalAddr().String()+"/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Confirmable = false
+		ctx, cancel := context.WithCancel(context.Background())
+		if _, err := trans.RoundTripMulticast(req.WithContext(ctx)); err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+
+		buf := make([]byte, 1500)
+		group.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := group.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := coapmsg.ParseMessage(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, msg.MessageID)
+	}
+	if ids[1] != ids[0]+1 {
+		t.Errorf("Expected consecutive message IDs but got %v", ids)
+	}
+	if n := trans.messageIds.get(group.LocalAddr().String(), 0).InUse(); n != 2 {
+		t.Errorf("Expected 2 message IDs in use for the group but got %d", n)
+	}
+}
@@ -0,0 +1,91 @@
+package coap
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// ServeMux is a CoAP request multiplexer. It matches the URL path of
+// each incoming request against a list of registered patterns and
+// calls the handler for the pattern that most closely matches.
+//
+// Patterns name fixed paths, like "/3/0", or rooted subtrees, like
+// "/3/" (note the trailing slash). Longer patterns take precedence
+// over shorter ones, so "/3/0/" is preferred over "/3/" for the path
+// "/3/0/1". A subtree pattern also matches the path without the
+// trailing slash, i.e. "/3/" handles requests for "/3" unless "/3"
+// is registered as well.
+//
+// Requests that do not match any pattern are answered with 4.04 Not Found.
+type ServeMux struct {
+	mu sync.RWMutex
+	m  map[string]Handler
+}
+
+// NewServeMux allocates and returns a new ServeMux.
+func NewServeMux() *ServeMux {
+	return &ServeMux{m: make(map[string]Handler)}
+}
+
+// Handle registers the handler for the given pattern.
+// If a handler already exists for pattern, Handle panics.
+func (mux *ServeMux) Handle(pattern string, handler Handler) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	if pattern == "" || pattern[0] != '/' {
+		panic("coap: invalid pattern " + pattern)
+	}
+	if handler == nil {
+		panic("coap: nil handler")
+	}
+	if _, exist := mux.m[pattern]; exist {
+		panic("coap: multiple registrations for " + pattern)
+	}
+	if mux.m == nil {
+		mux.m = make(map[string]Handler)
+	}
+	mux.m[pattern] = handler
+}
+
+// HandleFunc registers the handler function for the given pattern.
+func (mux *ServeMux) HandleFunc(pattern string, handler func(ResponseWriter, *Request)) {
+	mux.Handle(pattern, HandlerFunc(handler))
+}
+
+// Handler returns the handler to use for the given path and the
+// registered pattern that matches it. If no pattern matches, Handler
+// returns nil and an empty pattern.
+func (mux *ServeMux) Handler(path string) (h Handler, pattern string) {
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+
+	if path == "" {
+		path = "/"
+	}
+	if h, ok := mux.m[path]; ok {
+		return h, path
+	}
+	for p, handler := range mux.m {
+		if !strings.HasSuffix(p, "/") {
+			continue
+		}
+		if (strings.HasPrefix(path, p) || path == strings.TrimSuffix(p, "/")) && len(p) > len(pattern) {
+			h, pattern = handler, p
+		}
+	}
+	return h, pattern
+}
+
+// ServeCOAP dispatches the request to the handler whose
+// pattern most closely matches the request URL path.
+func (mux *ServeMux) ServeCOAP(w ResponseWriter, r *Request) {
+	h, _ := mux.Handler(r.URL.Path)
+	if h == nil {
+		w.WriteCode(coapmsg.NotFound)
+		return
+	}
+	h.ServeCOAP(w, r)
+}
@@ -0,0 +1,62 @@
+package coap
+
+import (
+	"testing"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+func TestServeMux(t *testing.T) {
+	mux := NewServeMux()
+	handler := func(name string) HandlerFunc {
+		return func(w ResponseWriter, r *Request) {
+			w.Write([]byte(name))
+		}
+	}
+	mux.Handle("/3/", handler("object"))
+	mux.Handle("/3/0/", handler("instance"))
+	mux.Handle("/3/0/1", handler("resource"))
+	mux.HandleFunc("/rd", handler("rd"))
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"3", "object"},
+		{"3/1", "object"},
+		{"3/0", "instance"},
+		{"3/0/0", "instance"},
+		{"3/0/1", "resource"},
+		{"rd", "rd"},
+		{"rd/1", ""},
+		{"4", ""},
+	}
+	for _, test := range tests {
+		msg := coapmsg.NewMessage()
+		msg.Type = coapmsg.Confirmable
+		msg.Code = coapmsg.GET
+		msg.SetPathString(test.path)
+		res := ServeMessage(mux, &msg)
+
+		if test.expected == "" {
+			if res.Code != coapmsg.NotFound {
+				t.Errorf("%s: Expected 4.04 but got %s", test.path, res.Code.Dotted())
+			}
+			continue
+		}
+		if string(res.Payload) != test.expected {
+			t.Errorf("%s: Expected handler %q but got %q", test.path, test.expected, res.Payload)
+		}
+	}
+}
+
+func TestServeMuxDuplicatePattern(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic for duplicate pattern")
+		}
+	}()
+	mux := NewServeMux()
+	mux.Handle("/a", HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	mux.Handle("/a", HandlerFunc(func(w ResponseWriter, r *Request) {}))
+}
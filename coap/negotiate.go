@@ -0,0 +1,64 @@
+package coap
+
+import (
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// Negotiate selects the content format of the response from the offered
+// formats (RFC 7252, Section 5.10.4).
+//
+// Without Accept option the first offer is selected. Otherwise the
+// accepted format is selected if it is offered. ok is false if none of
+// the offers is acceptable and the request should be answered with
+// 4.06 Not Acceptable.
+func Negotiate(r *Request, offers ...coapmsg.MediaType) (mt coapmsg.MediaType, ok bool) {
+	if len(offers) == 0 {
+		return 0, false
+	}
+	accept := r.Options.Get(coapmsg.Accept)
+	if accept.IsNotSet() {
+		return offers[0], true
+	}
+	want := accept.AsMediaType()
+	for _, o := range offers {
+		if o == want {
+			return o, true
+		}
+	}
+	return 0, false
+}
+
+// NegotiatingHandler serves a resource in multiple representations.
+//
+// The representation is selected with Negotiate from the Accept option
+// of the request and the handler of the selected format is called with
+// the Content-Format option of the response already set. Requests
+// accepting none of the formats are answered with 4.06 Not Acceptable.
+type NegotiatingHandler struct {
+	// Representations maps content formats to the handlers producing them
+	Representations map[coapmsg.MediaType]Handler
+
+	// Default is the format for requests without Accept option.
+	// It must be one of Representations.
+	Default coapmsg.MediaType
+}
+
+func (h *NegotiatingHandler) ServeCOAP(w ResponseWriter, r *Request) {
+	offers := []coapmsg.MediaType{h.Default}
+	for mt := range h.Representations {
+		if mt != h.Default {
+			offers = append(offers, mt)
+		}
+	}
+
+	mt, ok := Negotiate(r, offers...)
+	next := h.Representations[mt]
+	if !ok || next == nil {
+		log.WithField("Accept", r.Options.Get(coapmsg.Accept).AsMediaType()).Debug("No acceptable representation")
+		w.WriteCode(coapmsg.NotAcceptable)
+		return
+	}
+
+	w.Options().Set(coapmsg.ContentFormat, mt)
+	next.ServeCOAP(w, r)
+}
@@ -0,0 +1,58 @@
+package coap
+
+import (
+	"testing"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+func TestNegotiatingHandler(t *testing.T) {
+	text := HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Write([]byte("22.5"))
+	})
+	senml := HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Write([]byte(`[{"n":"temp","v":22.5}]`))
+	})
+	h := &NegotiatingHandler{
+		Representations: map[coapmsg.MediaType]Handler{
+			coapmsg.TextPlain:    text,
+			coapmsg.AppSenMLJSON: senml,
+		},
+		Default: coapmsg.TextPlain,
+	}
+
+	tests := []struct {
+		accept  interface{}
+		code    coapmsg.COAPCode
+		format  coapmsg.MediaType
+		payload string
+	}{
+		{nil, coapmsg.Content, coapmsg.TextPlain, "22.5"},
+		{coapmsg.TextPlain, coapmsg.Content, coapmsg.TextPlain, "22.5"},
+		{coapmsg.AppSenMLJSON, coapmsg.Content, coapmsg.AppSenMLJSON, `[{"n":"temp","v":22.5}]`},
+		{coapmsg.AppLwM2MTLV, coapmsg.NotAcceptable, 0, ""},
+	}
+
+	for _, test := range tests {
+		req, _ := NewRequest("GET", "coap://dev/temp", nil)
+		if test.accept != nil {
+			req.Options.Set(coapmsg.Accept, test.accept)
+		}
+		w := newResponseWriter()
+		h.ServeCOAP(w, req)
+
+		if w.msg.Code != test.code {
+			t.Errorf("Accept %v: expected code %s but got %s", test.accept, test.code.Dotted(), w.msg.Code.Dotted())
+			continue
+		}
+		if test.code != coapmsg.Content {
+			continue
+		}
+		if got := w.msg.Options().Get(coapmsg.ContentFormat).AsMediaType(); got != test.format {
+			t.Errorf("Accept %v: expected Content-Format %s but got %s", test.accept, test.format, got)
+		}
+		if string(w.msg.Payload) != test.payload {
+			t.Errorf("Accept %v: unexpected payload %q", test.accept, w.msg.Payload)
+		}
+	}
+}
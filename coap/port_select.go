@@ -0,0 +1,207 @@
+package coap
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/trusch/coap-go/coapmsg"
+	"go.bug.st/serial.v1"
+	"go.bug.st/serial.v1/enumerator"
+)
+
+// DefaultProbeTimeout is the time to wait for the answer to a probe ping
+const DefaultProbeTimeout = 500 * time.Millisecond
+
+// Hosts of coap+uart URLs that select a port by its properties instead
+// of its name:
+//
+//	any              the first port, USB ports are preferred
+//	usb-0483-5740    the first USB port with the given vendor and product ID (hex)
+//	serial=ABC123    the port of the USB device with the given serial number
+const (
+	HostAny          = "any"
+	HostUSBPrefix    = "usb-"
+	HostSerialPrefix = "serial="
+)
+
+// listPorts enumerates the serial ports, replaced in tests
+var listPorts = enumerator.GetDetailedPortsList
+
+// portMatcher returns the filter for a host selecting ports by their
+// properties. ok is false if host is a port name.
+func portMatcher(host string) (match func(*enumerator.PortDetails) bool, ok bool) {
+	switch {
+	case host == HostAny:
+		return func(*enumerator.PortDetails) bool { return true }, true
+	case strings.HasPrefix(host, HostSerialPrefix):
+		serialNumber := strings.TrimPrefix(host, HostSerialPrefix)
+		return func(p *enumerator.PortDetails) bool {
+			return p.IsUSB && p.SerialNumber == serialNumber
+		}, true
+	case strings.HasPrefix(host, HostUSBPrefix):
+		ids := strings.Split(strings.TrimPrefix(host, HostUSBPrefix), "-")
+		if len(ids) != 2 {
+			return nil, false
+		}
+		return func(p *enumerator.PortDetails) bool {
+			return p.IsUSB && strings.EqualFold(p.VID, ids[0]) && strings.EqualFold(p.PID, ids[1])
+		}, true
+	}
+	return nil, false
+}
+
+// selectPort returns the name of the port for a host selecting ports by
+// their properties. The port selected last time for the host is tried
+// first. Ports that are already open, also as RS-485 bus, are skipped.
+// When probing is enabled, the first port answering a CoAP ping is
+// selected.
+//
+// Must be called with c.connectMutex held. It is released while probing,
+// so the caller must check again for connections opened meanwhile.
+func (c *UartConnector) selectPort(host string, match func(*enumerator.PortDetails) bool, mode Mode) (string, error) {
+	ports, err := listPorts()
+	if err != nil {
+		return "", fmt.Errorf("coap: failed to list serial ports: %w", err)
+	}
+
+	var usb, other []string
+	for _, p := range ports {
+		if !match(p) || c.portInUse(p.Name) {
+			continue
+		}
+		if p.IsUSB {
+			usb = append(usb, p.Name)
+		} else {
+			other = append(other, p.Name)
+		}
+	}
+	candidates := append(usb, other...)
+	if last := c.lastPorts[host]; last != "" {
+		for i, name := range candidates {
+			if name == last {
+				candidates = append([]string{last}, append(candidates[:i:i], candidates[i+1:]...)...)
+				break
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("coap: no serial port found for %q", host)
+	}
+
+	if !c.Probe {
+		c.rememberPort(host, candidates[0])
+		return candidates[0], nil
+	}
+	for _, name := range candidates {
+		// Probing takes up to ProbeTimeout per port, connections to
+		// other hosts must not wait for it
+		c.connectMutex.Unlock()
+		ok := c.probe(name, mode)
+		c.connectMutex.Lock()
+		if ok {
+			c.rememberPort(host, name)
+			return name, nil
+		}
+		log.WithField("port", name).WithField("host", host).Debug("No answer to probe")
+	}
+	return "", fmt.Errorf("coap: no serial port for %q answered the probe", host)
+}
+
+// portInUse reports whether a connection or an RS-485 bus of the
+// connector has the port open. Must be called with c.connectMutex held.
+func (c *UartConnector) portInUse(portName string) bool {
+	for _, con := range c.connections {
+		if sc, ok := con.(*serialConnection); ok && !sc.Closed() && sc.info().Port == portName {
+			return true
+		}
+	}
+	for _, b := range c.buses {
+		if !b.conn.Closed() && b.conn.info().Port == portName {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *UartConnector) rememberPort(host, portName string) {
+	if c.lastPorts == nil {
+		c.lastPorts = make(map[string]string)
+	}
+	if c.lastPorts[host] != portName {
+		log.WithField("host", host).WithField("port", portName).Info("Selected serial port")
+	}
+	c.lastPorts[host] = portName
+}
+
+func (c *UartConnector) probe(portName string, mode Mode) bool {
+	if c.probePort != nil {
+		return c.probePort(portName, mode)
+	}
+	timeout := c.ProbeTimeout
+	if timeout <= 0 {
+		timeout = DefaultProbeTimeout
+	}
+	port, err := serial.Open(portName, newSerialMode(mode))
+	if err != nil {
+		log.WithError(err).WithField("port", portName).Debug("Failed to open port for probe")
+		return false
+	}
+	defer port.Close()
+	framer := mode.Framer
+	if framer == nil {
+		framer = SLIPFramer{}
+	}
+	return probe(port, framer, timeout, c.messageIds.get(portName, c.MessageIdLifetime))
+}
+
+// probe sends a CoAP ping (RFC 7252, Section 4.3) and reports whether
+// the matching RST arrives within timeout. The caller must close rw
+// afterwards to stop a pending read. The message ID is taken from ids.
+func probe(rw io.ReadWriter, framer Framer, timeout time.Duration, ids *MessageIds) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	msgId, err := ids.Next(ctx)
+	if err != nil {
+		return false
+	}
+	ping := coapmsg.NewMessage()
+	ping.Type = coapmsg.Confirmable
+	ping.Code = coapmsg.Empty
+	ping.MessageID = uint16(msgId)
+	if err := framer.NewWriter(rw).WritePacket(ping.MustMarshalBinary()); err != nil {
+		return false
+	}
+
+	answered := make(chan bool, 1)
+	go func() {
+		reader := framer.NewReader(rw)
+		var packet []byte
+		for {
+			p, isPrefix, err := reader.ReadPacket()
+			if err != nil {
+				answered <- false
+				return
+			}
+			packet = append(packet, p...)
+			if isPrefix {
+				continue
+			}
+			msg, err := coapmsg.ParseMessage(packet)
+			packet = nil
+			if err == nil && msg.Type == coapmsg.Reset && msg.MessageID == ping.MessageID {
+				answered <- true
+				return
+			}
+		}
+	}()
+
+	select {
+	case ok := <-answered:
+		return ok
+	case <-time.After(timeout):
+		return false
+	}
+}
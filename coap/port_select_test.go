@@ -0,0 +1,161 @@
+package coap
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Lobaro/slip"
+	"github.com/trusch/coap-go/coapmsg"
+	"go.bug.st/serial.v1/enumerator"
+)
+
+var testPorts = []*enumerator.PortDetails{
+	{Name: "/dev/ttyS0"},
+	{Name: "/dev/ttyACM0", IsUSB: true, VID: "0483", PID: "5740", SerialNumber: "ABC123"},
+	{Name: "/dev/ttyUSB0", IsUSB: true, VID: "0403", PID: "6001", SerialNumber: "FT1"},
+	{Name: "/dev/ttyUSB1", IsUSB: true, VID: "0403", PID: "6001", SerialNumber: "FT2"},
+}
+
+func withTestPorts(t *testing.T) {
+	listPorts = func() ([]*enumerator.PortDetails, error) {
+		return testPorts, nil
+	}
+	t.Cleanup(func() { listPorts = enumerator.GetDetailedPortsList })
+}
+
+func TestSelectPort(t *testing.T) {
+	withTestPorts(t)
+	c := NewUartConnecter()
+
+	tests := map[string]string{
+		"any":            "/dev/ttyACM0",
+		"usb-0483-5740":  "/dev/ttyACM0",
+		"usb-0403-6001":  "/dev/ttyUSB0",
+		"serial=FT2":     "/dev/ttyUSB1",
+		"USB-0483-5740":  "",
+		"serial=unknown": "",
+	}
+	for host, expected := range tests {
+		match, ok := portMatcher(host)
+		if !ok {
+			if expected != "" {
+				t.Errorf("%s: expected port selection", host)
+			}
+			continue
+		}
+		name, err := c.selectPort(host, match, Mode{})
+		if expected == "" {
+			if err == nil {
+				t.Errorf("%s: expected error but got %s", host, name)
+			}
+			continue
+		}
+		if err != nil || name != expected {
+			t.Errorf("%s: expected %s but got %s (%v)", host, expected, name, err)
+		}
+	}
+
+	if _, ok := portMatcher("ttyUSB0"); ok {
+		t.Error("Expected port name not to select by properties")
+	}
+}
+
+func TestSelectPortProbe(t *testing.T) {
+	withTestPorts(t)
+	c := NewUartConnecter()
+	c.Probe = true
+	var probed []string
+	answering := "/dev/ttyUSB1"
+	c.probePort = func(portName string, mode Mode) bool {
+		probed = append(probed, portName)
+		// Other hosts can be connected while probing
+		done := make(chan struct{})
+		go func() {
+			c.Connections()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("Connector is locked while probing")
+		}
+		return portName == answering
+	}
+	c.connectMutex.Lock()
+	defer c.connectMutex.Unlock()
+
+	match, _ := portMatcher("any")
+	name, err := c.selectPort("any", match, Mode{})
+	if err != nil || name != "/dev/ttyUSB1" {
+		t.Fatalf("Expected /dev/ttyUSB1 but got %s (%v)", name, err)
+	}
+	if len(probed) != 3 {
+		t.Errorf("Expected 3 probes but got %v", probed)
+	}
+
+	// The selected port is remembered and probed first
+	probed = nil
+	name, err = c.selectPort("any", match, Mode{})
+	if err != nil || name != "/dev/ttyUSB1" || len(probed) != 1 {
+		t.Errorf("Expected remembered port to be probed first, got %s, probes %v", name, probed)
+	}
+
+	answering = ""
+	if _, err := c.selectPort("any", match, Mode{}); err == nil {
+		t.Error("Expected error when no port answers")
+	}
+}
+
+func TestSelectPortSkipsOpenPorts(t *testing.T) {
+	withTestPorts(t)
+	c := NewUartConnecter()
+	open := newSerialConnection("/dev/ttyACM0", Mode{})
+	open.state = StateConnected
+	c.connections = append(c.connections, open)
+	bus := newRS485Bus(newSerialConnection("/dev/ttyUSB0", Mode{}), 0, 0)
+	bus.conn.state = StateConnected
+	c.buses = append(c.buses, bus)
+
+	match, _ := portMatcher("any")
+	if name, err := c.selectPort("any", match, Mode{}); err != nil || name != "/dev/ttyUSB1" {
+		t.Errorf("Expected /dev/ttyUSB1 but got %s (%v)", name, err)
+	}
+	match, _ = portMatcher("usb-0483-5740")
+	if name, err := c.selectPort("usb-0483-5740", match, Mode{}); err == nil {
+		t.Error("Expected error for open port but got", name)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	host, device := net.Pipe()
+	defer host.Close()
+	go func() {
+		defer device.Close()
+		reader := slip.NewReader(device)
+		p, _, err := reader.ReadPacket()
+		if err != nil {
+			return
+		}
+		ping, err := coapmsg.ParseMessage(p)
+		if err != nil || ping.Code != coapmsg.Empty {
+			return
+		}
+		rst := coapmsg.NewRst(ping.MessageID)
+		slip.NewWriter(device).WritePacket(rst.MustMarshalBinary())
+	}()
+	if !probe(host, SLIPFramer{}, time.Second, NewMessageIds(0)) {
+		t.Error("Expected device to answer the probe")
+	}
+
+	silent, peer := net.Pipe()
+	defer silent.Close()
+	go func() {
+		buf := make([]byte, 64)
+		peer.Read(buf)
+	}()
+	if probe(silent, SLIPFramer{}, 50*time.Millisecond, NewMessageIds(0)) {
+		t.Error("Expected probe of silent port to fail")
+	}
+	peer.Close()
+}
@@ -65,6 +65,14 @@ type Request struct {
 	// Handler does not need to.
 	Body io.ReadCloser
 
+	// RemoteAddr identifies the endpoint that sent the request.
+	// It is set by the server before invoking a handler, see
+	// ServeMessageFrom and ServeMulticast, and is used e.g. by the
+	// EchoHandler to track verified endpoints.
+	//
+	// For client requests, this field is ignored.
+	RemoteAddr string
+
 	// Cancel is an optional channel whose closure indicates that the client
 	// request should be regarded as canceled. Not all implementations of
 	// RoundTripper may support Cancel.
@@ -11,8 +11,9 @@ import (
 const OBSERVE_TIMEOUT = 256 * time.Second
 
 type Response struct {
-	Status     string // e.g. "2.05 Content"
-	StatusCode uint8  // e.g. 69 - which is the int representation of 2.05
+	Status     string           // e.g. "2.05 Content"
+	StatusCode uint8            // e.g. 69 - which is the int representation of 2.05
+	Code       coapmsg.COAPCode // e.g. coapmsg.Content, same value as StatusCode
 
 	// Body represents the response body.
 	//
@@ -30,6 +31,11 @@ type Response struct {
 
 	Options coapmsg.CoapOptions
 
+	// RemoteAddr is the address of the endpoint that sent the
+	// response. It is set for responses to multicast requests,
+	// where a single request is answered by several endpoints.
+	RemoteAddr string
+
 	// Request is the request that was sent to obtain this Response.
 	// Request's Body is nil (having already been consumed).
 	// This is only populated for Client requests.
@@ -49,3 +55,12 @@ type Response struct {
 func (r Response) Next() <-chan *Response {
 	return r.next
 }
+
+// Err returns a *ResponseError if the response carries a 4.xx or 5.xx
+// code and nil otherwise.
+func (r *Response) Err() error {
+	if r.Code.IsError() {
+		return &ResponseError{Code: r.Code}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package coap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// A Handler responds to a CoAP request.
+//
+// ServeCOAP should write the response options and payload to the
+// ResponseWriter and then return. The response is sent after
+// ServeCOAP returned.
+type Handler interface {
+	ServeCOAP(w ResponseWriter, r *Request)
+}
+
+// The HandlerFunc type is an adapter to allow the use of
+// ordinary functions as CoAP handlers.
+type HandlerFunc func(ResponseWriter, *Request)
+
+// ServeCOAP calls f(w, r).
+func (f HandlerFunc) ServeCOAP(w ResponseWriter, r *Request) {
+	f(w, r)
+}
+
+// A ResponseWriter is used by a CoAP handler to construct a CoAP response.
+type ResponseWriter interface {
+	// Options returns the option map that will be sent with the response.
+	Options() coapmsg.CoapOptions
+
+	// Write appends data to the response payload.
+	//
+	// If WriteCode has not yet been called, Write calls
+	// WriteCode(coapmsg.Content) before writing the data.
+	Write([]byte) (int, error)
+
+	// WriteCode sets the response code. Only the first call has an effect.
+	WriteCode(code coapmsg.COAPCode)
+}
+
+// responseWriter collects the response of a handler into a message
+type responseWriter struct {
+	msg         coapmsg.Message
+	codeWritten bool
+}
+
+func newResponseWriter() *responseWriter {
+	return &responseWriter{
+		msg: coapmsg.NewMessage(),
+	}
+}
+
+func (w *responseWriter) Options() coapmsg.CoapOptions {
+	return w.msg.Options()
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.codeWritten {
+		w.WriteCode(coapmsg.Content)
+	}
+	w.msg.Payload = append(w.msg.Payload, p...)
+	return len(p), nil
+}
+
+func (w *responseWriter) WriteCode(code coapmsg.COAPCode) {
+	if w.codeWritten {
+		return
+	}
+	w.codeWritten = true
+	w.msg.Code = code
+}
+
+// ReadRequest converts a received CoAP request message into a Request
+// that can be passed to a Handler.
+//
+// The URL is built from the Uri-Host, Uri-Port, Uri-Path and Uri-Query options.
+// The scheme is always "coap".
+func ReadRequest(msg *coapmsg.Message) (*Request, error) {
+	if msg.Code.Class() != 0 || msg.Code == coapmsg.Empty {
+		return nil, errors.New(fmt.Sprint("coap: message is not a request: ", msg.Code.String()))
+	}
+	method := msg.Code.String()
+	if !ValidMethod(method) {
+		return nil, fmt.Errorf("coap: invalid method %q", method)
+	}
+
+	opts := msg.Options()
+	u := &url.URL{
+		Scheme: "coap",
+		Host:   opts.Get(coapmsg.URIHost).AsString(),
+		Path:   "/" + msg.PathString(),
+	}
+	if port := opts.Get(coapmsg.URIPort); port.IsSet() {
+		u.Host += ":" + strconv.Itoa(int(port.AsUInt16()))
+	}
+	var query []string
+	for _, q := range opts[coapmsg.URIQuery] {
+		query = append(query, q.AsString())
+	}
+	u.RawQuery = strings.Join(query, "&")
+
+	return &Request{
+		Method:       method,
+		Confirmable:  msg.Type == coapmsg.Confirmable,
+		URL:          u,
+		Proto:        "CoAP/1",
+		ProtoVersion: 1,
+		Options:      opts,
+		Token:        Token(msg.Token),
+		Body:         ioutil.NopCloser(bytes.NewReader(msg.Payload)),
+	}, nil
+}
+
+// ServeMessage handles a single request message with the given handler
+// and returns the response message.
+//
+// A confirmable request is answered by a piggybacked ACK with the same
+// message ID. Responses to non-confirmable requests are NON messages,
+// the caller is responsible to assign a new message ID.
+// Requests that can not be parsed are answered with 4.00 Bad Request
+// and empty confirmable messages (CoAP ping) with a RST.
+//
+// Request.RemoteAddr is empty, servers should use ServeMessageFrom.
+func ServeMessage(h Handler, reqMsg *coapmsg.Message) *coapmsg.Message {
+	return ServeMessageFrom(h, reqMsg, "")
+}
+
+// ServeMessageFrom handles a request message like ServeMessage.
+// remoteAddr identifies the endpoint that sent the message and is passed
+// to the handler as Request.RemoteAddr.
+func ServeMessageFrom(h Handler, reqMsg *coapmsg.Message, remoteAddr string) *coapmsg.Message {
+	if reqMsg.Code == coapmsg.Empty {
+		rst := coapmsg.NewRst(reqMsg.MessageID)
+		return &rst
+	}
+
+	w := newResponseWriter()
+	req, err := ReadRequest(reqMsg)
+	if err != nil {
+		log.WithError(err).Warn("Failed to read request")
+		w.WriteCode(coapmsg.BadRequest)
+	} else {
+		req.RemoteAddr = remoteAddr
+		h.ServeCOAP(w, req)
+	}
+
+	if !w.codeWritten {
+		w.WriteCode(coapmsg.Content)
+	}
+
+	res := &w.msg
+	res.Token = reqMsg.Token
+	if reqMsg.Type == coapmsg.Confirmable {
+		res.Type = coapmsg.Acknowledgement
+		res.MessageID = reqMsg.MessageID
+	} else {
+		res.Type = coapmsg.NonConfirmable
+	}
+	return res
+}
@@ -0,0 +1,90 @@
+package coap
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+func TestServeMessage(t *testing.T) {
+	var handled *Request
+	h := HandlerFunc(func(w ResponseWriter, r *Request) {
+		handled = r
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		w.Options().Set(coapmsg.ContentFormat, coapmsg.TextPlain)
+		w.WriteCode(coapmsg.Changed)
+		w.Write([]byte("got " + string(body)))
+	})
+
+	req := coapmsg.NewMessage()
+	req.Type = coapmsg.Confirmable
+	req.Code = coapmsg.PUT
+	req.MessageID = 42
+	req.Token = []byte{1, 2}
+	req.Options().Set(coapmsg.URIHost, "example.com")
+	req.Options().Set(coapmsg.URIPort, 5684)
+	req.SetPathString("a/b")
+	req.Options().Add(coapmsg.URIQuery, "x=1")
+	req.Options().Add(coapmsg.URIQuery, "y=2")
+	req.Payload = []byte("data")
+
+	res := ServeMessage(h, &req)
+
+	if handled == nil {
+		t.Fatal("Handler not called")
+	}
+	if handled.Method != "PUT" {
+		t.Error("Expected method PUT but got", handled.Method)
+	}
+	if handled.URL.String() != "coap://example.com:5684/a/b?x=1&y=2" {
+		t.Error("Unexpected URL", handled.URL.String())
+	}
+	if res.Type != coapmsg.Acknowledgement || res.MessageID != 42 {
+		t.Errorf("Expected piggybacked ACK but got %s with id %d", res.Type, res.MessageID)
+	}
+	if !Token(res.Token).Equals(req.Token) {
+		t.Error("Expected token to match")
+	}
+	if res.Code != coapmsg.Changed {
+		t.Error("Expected code Changed but got", res.Code)
+	}
+	if string(res.Payload) != "got data" {
+		t.Errorf("Unexpected payload %q", res.Payload)
+	}
+	if res.Options().Get(coapmsg.ContentFormat).IsNotSet() {
+		t.Error("Expected Content-Format option")
+	}
+}
+
+func TestServeMessageDefaults(t *testing.T) {
+	h := HandlerFunc(func(w ResponseWriter, r *Request) {})
+
+	req := coapmsg.NewMessage()
+	req.Type = coapmsg.NonConfirmable
+	req.Code = coapmsg.GET
+
+	res := ServeMessage(h, &req)
+	if res.Type != coapmsg.NonConfirmable || res.Code != coapmsg.Content {
+		t.Errorf("Expected NON 2.05 but got %s %s", res.Type, res.Code)
+	}
+
+	ping := coapmsg.NewMessage()
+	ping.Type = coapmsg.Confirmable
+	ping.MessageID = 7
+	res = ServeMessage(h, &ping)
+	if res.Type != coapmsg.Reset || res.MessageID != 7 {
+		t.Errorf("Expected RST for ping but got %s", res.Type)
+	}
+
+	invalid := coapmsg.NewMessage()
+	invalid.Type = coapmsg.Confirmable
+	invalid.Code = coapmsg.BuildCode(0, 30)
+	res = ServeMessage(h, &invalid)
+	if res.Code != coapmsg.BadRequest {
+		t.Error("Expected BadRequest but got", res.Code)
+	}
+}
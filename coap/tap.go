@@ -0,0 +1,49 @@
+package coap
+
+import (
+	"sync/atomic"
+)
+
+// PacketDirection tells if a tapped packet was received or sent
+type PacketDirection uint8
+
+const (
+	PacketIncoming PacketDirection = iota // Received from the remote endpoint
+	PacketOutgoing                        // Sent to the remote endpoint
+)
+
+func (d PacketDirection) String() string {
+	if d == PacketOutgoing {
+		return "outgoing"
+	}
+	return "incoming"
+}
+
+// A PacketTap gets a copy of every CoAP packet that is sent or received
+// by any connection, e.g. to write a capture file.
+//
+// TapPacket is called synchronously from the send and receive path and must be
+// safe for concurrent use. It must not modify or retain the packet.
+type PacketTap interface {
+	TapPacket(dir PacketDirection, packet []byte)
+}
+
+type tapHolder struct {
+	tap PacketTap
+}
+
+var packetTap atomic.Value // tapHolder
+
+// SetPacketTap installs a tap that is called for all packets of all
+// transports. Pass nil to remove the tap.
+func SetPacketTap(tap PacketTap) {
+	packetTap.Store(tapHolder{tap})
+}
+
+func tapPacket(dir PacketDirection, packet []byte) {
+	h, ok := packetTap.Load().(tapHolder)
+	if !ok || h.tap == nil {
+		return
+	}
+	h.tap.TapPacket(dir, packet)
+}
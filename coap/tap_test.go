@@ -0,0 +1,88 @@
+package coap
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+type recordingTap struct {
+	mu      sync.Mutex
+	packets map[PacketDirection][][]byte
+}
+
+func (t *recordingTap) TapPacket(dir PacketDirection, packet []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.packets[dir] = append(t.packets[dir], append([]byte{}, packet...))
+}
+
+func (t *recordingTap) count(dir PacketDirection) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.packets[dir])
+}
+
+func TestPacketTap(t *testing.T) {
+	tap := &recordingTap{packets: map[PacketDirection][][]byte{}}
+	SetPacketTap(tap)
+	defer SetPacketTap(nil)
+
+	client, testCon := NewTestClient(t)
+
+	go func() {
+		msg, err := testCon.WaitForSendMessage(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		if err := testCon.FakeReceiveMessage(ack); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	_, err := client.Get("coap+uart://any/tap")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tap.count(PacketOutgoing) != 1 {
+		t.Errorf("Expected 1 outgoing packet but got %d", tap.count(PacketOutgoing))
+	}
+	if tap.count(PacketIncoming) != 1 {
+		t.Errorf("Expected 1 incoming packet but got %d", tap.count(PacketIncoming))
+	}
+}
+
+func TestPacketTapMulticast(t *testing.T) {
+	tap := &recordingTap{packets: map[PacketDirection][][]byte{}}
+	SetPacketTap(tap)
+	defer SetPacketTap(nil)
+
+	conn := listenUDP(t)
+	defer conn.Close()
+	go ServeMulticast(conn, HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Write([]byte("here"))
+	}), 0)
+
+	trans := NewTransportMulticast()
+	trans.Leisure = 200 * time.Millisecond
+	client := &Client{Transport: &Transport{TransMulticast: trans}}
+	resCh, err := client.Multicast(context.Background(), "coap://"+conn.LocalAddr().String()+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range resCh {
+	}
+
+	// Request and response, each sent by one side and received by the other
+	if in, out := tap.count(PacketIncoming), tap.count(PacketOutgoing); in != 2 || out != 2 {
+		t.Errorf("Expected 2 incoming and 2 outgoing packets but got %d and %d", in, out)
+	}
+}
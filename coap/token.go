@@ -1,37 +1,89 @@
 package coap
 
 import (
+	crand "crypto/rand"
+	"fmt"
 	"math/rand"
 	"sync"
-	"time"
 )
 
+// MaxTokenLength is the maximum length of a token in bytes
+const MaxTokenLength = 8
+
+// DefaultTokenLength is the length of tokens of NewRandomTokenGenerator
+const DefaultTokenLength = 4
+
+// A TokenGenerator creates the tokens of requests. Tokens do not need to
+// be unique, the transport generates another token when it is in use by
+// an interaction on the connection.
 type TokenGenerator interface {
 	NextToken() []byte
 }
 
+// RandomTokenGenerator creates random tokens from crypto/rand, so they
+// can not be guessed by an attacker (RFC 7252, Section 5.3.1)
 type RandomTokenGenerator struct {
-	lastTokenSeq uint8      // Sequence counter
-	rand         *rand.Rand // Random source for token generation
-
-	mu sync.Mutex
+	length int
 }
 
+// NewRandomTokenGenerator returns a generator of random tokens with
+// DefaultTokenLength bytes
 func NewRandomTokenGenerator() TokenGenerator {
-	return &RandomTokenGenerator{
-		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	return &RandomTokenGenerator{length: DefaultTokenLength}
+}
+
+// NewRandomTokenGeneratorLength returns a generator of random tokens
+// with 0 to MaxTokenLength bytes. Empty tokens only match responses
+// piggybacked on the ACK, since separate responses are matched by token.
+func NewRandomTokenGeneratorLength(length int) (TokenGenerator, error) {
+	if err := validTokenLength(length); err != nil {
+		return nil, err
 	}
+	return &RandomTokenGenerator{length: length}, nil
+}
+
+func validTokenLength(length int) error {
+	if length < 0 || length > MaxTokenLength {
+		return fmt.Errorf("coap: invalid token length %d, must be 0 to %d", length, MaxTokenLength)
+	}
+	return nil
 }
 
 func (t *RandomTokenGenerator) NextToken() []byte {
-	// It's critical to not get the same token twice,
-	// since we identify our interactions by the token
+	tok := make([]byte, t.length)
+	if _, err := crand.Read(tok); err != nil {
+		panic("coap: failed to read random token: " + err.Error())
+	}
+	return tok
+}
+
+// DeterministicTokenGenerator creates pseudo random tokens from a seed,
+// e.g. to reproduce the tokens of a test. Do not use it in production,
+// the tokens are predictable.
+type DeterministicTokenGenerator struct {
+	length int
+	rand   *rand.Rand
+
+	mu sync.Mutex
+}
+
+// NewDeterministicTokenGenerator returns a generator of tokens with 0 to
+// MaxTokenLength bytes that always yields the same tokens for a seed
+func NewDeterministicTokenGenerator(seed int64, length int) (TokenGenerator, error) {
+	if err := validTokenLength(length); err != nil {
+		return nil, err
+	}
+	return &DeterministicTokenGenerator{
+		length: length,
+		rand:   rand.New(rand.NewSource(seed)),
+	}, nil
+}
+
+func (t *DeterministicTokenGenerator) NextToken() []byte {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	tok := make([]byte, 4)
+	tok := make([]byte, t.length)
 	t.rand.Read(tok)
-	t.lastTokenSeq++
-	tok[0] = t.lastTokenSeq
 	return tok
 }
 
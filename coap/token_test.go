@@ -0,0 +1,94 @@
+package coap
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+func TestRandomTokenGeneratorLength(t *testing.T) {
+	for length := 0; length <= MaxTokenLength; length++ {
+		gen, err := NewRandomTokenGeneratorLength(length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok := gen.NextToken(); len(tok) != length {
+			t.Errorf("Expected token with %d bytes but got % x", length, tok)
+		}
+	}
+	for _, length := range []int{-1, MaxTokenLength + 1} {
+		if _, err := NewRandomTokenGeneratorLength(length); err == nil {
+			t.Errorf("Expected error for token length %d", length)
+		}
+	}
+	if tok := NewRandomTokenGenerator().NextToken(); len(tok) != DefaultTokenLength {
+		t.Errorf("Expected default token length %d but got % x", DefaultTokenLength, tok)
+	}
+}
+
+func TestDeterministicTokenGenerator(t *testing.T) {
+	a, _ := NewDeterministicTokenGenerator(42, 8)
+	b, _ := NewDeterministicTokenGenerator(42, 8)
+	for i := 0; i < 3; i++ {
+		if ta, tb := a.NextToken(), b.NextToken(); !bytes.Equal(ta, tb) {
+			t.Errorf("Expected same tokens for same seed but got % x and % x", ta, tb)
+		}
+	}
+	if _, err := NewDeterministicTokenGenerator(42, MaxTokenLength+1); err == nil {
+		t.Error("Expected error for invalid token length")
+	}
+}
+
+// fixedTokens returns the given tokens in order and then repeats the last
+type fixedTokens [][]byte
+
+func (f *fixedTokens) NextToken() []byte {
+	tok := (*f)[0]
+	if len(*f) > 1 {
+		*f = (*f)[1:]
+	}
+	return tok
+}
+
+func TestTransportUartUniqueToken(t *testing.T) {
+	conn, err := NewTestConnector().Connect("ignored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trans := NewTransportUart()
+	trans.TokenGenerator = &fixedTokens{[]byte("a"), []byte("a"), []byte("b")}
+
+	start := func() (*Interaction, error) {
+		req, err := NewRequest("GET", "coap+uart://ignored/x", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqMsg := coapmsg.NewMessage()
+		return trans.startInteractionWithToken(conn, req, &reqMsg)
+	}
+
+	first, err := start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(first.Token()) != "a" || string(second.Token()) != "b" {
+		t.Errorf("Expected tokens a and b but got %s and %s", first.Token(), second.Token())
+	}
+
+	// The generator only yields tokens in use
+	if _, err := start(); !errors.Is(err, ErrTokenInUse) {
+		t.Errorf("Expected ErrTokenInUse but got %v", err)
+	}
+
+	// Tokens can be used again after the interaction ended
+	second.Close()
+	if ia, err := start(); err != nil || string(ia.Token()) != "b" {
+		t.Errorf("Expected token b to be reused but got %v", err)
+	}
+}
@@ -12,7 +12,8 @@ const MAX_RETRANSMIT = 4
 // Transport that delegates to other transports based
 // on the request URL scheme
 type Transport struct {
-	TransUart RoundTripper
+	TransUart      RoundTripper
+	TransMulticast MulticastRoundTripper
 }
 
 func (t *Transport) RoundTrip(req *Request) (*Response, error) {
@@ -24,8 +25,18 @@ func (t *Transport) RoundTrip(req *Request) (*Response, error) {
 	return nil, errors.New("Unsupported scheme: " + req.URL.Scheme)
 }
 
+func (t *Transport) RoundTripMulticast(req *Request) (<-chan *Response, error) {
+
+	if req.URL.Scheme == UdpScheme && t.TransMulticast != nil {
+		return t.TransMulticast.RoundTripMulticast(req)
+	}
+
+	return nil, errors.New("Unsupported multicast scheme: " + req.URL.Scheme)
+}
+
 var DefaultTransport RoundTripper = &Transport{
-	TransUart: NewTransportUart(),
+	TransUart:      NewTransportUart(),
+	TransMulticast: NewTransportMulticast(),
 }
 
 // For a new Confirmable message, the initial timeout is set
@@ -8,7 +8,6 @@ import (
 	"io/ioutil"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/trusch/coap-go/coapmsg"
@@ -44,18 +43,36 @@ const UartScheme = "coap+uart"
 // the /dev/ part of the device file handle is added implicitly
 // https://tools.ietf.org/html/rfc3986#page-21 allows system specific Host lookups
 //
-// The URI host can be set to "any" to take the first open port found
+// The URI host can be set to "any" to take the first open port found,
+// or select a USB port by its IDs, e.g. coap+uart://usb-0483-5740/ or
+// coap+uart://serial=ABC123/, see HostAny
+//
+// Serial parameters of a single request can be set in the URL query,
+// e.g. coap+uart://ttyUSB1/sensors?baud=9600&parity=E. They are not sent
+// as Uri-Query, see QueryBaud for all parameters.
+//
+// Devices on an RS-485 bus are addressed by a port in the host, e.g.
+// coap+uart://ttyS2:17/sensors for the device with address 17 on ttyS2,
+// see UartConnector.ConnectMode.
+//
+// Each endpoint has its own message IDs, starting at a random value. An
+// ID is not used again within its lifetime, requests to an endpoint
+// block while all its IDs are in use, see MessageIds.
 type TransportUart struct {
-	mu        *sync.Mutex
-	lastMsgId uint16 // Sequence counter
+	messageIds hostMessageIds // Of connections that do not allocate IDs
 
 	TokenGenerator TokenGenerator
 	Connecter      SerialConnecter
+
+	// MessageIdLifetime is the time before a message ID is used again
+	// for the same host, EXCHANGE_LIFETIME if 0. Only used for
+	// connections that do not allocate message IDs themselves, see
+	// UartConnector.MessageIdLifetime.
+	MessageIdLifetime time.Duration
 }
 
 func NewTransportUart() *TransportUart {
 	return &TransportUart{
-		mu:             &sync.Mutex{},
 		TokenGenerator: NewRandomTokenGenerator(),
 		Connecter:      NewUartConnecter(),
 	}
@@ -90,10 +107,11 @@ func (t *TransportUart) RoundTrip(req *Request) (res *Response, err error) {
 		return nil, errors.New("coap: Got nil request")
 	}
 
-	// The client might set a specific token, e.g. to cancel an observe.
-	// If there is no token set we create a random token.
-	if len(req.Token) == 0 {
-		req.Token = t.TokenGenerator.NextToken()
+	if req.URL == nil {
+		return nil, errors.New(fmt.Sprint("coap: Missing request URL"))
+	}
+	if req.URL.Scheme != UartScheme {
+		return nil, errors.New(fmt.Sprint("coap: Invalid URL scheme, expected "+UartScheme+" but got: ", req.URL.Scheme))
 	}
 
 	reqMsg, err := t.buildRequestMessage(req)
@@ -105,27 +123,47 @@ func (t *TransportUart) RoundTrip(req *Request) (res *Response, err error) {
 	// Open / Reuse the connection
 	//###########################################
 
-	if req.URL == nil {
-		return nil, errors.New(fmt.Sprint("coap: Missing request URL"))
+	mode, err := takeSerialMode(reqMsg.Options())
+	if err != nil {
+		return
 	}
-	if req.URL.Scheme != UartScheme {
-		return nil, errors.New(fmt.Sprint("coap: Invalid URL scheme, expected "+UartScheme+" but got: ", req.URL.Scheme))
+	var conn Connection
+	if mc, ok := t.Connecter.(ModeConnecter); ok {
+		conn, err = mc.ConnectMode(req.URL.Host, mode)
+	} else {
+		if mode != (Mode{}) {
+			log.WithField("host", req.URL.Host).Warn("Connecter does not support serial parameters in the URL, ignoring them")
+		}
+		conn, err = t.Connecter.Connect(req.URL.Host)
+	}
+	if err != nil {
+		return
 	}
 
-	conn, err := t.Connecter.Connect(req.URL.Host)
+	msgId, err := t.nextMessageId(req, conn)
 	if err != nil {
 		return
 	}
+	reqMsg.MessageID = uint16(msgId)
 
 	//###########################################
 	// Start an interaction and send the request
 	//###########################################
 
-	// When canceling an observer we must reuse the interaction
-	// TODO: When do we delete interactions?
-	ia := conn.FindInteraction(req.Token, MessageId(0))
-	if ia == nil {
-		ia = startInteraction(conn, reqMsg)
+	// The client might set a specific token, e.g. to cancel an observe.
+	// If there is no token set we create a token not used on the connection.
+	var ia *Interaction
+	if len(req.Token) > 0 {
+		// When canceling an observer we must reuse the interaction
+		ia = conn.FindInteraction(req.Token, MessageId(0))
+		if ia == nil {
+			ia = startInteraction(conn, reqMsg)
+		}
+	} else {
+		ia, err = t.startInteractionWithToken(conn, req, reqMsg)
+		if err != nil {
+			return
+		}
 	}
 
 	if ia.receiveCh == nil {
@@ -141,7 +179,7 @@ func (t *TransportUart) RoundTrip(req *Request) (res *Response, err error) {
 	}()
 
 	if err != nil {
-		return nil, wrapError(err, fmt.Sprint("Failed Interaction Roundtrip with Token ", ia.Token()))
+		return nil, fmt.Errorf("Failed Interaction Roundtrip with Token %v: %w", ia.Token(), err)
 	}
 
 	//###########################################
@@ -162,20 +200,61 @@ func (t *TransportUart) RoundTrip(req *Request) (res *Response, err error) {
 	return res, nil
 }
 
-func startInteraction(conn Connection, reqMsg *coapmsg.Message) *Interaction {
-	ia := &Interaction{
-		req:       *reqMsg,
-		conn:      conn,
-		receiveCh: make(chan *coapmsg.Message, 0),
+// CloseIdleConnections closes connections that are not used by any
+// request or observation, if the connecter supports it
+func (t *TransportUart) CloseIdleConnections() {
+	if ci, ok := t.Connecter.(interface{ CloseIdleConnections() }); ok {
+		ci.CloseIdleConnections()
 	}
+}
 
-	log.WithField("Token", ia.Token()).Info("Start interaction")
+// Close closes all connections of the connecter, if it supports it.
+// Pending requests fail and observations end.
+func (t *TransportUart) Close() error {
+	if closer, ok := t.Connecter.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
 
-	conn.AddInteraction(ia)
+// maxTokenAttempts is the number of generated tokens that are tried
+// before giving up because all are in use
+const maxTokenAttempts = 16
 
+func startInteraction(conn Connection, reqMsg *coapmsg.Message) *Interaction {
+	ia := newInteraction(conn, reqMsg)
+	log.WithField("Token", ia.Token()).Info("Start interaction")
+	conn.AddInteraction(ia)
 	return ia
 }
 
+// startInteractionWithToken starts an interaction with a generated token
+// that no other interaction on the connection uses. The token is set on
+// req and reqMsg.
+func (t *TransportUart) startInteractionWithToken(conn Connection, req *Request, reqMsg *coapmsg.Message) (*Interaction, error) {
+	for i := 0; i < maxTokenAttempts; i++ {
+		reqMsg.Token = t.TokenGenerator.NextToken()
+		ia := newInteraction(conn, reqMsg)
+		if tryAddInteraction(conn, ia) {
+			log.WithField("Token", ia.Token()).Info("Start interaction")
+			req.Token = reqMsg.Token
+			return ia, nil
+		}
+		log.WithField("Token", ia.Token()).Debug("Generated token in use, trying another")
+	}
+	reqMsg.Token = nil
+	return nil, fmt.Errorf("coap: no unused token after %d attempts: %w", maxTokenAttempts, ErrTokenInUse)
+}
+
+func newInteraction(conn Connection, reqMsg *coapmsg.Message) *Interaction {
+	return &Interaction{
+		req:       *reqMsg,
+		conn:      conn,
+		receiveCh: make(chan *coapmsg.Message, 0),
+		done:      make(chan struct{}),
+	}
+}
+
 func handleInteractionNotifyMessage(ia *Interaction, req *Request, currResponse *Response) {
 
 	defer close(currResponse.next)
@@ -184,8 +263,14 @@ func handleInteractionNotifyMessage(ia *Interaction, req *Request, currResponse
 	// this puts all responsibility to stop the observe to the client
 	// we should consider some big default timeout (e.g. 5 minutes) to close the interaction
 	// when nothing is received
+	notificationCh := ia.notifications()
+	if notificationCh == nil {
+		log.Info("Stopped observer, no more notifies expected.")
+		ia.closeIfIdle()
+		return
+	}
 	select {
-	case resMsg, ok := <-ia.NotificationCh:
+	case resMsg, ok := <-notificationCh:
 		if ok {
 			res := buildResponse(req, resMsg)
 			select {
@@ -199,8 +284,9 @@ func handleInteractionNotifyMessage(ia *Interaction, req *Request, currResponse
 
 		} else {
 			// Also happens for all non observe requests since ia.NotificationCh will be closed.
+			// A round trip canceling the observation closes the interaction itself.
 			log.Info("Stopped observer, no more notifies expected.")
-			ia.Close()
+			ia.closeIfIdle()
 		}
 	}
 }
@@ -208,7 +294,8 @@ func handleInteractionNotifyMessage(ia *Interaction, req *Request, currResponse
 func buildResponse(req *Request, resMsg *coapmsg.Message) *Response {
 	return &Response{
 		StatusCode: resMsg.Code.Number(),
-		Status:     fmt.Sprintf("%d.%02d %s", resMsg.Code.Class(), resMsg.Code.Detail(), resMsg.Code.String()),
+		Code:       resMsg.Code,
+		Status:     resMsg.Code.Dotted() + " " + resMsg.Code.String(),
 		Body:       ioutil.NopCloser(bytes.NewReader(resMsg.Payload)),
 		Options:    resMsg.Options(),
 		Request:    req,
@@ -216,9 +303,16 @@ func buildResponse(req *Request, resMsg *coapmsg.Message) *Response {
 	}
 }
 
-// BuildMessage creates a coap message based on the request
-// Takes care of closing the request body
+// BuildMessage creates a coap message based on the request, the message
+// ID is set once the connection is known. Takes care of closing the
+// request body
 func (t *TransportUart) buildRequestMessage(req *Request) (*coapmsg.Message, error) {
+	return buildRequestMessage(req, 0)
+}
+
+// buildRequestMessage creates a coap message with the given message ID
+// based on the request. Takes care of closing the request body
+func buildRequestMessage(req *Request, msgId uint16) (*coapmsg.Message, error) {
 	defer func() {
 		_ = req.Body.Close() // Closed already, ignore error
 	}()
@@ -234,7 +328,7 @@ func (t *TransportUart) buildRequestMessage(req *Request) (*coapmsg.Message, err
 	msg := &coapmsg.Message{
 		Code:      methodToCode(req.Method),
 		Type:      msgType,
-		MessageID: t.nextMessageId(),
+		MessageID: msgId,
 		Token:     req.Token,
 	}
 	msg.SetOptions(req.Options)
@@ -269,12 +363,21 @@ func (t *TransportUart) buildRequestMessage(req *Request) (*coapmsg.Message, err
 	return msg, nil
 }
 
-func (t *TransportUart) nextMessageId() uint16 {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	t.lastMsgId++
-	msgId := t.lastMsgId
-	return msgId
+// nextMessageId returns an unused message ID of the endpoint of conn.
+// Connections of other connecters than UartConnector use the IDs of the
+// host of req.
+func (t *TransportUart) nextMessageId(req *Request, conn Connection) (MessageId, error) {
+	var msgId MessageId
+	var err error
+	if src, ok := conn.(messageIdSource); ok {
+		msgId, err = src.nextMessageId(req.Context())
+	} else {
+		msgId, err = t.messageIds.get(req.URL.Host, t.MessageIdLifetime).Next(req.Context())
+	}
+	if err != nil {
+		return 0, fmt.Errorf("coap: message IDs of %s exhausted: %w", req.URL.Host, err)
+	}
+	return msgId, nil
 }
 
 var methodToCodeTable = map[string]coapmsg.COAPCode{
@@ -338,7 +338,8 @@ func NewTestClient(t *testing.T) (*Client, *TestConnector) {
 // The first response is an empty ACK
 // The second response is a piggyback response to /foo with content test2
 // The third response is a postponed response to /bar with content test1
-// Tokens and message ID's are counting up so they are predictable as "1" and "2"
+// Tokens are counting up so they are predictable as "1" and "2", message
+// IDs are taken from the sent requests
 func TestParallelRequests(t *testing.T) {
 	client, conn := NewTestClient(t)
 	client.Transport.(*TransportUart).TokenGenerator = NewCountingTokenGenerator()
@@ -365,14 +366,14 @@ func TestParallelRequests(t *testing.T) {
 	}()
 
 	wg.Add(1)
-	requestsSend := make(chan bool)
+	requestsSend := make(chan coapmsg.Message)
 	go func() {
 		// Wait for first get to be send
-		_, err := conn.WaitForSendMessage(3 * time.Second)
+		first, err := conn.WaitForSendMessage(3 * time.Second)
 		if err != nil {
 			t.Error(err)
 		}
-		requestsSend <- true
+		requestsSend <- first
 		res, err := client.Get("coap+uart://any/bar")
 		if err != nil {
 			t.Error(err)
@@ -394,10 +395,10 @@ func TestParallelRequests(t *testing.T) {
 	wg.Add(1)
 	go func() {
 		// Wait till all requests are send
-		<-requestsSend
+		first := <-requestsSend
 
-		// Wait for first get to be send
-		_, err := conn.WaitForSendMessage(3 * time.Second)
+		// Wait for second get to be send
+		second, err := conn.WaitForSendMessage(3 * time.Second)
 		if err != nil {
 			t.Error(err)
 		}
@@ -405,14 +406,14 @@ func TestParallelRequests(t *testing.T) {
 		logrus.Info("Start sending responses")
 
 		// Confirm Message 1 as postboned
-		ack := coapmsg.NewAck(1)
+		ack := coapmsg.NewAck(first.MessageID)
 		err = conn.FakeReceiveMessage(ack)
 		if err != nil {
 			t.Error(err)
 		}
 
 		// Confirm Message 2
-		ack = coapmsg.NewAck(2)
+		ack = coapmsg.NewAck(second.MessageID)
 		ack.Code = coapmsg.Content // For piggyback response. Default Empty would be postponed
 		ack.Token = []byte{2}
 		ack.Payload = []byte("test2")
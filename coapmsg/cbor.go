@@ -0,0 +1,151 @@
+package coapmsg
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/trusch/coap-go/internal/cbor"
+)
+
+// CBOR map keys of the compact Message encoding
+//
+//	{1: type, 2: code, 3: message id, 4: token, 5: [[number, value], ...], 6: payload}
+//
+// Uint options are encoded as CBOR unsigned integers, string options as
+// text strings and all other options as byte strings.
+const (
+	cborKeyType      = 1
+	cborKeyCode      = 2
+	cborKeyMessageID = 3
+	cborKeyToken     = 4
+	cborKeyOptions   = 5
+	cborKeyPayload   = 6
+)
+
+var errInvalidCBOR = errors.New("coapmsg: invalid CBOR message")
+
+// MarshalCBOR encodes the message in a compact CBOR representation
+// that is suitable for storage. See UnmarshalCBOR for decoding.
+func (m Message) MarshalCBOR() ([]byte, error) {
+	enc := map[interface{}]interface{}{
+		cborKeyType:      uint8(m.Type),
+		cborKeyCode:      uint8(m.Code),
+		cborKeyMessageID: m.MessageID,
+	}
+	if len(m.Token) > 0 {
+		enc[cborKeyToken] = m.Token
+	}
+
+	opts := []interface{}{}
+	for _, id := range m.options.sortedIds() {
+		for _, val := range m.options[id] {
+			var v interface{}
+			switch id.Format() {
+			case ValueUint:
+				v = decodeInt(val.AsBytes())
+			case ValueString:
+				v = val.AsString()
+			default:
+				v = append([]byte{}, val.AsBytes()...)
+			}
+			opts = append(opts, []interface{}{uint16(id), v})
+		}
+	}
+	if len(opts) > 0 {
+		enc[cborKeyOptions] = opts
+	}
+	if len(m.Payload) > 0 {
+		enc[cborKeyPayload] = m.Payload
+	}
+
+	return cbor.Marshal(enc)
+}
+
+// UnmarshalCBOR decodes a message encoded by MarshalCBOR
+func (m *Message) UnmarshalCBOR(data []byte) error {
+	v, err := cbor.Unmarshal(data)
+	if err != nil {
+		return err
+	}
+	dec, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return errInvalidCBOR
+	}
+
+	uintField := func(key uint64, max uint64) (uint64, error) {
+		u, ok := cbor.Uint(dec[key])
+		if !ok || u > max {
+			return 0, fmt.Errorf("coapmsg: invalid CBOR field %d", key)
+		}
+		return u, nil
+	}
+
+	msg := NewMessage()
+	t, err := uintField(cborKeyType, uint64(Reset))
+	if err != nil {
+		return err
+	}
+	msg.Type = COAPType(t)
+	c, err := uintField(cborKeyCode, 0xff)
+	if err != nil {
+		return err
+	}
+	msg.Code = COAPCode(c)
+	id, err := uintField(cborKeyMessageID, 0xffff)
+	if err != nil {
+		return err
+	}
+	msg.MessageID = uint16(id)
+
+	if tok, ok := dec[uint64(cborKeyToken)]; ok {
+		if msg.Token, ok = tok.([]byte); !ok {
+			return errInvalidCBOR
+		}
+		if len(msg.Token) > 8 {
+			return ErrInvalidTokenLen
+		}
+	}
+
+	if opts, ok := dec[uint64(cborKeyOptions)]; ok {
+		list, ok := opts.([]interface{})
+		if !ok {
+			return errInvalidCBOR
+		}
+		for _, o := range list {
+			pair, ok := o.([]interface{})
+			if !ok || len(pair) != 2 {
+				return errInvalidCBOR
+			}
+			num, ok := cbor.Uint(pair[0])
+			if !ok || num > 0xffff {
+				return errInvalidCBOR
+			}
+			oid := OptionId(num)
+
+			var val OptionValue
+			switch x := pair[1].(type) {
+			case uint64:
+				if x > 0xffffffff {
+					return errInvalidCBOR
+				}
+				val = OptionValue{b: encodeInt(uint32(x))}
+			case string:
+				val = OptionValue{b: []byte(x)}
+			case []byte:
+				val = OptionValue{b: x}
+			default:
+				return errInvalidCBOR
+			}
+			msg.options[oid] = append(msg.options[oid], val)
+		}
+	}
+
+	if p, ok := dec[uint64(cborKeyPayload)]; ok {
+		if msg.Payload, ok = p.([]byte); !ok {
+			return errInvalidCBOR
+		}
+	}
+
+	*m = msg
+	return nil
+}
@@ -0,0 +1,57 @@
+package coapmsg
+
+import (
+	"testing"
+)
+
+func TestCBORRoundTrip(t *testing.T) {
+	msg := newJSONTestMessage()
+	msg.Options().Add(OptionId(3000), []byte{1, 2, 3})
+
+	b, err := msg.MarshalCBOR()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bin := msg.MustMarshalBinary()
+	if len(b) > len(bin)+32 {
+		t.Errorf("CBOR encoding is not compact: %d bytes vs. %d bytes binary", len(b), len(bin))
+	}
+
+	parsed := Message{}
+	if err := parsed.UnmarshalCBOR(b); err != nil {
+		t.Fatal(err)
+	}
+	assertEqualMessages(t, msg, parsed)
+}
+
+func TestCBOREmptyMessage(t *testing.T) {
+	msg := NewRst(42)
+
+	b, err := msg.MarshalCBOR()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed := Message{}
+	if err := parsed.UnmarshalCBOR(b); err != nil {
+		t.Fatal(err)
+	}
+	assertEqualMessages(t, msg, parsed)
+}
+
+func TestUnmarshalCBORErrors(t *testing.T) {
+	invalid := [][]byte{
+		{0x01},             // not a map
+		{0xa1, 0x01, 0x04}, // type out of range
+		{0xa3, 0x01, 0x00, 0x02, 0x01, 0x03, 0x41, 0x00}, // message id as bytes
+		{0xa1, 0x01}, // truncated
+	}
+
+	for _, data := range invalid {
+		msg := Message{}
+		if err := msg.UnmarshalCBOR(data); err == nil {
+			t.Errorf("Expected error for %x", data)
+		}
+	}
+}
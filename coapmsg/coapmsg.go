@@ -6,7 +6,6 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"sort"
 	"strings"
 )
 
@@ -43,6 +42,14 @@ func (t COAPType) String() string {
 	return typeNames[t]
 }
 
+// Abbreviations as used in RFC 7252
+var typeShortNames = map[COAPType]string{
+	Confirmable:     "CON",
+	NonConfirmable:  "NON",
+	Acknowledgement: "ACK",
+	Reset:           "RST",
+}
+
 // COAPCode is the type used for both request and response codes.
 type COAPCode uint8
 
@@ -52,64 +59,106 @@ const (
 	POST   COAPCode = 2 // 0.02
 	PUT    COAPCode = 3 // 0.03
 	DELETE COAPCode = 4 // 0.04
+	FETCH  COAPCode = 5 // 0.05 (RFC 8132)
+	PATCH  COAPCode = 6 // 0.06 (RFC 8132)
+	IPATCH COAPCode = 7 // 0.07 (RFC 8132)
 )
 
 // Response Codes
+//
+// The list follows the IANA "CoAP Response Codes" registry.
+const (
+	Empty                   COAPCode = 0   // 0.00
+	Created                 COAPCode = 65  // 2.01
+	Deleted                 COAPCode = 66  // 2.02
+	Valid                   COAPCode = 67  // 2.03
+	Changed                 COAPCode = 68  // 2.04
+	Content                 COAPCode = 69  // 2.05
+	Continue                COAPCode = 95  // 2.31 (RFC 7959)
+	BadRequest              COAPCode = 128 // 4.00
+	Unauthorized            COAPCode = 129 // 4.01
+	BadOption               COAPCode = 130 // 4.02
+	Forbidden               COAPCode = 131 // 4.03
+	NotFound                COAPCode = 132 // 4.04
+	MethodNotAllowed        COAPCode = 133 // 4.05
+	NotAcceptable           COAPCode = 134 // 4.06
+	RequestEntityIncomplete COAPCode = 136 // 4.08 (RFC 7959)
+	Conflict                COAPCode = 137 // 4.09 (RFC 8132)
+	PreconditionFailed      COAPCode = 140 // 4.12
+	RequestEntityTooLarge   COAPCode = 141 // 4.13
+	UnsupportedMediaType    COAPCode = 143 // 4.15
+	UnprocessableEntity     COAPCode = 150 // 4.22 (RFC 8132)
+	TooManyRequests         COAPCode = 157 // 4.29 (RFC 8516)
+	InternalServerError     COAPCode = 160 // 5.00
+	NotImplemented          COAPCode = 161 // 5.01
+	BadGateway              COAPCode = 162 // 5.02
+	ServiceUnavailable      COAPCode = 163 // 5.03
+	GatewayTimeout          COAPCode = 164 // 5.04
+	ProxyingNotSupported    COAPCode = 165 // 5.05
+	HopLimitReached         COAPCode = 168 // 5.08 (RFC 8768)
+)
+
+// Signaling Codes (RFC 8323)
 const (
-	Empty                 COAPCode = 0   // 0.00
-	Created               COAPCode = 65  // 2.01
-	Deleted               COAPCode = 66  // 2.02
-	Valid                 COAPCode = 67  // 2.03
-	Changed               COAPCode = 68  // 2.04
-	Content               COAPCode = 69  // 2.05
-	BadRequest            COAPCode = 128 // 4.00
-	Unauthorized          COAPCode = 129 // 4.01
-	BadOption             COAPCode = 130 // 4.02
-	Forbidden             COAPCode = 131 // 4.03
-	NotFound              COAPCode = 132 // 4.04
-	MethodNotAllowed      COAPCode = 133 // 4.05
-	NotAcceptable         COAPCode = 134 // 4.06
-	PreconditionFailed    COAPCode = 140 // 4.12
-	RequestEntityTooLarge COAPCode = 141 // 4.13
-	UnsupportedMediaType  COAPCode = 143 // 4.15
-	InternalServerError   COAPCode = 160 // 5.00
-	NotImplemented        COAPCode = 161 // 5.01
-	BadGateway            COAPCode = 162 // 5.02
-	ServiceUnavailable    COAPCode = 163 // 5.03
-	GatewayTimeout        COAPCode = 164 // 5.04
-	ProxyingNotSupported  COAPCode = 165 // 5.05
+	CSM     COAPCode = 225 // 7.01
+	Ping    COAPCode = 226 // 7.02
+	Pong    COAPCode = 227 // 7.03
+	Release COAPCode = 228 // 7.04
+	Abort   COAPCode = 229 // 7.05
 )
 
 var codeNames = [256]string{
-	GET:                   "GET",
-	POST:                  "POST",
-	PUT:                   "PUT",
-	DELETE:                "DELETE",
-	Empty:                 "Empty",
-	Created:               "Created",
-	Deleted:               "Deleted",
-	Valid:                 "Valid",
-	Changed:               "Changed",
-	Content:               "Content",
-	BadRequest:            "BadRequest",
-	Unauthorized:          "Unauthorized",
-	BadOption:             "BadOption",
-	Forbidden:             "Forbidden",
-	NotFound:              "NotFound",
-	MethodNotAllowed:      "MethodNotAllowed",
-	NotAcceptable:         "NotAcceptable",
-	PreconditionFailed:    "PreconditionFailed",
-	RequestEntityTooLarge: "RequestEntityTooLarge",
-	UnsupportedMediaType:  "UnsupportedMediaType",
-	InternalServerError:   "InternalServerError",
-	NotImplemented:        "NotImplemented",
-	BadGateway:            "BadGateway",
-	ServiceUnavailable:    "ServiceUnavailable",
-	GatewayTimeout:        "GatewayTimeout",
-	ProxyingNotSupported:  "ProxyingNotSupported",
+	GET:                     "GET",
+	POST:                    "POST",
+	PUT:                     "PUT",
+	DELETE:                  "DELETE",
+	FETCH:                   "FETCH",
+	PATCH:                   "PATCH",
+	IPATCH:                  "iPATCH",
+	Empty:                   "Empty",
+	Created:                 "Created",
+	Deleted:                 "Deleted",
+	Valid:                   "Valid",
+	Changed:                 "Changed",
+	Content:                 "Content",
+	Continue:                "Continue",
+	BadRequest:              "BadRequest",
+	Unauthorized:            "Unauthorized",
+	BadOption:               "BadOption",
+	Forbidden:               "Forbidden",
+	NotFound:                "NotFound",
+	MethodNotAllowed:        "MethodNotAllowed",
+	NotAcceptable:           "NotAcceptable",
+	RequestEntityIncomplete: "RequestEntityIncomplete",
+	Conflict:                "Conflict",
+	PreconditionFailed:      "PreconditionFailed",
+	RequestEntityTooLarge:   "RequestEntityTooLarge",
+	UnsupportedMediaType:    "UnsupportedMediaType",
+	UnprocessableEntity:     "UnprocessableEntity",
+	TooManyRequests:         "TooManyRequests",
+	InternalServerError:     "InternalServerError",
+	NotImplemented:          "NotImplemented",
+	BadGateway:              "BadGateway",
+	ServiceUnavailable:      "ServiceUnavailable",
+	GatewayTimeout:          "GatewayTimeout",
+	ProxyingNotSupported:    "ProxyingNotSupported",
+	HopLimitReached:         "HopLimitReached",
+	CSM:                     "CSM",
+	Ping:                    "Ping",
+	Pong:                    "Pong",
+	Release:                 "Release",
+	Abort:                   "Abort",
 }
 
+// methodCodes maps the names of the registered request methods to their codes
+var methodCodes = map[string]COAPCode{}
+
 func init() {
+	for c := GET; c.IsRequest(); c++ {
+		if codeNames[c] != "" {
+			methodCodes[codeNames[c]] = c
+		}
+	}
 	for i := range codeNames {
 		if codeNames[i] == "" {
 			codeNames[i] = fmt.Sprintf("Unknown (0x%x)", i)
@@ -135,18 +184,86 @@ func (c COAPCode) Number() uint8 {
 	return uint8(c)
 }
 
+// IsEmpty reports whether c is the 0.00 code of empty messages
+func (c COAPCode) IsEmpty() bool {
+	return c == Empty
+}
+
+// IsRequest reports whether c is a request method code (0.01 - 0.31)
+func (c COAPCode) IsRequest() bool {
+	return c.Class() == 0 && c != Empty
+}
+
+// IsSafe reports whether c is a safe request method (GET, FETCH), which
+// only retrieves a representation and does not change the resource
+func (c COAPCode) IsSafe() bool {
+	return c == GET || c == FETCH
+}
+
+// IsResponse reports whether c is a response code (2.xx, 4.xx or 5.xx)
+func (c COAPCode) IsResponse() bool {
+	return c.IsSuccess() || c.IsError()
+}
+
+// IsSuccess reports whether c is a 2.xx response code
 func (c COAPCode) IsSuccess() bool {
 	return c.Class() == 2
 }
 
+// IsClientError reports whether c is a 4.xx response code
+func (c COAPCode) IsClientError() bool {
+	return c.Class() == 4
+}
+
+// IsServerError reports whether c is a 5.xx response code
+func (c COAPCode) IsServerError() bool {
+	return c.Class() == 5
+}
+
+// IsError reports whether c is a 4.xx or 5.xx response code.
+// Requests, empty messages and signaling codes are no errors.
 func (c COAPCode) IsError() bool {
-	return c.Class() != 2
+	return c.IsClientError() || c.IsServerError()
+}
+
+// IsSignal reports whether c is a 7.xx signaling code (RFC 8323)
+func (c COAPCode) IsSignal() bool {
+	return c.Class() == 7
 }
 
 func BuildCode(class, detail uint8) COAPCode {
 	return COAPCode((class << 5) | detail)
 }
 
+// Dotted formats the code in "c.dd" notation, e.g. "2.05"
+func (c COAPCode) Dotted() string {
+	return fmt.Sprintf("%d.%02d", c.Class(), c.Detail())
+}
+
+// ParseCode parses a code in "c.dd" notation, e.g. "4.04"
+func ParseCode(s string) (COAPCode, error) {
+	if len(s) != 4 || !isDigit(s[0]) || s[1] != '.' || !isDigit(s[2]) || !isDigit(s[3]) {
+		return 0, fmt.Errorf("coapmsg: invalid code %q", s)
+	}
+	class := s[0] - '0'
+	detail := (s[2]-'0')*10 + s[3] - '0'
+	if class > 7 || detail > 31 {
+		return 0, fmt.Errorf("coapmsg: code out of range %q", s)
+	}
+	return BuildCode(class, detail), nil
+}
+
+// MethodCode returns the request code of a method name, e.g. "FETCH".
+// ok is false if the name is not a registered request method.
+func MethodCode(method string) (code COAPCode, ok bool) {
+	code, ok = methodCodes[method]
+	return
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
 // Message encoding errors.
 var (
 	ErrInvalidTokenLen   = errors.New("invalid token length")
@@ -342,15 +459,9 @@ func (m *Message) MustMarshalBinary() []byte {
 
 	options := m.Options()
 
-	ids := optionsIds{}
-	for id := range options {
-		ids = append(ids, id)
-	}
-	sort.Sort(ids)
-
 	prev := 0
 
-	for _, id := range ids {
+	for _, id := range options.sortedIds() {
 		for _, val := range options[id] {
 			writeOptHeader(int(id)-prev, val.Len())
 			buf.Write(val.AsBytes())
@@ -68,6 +68,91 @@ func TestCode(t *testing.T) {
 	}
 }
 
+func TestCodeClassification(t *testing.T) {
+	tests := []struct {
+		code                                                      COAPCode
+		empty, request, success, clientError, serverError, signal bool
+	}{
+		{Empty, true, false, false, false, false, false},
+		{GET, false, true, false, false, false, false},
+		{FETCH, false, true, false, false, false, false},
+		{Content, false, false, true, false, false, false},
+		{Continue, false, false, true, false, false, false},
+		{TooManyRequests, false, false, false, true, false, false},
+		{HopLimitReached, false, false, false, false, true, false},
+		{Pong, false, false, false, false, false, true},
+	}
+
+	for _, test := range tests {
+		c := test.code
+		got := []bool{c.IsEmpty(), c.IsRequest(), c.IsSuccess(), c.IsClientError(), c.IsServerError(), c.IsSignal()}
+		exp := []bool{test.empty, test.request, test.success, test.clientError, test.serverError, test.signal}
+		for i := range got {
+			if got[i] != exp[i] {
+				t.Errorf("Unexpected classification of %s: %v, expected %v", c.Dotted(), got, exp)
+				break
+			}
+		}
+		if c.IsError() != (test.clientError || test.serverError) {
+			t.Errorf("Unexpected IsError() for %s", c.Dotted())
+		}
+	}
+}
+
+func TestParseCode(t *testing.T) {
+	tests := map[string]COAPCode{
+		"0.00": Empty,
+		"0.01": GET,
+		"2.05": Content,
+		"2.31": Continue,
+		"4.04": NotFound,
+		"4.29": TooManyRequests,
+		"5.08": HopLimitReached,
+		"7.02": Ping,
+	}
+	for s, exp := range tests {
+		code, err := ParseCode(s)
+		if err != nil {
+			t.Error(err)
+		}
+		if code != exp {
+			t.Errorf("ParseCode(%q) = %v, expected %v", s, code, exp)
+		}
+		if code.Dotted() != s {
+			t.Errorf("Expected %q but got %q", s, code.Dotted())
+		}
+	}
+
+	for _, s := range []string{"", "4.4", "404", "8.00", "4.32", "a.bc", "4.04 ", "4.4x", " 4.4", "2.5 ", "4,04", "+.04", "4.-4"} {
+		if _, err := ParseCode(s); err == nil {
+			t.Errorf("Expected error for %q", s)
+		}
+	}
+}
+
+func TestMethodCode(t *testing.T) {
+	tests := map[string]COAPCode{
+		"GET":    GET,
+		"DELETE": DELETE,
+		"FETCH":  FETCH,
+		"iPATCH": IPATCH,
+	}
+	for s, exp := range tests {
+		if code, ok := MethodCode(s); !ok || code != exp {
+			t.Errorf("MethodCode(%q) = %v, %v, expected %v", s, code, ok, exp)
+		}
+	}
+	for _, s := range []string{"", "get", "IPATCH", "Content", "Unknown (0x8)"} {
+		if code, ok := MethodCode(s); ok {
+			t.Errorf("Expected no method for %q but got %v", s, code)
+		}
+	}
+
+	if !GET.IsSafe() || !FETCH.IsSafe() || POST.IsSafe() || IPATCH.IsSafe() {
+		t.Error("Expected only GET and FETCH to be safe")
+	}
+}
+
 func TestSetOptions(t *testing.T) {
 	msg := Message{}
 
@@ -187,6 +272,8 @@ func TestCodeString(t *testing.T) {
 		GET:           "GET",
 		POST:          "POST",
 		NotAcceptable: "NotAcceptable",
+		Continue:      "Continue",
+		IPATCH:        "iPATCH",
 		255:           "Unknown (0xff)",
 	}
 
@@ -3,7 +3,7 @@
 package coapmsg
 
 import (
-	"encoding/binary"
+	"sort"
 )
 
 // Currently only used in tests to find options
@@ -45,49 +45,32 @@ func (v OptionValue) IsNotSet() bool {
 	return !v.IsSet()
 }
 
-// For signed values just convert the result
+// The AsUInt accessors decode uint option values in network byte order
+// (RFC 7252, Section 3.2), values longer than the result are truncated to
+// the least significant bytes. For signed values just convert the result.
 func (v OptionValue) AsUInt8() uint8 {
-	if len(v.b) == 0 {
-		return 0
-	}
-	return v.b[0]
+	return uint8(v.AsUInt64())
 }
 
-// For signed values just convert the result
 func (v OptionValue) AsUInt16() uint16 {
-	if len(v.b) == 0 {
-		return 0
-	}
-	val := v
-	for len(val.b) < 2 {
-		val.b = append(val.b, 0)
-	}
-	return binary.LittleEndian.Uint16(val.b)
+	return uint16(v.AsUInt64())
 }
 
-// For signed values just convert the result
 func (v OptionValue) AsUInt32() uint32 {
-	if len(v.b) == 0 {
-		return 0
-	}
-	val := v
-	for len(val.b) < 4 {
-		val.b = append(val.b, 0)
-	}
-	return binary.LittleEndian.Uint32(val.b)
+	return uint32(v.AsUInt64())
 }
 
-// For signed values just convert the result
 func (v OptionValue) AsUInt64() uint64 {
-
-	if len(v.b) == 0 {
-		return 0
+	var n uint64
+	for _, b := range v.b {
+		n = n<<8 | uint64(b)
 	}
-	val := v
-	for len(val.b) < 8 {
-		val.b = append(val.b, 0)
-	}
-	return binary.LittleEndian.Uint64(val.b)
+	return n
+}
+
+// AsMediaType decodes a Content-Format or Accept option value
+func (v OptionValue) AsMediaType() MediaType {
+	return MediaType(decodeInt(v.b))
 }
 
 func (v OptionValue) AsString() string {
@@ -148,9 +131,29 @@ func (h CoapOptions) Del(key OptionId) {
 	delete(h, key)
 }
 
+// Clone returns a copy of the options that can be modified
+// without affecting h.
+func (h CoapOptions) Clone() CoapOptions {
+	c := make(CoapOptions, len(h))
+	for k, v := range h {
+		c[k] = append([]OptionValue(nil), v...)
+	}
+	return c
+}
+
 // Clear deletes all options.
 func (h CoapOptions) Clear() {
 	for k := range h {
 		delete(h, k)
 	}
 }
+
+// sortedIds returns all option ids in ascending order
+func (h CoapOptions) sortedIds() []OptionId {
+	ids := optionsIds{}
+	for id := range h {
+		ids = append(ids, id)
+	}
+	sort.Sort(ids)
+	return ids
+}
@@ -113,6 +113,26 @@ func TestParsing(t *testing.T) {
 	}
 }
 
+func TestUIntNetworkByteOrder(t *testing.T) {
+	msg := NewMessage()
+	msg.Options().Set(Observe, uint32(0x010203))
+	v := msg.Options().Get(Observe)
+	if v.AsUInt32() != 0x010203 || v.AsUInt64() != 0x010203 {
+		t.Errorf("Expected 0x010203 but got %#x", v.AsUInt32())
+	}
+	if v.AsUInt16() != 0x0203 || v.AsUInt8() != 0x03 {
+		t.Errorf("Expected truncated values 0x0203 and 0x03 but got %#x and %#x", v.AsUInt16(), v.AsUInt8())
+	}
+
+	msg.Options().Set(URIPort, []byte{0x16, 0x33})
+	if v := msg.Options().Get(URIPort).AsUInt16(); v != 5683 {
+		t.Errorf("Expected port 5683 but got %d", v)
+	}
+	if v := msg.Options().Get(MaxAge).AsUInt16(); v != 0 {
+		t.Errorf("Expected 0 for unset option but got %#x", v)
+	}
+}
+
 func _TestFindNumbers(t *testing.T) {
 	for i := 3000; i < 3200; i++ {
 		def := OptionDef{
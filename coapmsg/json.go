@@ -0,0 +1,209 @@
+package coapmsg
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// jsonMessage is the stable JSON schema of a Message
+//
+//	{
+//	  "type": "CON",
+//	  "code": "0.01",
+//	  "messageId": 4660,
+//	  "token": "4a3b",
+//	  "options": [{"number": 11, "name": "Uri-Path", "value": "sensors"}],
+//	  "payload": "22.5 C"
+//	}
+//
+// Uint options are JSON numbers, string options are JSON strings and
+// opaque options are hex encoded strings. Empty options have a null value.
+// The payload is written as text for textual content formats and
+// as base64 in "payloadBase64" for all other (or missing) content formats.
+type jsonMessage struct {
+	Type          string       `json:"type"`
+	Code          string       `json:"code"`
+	MessageID     uint16       `json:"messageId"`
+	Token         string       `json:"token,omitempty"`
+	Options       []jsonOption `json:"options,omitempty"`
+	Payload       *string      `json:"payload,omitempty"`
+	PayloadBase64 *string      `json:"payloadBase64,omitempty"`
+}
+
+type jsonOption struct {
+	Number OptionId        `json:"number"`
+	Name   string          `json:"name"`
+	Value  json.RawMessage `json:"value"`
+}
+
+// textMediaTypes have payloads that are stored as text in JSON
+var textMediaTypes = map[MediaType]bool{
+	TextPlain:     true,
+	AppLinkFormat: true,
+	AppXML:        true,
+	AppJSON:       true,
+}
+
+// hasTextPayload checks the content format of the message
+// to decide if the payload can be represented as text
+func (m Message) hasTextPayload() bool {
+	cf := m.options.Get(ContentFormat)
+	if cf.IsNotSet() {
+		return false
+	}
+	return textMediaTypes[cf.AsMediaType()] && utf8.Valid(m.Payload)
+}
+
+// MarshalJSON implements json.Marshaler
+func (m Message) MarshalJSON() ([]byte, error) {
+	jm := jsonMessage{
+		Type:      typeShortNames[m.Type],
+		Code:      m.Code.Dotted(),
+		MessageID: m.MessageID,
+		Token:     hex.EncodeToString(m.Token),
+	}
+	if jm.Type == "" {
+		return nil, fmt.Errorf("coapmsg: invalid message type %d", m.Type)
+	}
+
+	for _, id := range m.options.sortedIds() {
+		for _, val := range m.options[id] {
+			v, err := optionValueToJSON(id, val)
+			if err != nil {
+				return nil, err
+			}
+			jm.Options = append(jm.Options, jsonOption{
+				Number: id,
+				Name:   id.String(),
+				Value:  v,
+			})
+		}
+	}
+
+	if len(m.Payload) > 0 {
+		if m.hasTextPayload() {
+			p := string(m.Payload)
+			jm.Payload = &p
+		} else {
+			p := base64.StdEncoding.EncodeToString(m.Payload)
+			jm.PayloadBase64 = &p
+		}
+	}
+
+	return json.Marshal(jm)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (m *Message) UnmarshalJSON(data []byte) error {
+	jm := jsonMessage{}
+	if err := json.Unmarshal(data, &jm); err != nil {
+		return err
+	}
+
+	msg := NewMessage()
+	found := false
+	for t, name := range typeShortNames {
+		if name == jm.Type {
+			msg.Type = t
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("coapmsg: invalid message type %q", jm.Type)
+	}
+
+	code, err := ParseCode(jm.Code)
+	if err != nil {
+		return err
+	}
+	msg.Code = code
+	msg.MessageID = jm.MessageID
+
+	if jm.Token != "" {
+		msg.Token, err = hex.DecodeString(jm.Token)
+		if err != nil {
+			return fmt.Errorf("coapmsg: invalid token: %s", err)
+		}
+		if len(msg.Token) > 8 {
+			return ErrInvalidTokenLen
+		}
+	}
+
+	for _, o := range jm.Options {
+		id := o.Number
+		if id == 0 {
+			var ok bool
+			if id, ok = optionIdByName(o.Name); !ok {
+				return fmt.Errorf("coapmsg: unknown option %q", o.Name)
+			}
+		}
+		val, err := optionValueFromJSON(id, o.Value)
+		if err != nil {
+			return err
+		}
+		msg.options[id] = append(msg.options[id], val)
+	}
+
+	switch {
+	case jm.Payload != nil && jm.PayloadBase64 != nil:
+		return errors.New("coapmsg: payload and payloadBase64 must not both be set")
+	case jm.Payload != nil:
+		msg.Payload = []byte(*jm.Payload)
+	case jm.PayloadBase64 != nil:
+		msg.Payload, err = base64.StdEncoding.DecodeString(*jm.PayloadBase64)
+		if err != nil {
+			return fmt.Errorf("coapmsg: invalid payloadBase64: %s", err)
+		}
+	}
+
+	*m = msg
+	return nil
+}
+
+func optionValueToJSON(id OptionId, val OptionValue) (json.RawMessage, error) {
+	switch id.Format() {
+	case ValueEmpty:
+		return json.RawMessage("null"), nil
+	case ValueUint:
+		return json.Marshal(decodeInt(val.AsBytes()))
+	case ValueString:
+		return json.Marshal(val.AsString())
+	default:
+		return json.Marshal(hex.EncodeToString(val.AsBytes()))
+	}
+}
+
+func optionValueFromJSON(id OptionId, raw json.RawMessage) (OptionValue, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return OptionValue{b: []byte{}}, nil
+	}
+
+	switch id.Format() {
+	case ValueUint:
+		var v uint32
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return NilOption, fmt.Errorf("coapmsg: option %s: %s", id, err)
+		}
+		return OptionValue{b: encodeInt(v)}, nil
+	case ValueString:
+		var v string
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return NilOption, fmt.Errorf("coapmsg: option %s: %s", id, err)
+		}
+		return OptionValue{b: []byte(v)}, nil
+	default:
+		var v string
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return NilOption, fmt.Errorf("coapmsg: option %s: %s", id, err)
+		}
+		b, err := hex.DecodeString(v)
+		if err != nil {
+			return NilOption, fmt.Errorf("coapmsg: option %s: %s", id, err)
+		}
+		return OptionValue{b: b}, nil
+	}
+}
@@ -0,0 +1,109 @@
+package coapmsg
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+var (
+	_ = json.Marshaler(Message{})
+	_ = json.Unmarshaler(&Message{})
+)
+
+func newJSONTestMessage() Message {
+	msg := NewMessage()
+	msg.Type = Confirmable
+	msg.Code = Content
+	msg.MessageID = 0x1234
+	msg.Token = []byte{0x4a, 0x3b}
+	msg.SetPathString("sensors/temp")
+	msg.Options().Set(ContentFormat, TextPlain)
+	msg.Options().Set(ETag, []byte{0xde, 0xad})
+	msg.Options().Set(IfNoneMatch, nil)
+	msg.Payload = []byte("22.5 C")
+	return msg
+}
+
+func TestMarshalJSON(t *testing.T) {
+	msg := newJSONTestMessage()
+
+	b, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"type":"CON","code":"2.05","messageId":4660,"token":"4a3b","options":[` +
+		`{"number":4,"name":"ETag","value":"dead"},` +
+		`{"number":5,"name":"If-None-Match","value":null},` +
+		`{"number":11,"name":"Uri-Path","value":"sensors"},` +
+		`{"number":11,"name":"Uri-Path","value":"temp"},` +
+		`{"number":12,"name":"Content-Format","value":0}],` +
+		`"payload":"22.5 C"}`
+	if string(b) != expected {
+		t.Errorf("Expected\n%s\nbut got\n%s", expected, string(b))
+	}
+
+	parsed := Message{}
+	if err := json.Unmarshal(b, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	assertEqualMessages(t, msg, parsed)
+}
+
+func TestMarshalJSONBinaryPayload(t *testing.T) {
+	msg := newJSONTestMessage()
+	msg.Options().Set(ContentFormat, AppOctets)
+	msg.Payload = []byte{0x00, 0xff}
+
+	b, err := json.Marshal(&msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"payloadBase64":"AP8="`) {
+		t.Error("Expected base64 payload but got", string(b))
+	}
+
+	parsed := Message{}
+	if err := json.Unmarshal(b, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	assertEqualMessages(t, msg, parsed)
+}
+
+func TestUnmarshalJSONOptionByName(t *testing.T) {
+	data := `{"type":"NON","code":"0.01","messageId":1,"options":[{"name":"Uri-Query","value":"a=1"},{"name":"Option(3000)","value":"01"}]}`
+
+	msg := Message{}
+	if err := json.Unmarshal([]byte(data), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != NonConfirmable || msg.Code != GET {
+		t.Errorf("Unexpected type %v or code %v", msg.Type, msg.Code)
+	}
+	if msg.Options().Get(URIQuery).AsString() != "a=1" {
+		t.Error("Expected Uri-Query a=1 but got", msg.Options().Get(URIQuery).AsString())
+	}
+	if msg.Options().Get(OptionId(3000)).AsUInt8() != 1 {
+		t.Error("Expected custom option 3000 to be 1")
+	}
+}
+
+func TestUnmarshalJSONErrors(t *testing.T) {
+	invalid := []string{
+		`{"type":"FOO","code":"0.01","messageId":1}`,
+		`{"type":"CON","code":"0.1","messageId":1}`,
+		`{"type":"CON","code":"8.01","messageId":1}`,
+		`{"type":"CON","code":"0.01","messageId":1,"token":"001122334455667788"}`,
+		`{"type":"CON","code":"0.01","messageId":1,"options":[{"name":"Unknown","value":1}]}`,
+		`{"type":"CON","code":"0.01","messageId":1,"options":[{"number":12,"value":"text"}]}`,
+		`{"type":"CON","code":"0.01","messageId":1,"payload":"a","payloadBase64":"YQ=="}`,
+	}
+
+	for _, data := range invalid {
+		msg := Message{}
+		if err := json.Unmarshal([]byte(data), &msg); err == nil {
+			t.Error("Expected error for", data)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package coapmsg
+
+import "testing"
+
+func TestParseMediaType(t *testing.T) {
+	tests := map[string]MediaType{
+		"text/plain;charset=utf-8":                    TextPlain,
+		"text/plain; charset=UTF-8":                   TextPlain,
+		"text/plain":                                  TextPlain,
+		"application/cbor":                            AppCBOR,
+		"Application/JSON":                            AppJSON,
+		"application/senml+json":                      AppSenMLJSON,
+		"application/vnd.oma.lwm2m+tlv":               AppLwM2MTLV,
+		`application/cose; cose-type="cose-encrypt0"`: AppCOSEEncrypt0,
+		"11543": AppLwM2MJSON,
+	}
+	for s, exp := range tests {
+		got, err := ParseMediaType(s)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got != exp {
+			t.Errorf("ParseMediaType(%q) = %d, expected %d", s, got, exp)
+		}
+	}
+
+	for _, s := range []string{"", "application/unknown", "70000"} {
+		if _, err := ParseMediaType(s); err == nil {
+			t.Errorf("Expected error for %q", s)
+		}
+	}
+}
+
+func TestMediaTypeString(t *testing.T) {
+	for mt, name := range mediaTypeNames {
+		if mt.String() != name {
+			t.Errorf("Expected %q but got %q", name, mt.String())
+		}
+		parsed, err := ParseMediaType(name)
+		if err != nil || parsed != mt {
+			t.Errorf("Failed to parse %q back to %d: %v", name, mt, err)
+		}
+	}
+	if s := MediaType(65000).String(); s != "MediaType(65000)" {
+		t.Error("Unexpected name for unknown media type", s)
+	}
+}
+
+func TestMediaTypeOptionValue(t *testing.T) {
+	opts := CoapOptions{}
+	opts.Set(ContentFormat, AppLwM2MTLV)
+	if v := opts.Get(ContentFormat); v.Len() != 2 || v.AsMediaType() != AppLwM2MTLV {
+		t.Errorf("Expected 2 byte value %d but got %x", AppLwM2MTLV, v.AsBytes())
+	}
+
+	msg := NewMessage()
+	msg.Type = Confirmable
+	msg.Code = GET
+	msg.Options().Set(Accept, AppSenMLCBOR)
+	parsed, err := ParseMessage(msg.MustMarshalBinary())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := parsed.Options().Get(Accept).AsMediaType(); got != AppSenMLCBOR {
+		t.Errorf("Expected Accept %d but got %d", AppSenMLCBOR, got)
+	}
+}
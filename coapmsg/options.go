@@ -20,6 +20,7 @@ type OptionId uint16
    |   7 | x  | x | - |   | Uri-Port       | uint   | 0-2    | (see    |
    |     |    |   |   |   |                |        |        | below)  |
    |   8 |    |   |   | x | Location-Path  | string | 0-255  | (none)  |
+   |   9 | x  | x | - |   | OSCORE         | opaque | 0-255  | (none)  |
    |  11 | x  | x | - | x | Uri-Path       | string | 0-255  | (none)  |
    |  12 |    |   |   |   | Content-Format | uint   | 0-2    | (none)  |
    |  14 |    | x | - |   | Max-Age        | uint   | 0-4    | 60      |
@@ -31,6 +32,15 @@ type OptionId uint16
    |  60 |    |   | x |   | Size1          | uint   | 0-4    | (none)  |
    +-----+----+---+---+---+----------------+--------+--------+---------+
    C=Critical, U=Unsafe, N=NoCacheKey, R=Repeatable
+
+   Block-wise transfers (RFC 7959) and freshness (RFC 9175):
+   +-----+----+---+---+---+----------------+--------+--------+---------+
+   |  23 | x  | x | - | - | Block2         | uint   | 0-3    | (none)  |
+   |  27 | x  | x | - | - | Block1         | uint   | 0-3    | (none)  |
+   |  28 |    |   | x |   | Size2          | uint   | 0-4    | (none)  |
+   | 252 |    |   | x |   | Echo           | opaque | 1-40   | (none)  |
+   | 292 |    |   |   | x | Request-Tag    | opaque | 0-8    | (none)  |
+   +-----+----+---+---+---+----------------+--------+--------+---------+
 */
 
 // Option IDs.
@@ -42,17 +52,79 @@ const (
 	Observe       OptionId = 6
 	URIPort       OptionId = 7
 	LocationPath  OptionId = 8
+	OSCORE        OptionId = 9 // RFC 8613
 	URIPath       OptionId = 11
 	ContentFormat OptionId = 12
 	MaxAge        OptionId = 14
 	URIQuery      OptionId = 15
 	Accept        OptionId = 17
 	LocationQuery OptionId = 20
+	Block2        OptionId = 23
+	Block1        OptionId = 27
+	Size2         OptionId = 28
 	ProxyURI      OptionId = 35
 	ProxyScheme   OptionId = 39
 	Size1         OptionId = 60
+	Echo          OptionId = 252
+	RequestTag    OptionId = 292
 )
 
+var optionNames = map[OptionId]string{
+	IfMatch:       "If-Match",
+	URIHost:       "Uri-Host",
+	ETag:          "ETag",
+	IfNoneMatch:   "If-None-Match",
+	Observe:       "Observe",
+	URIPort:       "Uri-Port",
+	LocationPath:  "Location-Path",
+	OSCORE:        "OSCORE",
+	URIPath:       "Uri-Path",
+	ContentFormat: "Content-Format",
+	MaxAge:        "Max-Age",
+	URIQuery:      "Uri-Query",
+	Accept:        "Accept",
+	LocationQuery: "Location-Query",
+	Block2:        "Block2",
+	Block1:        "Block1",
+	Size2:         "Size2",
+	ProxyURI:      "Proxy-Uri",
+	ProxyScheme:   "Proxy-Scheme",
+	Size1:         "Size1",
+	Echo:          "Echo",
+	RequestTag:    "Request-Tag",
+}
+
+// String returns the registered option name, e.g. "Uri-Path".
+// Unknown options are formatted as "Option(<number>)".
+func (o OptionId) String() string {
+	if name, ok := optionNames[o]; ok {
+		return name
+	}
+	return fmt.Sprintf("Option(%d)", uint16(o))
+}
+
+// optionIdByName is the inverse of OptionId.String
+func optionIdByName(name string) (OptionId, bool) {
+	for id, n := range optionNames {
+		if n == name {
+			return id, true
+		}
+	}
+	var num uint16
+	if _, err := fmt.Sscanf(name, "Option(%d)", &num); err == nil {
+		return OptionId(num), true
+	}
+	return 0, false
+}
+
+// Format returns the value format of a known option or ValueOpaque for unknown options.
+func (o OptionId) Format() ValueFormat {
+	if def, ok := optionDefs[o]; ok {
+		return def.valueFormat
+	}
+	return ValueOpaque
+}
+
 func (o OptionId) Critical() bool {
 	return uint16(o)&1 != 0
 }
@@ -67,19 +139,6 @@ func (o OptionId) NoCacheKey() bool {
 	return bool((o & 0x1e) == 0x1c)
 }
 
-// MediaType specifies the content type of a message.
-type MediaType byte
-
-// Content types.
-const (
-	TextPlain     MediaType = 0  // text/plain;charset=utf-8
-	AppLinkFormat MediaType = 40 // application/link-format
-	AppXML        MediaType = 41 // application/xml
-	AppOctets     MediaType = 42 // application/octet-stream
-	AppExi        MediaType = 47 // application/exi
-	AppJSON       MediaType = 50 // application/json
-)
-
 // Option value format (RFC7252 section 3.2)
 // Defines the option format inside the packet
 type ValueFormat uint8
@@ -112,15 +171,21 @@ var optionDefs = map[OptionId]optionDef{
 	Observe:       {valueFormat: ValueUint, minLen: 0, maxLen: 3}, // Client: 0 = register, 1 = unregister; Server: Seq. number
 	URIPort:       {valueFormat: ValueUint, minLen: 0, maxLen: 2},
 	LocationPath:  {valueFormat: ValueString, minLen: 0, maxLen: 255},
+	OSCORE:        {valueFormat: ValueOpaque, minLen: 0, maxLen: 255},
 	URIPath:       {valueFormat: ValueString, minLen: 0, maxLen: 255},
 	ContentFormat: {valueFormat: ValueUint, minLen: 0, maxLen: 2},
 	MaxAge:        {valueFormat: ValueUint, minLen: 0, maxLen: 4},
 	URIQuery:      {valueFormat: ValueString, minLen: 0, maxLen: 255},
 	Accept:        {valueFormat: ValueUint, minLen: 0, maxLen: 2},
 	LocationQuery: {valueFormat: ValueString, minLen: 0, maxLen: 255},
+	Block2:        {valueFormat: ValueUint, minLen: 0, maxLen: 3},
+	Block1:        {valueFormat: ValueUint, minLen: 0, maxLen: 3},
+	Size2:         {valueFormat: ValueUint, minLen: 0, maxLen: 4},
 	ProxyURI:      {valueFormat: ValueString, minLen: 1, maxLen: 1034},
 	ProxyScheme:   {valueFormat: ValueString, minLen: 1, maxLen: 255},
 	Size1:         {valueFormat: ValueUint, minLen: 0, maxLen: 4},
+	Echo:          {valueFormat: ValueOpaque, minLen: 1, maxLen: 40},
+	RequestTag:    {valueFormat: ValueOpaque, minLen: 0, maxLen: 8},
 }
 
 type optionsIds []OptionId
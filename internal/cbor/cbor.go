@@ -0,0 +1,385 @@
+// Package cbor implements the small subset of CBOR (RFC 7049) that is
+// needed inside this repository: unsigned and negative integers, byte
+// and text strings, arrays, maps, booleans, null and floats.
+//
+// Values are mapped onto plain Go types, similar to encoding/json:
+//
+//	CBOR unsigned int  <-> uint64
+//	CBOR negative int  <-> int64
+//	CBOR byte string   <-> []byte
+//	CBOR text string   <-> string
+//	CBOR array         <-> []interface{}
+//	CBOR map           <-> map[interface{}]interface{}
+//	CBOR true/false    <-> bool
+//	CBOR null          <-> nil
+//	CBOR float         <-> float64
+package cbor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	majorUint   = 0
+	majorNegInt = 1
+	majorBytes  = 2
+	majorText   = 3
+	majorArray  = 4
+	majorMap    = 5
+	majorTag    = 6
+	majorSimple = 7
+)
+
+const (
+	simpleFalse   = 20
+	simpleTrue    = 21
+	simpleNull    = 22
+	simpleFloat16 = 25
+	simpleFloat32 = 26
+	simpleFloat64 = 27
+)
+
+// Decoding errors.
+var (
+	ErrTruncated   = errors.New("cbor: truncated data")
+	ErrUnsupported = errors.New("cbor: unsupported data item")
+	ErrTrailing    = errors.New("cbor: trailing data")
+)
+
+// Marshal returns the CBOR encoding of v.
+//
+// Map keys are written in length-first canonical order (RFC 7049 section 3.9)
+// so the output is stable for equal input.
+func Marshal(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := encode(buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes exactly one CBOR data item from data.
+func Unmarshal(data []byte) (interface{}, error) {
+	v, rest, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, ErrTrailing
+	}
+	return v, nil
+}
+
+// Decode decodes the first CBOR data item in data and returns the remaining bytes.
+func Decode(data []byte) (v interface{}, rest []byte, err error) {
+	d := &decoder{b: data}
+	v, err = d.value()
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, d.b, nil
+}
+
+func writeHead(buf *bytes.Buffer, major byte, n uint64) {
+	m := major << 5
+	switch {
+	case n < 24:
+		buf.WriteByte(m | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(m | 24)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(m | 25)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		buf.WriteByte(m | 26)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(m | 27)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+func encodeInt(buf *bytes.Buffer, i int64) {
+	if i < 0 {
+		writeHead(buf, majorNegInt, uint64(-1-i))
+		return
+	}
+	writeHead(buf, majorUint, uint64(i))
+}
+
+func encode(buf *bytes.Buffer, v interface{}) error {
+	switch x := v.(type) {
+	case nil:
+		buf.WriteByte(majorSimple<<5 | simpleNull)
+	case bool:
+		if x {
+			buf.WriteByte(majorSimple<<5 | simpleTrue)
+		} else {
+			buf.WriteByte(majorSimple<<5 | simpleFalse)
+		}
+	case uint:
+		writeHead(buf, majorUint, uint64(x))
+	case uint8:
+		writeHead(buf, majorUint, uint64(x))
+	case uint16:
+		writeHead(buf, majorUint, uint64(x))
+	case uint32:
+		writeHead(buf, majorUint, uint64(x))
+	case uint64:
+		writeHead(buf, majorUint, x)
+	case int:
+		encodeInt(buf, int64(x))
+	case int8:
+		encodeInt(buf, int64(x))
+	case int16:
+		encodeInt(buf, int64(x))
+	case int32:
+		encodeInt(buf, int64(x))
+	case int64:
+		encodeInt(buf, x)
+	case float32:
+		buf.WriteByte(majorSimple<<5 | simpleFloat32)
+		binary.Write(buf, binary.BigEndian, math.Float32bits(x))
+	case float64:
+		buf.WriteByte(majorSimple<<5 | simpleFloat64)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(x))
+	case []byte:
+		writeHead(buf, majorBytes, uint64(len(x)))
+		buf.Write(x)
+	case string:
+		writeHead(buf, majorText, uint64(len(x)))
+		buf.WriteString(x)
+	case []interface{}:
+		writeHead(buf, majorArray, uint64(len(x)))
+		for _, e := range x {
+			if err := encode(buf, e); err != nil {
+				return err
+			}
+		}
+	case map[interface{}]interface{}:
+		return encodeMap(buf, x)
+	case map[string]interface{}:
+		m := make(map[interface{}]interface{}, len(x))
+		for k, e := range x {
+			m[k] = e
+		}
+		return encodeMap(buf, m)
+	default:
+		return fmt.Errorf("cbor: unsupported type %T", v)
+	}
+	return nil
+}
+
+func encodeMap(buf *bytes.Buffer, m map[interface{}]interface{}) error {
+	type entry struct {
+		key []byte
+		val interface{}
+	}
+	entries := make([]entry, 0, len(m))
+	for k, v := range m {
+		kb := &bytes.Buffer{}
+		if err := encode(kb, k); err != nil {
+			return err
+		}
+		entries = append(entries, entry{kb.Bytes(), v})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].key, entries[j].key
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return bytes.Compare(a, b) < 0
+	})
+
+	writeHead(buf, majorMap, uint64(len(entries)))
+	for _, e := range entries {
+		buf.Write(e.key)
+		if err := encode(buf, e.val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type decoder struct {
+	b []byte
+}
+
+func (d *decoder) next(n uint64) ([]byte, error) {
+	if uint64(len(d.b)) < n {
+		return nil, ErrTruncated
+	}
+	p := d.b[:n]
+	d.b = d.b[n:]
+	return p, nil
+}
+
+// head reads the initial byte and the argument of a data item.
+func (d *decoder) head() (major byte, info byte, arg uint64, err error) {
+	p, err := d.next(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major = p[0] >> 5
+	info = p[0] & 0x1f
+
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info == 24:
+		p, err = d.next(1)
+		if err == nil {
+			arg = uint64(p[0])
+		}
+	case info == 25:
+		p, err = d.next(2)
+		if err == nil {
+			arg = uint64(binary.BigEndian.Uint16(p))
+		}
+	case info == 26:
+		p, err = d.next(4)
+		if err == nil {
+			arg = uint64(binary.BigEndian.Uint32(p))
+		}
+	case info == 27:
+		p, err = d.next(8)
+		if err == nil {
+			arg = binary.BigEndian.Uint64(p)
+		}
+	default:
+		// Indefinite length items are not supported
+		err = ErrUnsupported
+	}
+	return
+}
+
+func (d *decoder) value() (interface{}, error) {
+	major, info, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case majorUint:
+		return arg, nil
+	case majorNegInt:
+		if arg > math.MaxInt64 {
+			return nil, ErrUnsupported
+		}
+		return -1 - int64(arg), nil
+	case majorBytes:
+		p, err := d.next(arg)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, p...), nil
+	case majorText:
+		p, err := d.next(arg)
+		if err != nil {
+			return nil, err
+		}
+		return string(p), nil
+	case majorArray:
+		if arg > uint64(len(d.b)) {
+			return nil, ErrTruncated
+		}
+		a := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			v, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		return a, nil
+	case majorMap:
+		if arg > uint64(len(d.b)) {
+			return nil, ErrTruncated
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			k, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case []byte, []interface{}, map[interface{}]interface{}:
+				// Not comparable, can not be used as Go map key
+				return nil, ErrUnsupported
+			}
+			v, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	case majorTag:
+		// Tags are skipped, only the tagged value is returned
+		return d.value()
+	case majorSimple:
+		switch info {
+		case simpleFalse:
+			return false, nil
+		case simpleTrue:
+			return true, nil
+		case simpleNull:
+			return nil, nil
+		case simpleFloat16:
+			return halfToFloat(uint16(arg)), nil
+		case simpleFloat32:
+			return float64(math.Float32frombits(uint32(arg))), nil
+		case simpleFloat64:
+			return math.Float64frombits(arg), nil
+		}
+	}
+	return nil, ErrUnsupported
+}
+
+func halfToFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var val float64
+	switch exp {
+	case 0:
+		val = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			val = math.Inf(1)
+		} else {
+			val = math.NaN()
+		}
+	default:
+		val = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -val
+	}
+	return val
+}
+
+// Uint converts a decoded CBOR value into an unsigned integer.
+func Uint(v interface{}) (uint64, bool) {
+	u, ok := v.(uint64)
+	return u, ok
+}
+
+// Int converts a decoded CBOR integer into an int64.
+func Int(v interface{}) (int64, bool) {
+	switch x := v.(type) {
+	case uint64:
+		if x > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(x), true
+	case int64:
+		return x, true
+	}
+	return 0, false
+}
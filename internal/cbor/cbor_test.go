@@ -0,0 +1,72 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+// Examples from RFC 7049 Appendix A
+var vectors = []struct {
+	value interface{}
+	hex   string
+}{
+	{uint64(0), "00"},
+	{uint64(23), "17"},
+	{uint64(24), "1818"},
+	{uint64(1000), "1903e8"},
+	{uint64(1000000), "1a000f4240"},
+	{int64(-1), "20"},
+	{int64(-1000), "3903e7"},
+	{[]byte{1, 2, 3, 4}, "4401020304"},
+	{"IETF", "6449455446"},
+	{[]interface{}{uint64(1), []interface{}{uint64(2), uint64(3)}}, "8201820203"},
+	{map[interface{}]interface{}{"a": uint64(1), "b": []interface{}{uint64(2), uint64(3)}}, "a26161016162820203"},
+	{true, "f5"},
+	{nil, "f6"},
+	{1.1, "fb3ff199999999999a"},
+}
+
+func TestVectors(t *testing.T) {
+	for _, v := range vectors {
+		b, err := Marshal(v.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, _ := hex.DecodeString(v.hex)
+		if !bytes.Equal(b, expected) {
+			t.Errorf("Marshal(%v): expected %s got %x", v.value, v.hex, b)
+		}
+
+		decoded, err := Unmarshal(expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, v.value) {
+			t.Errorf("Unmarshal(%s): expected %#v got %#v", v.hex, v.value, decoded)
+		}
+	}
+}
+
+func TestDecodeHalfFloat(t *testing.T) {
+	v, err := Unmarshal([]byte{0xf9, 0x3e, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 1.5 {
+		t.Errorf("Expected 1.5 but got %v", v)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	if _, err := Unmarshal([]byte{0x44, 0x01}); err != ErrTruncated {
+		t.Error("Expected ErrTruncated but got", err)
+	}
+	if _, err := Unmarshal([]byte{0x01, 0x01}); err != ErrTrailing {
+		t.Error("Expected ErrTrailing but got", err)
+	}
+	if _, err := Unmarshal([]byte{0x5f}); err != ErrUnsupported {
+		t.Error("Expected ErrUnsupported but got", err)
+	}
+}
@@ -0,0 +1,427 @@
+package lwm2m
+
+import (
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"sync"
+
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coapmsg"
+	"github.com/trusch/coap-go/rd"
+)
+
+// Binding of the client sent on registration (b parameter)
+const DefaultBinding = "U"
+
+// Client is a LwM2M client (LwM2M, Section 6).
+//
+// The embedded rd.Endpoint implements the Register, Update and
+// De-register operations of the Client Registration interface, the
+// registered links are kept in sync with the objects of the client.
+//
+// The Device Management and Information Reporting interfaces are
+// served by ServeCOAP, see Handle. Requests of a server connected over
+// UART reach the client when the mux is the Handler of the
+// coap.UartConnector.
+type Client struct {
+	rd.Endpoint
+
+	// Send delivers notifications of observations to the LwM2M server.
+	// The message carries the token of the observe request, the caller
+	// is responsible to assign a message ID. If Send returns an error,
+	// the observation is canceled.
+	Send func(msg *coapmsg.Message) error
+
+	mu           sync.Mutex
+	objects      map[uint16]*Object
+	attrs        map[string]Attributes   // by path
+	observations map[string]*observation // by token
+	outbox       []*notification         // notifications to send after unlock
+}
+
+// NewClient returns a client with the given endpoint name that
+// registers at the registration interface of the server, e.g.
+// coap+uart://any/rd
+func NewClient(name, server string) *Client {
+	c := &Client{
+		objects:      map[uint16]*Object{},
+		attrs:        map[string]Attributes{},
+		observations: map[string]*observation{},
+	}
+	c.Name = name
+	c.RD = server
+	c.Params = map[string]string{"lwm2m": Version, "b": DefaultBinding}
+	return c
+}
+
+// AddObject adds an object to the client. Objects should be added
+// before the client registers and Handle is called.
+func (c *Client) AddObject(o *Object) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.objects[o.ID] = o
+	c.Links = c.objectLinks()
+}
+
+// objectLinks returns the links sent on registration,
+// e.g. </1/0>,</3/0>
+func (c *Client) objectLinks() []rd.Link {
+	var links []rd.Link
+	for _, id := range c.objectIDs() {
+		o := c.objects[id]
+		p := Path{id}
+		if len(o.Instances) == 0 {
+			links = append(links, rd.Link{Target: p.String()})
+			continue
+		}
+		for _, inst := range o.instanceIDs() {
+			links = append(links, rd.Link{Target: p.Append(inst).String()})
+		}
+	}
+	return links
+}
+
+func (c *Client) objectIDs() []uint16 {
+	ids := make([]uint16, 0, len(c.objects))
+	for id := range c.objects {
+		ids = append(ids, id)
+	}
+	return sortIDs(ids)
+}
+
+// Handle registers the client for all of its objects at mux
+func (c *Client) Handle(mux *coap.ServeMux) {
+	c.mu.Lock()
+	ids := c.objectIDs()
+	c.mu.Unlock()
+	for _, id := range ids {
+		mux.Handle("/"+strconv.Itoa(int(id))+"/", c)
+	}
+}
+
+// resource returns the resource at the first three elements of p
+func (c *Client) resource(p Path) (*Resource, error) {
+	if len(p) < 3 {
+		return nil, ErrInvalidPath
+	}
+	o := c.objects[p[0]]
+	if o == nil {
+		return nil, ErrNotFound
+	}
+	inst := o.Instances[p[1]]
+	if inst == nil {
+		return nil, ErrNotFound
+	}
+	r := inst.Resources[p[2]]
+	if r == nil {
+		return nil, ErrNotFound
+	}
+	return r, nil
+}
+
+// read returns the readable values at p
+func (c *Client) read(p Path) ([]entry, error) {
+	switch len(p) {
+	case 1:
+		if o := c.objects[p[0]]; o != nil {
+			return o.entries(p), nil
+		}
+	case 2:
+		if o := c.objects[p[0]]; o != nil && o.Instances[p[1]] != nil {
+			return o.Instances[p[1]].entries(p), nil
+		}
+	case 3, 4:
+		r, err := c.resource(p)
+		if err != nil {
+			return nil, err
+		}
+		if r.Operations&OpRead == 0 {
+			return nil, ErrNotAllowed
+		}
+		if len(p) == 3 {
+			return r.entries(p), nil
+		}
+		if v, ok := r.Instances[p[3]]; ok {
+			return []entry{{p, v}}, nil
+		}
+	default:
+		return nil, ErrInvalidPath
+	}
+	return nil, ErrNotFound
+}
+
+// Get returns the value of a resource or resource instance
+func (c *Client) Get(path string) (interface{}, error) {
+	p, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.resource(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(p) == 3 {
+		return r.Value, nil
+	}
+	v, ok := r.Instances[p[3]]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return v, nil
+}
+
+// Set changes the value of a resource or resource instance and notifies
+// observers of the resource. The value is converted to the resource type.
+func (c *Client) Set(path string, value interface{}) error {
+	p, err := ParsePath(path)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.unlockAndNotify()
+	return c.set(p, value)
+}
+
+func (c *Client) set(p Path, value interface{}) error {
+	r, err := c.resource(p)
+	if err != nil {
+		return err
+	}
+	v, err := convert(r.Type, value)
+	if err != nil {
+		return err
+	}
+	if len(p) == 4 {
+		if !r.Multiple() {
+			return ErrNotFound
+		}
+		r.Instances[p[3]] = v
+	} else if r.Multiple() {
+		return ErrInvalidPath
+	} else {
+		r.Value = v
+	}
+	c.changed(p)
+	return nil
+}
+
+// ServeCOAP serves the Device Management and Information Reporting
+// interfaces (LwM2M, Section 5.4 and 5.5).
+func (c *Client) ServeCOAP(w coap.ResponseWriter, r *coap.Request) {
+	p, err := ParsePath(r.URL.Path)
+	if err != nil || len(p) == 0 {
+		w.WriteCode(coapmsg.NotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		if responseFormat(r) == coapmsg.AppLinkFormat {
+			c.discover(w, p)
+			return
+		}
+		c.serveRead(w, r, p)
+	case "PUT":
+		body, _ := ioutil.ReadAll(r.Body)
+		if len(body) == 0 && len(r.Options[coapmsg.URIQuery]) > 0 {
+			c.writeAttributes(w, r, p)
+			return
+		}
+		c.write(w, r, p, body, true)
+	case "POST":
+		body, _ := ioutil.ReadAll(r.Body)
+		switch len(p) {
+		case 2:
+			c.write(w, r, p, body, false)
+		case 3:
+			c.execute(w, p, string(body))
+		default:
+			w.WriteCode(coapmsg.MethodNotAllowed)
+		}
+	default:
+		w.WriteCode(coapmsg.MethodNotAllowed)
+	}
+}
+
+// writeError writes the response code for an error of the object model
+func writeError(w coap.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		w.WriteCode(coapmsg.NotFound)
+	case errors.Is(err, ErrNotAllowed):
+		w.WriteCode(coapmsg.MethodNotAllowed)
+	case errors.Is(err, ErrUnsupportedFormat):
+		w.WriteCode(coapmsg.UnsupportedMediaType)
+	default:
+		w.WriteCode(coapmsg.BadRequest)
+	}
+}
+
+// responseFormat returns the format requested by the Accept option
+func responseFormat(r *coap.Request) coapmsg.MediaType {
+	if accept := r.Options.Get(coapmsg.Accept); accept.IsSet() {
+		return accept.AsMediaType()
+	}
+	return DefaultFormat
+}
+
+// serveRead handles Read and Observe operations
+func (c *Client) serveRead(w coap.ResponseWriter, r *coap.Request, p Path) {
+	format := responseFormat(r)
+	if !isSupported(format) {
+		w.WriteCode(coapmsg.NotAcceptable)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries, err := c.read(p)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	payload, err := encodeEntries(p, entries, format)
+	if err != nil {
+		w.WriteCode(coapmsg.NotAcceptable)
+		return
+	}
+
+	if obs := r.Options.Get(coapmsg.Observe); obs.IsSet() {
+		if obs.AsUInt32() == 0 {
+			seq := c.observe(r.Token, p, format)
+			w.Options().Set(coapmsg.Observe, seq)
+		} else {
+			c.cancelObservation(string(r.Token))
+		}
+	}
+	w.Options().Set(coapmsg.ContentFormat, format)
+	w.Write(payload)
+}
+
+// write handles Write operations. PUT replaces multiple-instance
+// resources, POST on an instance is a partial update.
+func (c *Client) write(w coap.ResponseWriter, r *coap.Request, p Path, body []byte, replace bool) {
+	if len(p) < 2 {
+		w.WriteCode(coapmsg.MethodNotAllowed)
+		return
+	}
+	cf := r.Options.Get(coapmsg.ContentFormat)
+	if cf.IsNotSet() {
+		w.WriteCode(coapmsg.BadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.unlockAndNotify()
+	if _, err := c.read(p[:2]); err != nil {
+		writeError(w, err)
+		return
+	}
+	entries, err := decodeEntries(p, body, cf.AsMediaType(), func(p Path) (ValueType, bool) {
+		res, err := c.resource(p)
+		if err != nil {
+			return None, false
+		}
+		return res.Type, true
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	for _, e := range entries {
+		if res, _ := c.resource(e.Path); res.Operations&OpWrite == 0 {
+			w.WriteCode(coapmsg.MethodNotAllowed)
+			return
+		}
+	}
+
+	if replace && len(p) == 3 {
+		if res, err := c.resource(p); err == nil && res.Multiple() {
+			res.Instances = map[uint16]interface{}{}
+		}
+	}
+	for _, e := range entries {
+		if err := c.set(e.Path, e.Value); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	w.WriteCode(coapmsg.Changed)
+}
+
+// execute handles the Execute operation
+func (c *Client) execute(w coap.ResponseWriter, p Path, args string) {
+	c.mu.Lock()
+	res, err := c.resource(p)
+	c.mu.Unlock()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if res.Operations&OpExecute == 0 || res.Execute == nil {
+		w.WriteCode(coapmsg.MethodNotAllowed)
+		return
+	}
+	if err := res.Execute(args); err != nil {
+		log.WithError(err).WithField("path", p.String()).Warn("Execute failed")
+		w.WriteCode(coapmsg.InternalServerError)
+		return
+	}
+	w.WriteCode(coapmsg.Changed)
+}
+
+// discover handles the Discover operation (LwM2M, Section 5.4.2).
+// The links contain the attributes attached to each path and the
+// number of instances of multiple-instance resources (dim).
+func (c *Client) discover(w coap.ResponseWriter, p Path) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var links []rd.Link
+	link := func(p Path) rd.Link {
+		l := rd.Link{Target: p.String(), Params: c.attrs[p.String()].params()}
+		if len(p) == 3 {
+			if res, _ := c.resource(p); res != nil && res.Multiple() {
+				l.Params["dim"] = strconv.Itoa(len(res.Instances))
+			}
+		}
+		return l
+	}
+	addInstance := func(p Path, inst *Instance) {
+		if len(p) == 2 {
+			links = append(links, link(p))
+		}
+		for _, id := range inst.resourceIDs() {
+			links = append(links, link(p.Append(id)))
+		}
+	}
+
+	o := c.objects[p[0]]
+	switch {
+	case o == nil:
+		w.WriteCode(coapmsg.NotFound)
+		return
+	case len(p) == 1:
+		links = append(links, link(p))
+		for _, id := range o.instanceIDs() {
+			addInstance(p.Append(id), o.Instances[id])
+		}
+	case len(p) == 2 && o.Instances[p[1]] != nil:
+		addInstance(p, o.Instances[p[1]])
+	case len(p) == 3:
+		if _, err := c.resource(p); err != nil {
+			writeError(w, err)
+			return
+		}
+		links = append(links, link(p))
+	default:
+		w.WriteCode(coapmsg.NotFound)
+		return
+	}
+
+	w.Options().Set(coapmsg.ContentFormat, coapmsg.AppLinkFormat)
+	w.Write([]byte(rd.FormatLinks(links)))
+}
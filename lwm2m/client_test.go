@@ -0,0 +1,251 @@
+package lwm2m
+
+import (
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coap/coaptest"
+	"github.com/trusch/coap-go/coapmsg"
+	"github.com/trusch/coap-go/rd"
+)
+
+func newTestClient(h coap.Handler) *coap.Client {
+	client := coap.NewClient()
+	client.Transport = &coaptest.HandlerTransport{Handler: h}
+	return client
+}
+
+// newDevice returns a client with a Device object (3) and a mux serving it
+func newDevice() (*Client, *coap.ServeMux, *[]string) {
+	var executed []string
+	device := NewObject(3)
+	device.AddInstance(0).
+		AddResource(&Resource{ID: 0, Type: String, Operations: OpRead, Value: "Lobaro"}).
+		AddResource(&Resource{ID: 4, Operations: OpExecute, Execute: func(args string) error {
+			executed = append(executed, args)
+			return nil
+		}}).
+		AddResource(&Resource{ID: 6, Type: Integer, Operations: OpRead, Instances: map[uint16]interface{}{0: int64(1), 1: int64(5)}}).
+		AddResource(&Resource{ID: 13, Type: Time, Operations: OpReadWrite, Value: time.Unix(1000, 0)}).
+		AddResource(&Resource{ID: 15, Type: String, Operations: OpReadWrite, Value: "UTC"})
+
+	c := NewClient("node1", "coap+uart://any/rd")
+	c.AddObject(device)
+	mux := coap.NewServeMux()
+	c.Handle(mux)
+	return c, mux, &executed
+}
+
+func do(t *testing.T, client *coap.Client, method, url string, opts map[coapmsg.OptionId]interface{}, body string) (*coap.Response, string) {
+	req, err := coap.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, v := range opts {
+		req.Options.Set(id, v)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := ioutil.ReadAll(res.Body)
+	return res, string(payload)
+}
+
+func TestClientRegistrationLinks(t *testing.T) {
+	c, _, _ := newDevice()
+	if got := rd.FormatLinks(c.Links); got != "</3/0>" {
+		t.Errorf("Unexpected links %s", got)
+	}
+	if c.Params["lwm2m"] != Version || c.Params["b"] != "U" {
+		t.Errorf("Unexpected registration params %v", c.Params)
+	}
+}
+
+func TestClientRead(t *testing.T) {
+	_, mux, _ := newDevice()
+	client := newTestClient(mux)
+
+	res, body := do(t, client, "GET", "coap+uart://any/3/0/0", map[coapmsg.OptionId]interface{}{coapmsg.Accept: coapmsg.TextPlain}, "")
+	if res.Code != coapmsg.Content || body != "Lobaro" {
+		t.Fatalf("Unexpected response %s %q", res.Status, body)
+	}
+
+	res, body = do(t, client, "GET", "coap+uart://any/3/0", nil, "")
+	if res.Code != coapmsg.Content || res.Options.Get(coapmsg.ContentFormat).AsMediaType() != coapmsg.AppSenMLJSON {
+		t.Fatalf("Unexpected response %s", res.Status)
+	}
+	records, err := UnmarshalSenMLJSON([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, r := range records {
+		names = append(names, r.Name)
+	}
+	if strings.Join(names, ",") != "/3/0/0,/3/0/6/0,/3/0/6/1,/3/0/13,/3/0/15" {
+		t.Errorf("Unexpected records %v", records)
+	}
+
+	res, body = do(t, client, "GET", "coap+uart://any/3/0/6", map[coapmsg.OptionId]interface{}{coapmsg.Accept: coapmsg.AppLwM2MTLV}, "")
+	if res.Code != coapmsg.Content || body != string([]byte{0x86, 0x06, 0x41, 0x00, 0x01, 0x41, 0x01, 0x05}) {
+		t.Errorf("Unexpected TLV response %s % x", res.Status, body)
+	}
+
+	for url, code := range map[string]coapmsg.COAPCode{
+		"coap+uart://any/3/1":   coapmsg.NotFound,
+		"coap+uart://any/3/0/9": coapmsg.NotFound,
+		"coap+uart://any/3/0/4": coapmsg.MethodNotAllowed,
+		"coap+uart://any/5/0":   coapmsg.NotFound,
+	} {
+		if res, _ := do(t, client, "GET", url, nil, ""); res.Code != code {
+			t.Errorf("%s: expected %s but got %s", url, code.Dotted(), res.Status)
+		}
+	}
+}
+
+func TestClientWrite(t *testing.T) {
+	c, mux, _ := newDevice()
+	client := newTestClient(mux)
+
+	res, _ := do(t, client, "PUT", "coap+uart://any/3/0/15", map[coapmsg.OptionId]interface{}{coapmsg.ContentFormat: coapmsg.TextPlain}, "CET")
+	if res.Code != coapmsg.Changed {
+		t.Fatal("Expected 2.04 but got", res.Status)
+	}
+	if v, _ := c.Get("/3/0/15"); v != "CET" {
+		t.Errorf("Unexpected value %v", v)
+	}
+
+	res, _ = do(t, client, "POST", "coap+uart://any/3/0", map[coapmsg.OptionId]interface{}{coapmsg.ContentFormat: coapmsg.AppSenMLJSON},
+		`[{"bn":"/3/0/","n":"13","v":2000},{"n":"15","vs":"UTC"}]`)
+	if res.Code != coapmsg.Changed {
+		t.Fatal("Expected 2.04 but got", res.Status)
+	}
+	if v, _ := c.Get("/3/0/13"); !v.(time.Time).Equal(time.Unix(2000, 0)) {
+		t.Errorf("Unexpected time %v", v)
+	}
+
+	res, _ = do(t, client, "PUT", "coap+uart://any/3/0/0", map[coapmsg.OptionId]interface{}{coapmsg.ContentFormat: coapmsg.TextPlain}, "ACME")
+	if res.Code != coapmsg.MethodNotAllowed {
+		t.Error("Expected 4.05 for read-only resource but got", res.Status)
+	}
+	res, _ = do(t, client, "PUT", "coap+uart://any/3/0/13", map[coapmsg.OptionId]interface{}{coapmsg.ContentFormat: coapmsg.TextPlain}, "noon")
+	if res.Code != coapmsg.BadRequest {
+		t.Error("Expected 4.00 for invalid value but got", res.Status)
+	}
+}
+
+func TestClientExecute(t *testing.T) {
+	_, mux, executed := newDevice()
+	client := newTestClient(mux)
+
+	res, _ := do(t, client, "POST", "coap+uart://any/3/0/4", nil, "0='now'")
+	if res.Code != coapmsg.Changed {
+		t.Fatal("Expected 2.04 but got", res.Status)
+	}
+	if len(*executed) != 1 || (*executed)[0] != "0='now'" {
+		t.Errorf("Unexpected executions %v", *executed)
+	}
+	if res, _ := do(t, client, "POST", "coap+uart://any/3/0/15", nil, ""); res.Code != coapmsg.MethodNotAllowed {
+		t.Error("Expected 4.05 but got", res.Status)
+	}
+}
+
+func TestClientDiscoverAndWriteAttributes(t *testing.T) {
+	_, mux, _ := newDevice()
+	client := newTestClient(mux)
+
+	res, _ := do(t, client, "PUT", "coap+uart://any/3/0/13?pmin=10&pmax=60", nil, "")
+	if res.Code != coapmsg.Changed {
+		t.Fatal("Expected 2.04 but got", res.Status)
+	}
+	if res, _ := do(t, client, "PUT", "coap+uart://any/3/0/13?gt=5", nil, ""); res.Code != coapmsg.BadRequest {
+		t.Error("Expected 4.00 for unsupported attribute but got", res.Status)
+	}
+
+	res, body := do(t, client, "GET", "coap+uart://any/3/0", map[coapmsg.OptionId]interface{}{coapmsg.Accept: coapmsg.AppLinkFormat}, "")
+	if res.Code != coapmsg.Content {
+		t.Fatal("Expected 2.05 but got", res.Status)
+	}
+	expected := "</3/0>,</3/0/0>,</3/0/4>,</3/0/6>;dim=2,</3/0/13>;pmax=60;pmin=10,</3/0/15>"
+	if body != expected {
+		t.Errorf("Expected %s but got %s", expected, body)
+	}
+}
+
+func TestClientNotifications(t *testing.T) {
+	c, mux, _ := newDevice()
+	var mu sync.Mutex
+	var sent []*coapmsg.Message
+	c.Send = func(msg *coapmsg.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, msg)
+		return nil
+	}
+	notifications := func() []*coapmsg.Message {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*coapmsg.Message{}, sent...)
+	}
+
+	req := coapmsg.NewMessage()
+	req.Type = coapmsg.Confirmable
+	req.Code = coapmsg.GET
+	req.Token = []byte{0x42}
+	req.SetPathString("/3/0/15")
+	req.Options().Set(coapmsg.Observe, 0)
+	req.Options().Set(coapmsg.Accept, coapmsg.TextPlain)
+	res := coap.ServeMessage(mux, &req)
+	if res.Code != coapmsg.Content || res.Options().Get(coapmsg.Observe).IsNotSet() {
+		t.Fatal("Unexpected observe response", res.Code.Dotted())
+	}
+
+	if err := c.Set("/3/0/15", "CET"); err != nil {
+		t.Fatal(err)
+	}
+	got := notifications()
+	if len(got) != 1 {
+		t.Fatalf("Expected 1 notification but got %d", len(got))
+	}
+	n := got[0]
+	if n.Type != coapmsg.NonConfirmable || string(n.Token) != "\x42" || string(n.Payload) != "CET" {
+		t.Errorf("Unexpected notification %v %q", n.Token, n.Payload)
+	}
+
+	// Unrelated changes are not notified
+	c.Set("/3/0/13", int64(5))
+	if len(notifications()) != 1 {
+		t.Error("Expected no notification for other resource")
+	}
+
+	// pmax forces a notification without change
+	attrs := coapmsg.NewMessage()
+	attrs.Type = coapmsg.Confirmable
+	attrs.Code = coapmsg.PUT
+	attrs.SetPathString("/3/0/15")
+	attrs.Options().Add(coapmsg.URIQuery, "pmax=1")
+	if res := coap.ServeMessage(mux, &attrs); res.Code != coapmsg.Changed {
+		t.Fatal("Expected 2.04 but got", res.Code.Dotted())
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for len(notifications()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(notifications()) < 2 {
+		t.Fatal("Expected notification after pmax")
+	}
+
+	// Observe=1 cancels the observation
+	req.Options().Set(coapmsg.Observe, 1)
+	coap.ServeMessage(mux, &req)
+	count := len(notifications())
+	c.Set("/3/0/15", "UTC")
+	if len(notifications()) != count {
+		t.Error("Expected no notification after cancel")
+	}
+}
@@ -0,0 +1,237 @@
+package lwm2m
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// DefaultFormat is used for Read and Observe responses when the request
+// has no Accept option
+const DefaultFormat = coapmsg.AppSenMLJSON
+
+// supportedFormats are the content formats of Read and Write payloads
+var supportedFormats = []coapmsg.MediaType{
+	coapmsg.AppSenMLJSON,
+	coapmsg.AppSenMLCBOR,
+	coapmsg.AppLwM2MTLV,
+	coapmsg.TextPlain,
+	coapmsg.AppOctets,
+}
+
+func isSupported(format coapmsg.MediaType) bool {
+	for _, f := range supportedFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeEntries encodes the values below base in the given content format.
+// Plain text and octet streams can only hold a single resource value.
+func encodeEntries(base Path, entries []entry, format coapmsg.MediaType) ([]byte, error) {
+	switch format {
+	case coapmsg.AppLwM2MTLV:
+		tlvs, err := entriesToTLV(base, entries)
+		if err != nil {
+			return nil, err
+		}
+		return MarshalTLV(tlvs), nil
+	case coapmsg.AppSenMLJSON, coapmsg.AppSenMLCBOR:
+		records := make([]Record, len(entries))
+		for i, e := range entries {
+			records[i] = Record{Name: e.Path.String(), Value: e.Value}
+		}
+		if format == coapmsg.AppSenMLCBOR {
+			return MarshalSenMLCBOR(records)
+		}
+		return MarshalSenMLJSON(records)
+	case coapmsg.TextPlain, coapmsg.AppOctets:
+		if len(entries) != 1 || len(entries[0].Path) != len(base) {
+			return nil, ErrUnsupportedFormat
+		}
+		if format == coapmsg.AppOctets {
+			b, ok := entries[0].Value.([]byte)
+			if !ok {
+				return nil, ErrUnsupportedFormat
+			}
+			return b, nil
+		}
+		return []byte(formatText(entries[0].Value)), nil
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// formatText returns the plain text representation of a resource value
+func formatText(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case bool:
+		if x {
+			return "1"
+		}
+		return "0"
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case time.Time:
+		return strconv.FormatInt(x.Unix(), 10)
+	case ObjectLink:
+		return x.String()
+	case []byte:
+		return string(x)
+	}
+	if i, ok := asInt64(v); ok {
+		return strconv.FormatInt(i, 10)
+	}
+	return fmt.Sprint(v)
+}
+
+// parseText parses the plain text representation of a resource value
+func parseText(t ValueType, s string) (interface{}, error) {
+	switch t {
+	case String:
+		return s, nil
+	case Integer, Time:
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidValue, s)
+		}
+		return convert(t, i)
+	case Float:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidValue, s)
+		}
+		return f, nil
+	case Boolean:
+		switch s {
+		case "0", "false":
+			return false, nil
+		case "1", "true":
+			return true, nil
+		}
+		return nil, fmt.Errorf("%w: %q", ErrInvalidValue, s)
+	case ObjectLinkType:
+		return ParseObjectLink(s)
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// decodeEntries decodes a Write payload sent for base. typeOf returns
+// the type of the resource at a path, values are converted accordingly.
+func decodeEntries(base Path, payload []byte, format coapmsg.MediaType, typeOf func(Path) (ValueType, bool)) ([]entry, error) {
+	lookup := func(p Path) (ValueType, error) {
+		if !p.HasPrefix(base) || len(p) < 3 {
+			return None, fmt.Errorf("%w: %s outside of %s", ErrInvalidPath, p, base)
+		}
+		t, ok := typeOf(p)
+		if !ok {
+			return None, fmt.Errorf("%w: %s", ErrNotFound, p)
+		}
+		return t, nil
+	}
+
+	var entries []entry
+	switch format {
+	case coapmsg.AppLwM2MTLV:
+		tlvs, err := UnmarshalTLV(payload)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := tlvToEntries(base, tlvs)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range raw {
+			t, err := lookup(r.Path)
+			if err != nil {
+				return nil, err
+			}
+			v, err := DecodeTLVValue(t, r.Value)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry{r.Path, v})
+		}
+	case coapmsg.AppSenMLJSON, coapmsg.AppSenMLCBOR:
+		records, err := unmarshalRecords(payload, format)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			p, err := ParsePath(r.Name)
+			if err != nil {
+				return nil, err
+			}
+			t, err := lookup(p)
+			if err != nil {
+				return nil, err
+			}
+			v, err := convert(t, r.Value)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry{p, v})
+		}
+	case coapmsg.TextPlain, coapmsg.AppOctets:
+		t, err := lookup(base)
+		if err != nil {
+			return nil, err
+		}
+		var v interface{}
+		if format == coapmsg.AppOctets {
+			v, err = convert(t, append([]byte{}, payload...))
+		} else {
+			v, err = parseText(t, string(payload))
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry{base, v})
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	return entries, nil
+}
+
+func unmarshalRecords(payload []byte, format coapmsg.MediaType) ([]Record, error) {
+	if format == coapmsg.AppSenMLCBOR {
+		return UnmarshalSenMLCBOR(payload)
+	}
+	return UnmarshalSenMLJSON(payload)
+}
+
+// DecodeRecords decodes the payload of a Read or Observe response for
+// the given path into records.
+//
+// OMA TLV does not contain type information, values of TLV payloads are
+// returned as []byte and can be decoded with DecodeTLVValue.
+func DecodeRecords(path Path, payload []byte, format coapmsg.MediaType) ([]Record, error) {
+	switch format {
+	case coapmsg.AppSenMLJSON, coapmsg.AppSenMLCBOR:
+		return unmarshalRecords(payload, format)
+	case coapmsg.AppLwM2MTLV:
+		tlvs, err := UnmarshalTLV(payload)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := tlvToEntries(path, tlvs)
+		if err != nil {
+			return nil, err
+		}
+		records := make([]Record, len(raw))
+		for i, r := range raw {
+			records[i] = Record{Name: r.Path.String(), Value: r.Value}
+		}
+		return records, nil
+	case coapmsg.TextPlain:
+		return []Record{{Name: path.String(), Value: string(payload)}}, nil
+	case coapmsg.AppOctets:
+		return []Record{{Name: path.String(), Value: payload}}, nil
+	}
+	return nil, ErrUnsupportedFormat
+}
@@ -0,0 +1,241 @@
+// Package lwm2m implements the OMA Lightweight M2M protocol on top of
+// the coap package.
+//
+// A Client is the LwM2M client (the device). It holds the
+// object/instance/resource model, registers at a LwM2M server via the
+// Register/Update/De-register interface and serves the Device
+// Management and Information Reporting interfaces:
+//
+//	c := lwm2m.NewClient("node1", "coap+uart://any/rd")
+//	c.AddObject(device)
+//	mux := coap.NewServeMux()
+//	c.Handle(mux)
+//	connector := coap.NewUartConnecter()
+//	connector.Handler = mux
+//	transport := coap.NewTransportUart()
+//	transport.Connecter = connector
+//	c.Client = &coap.Client{Transport: transport}
+//	c.Register()
+//
+// The connector passes the requests of the server to the mux. Notifications
+// of observations are sent with the Send function of the client.
+//
+// A Server is the LwM2M server. It accepts registrations and manages
+// registered clients with Read, Write, Execute, Discover,
+// Write-Attributes and Observe operations.
+//
+// Payloads are encoded as OMA TLV, SenML JSON or SenML CBOR.
+package lwm2m
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+var log logrus.FieldLogger = logrus.StandardLogger()
+
+func SetLogger(logger logrus.FieldLogger) {
+	log = logger
+}
+
+// Version of the LwM2M enabler sent on registration
+const Version = "1.1"
+
+// Errors of the object model and the codecs
+var (
+	ErrNotFound        = errors.New("lwm2m: not found")
+	ErrNotAllowed      = errors.New("lwm2m: operation not allowed")
+	ErrInvalidPath     = errors.New("lwm2m: invalid path")
+	ErrInvalidValue    = errors.New("lwm2m: invalid value")
+	ErrInvalidTLV      = errors.New("lwm2m: invalid TLV")
+	ErrUnknownEndpoint = errors.New("lwm2m: unknown endpoint")
+
+	ErrUnsupportedFormat = errors.New("lwm2m: unsupported content format")
+)
+
+// Path addresses an object, object instance, resource or resource
+// instance, e.g. /3/0/1 is Path{3, 0, 1}. The empty path is the root.
+type Path []uint16
+
+// ParsePath parses a path like "/3/0/1"
+func ParsePath(s string) (Path, error) {
+	s = strings.Trim(s, "/")
+	if s == "" {
+		return Path{}, nil
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) > 4 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidPath, s)
+	}
+	p := make(Path, len(parts))
+	for i, part := range parts {
+		id, err := strconv.ParseUint(part, 10, 16)
+		if err != nil || id == math.MaxUint16 {
+			// 65535 is reserved (LwM2M, Section 7.1)
+			return nil, fmt.Errorf("%w: %q", ErrInvalidPath, s)
+		}
+		p[i] = uint16(id)
+	}
+	return p, nil
+}
+
+func (p Path) String() string {
+	s := ""
+	for _, id := range p {
+		s += "/" + strconv.Itoa(int(id))
+	}
+	if s == "" {
+		return "/"
+	}
+	return s
+}
+
+// Append returns a new path with the id appended
+func (p Path) Append(id uint16) Path {
+	return append(append(Path{}, p...), id)
+}
+
+// HasPrefix reports whether the path is equal to or below prefix
+func (p Path) HasPrefix(prefix Path) bool {
+	if len(prefix) > len(p) {
+		return false
+	}
+	for i := range prefix {
+		if p[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ValueType is the data type of a resource (LwM2M, Appendix C)
+type ValueType uint8
+
+const (
+	None ValueType = iota // executable resources
+	String
+	Integer
+	Float
+	Boolean
+	Opaque
+	Time
+	ObjectLinkType
+)
+
+// ObjectLink references an object instance, e.g. "3:0"
+type ObjectLink struct {
+	Object   uint16
+	Instance uint16
+}
+
+func (l ObjectLink) String() string {
+	return strconv.Itoa(int(l.Object)) + ":" + strconv.Itoa(int(l.Instance))
+}
+
+// ParseObjectLink parses an object link like "3:0"
+func ParseObjectLink(s string) (ObjectLink, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return ObjectLink{}, fmt.Errorf("%w: object link %q", ErrInvalidValue, s)
+	}
+	obj, err1 := strconv.ParseUint(s[:i], 10, 16)
+	inst, err2 := strconv.ParseUint(s[i+1:], 10, 16)
+	if err1 != nil || err2 != nil {
+		return ObjectLink{}, fmt.Errorf("%w: object link %q", ErrInvalidValue, s)
+	}
+	return ObjectLink{Object: uint16(obj), Instance: uint16(inst)}, nil
+}
+
+// convert converts a value into the Go type used for resources of type t:
+// int64, float64, bool, string, []byte, time.Time or ObjectLink.
+// Decoded numbers of any integer or float type are accepted.
+func convert(t ValueType, v interface{}) (interface{}, error) {
+	switch t {
+	case String:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	case Integer:
+		if i, ok := asInt64(v); ok {
+			return i, nil
+		}
+	case Float:
+		if f, ok := asFloat64(v); ok {
+			return f, nil
+		}
+	case Boolean:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case Opaque:
+		if b, ok := v.([]byte); ok {
+			return b, nil
+		}
+	case Time:
+		if tm, ok := v.(time.Time); ok {
+			return tm, nil
+		}
+		if i, ok := asInt64(v); ok {
+			return time.Unix(i, 0), nil
+		}
+	case ObjectLinkType:
+		switch l := v.(type) {
+		case ObjectLink:
+			return l, nil
+		case string:
+			return ParseObjectLink(l)
+		}
+	}
+	return nil, fmt.Errorf("%w: %T for resource type %d", ErrInvalidValue, v, t)
+}
+
+func asInt64(v interface{}) (int64, bool) {
+	switch x := v.(type) {
+	case int:
+		return int64(x), true
+	case int8:
+		return int64(x), true
+	case int16:
+		return int64(x), true
+	case int32:
+		return int64(x), true
+	case int64:
+		return x, true
+	case uint8:
+		return int64(x), true
+	case uint16:
+		return int64(x), true
+	case uint32:
+		return int64(x), true
+	case uint64:
+		if x > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(x), true
+	case float64:
+		if x != math.Trunc(x) || x > math.MaxInt64 || x < math.MinInt64 {
+			return 0, false
+		}
+		return int64(x), true
+	}
+	return 0, false
+}
+
+func asFloat64(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float32:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	if i, ok := asInt64(v); ok {
+		return float64(i), true
+	}
+	return 0, false
+}
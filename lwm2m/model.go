@@ -0,0 +1,143 @@
+package lwm2m
+
+import (
+	"sort"
+)
+
+// Operations a resource supports
+type Operations uint8
+
+const (
+	OpRead Operations = 1 << iota
+	OpWrite
+	OpExecute
+
+	OpReadWrite = OpRead | OpWrite
+)
+
+// A Resource of an object instance.
+//
+// Single-instance resources hold their value in Value, multiple-instance
+// resources in Instances. Values have the Go type of the resource type:
+// int64, float64, bool, string, []byte, time.Time or ObjectLink.
+type Resource struct {
+	ID         uint16
+	Type       ValueType
+	Operations Operations
+
+	Value     interface{}
+	Instances map[uint16]interface{} // nil for single-instance resources
+
+	// Execute is called for the Execute operation with the
+	// arguments sent by the server
+	Execute func(args string) error
+}
+
+// Multiple reports whether the resource has multiple instances
+func (r *Resource) Multiple() bool {
+	return r.Instances != nil
+}
+
+// An Instance of an object
+type Instance struct {
+	ID        uint16
+	Resources map[uint16]*Resource
+}
+
+// AddResource adds or replaces a resource of the instance
+func (i *Instance) AddResource(r *Resource) *Instance {
+	if i.Resources == nil {
+		i.Resources = map[uint16]*Resource{}
+	}
+	i.Resources[r.ID] = r
+	return i
+}
+
+// An Object with its instances, e.g. the Device object (3)
+type Object struct {
+	ID        uint16
+	Instances map[uint16]*Instance
+}
+
+func NewObject(id uint16) *Object {
+	return &Object{ID: id, Instances: map[uint16]*Instance{}}
+}
+
+// AddInstance creates a new instance of the object
+func (o *Object) AddInstance(id uint16) *Instance {
+	inst := &Instance{ID: id, Resources: map[uint16]*Resource{}}
+	if o.Instances == nil {
+		o.Instances = map[uint16]*Instance{}
+	}
+	o.Instances[id] = inst
+	return inst
+}
+
+// entry is a single value of a resource or resource instance
+type entry struct {
+	Path  Path
+	Value interface{}
+}
+
+func sortIDs(ids []uint16) []uint16 {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (o *Object) instanceIDs() []uint16 {
+	ids := make([]uint16, 0, len(o.Instances))
+	for id := range o.Instances {
+		ids = append(ids, id)
+	}
+	return sortIDs(ids)
+}
+
+func (i *Instance) resourceIDs() []uint16 {
+	ids := make([]uint16, 0, len(i.Resources))
+	for id := range i.Resources {
+		ids = append(ids, id)
+	}
+	return sortIDs(ids)
+}
+
+func (r *Resource) instanceIDs() []uint16 {
+	ids := make([]uint16, 0, len(r.Instances))
+	for id := range r.Instances {
+		ids = append(ids, id)
+	}
+	return sortIDs(ids)
+}
+
+// entries returns the readable values of the resource
+func (r *Resource) entries(p Path) []entry {
+	if r.Operations&OpRead == 0 {
+		return nil
+	}
+	if !r.Multiple() {
+		if r.Value == nil {
+			return nil
+		}
+		return []entry{{p, r.Value}}
+	}
+	var entries []entry
+	for _, id := range r.instanceIDs() {
+		entries = append(entries, entry{p.Append(id), r.Instances[id]})
+	}
+	return entries
+}
+
+func (i *Instance) entries(p Path) []entry {
+	var entries []entry
+	for _, id := range i.resourceIDs() {
+		entries = append(entries, i.Resources[id].entries(p.Append(id))...)
+	}
+	return entries
+}
+
+func (o *Object) entries(p Path) []entry {
+	var entries []entry
+	for _, id := range o.instanceIDs() {
+		entries = append(entries, o.Instances[id].entries(p.Append(id))...)
+	}
+	return entries
+}
@@ -0,0 +1,262 @@
+package lwm2m
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// Attributes of the Information Reporting interface
+// (LwM2M, Section 5.1.2). A zero value means the attribute is not set.
+type Attributes struct {
+	Pmin time.Duration // minimum period between two notifications
+	Pmax time.Duration // maximum period between two notifications
+}
+
+// query returns the attributes as Uri-Query values, e.g. pmin=10
+func (a Attributes) query() []string {
+	var q []string
+	if a.Pmin > 0 {
+		q = append(q, "pmin="+strconv.Itoa(int(a.Pmin/time.Second)))
+	}
+	if a.Pmax > 0 {
+		q = append(q, "pmax="+strconv.Itoa(int(a.Pmax/time.Second)))
+	}
+	return q
+}
+
+// params returns the attributes as link parameters for Discover
+func (a Attributes) params() map[string]string {
+	params := map[string]string{}
+	if a.Pmin > 0 {
+		params["pmin"] = strconv.Itoa(int(a.Pmin / time.Second))
+	}
+	if a.Pmax > 0 {
+		params["pmax"] = strconv.Itoa(int(a.Pmax / time.Second))
+	}
+	return params
+}
+
+// merge returns a with all unset attributes taken from parent
+func (a Attributes) merge(parent Attributes) Attributes {
+	if a.Pmin == 0 {
+		a.Pmin = parent.Pmin
+	}
+	if a.Pmax == 0 {
+		a.Pmax = parent.Pmax
+	}
+	return a
+}
+
+// attributes returns the attributes of p, inherited from the object
+// and instance level (LwM2M, Section 5.1.2)
+func (c *Client) attributes(p Path) Attributes {
+	var a Attributes
+	for i := len(p); i > 0; i-- {
+		a = a.merge(c.attrs[p[:i].String()])
+	}
+	return a
+}
+
+// writeAttributes handles the Write-Attributes operation. Attributes
+// without value are removed, e.g. "pmin".
+func (c *Client) writeAttributes(w coap.ResponseWriter, r *coap.Request, p Path) {
+	c.mu.Lock()
+	defer c.unlockAndNotify()
+
+	if _, err := c.read(p[:1]); err != nil {
+		writeError(w, err)
+		return
+	}
+	attrs := c.attrs[p.String()]
+	for _, q := range r.Options[coapmsg.URIQuery] {
+		name, value := q.AsString(), ""
+		for i := 0; i < len(name); i++ {
+			if name[i] == '=' {
+				name, value = name[:i], name[i+1:]
+				break
+			}
+		}
+		var d time.Duration
+		if value != "" {
+			sec, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				w.WriteCode(coapmsg.BadRequest)
+				return
+			}
+			d = time.Duration(sec) * time.Second
+		}
+		switch name {
+		case "pmin":
+			attrs.Pmin = d
+		case "pmax":
+			attrs.Pmax = d
+		default:
+			// Other attributes (gt, lt, st, epmin, epmax) are not supported
+			w.WriteCode(coapmsg.BadRequest)
+			return
+		}
+	}
+	c.attrs[p.String()] = attrs
+
+	// Running observations use the new periods from now on
+	for _, obs := range c.observations {
+		if obs.path.HasPrefix(p) {
+			c.schedulePmax(obs)
+		}
+	}
+	w.WriteCode(coapmsg.Changed)
+}
+
+// observation of a path by the LwM2M server
+type observation struct {
+	token  coap.Token
+	path   Path
+	format coapmsg.MediaType
+	seq    uint32
+	last   time.Time // time of the last notification
+
+	pmin *time.Timer // pending notification delayed by pmin
+	pmax *time.Timer
+}
+
+// notification is a message for Send
+type notification struct {
+	obs *observation
+	msg *coapmsg.Message
+}
+
+// observe starts an observation (LwM2M, Section 5.5.1) and returns
+// the sequence number of the initial response
+func (c *Client) observe(token coap.Token, p Path, format coapmsg.MediaType) uint32 {
+	c.cancelObservation(string(token))
+	obs := &observation{
+		token:  token,
+		path:   p,
+		format: format,
+		last:   time.Now(),
+	}
+	c.observations[string(token)] = obs
+	c.schedulePmax(obs)
+	log.WithField("path", p.String()).Debug("Observation started")
+	return obs.seq
+}
+
+// cancelObservation removes the observation with the given token
+func (c *Client) cancelObservation(token string) {
+	obs := c.observations[token]
+	if obs == nil {
+		return
+	}
+	obs.stop()
+	delete(c.observations, token)
+	log.WithField("path", obs.path.String()).Debug("Observation canceled")
+}
+
+func (obs *observation) stop() {
+	if obs.pmin != nil {
+		obs.pmin.Stop()
+		obs.pmin = nil
+	}
+	if obs.pmax != nil {
+		obs.pmax.Stop()
+		obs.pmax = nil
+	}
+}
+
+// schedulePmax restarts the pmax timer of the observation
+func (c *Client) schedulePmax(obs *observation) {
+	if obs.pmax != nil {
+		obs.pmax.Stop()
+		obs.pmax = nil
+	}
+	pmax := c.attributes(obs.path).Pmax
+	if pmax <= 0 {
+		return
+	}
+	obs.pmax = time.AfterFunc(pmax-time.Since(obs.last), func() {
+		c.mu.Lock()
+		defer c.unlockAndNotify()
+		c.notify(obs)
+	})
+}
+
+// changed triggers notifications for all observations of p. Changes
+// within pmin after the last notification are notified when pmin elapsed.
+func (c *Client) changed(p Path) {
+	for _, obs := range c.observations {
+		if !p.HasPrefix(obs.path) && !obs.path.HasPrefix(p) {
+			continue
+		}
+		wait := c.attributes(obs.path).Pmin - time.Since(obs.last)
+		if wait <= 0 {
+			c.notify(obs)
+			continue
+		}
+		if obs.pmin == nil {
+			obs.pmin = time.AfterFunc(wait, func() {
+				c.mu.Lock()
+				defer c.unlockAndNotify()
+				c.notify(obs)
+			})
+		}
+	}
+}
+
+// notify queues a notification with the current value of the observed
+// path. Must be called with c.mu held.
+func (c *Client) notify(obs *observation) {
+	if c.observations[string(obs.token)] != obs {
+		return // canceled meanwhile
+	}
+	if obs.pmin != nil {
+		obs.pmin.Stop()
+		obs.pmin = nil
+	}
+	obs.seq = (obs.seq + 1) & 0xffffff
+	obs.last = time.Now()
+	c.schedulePmax(obs)
+
+	msg := coapmsg.NewMessage()
+	msg.Type = coapmsg.NonConfirmable
+	msg.Code = coapmsg.Content
+	msg.Token = obs.token
+	entries, err := c.read(obs.path)
+	if err == nil {
+		msg.Payload, err = encodeEntries(obs.path, entries, obs.format)
+	}
+	if err != nil {
+		// The observed path is gone, end the observation
+		msg.Code = coapmsg.NotFound
+		c.cancelObservation(string(obs.token))
+	} else {
+		msg.Options().Set(coapmsg.Observe, obs.seq)
+		msg.Options().Set(coapmsg.ContentFormat, obs.format)
+	}
+	c.outbox = append(c.outbox, &notification{obs, &msg})
+}
+
+// unlockAndNotify releases c.mu and sends the queued notifications
+func (c *Client) unlockAndNotify() {
+	outbox := c.outbox
+	c.outbox = nil
+	send := c.Send
+	c.mu.Unlock()
+
+	for _, n := range outbox {
+		if send == nil {
+			log.WithField("path", n.obs.path.String()).Debug("Dropped notification, client has no Send function")
+			continue
+		}
+		if err := send(n.msg); err != nil {
+			log.WithError(err).WithField("path", n.obs.path.String()).Warn("Failed to send notification")
+			c.mu.Lock()
+			if c.observations[string(n.obs.token)] == n.obs {
+				c.cancelObservation(string(n.obs.token))
+			}
+			c.mu.Unlock()
+		}
+	}
+}
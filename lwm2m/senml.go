@@ -0,0 +1,244 @@
+package lwm2m
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/trusch/coap-go/internal/cbor"
+)
+
+// Record is a resolved SenML record (RFC 8428) as used by LwM2M, e.g.
+// Record{Name: "/3/0/0", Value: "Lobaro"}.
+//
+// Value is one of float64, int64 (numeric values), string, bool,
+// []byte or ObjectLink.
+type Record struct {
+	Name  string
+	Value interface{}
+}
+
+// SenML labels in CBOR representation (RFC 8428, Section 6)
+const (
+	senmlBaseName    = -2
+	senmlName        = 0
+	senmlValue       = 2
+	senmlStringValue = 3
+	senmlBoolValue   = 4
+	senmlDataValue   = 8
+	senmlObjectLink  = "vlo" // LwM2M extension, always a text label
+)
+
+// baseName returns the common base name of the records. A single
+// record is sent with its full name as base name.
+func baseName(records []Record) string {
+	if len(records) == 0 {
+		return ""
+	}
+	if len(records) == 1 {
+		return records[0].Name
+	}
+	prefix := records[0].Name
+	for _, r := range records[1:] {
+		for !strings.HasPrefix(r.Name, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix[:strings.LastIndex(prefix, "/")+1]
+}
+
+type senmlJSON struct {
+	BaseName    string       `json:"bn,omitempty"`
+	Name        string       `json:"n,omitempty"`
+	Value       *json.Number `json:"v,omitempty"`
+	StringValue *string      `json:"vs,omitempty"`
+	BoolValue   *bool        `json:"vb,omitempty"`
+	DataValue   *string      `json:"vd,omitempty"`
+	ObjectLink  *string      `json:"vlo,omitempty"`
+}
+
+// MarshalSenMLJSON encodes the records as SenML JSON pack
+func MarshalSenMLJSON(records []Record) ([]byte, error) {
+	bn := baseName(records)
+	pack := make([]senmlJSON, len(records))
+	for i, r := range records {
+		rec := &pack[i]
+		if i == 0 {
+			rec.BaseName = bn
+		}
+		rec.Name = strings.TrimPrefix(r.Name, bn)
+
+		switch v := r.Value.(type) {
+		case string:
+			rec.StringValue = &v
+		case bool:
+			rec.BoolValue = &v
+		case []byte:
+			s := base64.RawURLEncoding.EncodeToString(v)
+			rec.DataValue = &s
+		case ObjectLink:
+			s := v.String()
+			rec.ObjectLink = &s
+		default:
+			n, err := senmlNumber(v)
+			if err != nil {
+				return nil, err
+			}
+			num := json.Number(n)
+			rec.Value = &num
+		}
+	}
+	return json.Marshal(pack)
+}
+
+func senmlNumber(v interface{}) (string, error) {
+	switch x := v.(type) {
+	case time.Time:
+		return strconv.FormatInt(x.Unix(), 10), nil
+	case float32, float64:
+		f, _ := asFloat64(x)
+		return strconv.FormatFloat(f, 'g', -1, 64), nil
+	}
+	if i, ok := asInt64(v); ok {
+		return strconv.FormatInt(i, 10), nil
+	}
+	return "", fmt.Errorf("%w: unsupported type %T", ErrInvalidValue, v)
+}
+
+// UnmarshalSenMLJSON decodes a SenML JSON pack into resolved records
+func UnmarshalSenMLJSON(data []byte) ([]Record, error) {
+	var pack []senmlJSON
+	if err := json.Unmarshal(data, &pack); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidValue, err)
+	}
+	records := make([]Record, len(pack))
+	bn := ""
+	for i, rec := range pack {
+		if rec.BaseName != "" {
+			bn = rec.BaseName
+		}
+		records[i].Name = bn + rec.Name
+
+		switch {
+		case rec.Value != nil:
+			if n, err := rec.Value.Int64(); err == nil {
+				records[i].Value = n
+			} else if f, err := rec.Value.Float64(); err == nil {
+				records[i].Value = f
+			} else {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidValue, err)
+			}
+		case rec.StringValue != nil:
+			records[i].Value = *rec.StringValue
+		case rec.BoolValue != nil:
+			records[i].Value = *rec.BoolValue
+		case rec.DataValue != nil:
+			b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(*rec.DataValue, "="))
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidValue, err)
+			}
+			records[i].Value = b
+		case rec.ObjectLink != nil:
+			l, err := ParseObjectLink(*rec.ObjectLink)
+			if err != nil {
+				return nil, err
+			}
+			records[i].Value = l
+		}
+	}
+	return records, nil
+}
+
+// MarshalSenMLCBOR encodes the records as SenML CBOR pack
+func MarshalSenMLCBOR(records []Record) ([]byte, error) {
+	bn := baseName(records)
+	pack := make([]interface{}, len(records))
+	for i, r := range records {
+		rec := map[interface{}]interface{}{}
+		if i == 0 && bn != "" {
+			rec[senmlBaseName] = bn
+		}
+		if n := strings.TrimPrefix(r.Name, bn); n != "" {
+			rec[senmlName] = n
+		}
+
+		switch v := r.Value.(type) {
+		case string:
+			rec[senmlStringValue] = v
+		case bool:
+			rec[senmlBoolValue] = v
+		case []byte:
+			rec[senmlDataValue] = v
+		case ObjectLink:
+			rec[senmlObjectLink] = v.String()
+		case time.Time:
+			rec[senmlValue] = v.Unix()
+		case float32, float64:
+			rec[senmlValue], _ = asFloat64(v)
+		default:
+			i, ok := asInt64(v)
+			if !ok {
+				return nil, fmt.Errorf("%w: unsupported type %T", ErrInvalidValue, v)
+			}
+			rec[senmlValue] = i
+		}
+		pack[i] = rec
+	}
+	return cbor.Marshal(pack)
+}
+
+// UnmarshalSenMLCBOR decodes a SenML CBOR pack into resolved records
+func UnmarshalSenMLCBOR(data []byte) ([]Record, error) {
+	v, err := cbor.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidValue, err)
+	}
+	pack, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: SenML pack is not an array", ErrInvalidValue)
+	}
+
+	records := make([]Record, len(pack))
+	bn := ""
+	for i, item := range pack {
+		rec, ok := item.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: SenML record is not a map", ErrInvalidValue)
+		}
+		var name string
+		for key, value := range rec {
+			if key == senmlObjectLink {
+				s, _ := value.(string)
+				l, err := ParseObjectLink(s)
+				if err != nil {
+					return nil, err
+				}
+				records[i].Value = l
+				continue
+			}
+			label, ok := cbor.Int(key)
+			if !ok {
+				continue
+			}
+			switch label {
+			case senmlBaseName:
+				bn, _ = value.(string)
+			case senmlName:
+				name, _ = value.(string)
+			case senmlValue:
+				if n, ok := cbor.Int(value); ok {
+					records[i].Value = n
+				} else {
+					records[i].Value = value
+				}
+			case senmlStringValue, senmlBoolValue, senmlDataValue:
+				records[i].Value = value
+			}
+		}
+		records[i].Name = bn + name
+	}
+	return records, nil
+}
@@ -0,0 +1,72 @@
+package lwm2m
+
+import (
+	"reflect"
+	"testing"
+)
+
+var testRecords = []Record{
+	{Name: "/3/0/0", Value: "Lobaro"},
+	{Name: "/3/0/9", Value: int64(87)},
+	{Name: "/3/0/13", Value: 21.5},
+	{Name: "/3/0/16", Value: true},
+	{Name: "/3/0/17", Value: []byte{0xde, 0xad}},
+	{Name: "/3/0/18", Value: ObjectLink{Object: 1, Instance: 0}},
+}
+
+func TestSenMLJSON(t *testing.T) {
+	data, err := MarshalSenMLJSON(testRecords[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[{"bn":"/3/0/","n":"0","vs":"Lobaro"},{"n":"9","v":87}]`
+	if string(data) != expected {
+		t.Errorf("Expected %s but got %s", expected, data)
+	}
+
+	data, err = MarshalSenMLJSON(testRecords)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := UnmarshalSenMLJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(records, testRecords) {
+		t.Errorf("Expected %v but got %v", testRecords, records)
+	}
+}
+
+func TestSenMLJSONSingleRecord(t *testing.T) {
+	data, err := MarshalSenMLJSON(testRecords[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[{"bn":"/3/0/0","vs":"Lobaro"}]`
+	if string(data) != expected {
+		t.Errorf("Expected %s but got %s", expected, data)
+	}
+}
+
+func TestSenMLCBOR(t *testing.T) {
+	data, err := MarshalSenMLCBOR(testRecords)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := UnmarshalSenMLCBOR(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(records, testRecords) {
+		t.Errorf("Expected %v but got %v", testRecords, records)
+	}
+}
+
+func TestUnmarshalSenMLInvalid(t *testing.T) {
+	if _, err := UnmarshalSenMLJSON([]byte(`{"n":"x"}`)); err == nil {
+		t.Error("Expected error for SenML JSON object")
+	}
+	if _, err := UnmarshalSenMLCBOR([]byte{0xa0}); err == nil {
+		t.Error("Expected error for SenML CBOR map")
+	}
+}
@@ -0,0 +1,167 @@
+package lwm2m
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coapmsg"
+	"github.com/trusch/coap-go/rd"
+)
+
+// Server is a LwM2M server (LwM2M, Section 6).
+//
+// The embedded rd.Server serves the Client Registration interface and
+// must be registered at the "/rd" path. The Device Management and
+// Information Reporting operations address registered clients by their
+// endpoint name and send requests to the base URI of the registration.
+type Server struct {
+	*rd.Server
+
+	// Client sends requests to LwM2M clients. If nil, DefaultClient is used.
+	Client *coap.Client
+}
+
+func NewServer() *Server {
+	return &Server{Server: rd.NewServer()}
+}
+
+func (s *Server) client() *coap.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	return coap.DefaultClient
+}
+
+// url returns the URL of path at the registered client ep
+func (s *Server) url(ep string, path string) (string, error) {
+	p, err := ParsePath(path)
+	if err != nil {
+		return "", err
+	}
+	for _, reg := range s.Registrations() {
+		if reg.Endpoint == ep {
+			return strings.TrimSuffix(reg.Base, "/") + p.String(), nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownEndpoint, ep)
+}
+
+// do sends a request to the client ep and returns the response if its
+// code equals expected
+func (s *Server) do(ep, method, path string, body []byte, prepare func(req *coap.Request) error, expected coapmsg.COAPCode) (*coap.Response, error) {
+	url, err := s.url(ep, path)
+	if err != nil {
+		return nil, err
+	}
+	req, err := coap.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if prepare != nil {
+		if err := prepare(req); err != nil {
+			return nil, err
+		}
+	}
+	res, err := s.client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("lwm2m: %s %s failed: %w", method, path, err)
+	}
+	if res.Code != expected {
+		if err := res.Err(); err != nil {
+			return nil, fmt.Errorf("lwm2m: %w", err)
+		}
+		return nil, fmt.Errorf("lwm2m: %s %s: unexpected response %s", method, path, res.Status)
+	}
+	return res, nil
+}
+
+// Read reads the values below path from the client ep in the given
+// content format (LwM2M, Section 5.4.1)
+func (s *Server) Read(ep, path string, format coapmsg.MediaType) ([]Record, error) {
+	res, err := s.do(ep, "GET", path, nil, func(req *coap.Request) error {
+		return req.Options.Set(coapmsg.Accept, format)
+	}, coapmsg.Content)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	p, _ := ParsePath(path)
+	return DecodeRecords(p, payload, res.Options.Get(coapmsg.ContentFormat).AsMediaType())
+}
+
+// Write writes the records to the client ep as SenML JSON
+// (LwM2M, Section 5.4.3). Multiple-instance resources at path are
+// replaced.
+func (s *Server) Write(ep, path string, records []Record) error {
+	payload, err := MarshalSenMLJSON(records)
+	if err != nil {
+		return err
+	}
+	_, err = s.do(ep, "PUT", path, payload, func(req *coap.Request) error {
+		return req.Options.Set(coapmsg.ContentFormat, coapmsg.AppSenMLJSON)
+	}, coapmsg.Changed)
+	return err
+}
+
+// Execute executes the resource at path of the client ep with the
+// given arguments, e.g. "0='data'" (LwM2M, Section 5.4.5)
+func (s *Server) Execute(ep, path, args string) error {
+	var body []byte
+	var prepare func(req *coap.Request) error
+	if args != "" {
+		body = []byte(args)
+		prepare = func(req *coap.Request) error {
+			return req.Options.Set(coapmsg.ContentFormat, coapmsg.TextPlain)
+		}
+	}
+	_, err := s.do(ep, "POST", path, body, prepare, coapmsg.Changed)
+	return err
+}
+
+// Discover returns the links and attributes below path of the client
+// ep (LwM2M, Section 5.4.2)
+func (s *Server) Discover(ep, path string) ([]rd.Link, error) {
+	res, err := s.do(ep, "GET", path, nil, func(req *coap.Request) error {
+		return req.Options.Set(coapmsg.Accept, coapmsg.AppLinkFormat)
+	}, coapmsg.Content)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	return rd.ParseLinks(string(payload))
+}
+
+// WriteAttributes attaches the attributes to path of the client ep
+// (LwM2M, Section 5.4.4). Unset attributes are left unchanged.
+func (s *Server) WriteAttributes(ep, path string, attrs Attributes) error {
+	query := attrs.query()
+	if len(query) == 0 {
+		return nil
+	}
+	_, err := s.do(ep, "PUT", path, nil, func(req *coap.Request) error {
+		req.URL.RawQuery = strings.Join(query, "&")
+		return nil
+	}, coapmsg.Changed)
+	return err
+}
+
+// Observe starts an observation of path at the client ep
+// (LwM2M, Section 5.5.1). Notifications are received via Next of the
+// returned response, use coap.CancelObserve to stop the observation.
+func (s *Server) Observe(ep, path string, format coapmsg.MediaType) (*coap.Response, error) {
+	return s.do(ep, "GET", path, nil, func(req *coap.Request) error {
+		if err := req.Options.Add(coapmsg.Observe, 0); err != nil {
+			return err
+		}
+		return req.Options.Set(coapmsg.Accept, format)
+	}, coapmsg.Content)
+}
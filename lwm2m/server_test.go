@@ -0,0 +1,110 @@
+package lwm2m
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// newTestServer returns a server and a registered device sharing one
+// in-memory network
+func newTestServer(t *testing.T) (*Server, *Client) {
+	s := NewServer()
+	c, mux, _ := newDevice()
+	mux.Handle("/rd/", s)
+
+	client := newTestClient(mux)
+	s.Client = client
+	c.Client = client
+	c.Base = "coap+uart://device"
+	if err := c.Register(); err != nil {
+		t.Fatal(err)
+	}
+	return s, c
+}
+
+func TestServerRegistration(t *testing.T) {
+	s, _ := newTestServer(t)
+	regs := s.Registrations()
+	if len(regs) != 1 || regs[0].Endpoint != "node1" {
+		t.Fatal("Unexpected registrations", regs)
+	}
+	if regs[0].Params["lwm2m"] != Version || regs[0].Params["b"] != "U" {
+		t.Error("Unexpected registration params", regs[0].Params)
+	}
+	if _, err := s.Read("node2", "/3/0/0", coapmsg.TextPlain); !errors.Is(err, ErrUnknownEndpoint) {
+		t.Error("Expected ErrUnknownEndpoint but got", err)
+	}
+}
+
+func TestServerReadWrite(t *testing.T) {
+	s, c := newTestServer(t)
+
+	records, err := s.Read("node1", "/3/0/0", coapmsg.TextPlain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Value != "Lobaro" {
+		t.Errorf("Unexpected records %v", records)
+	}
+
+	if err := s.Write("node1", "/3/0/15", []Record{{Name: "/3/0/15", Value: "CET"}}); err != nil {
+		t.Fatal(err)
+	}
+	records, err = s.Read("node1", "/3/0", coapmsg.AppSenMLCBOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := records[len(records)-1]; last.Name != "/3/0/15" || last.Value != "CET" {
+		t.Errorf("Unexpected records %v", records)
+	}
+
+	if err := s.Write("node1", "/3/0/0", []Record{{Name: "/3/0/0", Value: "ACME"}}); err == nil {
+		t.Error("Expected error writing read-only resource")
+	}
+	if v, _ := c.Get("/3/0/0"); v != "Lobaro" {
+		t.Errorf("Read-only resource changed to %v", v)
+	}
+}
+
+func TestServerExecuteDiscover(t *testing.T) {
+	s, c := newTestServer(t)
+	executed := ""
+	c.objects[3].Instances[0].Resources[4].Execute = func(args string) error {
+		executed = args
+		return nil
+	}
+	if err := s.Execute("node1", "/3/0/4", "0='x'"); err != nil {
+		t.Fatal(err)
+	}
+	if executed != "0='x'" {
+		t.Errorf("Unexpected arguments %q", executed)
+	}
+
+	if err := s.WriteAttributes("node1", "/3/0/13", Attributes{Pmax: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	links, err := s.Discover("node1", "/3/0/13")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 || links[0].Target != "/3/0/13" || links[0].Param("pmax") != "60" {
+		t.Errorf("Unexpected links %v", links)
+	}
+}
+
+func TestServerObserve(t *testing.T) {
+	s, _ := newTestServer(t)
+	res, err := s.Observe("node1", "/3/0/15", coapmsg.TextPlain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Options.Get(coapmsg.Observe).IsNotSet() {
+		t.Error("Expected Observe option in response")
+	}
+	if _, err := s.Observe("node1", "/3/9", coapmsg.TextPlain); err == nil {
+		t.Error("Expected error observing unknown instance")
+	}
+}
@@ -0,0 +1,296 @@
+package lwm2m
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// TLVType identifies the kind of a TLV entry (LwM2M, Section 7.4.3)
+type TLVType uint8
+
+const (
+	TLVObjectInstance   TLVType = 0
+	TLVResourceInstance TLVType = 1
+	TLVMultipleResource TLVType = 2
+	TLVResource         TLVType = 3
+)
+
+// TLV is an entry of the OMA TLV format. Object instances and multiple
+// resources contain Children, all other types a Value.
+type TLV struct {
+	Type     TLVType
+	ID       uint16
+	Value    []byte
+	Children []TLV
+}
+
+// MarshalTLV returns the binary encoding of the TLV entries
+func MarshalTLV(tlvs []TLV) []byte {
+	var buf []byte
+	for _, t := range tlvs {
+		value := t.Value
+		if t.Type == TLVObjectInstance || t.Type == TLVMultipleResource {
+			value = MarshalTLV(t.Children)
+		}
+
+		typ := byte(t.Type) << 6
+		var id []byte
+		if t.ID > math.MaxUint8 {
+			typ |= 1 << 5
+			id = []byte{byte(t.ID >> 8), byte(t.ID)}
+		} else {
+			id = []byte{byte(t.ID)}
+		}
+		var length []byte
+		switch n := len(value); {
+		case n < 8:
+			typ |= byte(n)
+		case n <= math.MaxUint8:
+			typ |= 1 << 3
+			length = []byte{byte(n)}
+		case n <= math.MaxUint16:
+			typ |= 2 << 3
+			length = []byte{byte(n >> 8), byte(n)}
+		default:
+			typ |= 3 << 3
+			length = []byte{byte(n >> 16), byte(n >> 8), byte(n)}
+		}
+
+		buf = append(buf, typ)
+		buf = append(buf, id...)
+		buf = append(buf, length...)
+		buf = append(buf, value...)
+	}
+	return buf
+}
+
+// UnmarshalTLV parses a sequence of TLV entries
+func UnmarshalTLV(data []byte) ([]TLV, error) {
+	var tlvs []TLV
+	for len(data) > 0 {
+		typ := data[0]
+		data = data[1:]
+
+		idLen := 1 + int(typ>>5&1)
+		if len(data) < idLen {
+			return nil, ErrInvalidTLV
+		}
+		var id uint16
+		for _, b := range data[:idLen] {
+			id = id<<8 | uint16(b)
+		}
+		data = data[idLen:]
+
+		n := int(typ & 7)
+		if lenLen := int(typ >> 3 & 3); lenLen > 0 {
+			if len(data) < lenLen {
+				return nil, ErrInvalidTLV
+			}
+			n = 0
+			for _, b := range data[:lenLen] {
+				n = n<<8 | int(b)
+			}
+			data = data[lenLen:]
+		}
+		if len(data) < n {
+			return nil, ErrInvalidTLV
+		}
+
+		t := TLV{Type: TLVType(typ >> 6), ID: id}
+		if t.Type == TLVObjectInstance || t.Type == TLVMultipleResource {
+			children, err := UnmarshalTLV(data[:n])
+			if err != nil {
+				return nil, err
+			}
+			t.Children = children
+		} else {
+			t.Value = append([]byte{}, data[:n]...)
+		}
+		tlvs = append(tlvs, t)
+		data = data[n:]
+	}
+	return tlvs, nil
+}
+
+// EncodeTLVValue encodes a resource value for a TLV entry.
+// Integers use the shortest of 1, 2, 4 or 8 bytes.
+func EncodeTLVValue(v interface{}) ([]byte, error) {
+	switch x := v.(type) {
+	case string:
+		return []byte(x), nil
+	case []byte:
+		return x, nil
+	case bool:
+		if x {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case float32:
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, math.Float32bits(x))
+		return b, nil
+	case float64:
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, math.Float64bits(x))
+		return b, nil
+	case time.Time:
+		return encodeTLVInt(x.Unix()), nil
+	case ObjectLink:
+		return []byte{byte(x.Object >> 8), byte(x.Object), byte(x.Instance >> 8), byte(x.Instance)}, nil
+	}
+	if i, ok := asInt64(v); ok {
+		return encodeTLVInt(i), nil
+	}
+	return nil, fmt.Errorf("%w: unsupported type %T", ErrInvalidValue, v)
+}
+
+func encodeTLVInt(i int64) []byte {
+	switch {
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		return []byte{byte(i)}
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		b := make([]byte, 2)
+		binary.BigEndian.PutUint16(b, uint16(i))
+		return b
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(i))
+		return b
+	}
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(i))
+	return b
+}
+
+// DecodeTLVValue decodes the value of a TLV entry for a resource of type t
+func DecodeTLVValue(t ValueType, b []byte) (interface{}, error) {
+	switch t {
+	case String:
+		return string(b), nil
+	case Opaque:
+		return append([]byte{}, b...), nil
+	case Integer, Time:
+		var i int64
+		switch len(b) {
+		case 1:
+			i = int64(int8(b[0]))
+		case 2:
+			i = int64(int16(binary.BigEndian.Uint16(b)))
+		case 4:
+			i = int64(int32(binary.BigEndian.Uint32(b)))
+		case 8:
+			i = int64(binary.BigEndian.Uint64(b))
+		default:
+			return nil, ErrInvalidTLV
+		}
+		if t == Time {
+			return time.Unix(i, 0), nil
+		}
+		return i, nil
+	case Float:
+		switch len(b) {
+		case 4:
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+		case 8:
+			return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+		}
+	case Boolean:
+		if len(b) == 1 && b[0] <= 1 {
+			return b[0] == 1, nil
+		}
+	case ObjectLinkType:
+		if len(b) == 4 {
+			return ObjectLink{
+				Object:   binary.BigEndian.Uint16(b),
+				Instance: binary.BigEndian.Uint16(b[2:]),
+			}, nil
+		}
+	}
+	return nil, ErrInvalidTLV
+}
+
+// tlvLevel is the path depth of the parent of a TLV entry
+var tlvLevel = map[TLVType]int{
+	TLVObjectInstance:   1,
+	TLVResource:         2,
+	TLVMultipleResource: 2,
+	TLVResourceInstance: 3,
+}
+
+// entriesToTLV encodes the entries below base as TLV. A multiple-instance
+// resource is encoded as multiple resource entry, even if it was
+// requested directly.
+func entriesToTLV(base Path, entries []entry) ([]TLV, error) {
+	depth := len(base)
+	if depth == 3 {
+		depth = 2
+	}
+	return buildTLV(depth, entries)
+}
+
+func buildTLV(depth int, entries []entry) ([]TLV, error) {
+	var tlvs []TLV
+	for len(entries) > 0 {
+		id := entries[0].Path[depth]
+		n := 1
+		for n < len(entries) && entries[n].Path[depth] == id {
+			n++
+		}
+		group := entries[:n]
+		entries = entries[n:]
+
+		var t TLV
+		var err error
+		switch {
+		case depth == 1:
+			t = TLV{Type: TLVObjectInstance, ID: id}
+			t.Children, err = buildTLV(2, group)
+		case depth == 2 && len(group[0].Path) == 3:
+			t = TLV{Type: TLVResource, ID: id}
+			t.Value, err = EncodeTLVValue(group[0].Value)
+		case depth == 2:
+			t = TLV{Type: TLVMultipleResource, ID: id}
+			t.Children, err = buildTLV(3, group)
+		case depth == 3:
+			t = TLV{Type: TLVResourceInstance, ID: id}
+			t.Value, err = EncodeTLVValue(group[0].Value)
+		default:
+			err = ErrInvalidPath
+		}
+		if err != nil {
+			return nil, err
+		}
+		tlvs = append(tlvs, t)
+	}
+	return tlvs, nil
+}
+
+// tlvEntry is a decoded TLV value with its absolute path
+type tlvEntry struct {
+	Path  Path
+	Value []byte
+}
+
+// tlvToEntries resolves the TLV entries of a payload sent for base
+func tlvToEntries(base Path, tlvs []TLV) ([]tlvEntry, error) {
+	var entries []tlvEntry
+	for _, t := range tlvs {
+		level, ok := tlvLevel[t.Type]
+		if !ok || len(base) < level {
+			return nil, ErrInvalidTLV
+		}
+		p := base[:level].Append(t.ID)
+		if t.Type == TLVObjectInstance || t.Type == TLVMultipleResource {
+			children, err := tlvToEntries(p, t.Children)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, children...)
+			continue
+		}
+		entries = append(entries, tlvEntry{p, t.Value})
+	}
+	return entries, nil
+}
@@ -0,0 +1,90 @@
+package lwm2m
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMarshalTLV(t *testing.T) {
+	// Manufacturer and Supported Binding of the Device object
+	// (LwM2M, Section 7.4.3.1)
+	tlvs := []TLV{
+		{Type: TLVResource, ID: 0, Value: []byte("Open Mobile Alliance")},
+		{Type: TLVMultipleResource, ID: 6, Children: []TLV{
+			{Type: TLVResourceInstance, ID: 0, Value: []byte{0x01}},
+			{Type: TLVResourceInstance, ID: 1, Value: []byte{0x05}},
+		}},
+	}
+	expected := append([]byte{0xc8, 0x00, 0x14}, "Open Mobile Alliance"...)
+	expected = append(expected, 0x86, 0x06, 0x41, 0x00, 0x01, 0x41, 0x01, 0x05)
+
+	data := MarshalTLV(tlvs)
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("Expected % x but got % x", expected, data)
+	}
+
+	parsed, err := UnmarshalTLV(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, tlvs) {
+		t.Errorf("Expected %v but got %v", tlvs, parsed)
+	}
+}
+
+func TestMarshalTLVLongIDAndValue(t *testing.T) {
+	tlvs := []TLV{{Type: TLVResource, ID: 300, Value: bytes.Repeat([]byte{0xaa}, 300)}}
+	data := MarshalTLV(tlvs)
+	if !bytes.Equal(data[:5], []byte{0xf0, 0x01, 0x2c, 0x01, 0x2c}) {
+		t.Errorf("Unexpected header % x", data[:5])
+	}
+	parsed, err := UnmarshalTLV(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, tlvs) {
+		t.Errorf("Expected %v but got %v", tlvs, parsed)
+	}
+}
+
+func TestUnmarshalTLVTruncated(t *testing.T) {
+	if _, err := UnmarshalTLV([]byte{0xc8, 0x00, 0x14, 'O'}); err == nil {
+		t.Error("Expected error for truncated TLV")
+	}
+}
+
+func TestTLVValues(t *testing.T) {
+	tests := []struct {
+		typ   ValueType
+		value interface{}
+		data  []byte
+	}{
+		{Integer, int64(1), []byte{0x01}},
+		{Integer, int64(-200), []byte{0xff, 0x38}},
+		{Integer, int64(70000), []byte{0x00, 0x01, 0x11, 0x70}},
+		{Float, 1.5, []byte{0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{Boolean, true, []byte{0x01}},
+		{String, "abc", []byte("abc")},
+		{Opaque, []byte{1, 2}, []byte{1, 2}},
+		{Time, time.Unix(1, 0), []byte{0x01}},
+		{ObjectLinkType, ObjectLink{3, 0}, []byte{0x00, 0x03, 0x00, 0x00}},
+	}
+	for _, test := range tests {
+		data, err := EncodeTLVValue(test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, test.data) {
+			t.Errorf("%v: expected % x but got % x", test.value, test.data, data)
+		}
+		v, err := DecodeTLVValue(test.typ, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(v, test.value) {
+			t.Errorf("Expected %v but got %v", test.value, v)
+		}
+	}
+}
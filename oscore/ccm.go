@@ -0,0 +1,145 @@
+package oscore
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// ccm implements the AES-CCM-16-64-128 AEAD (COSE algorithm 10)
+// as described in RFC 3610 with L = 2 (13 byte nonce) and M = 8 (64 bit tag)
+type ccm struct {
+	block cipher.Block
+}
+
+const (
+	ccmNonceLen = 13
+	ccmTagLen   = 8
+	ccmL        = 15 - ccmNonceLen
+)
+
+var errOpen = errors.New("oscore: message authentication failed")
+
+func newCCM(block cipher.Block) cipher.AEAD {
+	return &ccm{block: block}
+}
+
+func (c *ccm) NonceSize() int {
+	return ccmNonceLen
+}
+
+func (c *ccm) Overhead() int {
+	return ccmTagLen
+}
+
+// mac calculates the CBC-MAC over the formatted nonce, additional data and plaintext
+func (c *ccm) mac(nonce, plaintext, data []byte) []byte {
+	b := make([]byte, 16)
+	mac := make([]byte, 16)
+
+	// B_0
+	flags := byte((ccmTagLen-2)/2<<3 | (ccmL - 1))
+	if len(data) > 0 {
+		flags |= 1 << 6
+	}
+	b[0] = flags
+	copy(b[1:], nonce)
+	binary.BigEndian.PutUint16(b[14:], uint16(len(plaintext)))
+	c.block.Encrypt(mac, b)
+
+	blocks := func(p []byte) {
+		for len(p) > 0 {
+			n := len(p)
+			if n > 16 {
+				n = 16
+			}
+			for i := 0; i < n; i++ {
+				mac[i] ^= p[i]
+			}
+			c.block.Encrypt(mac, mac)
+			p = p[n:]
+		}
+	}
+
+	if len(data) > 0 {
+		var encoded []byte
+		if len(data) < 0xff00 {
+			encoded = make([]byte, 2, 2+len(data))
+			binary.BigEndian.PutUint16(encoded, uint16(len(data)))
+		} else {
+			encoded = make([]byte, 6, 6+len(data))
+			encoded[0], encoded[1] = 0xff, 0xfe
+			binary.BigEndian.PutUint32(encoded[2:], uint32(len(data)))
+		}
+		blocks(append(encoded, data...))
+	}
+	blocks(plaintext)
+
+	return mac[:ccmTagLen]
+}
+
+// ctr applies the CTR mode keystream starting with counter 1 to src,
+// and returns the keystream block for counter 0 that encrypts the tag
+func (c *ccm) ctr(nonce, dst, src []byte) (s0 []byte) {
+	a := make([]byte, 16)
+	a[0] = ccmL - 1
+	copy(a[1:], nonce)
+
+	s0 = make([]byte, 16)
+	c.block.Encrypt(s0, a)
+
+	s := make([]byte, 16)
+	for i, counter := 0, uint16(1); i < len(src); i, counter = i+16, counter+1 {
+		binary.BigEndian.PutUint16(a[14:], counter)
+		c.block.Encrypt(s, a)
+		end := i + 16
+		if end > len(src) {
+			end = len(src)
+		}
+		for j := i; j < end; j++ {
+			dst[j] = src[j] ^ s[j-i]
+		}
+	}
+	return s0
+}
+
+func (c *ccm) Seal(dst, nonce, plaintext, data []byte) []byte {
+	if len(nonce) != ccmNonceLen {
+		panic("oscore: invalid CCM nonce length")
+	}
+	if len(plaintext) > 0xffff {
+		panic("oscore: CCM plaintext too long")
+	}
+
+	tag := c.mac(nonce, plaintext, data)
+
+	ret := make([]byte, len(plaintext)+ccmTagLen)
+	s0 := c.ctr(nonce, ret, plaintext)
+	for i := 0; i < ccmTagLen; i++ {
+		ret[len(plaintext)+i] = tag[i] ^ s0[i]
+	}
+	return append(dst, ret...)
+}
+
+func (c *ccm) Open(dst, nonce, ciphertext, data []byte) ([]byte, error) {
+	if len(nonce) != ccmNonceLen {
+		panic("oscore: invalid CCM nonce length")
+	}
+	if len(ciphertext) < ccmTagLen {
+		return nil, errOpen
+	}
+
+	n := len(ciphertext) - ccmTagLen
+	plaintext := make([]byte, n)
+	s0 := c.ctr(nonce, plaintext, ciphertext[:n])
+
+	tag := make([]byte, ccmTagLen)
+	for i := range tag {
+		tag[i] = ciphertext[n+i] ^ s0[i]
+	}
+	if subtle.ConstantTimeCompare(tag, c.mac(nonce, plaintext, data)) != 1 {
+		return nil, errOpen
+	}
+	return append(dst, plaintext...), nil
+}
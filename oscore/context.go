@@ -0,0 +1,188 @@
+package oscore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"sync"
+
+	"github.com/trusch/coap-go/internal/cbor"
+)
+
+// AES-CCM-16-64-128 is the mandatory to implement algorithm (RFC 8613 section 3.2.1)
+const (
+	algAESCCM16_64_128 = 10
+	keyLen             = 16
+	nonceLen           = ccmNonceLen
+)
+
+// maxSequenceNumber is the largest Partial IV (5 bytes)
+const maxSequenceNumber = 1<<40 - 1
+
+// replayWindowSize as proposed by RFC 8613 section 7.4
+const replayWindowSize = 32
+
+var (
+	ErrSequenceExhausted = errors.New("oscore: sender sequence number exhausted")
+	ErrReplay            = errors.New("oscore: replayed message")
+	ErrInvalidID         = errors.New("oscore: sender or recipient id too long")
+)
+
+// Context is an OSCORE security context (RFC 8613 section 3) shared by
+// a client and a server. The sender of one endpoint is the recipient
+// of the other endpoint.
+//
+// A Context is safe for concurrent use.
+type Context struct {
+	SenderID    []byte
+	RecipientID []byte
+	IDContext   []byte // Optional, nil if not used
+
+	senderKey    []byte
+	recipientKey []byte
+	commonIV     []byte
+
+	senderAEAD    cipher.AEAD
+	recipientAEAD cipher.AEAD
+
+	mu        sync.Mutex
+	senderSeq uint64
+	replay    replayWindow
+}
+
+// NewContext derives the sender and recipient context from the common context parameters.
+// The master salt and ID context are optional and can be nil.
+func NewContext(masterSecret, masterSalt, senderID, recipientID, idContext []byte) (*Context, error) {
+	// The id must fit into the nonce (nonce length - 6)
+	if len(senderID) > nonceLen-6 || len(recipientID) > nonceLen-6 {
+		return nil, ErrInvalidID
+	}
+
+	c := &Context{
+		SenderID:    senderID,
+		RecipientID: recipientID,
+		IDContext:   idContext,
+	}
+
+	var err error
+	if c.senderKey, err = derive(masterSecret, masterSalt, senderID, idContext, "Key", keyLen); err != nil {
+		return nil, err
+	}
+	if c.recipientKey, err = derive(masterSecret, masterSalt, recipientID, idContext, "Key", keyLen); err != nil {
+		return nil, err
+	}
+	if c.commonIV, err = derive(masterSecret, masterSalt, []byte{}, idContext, "IV", nonceLen); err != nil {
+		return nil, err
+	}
+
+	if c.senderAEAD, err = newAEAD(c.senderKey); err != nil {
+		return nil, err
+	}
+	if c.recipientAEAD, err = newAEAD(c.recipientKey); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return newCCM(block), nil
+}
+
+// derive implements the key derivation of RFC 8613 section 3.2.1
+func derive(masterSecret, masterSalt, id, idContext []byte, typ string, length int) ([]byte, error) {
+	var ctx interface{}
+	if idContext != nil {
+		ctx = idContext
+	}
+	info, err := cbor.Marshal([]interface{}{id, ctx, algAESCCM16_64_128, typ, length})
+	if err != nil {
+		return nil, err
+	}
+	return hkdf(masterSecret, masterSalt, info, length), nil
+}
+
+// SenderSequenceNumber returns the next sequence number that will be used
+// as Partial IV. It must be persisted to not reuse nonces after a restart.
+func (c *Context) SenderSequenceNumber() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.senderSeq
+}
+
+// SetSenderSequenceNumber restores a persisted sender sequence number
+func (c *Context) SetSenderSequenceNumber(seq uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.senderSeq = seq
+}
+
+func (c *Context) nextSequenceNumber() (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.senderSeq > maxSequenceNumber {
+		return 0, ErrSequenceExhausted
+	}
+	seq := c.senderSeq
+	c.senderSeq++
+	return seq, nil
+}
+
+// checkReplay verifies and updates the replay window with a received Partial IV
+func (c *Context) checkReplay(seq uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.replay.accept(seq) {
+		return ErrReplay
+	}
+	return nil
+}
+
+// nonce calculates the AEAD nonce (RFC 8613 section 5.2) from the id
+// of the endpoint that generated the Partial IV and the Partial IV itself.
+func (c *Context) nonce(idPIV, piv []byte) []byte {
+	n := make([]byte, nonceLen)
+	n[0] = byte(len(idPIV))
+	copy(n[1+(nonceLen-6)-len(idPIV):], idPIV)
+	copy(n[nonceLen-len(piv):], piv)
+	for i := range n {
+		n[i] ^= c.commonIV[i]
+	}
+	return n
+}
+
+// replayWindow is a sliding window over the received sequence numbers
+type replayWindow struct {
+	initialized bool
+	highest     uint64
+	seen        uint32 // bit i is set if highest - i was received
+}
+
+func (w *replayWindow) accept(seq uint64) bool {
+	if !w.initialized {
+		w.initialized = true
+		w.highest = seq
+		w.seen = 1
+		return true
+	}
+	if seq > w.highest {
+		shift := seq - w.highest
+		if shift >= replayWindowSize {
+			w.seen = 0
+		} else {
+			w.seen <<= shift
+		}
+		w.seen |= 1
+		w.highest = seq
+		return true
+	}
+	diff := w.highest - seq
+	if diff >= replayWindowSize || w.seen&(1<<diff) != 0 {
+		return false
+	}
+	w.seen |= 1 << diff
+	return true
+}
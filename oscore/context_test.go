@@ -0,0 +1,177 @@
+package oscore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+var (
+	masterSecret = "0102030405060708090a0b0c0d0e0f10"
+	masterSalt   = "9e7ca92223786340"
+)
+
+// RFC 8613 Appendix C.1 - C.3
+func TestKeyDerivation(t *testing.T) {
+	vectors := []struct {
+		name         string
+		salt         string
+		senderID     string
+		recipientID  string
+		idContext    []byte
+		senderKey    string
+		recipientKey string
+		commonIV     string
+		senderNonce  string
+	}{
+		{
+			name:         "C.1.1 Client",
+			salt:         masterSalt,
+			senderID:     "",
+			recipientID:  "01",
+			senderKey:    "f0910ed7295e6ad4b54fc793154302ff",
+			recipientKey: "ffb14e093c94c9cac9471648b4f98710",
+			commonIV:     "4622d4dd6d944168eefb54987c",
+			senderNonce:  "4622d4dd6d944168eefb54987c",
+		},
+		{
+			name:         "C.1.2 Server",
+			salt:         masterSalt,
+			senderID:     "01",
+			recipientID:  "",
+			senderKey:    "ffb14e093c94c9cac9471648b4f98710",
+			recipientKey: "f0910ed7295e6ad4b54fc793154302ff",
+			commonIV:     "4622d4dd6d944168eefb54987c",
+			senderNonce:  "4722d4dd6d944169eefb54987c",
+		},
+		{
+			name:         "C.2.1 Client",
+			salt:         "",
+			senderID:     "00",
+			recipientID:  "01",
+			senderKey:    "321b26943253c7ffb6003b0b64d74041",
+			recipientKey: "e57b5635815177cd679ab4bcec9d7dda",
+			commonIV:     "be35ae297d2dace910c52e99f9",
+			senderNonce:  "bf35ae297d2dace910c52e99f9",
+		},
+		{
+			name:         "C.3.1 Client",
+			salt:         masterSalt,
+			senderID:     "",
+			recipientID:  "01",
+			idContext:    []byte{0x37, 0xcb, 0xf3, 0x21, 0x00, 0x17, 0xa2, 0xd3},
+			senderKey:    "af2a1300a5e95788b356336eeecd2b92",
+			recipientKey: "e39a0c7c77b43f03b4b39ab9a268699f",
+			commonIV:     "2ca58fb85ff1b81c0b7181b85e",
+			senderNonce:  "2ca58fb85ff1b81c0b7181b85e",
+		},
+	}
+
+	for _, v := range vectors {
+		c, err := NewContext(unhex(t, masterSecret), unhex(t, v.salt), unhex(t, v.senderID), unhex(t, v.recipientID), v.idContext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(c.senderKey) != v.senderKey {
+			t.Errorf("%s: Expected sender key %s but got %x", v.name, v.senderKey, c.senderKey)
+		}
+		if hex.EncodeToString(c.recipientKey) != v.recipientKey {
+			t.Errorf("%s: Expected recipient key %s but got %x", v.name, v.recipientKey, c.recipientKey)
+		}
+		if hex.EncodeToString(c.commonIV) != v.commonIV {
+			t.Errorf("%s: Expected common IV %s but got %x", v.name, v.commonIV, c.commonIV)
+		}
+		if nonce := c.nonce(c.SenderID, encodePIV(0)); hex.EncodeToString(nonce) != v.senderNonce {
+			t.Errorf("%s: Expected sender nonce %s but got %x", v.name, v.senderNonce, nonce)
+		}
+	}
+}
+
+// RFC 3610 Packet Vector #1
+func TestCCM(t *testing.T) {
+	key := unhex(t, "c0c1c2c3c4c5c6c7c8c9cacbcccdcecf")
+	nonce := unhex(t, "00000003020100a0a1a2a3a4a5")
+	data := unhex(t, "0001020304050607")
+	plaintext := unhex(t, "08090a0b0c0d0e0f101112131415161718191a1b1c1d1e")
+	expected := unhex(t, "588c979a61c663d2f066d0c2c0f989806d5f6b61dac38417e8d12cfdf926e0")
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead := newCCM(block)
+	sealed := aead.Seal(nil, nonce, plaintext, data)
+	if !bytes.Equal(sealed, expected) {
+		t.Errorf("Expected %x but got %x", expected, sealed)
+	}
+
+	opened, err := aead.Open(nil, nonce, sealed, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("Expected %x but got %x", plaintext, opened)
+	}
+
+	sealed[0] ^= 1
+	if _, err := aead.Open(nil, nonce, sealed, data); err != errOpen {
+		t.Error("Expected authentication error but got", err)
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	w := replayWindow{}
+	steps := []struct {
+		seq    uint64
+		accept bool
+	}{
+		{5, true},
+		{5, false},
+		{3, true},
+		{7, true},
+		{3, false},
+		{6, true},
+		{100, true},
+		{68, false}, // Outside of window
+		{69, true},
+		{69, false},
+	}
+	for _, s := range steps {
+		if w.accept(s.seq) != s.accept {
+			t.Errorf("Expected accept(%d) to be %v", s.seq, s.accept)
+		}
+	}
+}
+
+func TestOptionValue(t *testing.T) {
+	values := []optionValue{
+		{},
+		{piv: []byte{0x14}, hasKID: true},
+		{piv: []byte{0x14}, kid: []byte{0x00}, hasKID: true},
+		{piv: []byte{0x14}, hasKID: true, kidContext: []byte{0x37, 0xcb}, hasKIDContext: true},
+	}
+	for _, v := range values {
+		parsed, err := parseOptionValue(v.encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(parsed.encode(), v.encode()) {
+			t.Errorf("Expected %x but got %x", v.encode(), parsed.encode())
+		}
+	}
+
+	for _, invalid := range [][]byte{{0x06}, {0x02, 0x01}, {0x20}, {0x01, 0x01, 0x01}, {0x10, 0x05}} {
+		if _, err := parseOptionValue(invalid); err != ErrInvalidOption {
+			t.Errorf("Expected ErrInvalidOption for %x but got %v", invalid, err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package oscore
+
+import (
+	"bytes"
+	"io/ioutil"
+
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// Handler is server middleware that verifies and decrypts OSCORE requests
+// before they are passed to Next and protects the responses of Next.
+//
+// Error responses of the OSCORE layer are sent unprotected as
+// described in RFC 8613 section 8.2.
+type Handler struct {
+	Next coap.Handler
+
+	// Contexts are selected by the kid (recipient id) and kid context of the request
+	Contexts []*Context
+
+	// AllowUnprotected passes requests without OSCORE option to Next.
+	// Else they are answered with 4.01 Unauthorized.
+	AllowUnprotected bool
+}
+
+// NewHandler returns a Handler that accepts requests protected with one of the contexts
+func NewHandler(next coap.Handler, contexts ...*Context) *Handler {
+	return &Handler{
+		Next:     next,
+		Contexts: contexts,
+	}
+}
+
+func (h *Handler) findContext(opt optionValue) *Context {
+	for _, c := range h.Contexts {
+		if !bytes.Equal(c.RecipientID, opt.kid) {
+			continue
+		}
+		if opt.hasKIDContext && !bytes.Equal(c.IDContext, opt.kidContext) {
+			continue
+		}
+		return c
+	}
+	return nil
+}
+
+func writeError(w coap.ResponseWriter, code coapmsg.COAPCode, diagnostic string) {
+	w.Options().Set(coapmsg.ContentFormat, coapmsg.TextPlain)
+	w.WriteCode(code)
+	w.Write([]byte(diagnostic))
+}
+
+func (h *Handler) ServeCOAP(w coap.ResponseWriter, r *coap.Request) {
+	optVal := r.Options.Get(coapmsg.OSCORE)
+	if optVal.IsNotSet() {
+		if h.AllowUnprotected {
+			h.Next.ServeCOAP(w, r)
+			return
+		}
+		writeError(w, coapmsg.Unauthorized, "OSCORE required")
+		return
+	}
+
+	opt, err := parseOptionValue(optVal.AsBytes())
+	if err != nil || len(opt.piv) == 0 || !opt.hasKID {
+		writeError(w, coapmsg.BadOption, "Invalid OSCORE option")
+		return
+	}
+	ctx := h.findContext(opt)
+	if ctx == nil {
+		writeError(w, coapmsg.Unauthorized, "Security context not found")
+		return
+	}
+
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, coapmsg.BadRequest, "Failed to read payload")
+		return
+	}
+
+	code, ok := methodToCode[r.Method]
+	if !ok {
+		writeError(w, coapmsg.MethodNotAllowed, "Invalid method")
+		return
+	}
+	msg := coapmsg.NewMessage()
+	msg.Code = code
+	msg.Token = r.Token
+	msg.SetOptions(r.Options)
+	msg.Payload = payload
+
+	inner, ex, err := ctx.UnprotectRequest(&msg)
+	switch err {
+	case nil:
+	case ErrReplay:
+		writeError(w, coapmsg.Unauthorized, "Replay detected")
+		return
+	default:
+		writeError(w, coapmsg.BadRequest, "Decryption failed")
+		return
+	}
+
+	innerReq, err := coap.ReadRequest(inner)
+	if err != nil {
+		writeError(w, coapmsg.BadRequest, err.Error())
+		return
+	}
+	innerReq.URL.Scheme = r.URL.Scheme
+	if innerReq.URL.Host == "" {
+		innerReq.URL.Host = r.URL.Host
+	}
+	innerReq.Confirmable = r.Confirmable
+	innerReq.RemoteAddr = r.RemoteAddr
+	innerReq = innerReq.WithContext(r.Context())
+
+	rec := &recorder{msg: coapmsg.NewMessage()}
+	h.Next.ServeCOAP(rec, innerReq)
+	if !rec.codeWritten {
+		rec.WriteCode(coapmsg.Content)
+	}
+
+	protected, err := ctx.ProtectResponse(&rec.msg, ex)
+	if err != nil {
+		writeError(w, coapmsg.InternalServerError, err.Error())
+		return
+	}
+	for id, vals := range protected.Options() {
+		w.Options()[id] = vals
+	}
+	w.WriteCode(protected.Code)
+	w.Write(protected.Payload)
+}
+
+// recorder buffers the inner response of the next handler
+type recorder struct {
+	msg         coapmsg.Message
+	codeWritten bool
+}
+
+func (r *recorder) Options() coapmsg.CoapOptions {
+	return r.msg.Options()
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	if !r.codeWritten {
+		r.WriteCode(coapmsg.Content)
+	}
+	r.msg.Payload = append(r.msg.Payload, p...)
+	return len(p), nil
+}
+
+func (r *recorder) WriteCode(code coapmsg.COAPCode) {
+	if r.codeWritten {
+		return
+	}
+	r.codeWritten = true
+	r.msg.Code = code
+}
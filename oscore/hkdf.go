@@ -0,0 +1,28 @@
+package oscore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// hkdf derives length bytes of output keying material with
+// HKDF-SHA-256 (RFC 5869) from the input keying material, salt and info
+func hkdf(ikm, salt, info []byte, length int) []byte {
+	if len(salt) == 0 {
+		salt = make([]byte, sha256.Size)
+	}
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(ikm)
+	prk := extract.Sum(nil)
+
+	var okm, t []byte
+	for counter := byte(1); len(okm) < length; counter++ {
+		expand := hmac.New(sha256.New, prk)
+		expand.Write(t)
+		expand.Write(info)
+		expand.Write([]byte{counter})
+		t = expand.Sum(nil)
+		okm = append(okm, t...)
+	}
+	return okm[:length]
+}
@@ -0,0 +1,131 @@
+// Package oscore implements Object Security for Constrained RESTful
+// Environments (OSCORE, RFC 8613) on top of the coap package.
+//
+// OSCORE protects CoAP messages end-to-end, so the protection survives
+// proxies, gateways and UART hops. Requests are protected on the client by
+// wrapping a RoundTripper with a Transport:
+//
+//	ctx, _ := oscore.NewContext(masterSecret, masterSalt, []byte{0x01}, []byte{}, nil)
+//	client := coap.NewClient()
+//	client.Transport = &oscore.Transport{Context: ctx}
+//
+// On the server a Handler unprotects the requests and protects the
+// responses of the wrapped handler.
+//
+// Only the mandatory AES-CCM-16-64-128 algorithm and HKDF SHA-256 are supported.
+// Observe is not supported yet.
+package oscore
+
+import (
+	"errors"
+)
+
+// Errors returned when (un)protecting messages
+var (
+	ErrNotProtected        = errors.New("oscore: message has no OSCORE option")
+	ErrInvalidOption       = errors.New("oscore: invalid OSCORE option value")
+	ErrContextNotFound     = errors.New("oscore: security context not found")
+	ErrObserveNotSupported = errors.New("oscore: observe is not supported")
+)
+
+// OSCORE option flag bits (RFC 8613 section 6.1)
+const (
+	flagPIVLen     = 0x07
+	flagKID        = 0x08
+	flagKIDContext = 0x10
+	flagReserved   = 0xe0
+)
+
+// optionValue is the decoded value of the OSCORE option
+type optionValue struct {
+	piv           []byte // Partial IV, empty if not present
+	kid           []byte
+	hasKID        bool
+	kidContext    []byte
+	hasKIDContext bool
+}
+
+func (o optionValue) encode() []byte {
+	if len(o.piv) == 0 && !o.hasKID && !o.hasKIDContext {
+		return []byte{}
+	}
+
+	flags := byte(len(o.piv))
+	if o.hasKID {
+		flags |= flagKID
+	}
+	if o.hasKIDContext {
+		flags |= flagKIDContext
+	}
+
+	b := append([]byte{flags}, o.piv...)
+	if o.hasKIDContext {
+		b = append(b, byte(len(o.kidContext)))
+		b = append(b, o.kidContext...)
+	}
+	if o.hasKID {
+		b = append(b, o.kid...)
+	}
+	return b
+}
+
+func parseOptionValue(b []byte) (optionValue, error) {
+	o := optionValue{}
+	if len(b) == 0 {
+		return o, nil
+	}
+
+	flags := b[0]
+	if flags&flagReserved != 0 {
+		return o, ErrInvalidOption
+	}
+	n := int(flags & flagPIVLen)
+	if n > 5 {
+		return o, ErrInvalidOption
+	}
+	b = b[1:]
+	if len(b) < n {
+		return o, ErrInvalidOption
+	}
+	o.piv = b[:n]
+	b = b[n:]
+
+	if flags&flagKIDContext != 0 {
+		if len(b) < 1 || len(b) < 1+int(b[0]) {
+			return o, ErrInvalidOption
+		}
+		o.hasKIDContext = true
+		o.kidContext = b[1 : 1+int(b[0])]
+		b = b[1+int(b[0]):]
+	}
+
+	if flags&flagKID != 0 {
+		o.hasKID = true
+		o.kid = b
+	} else if len(b) > 0 {
+		return o, ErrInvalidOption
+	}
+	return o, nil
+}
+
+// encodePIV encodes a sequence number as Partial IV with
+// the least number of bytes, 0 is encoded as 0x00
+func encodePIV(seq uint64) []byte {
+	var piv []byte
+	for seq > 0 {
+		piv = append([]byte{byte(seq)}, piv...)
+		seq >>= 8
+	}
+	if len(piv) == 0 {
+		return []byte{0}
+	}
+	return piv
+}
+
+func decodePIV(piv []byte) uint64 {
+	var seq uint64
+	for _, b := range piv {
+		seq = seq<<8 | uint64(b)
+	}
+	return seq
+}
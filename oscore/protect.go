@@ -0,0 +1,224 @@
+package oscore
+
+import (
+	"bytes"
+
+	"github.com/trusch/coap-go/coapmsg"
+	"github.com/trusch/coap-go/internal/cbor"
+)
+
+const oscoreVersion = 1
+
+// Exchange binds a response to its request. It contains the
+// request_kid, request_piv and nonce of a protected request.
+type Exchange struct {
+	kid   []byte
+	piv   []byte
+	nonce []byte
+}
+
+// classU options are only sent in the outer (unprotected) message,
+// all other options are class E and encrypted (RFC 8613 section 4.1)
+var classU = map[coapmsg.OptionId]bool{
+	coapmsg.URIHost:     true,
+	coapmsg.URIPort:     true,
+	coapmsg.ProxyURI:    true,
+	coapmsg.ProxyScheme: true,
+	coapmsg.OSCORE:      true,
+}
+
+// split copies the inner (class E) and outer (class U) options of msg
+func split(msg *coapmsg.Message) (inner, outer coapmsg.CoapOptions) {
+	inner, outer = coapmsg.CoapOptions{}, coapmsg.CoapOptions{}
+	for id, vals := range msg.Options() {
+		if classU[id] {
+			outer[id] = append(outer[id], vals...)
+		} else {
+			inner[id] = append(inner[id], vals...)
+		}
+	}
+	return
+}
+
+// aad builds the additional authenticated data (RFC 8613 section 5.4)
+func aad(requestKID, requestPIV []byte) []byte {
+	externalAAD, err := cbor.Marshal([]interface{}{
+		oscoreVersion,
+		[]interface{}{algAESCCM16_64_128},
+		requestKID,
+		requestPIV,
+		[]byte{}, // Class I options
+	})
+	if err != nil {
+		panic(err)
+	}
+	encStructure, err := cbor.Marshal([]interface{}{"Encrypt0", []byte{}, externalAAD})
+	if err != nil {
+		panic(err)
+	}
+	return encStructure
+}
+
+// plaintext encodes code, class E options and payload (RFC 8613 section 5.3)
+func plaintext(code coapmsg.COAPCode, opts coapmsg.CoapOptions, payload []byte) []byte {
+	msg := coapmsg.NewMessage()
+	msg.Code = code
+	msg.SetOptions(opts)
+	msg.Payload = payload
+	bin := msg.MustMarshalBinary()
+	// Replace the 4 byte header (without token) by the code
+	return append([]byte{bin[1]}, bin[4:]...)
+}
+
+func parsePlaintext(p []byte) (coapmsg.Message, error) {
+	if len(p) < 1 {
+		return coapmsg.Message{}, errOpen
+	}
+	return coapmsg.ParseMessage(append([]byte{0x40, p[0], 0, 0}, p[1:]...))
+}
+
+// outerMessage copies the message header fields into a new message
+func outerMessage(msg *coapmsg.Message, code coapmsg.COAPCode, opts coapmsg.CoapOptions, oscoreOpt []byte, payload []byte) *coapmsg.Message {
+	outer := coapmsg.NewMessage()
+	outer.Type = msg.Type
+	outer.MessageID = msg.MessageID
+	outer.Token = msg.Token
+	outer.Code = code
+	outer.SetOptions(opts)
+	outer.Options().Set(coapmsg.OSCORE, oscoreOpt)
+	outer.Payload = payload
+	return &outer
+}
+
+// innerMessage merges the decrypted message with the outer header and class U options
+func innerMessage(outer *coapmsg.Message, decrypted coapmsg.Message) *coapmsg.Message {
+	inner := coapmsg.NewMessage()
+	inner.Type = outer.Type
+	inner.MessageID = outer.MessageID
+	inner.Token = outer.Token
+	inner.Code = decrypted.Code
+	inner.Payload = decrypted.Payload
+	for id, vals := range decrypted.Options() {
+		if !classU[id] {
+			inner.Options()[id] = vals
+		}
+	}
+	for id, vals := range outer.Options() {
+		if classU[id] && id != coapmsg.OSCORE {
+			inner.Options()[id] = vals
+		}
+	}
+	return &inner
+}
+
+// ProtectRequest encrypts a request message with the sender context.
+// The returned Exchange is needed to unprotect the response.
+func (c *Context) ProtectRequest(msg *coapmsg.Message) (*coapmsg.Message, *Exchange, error) {
+	if msg.Options().Get(coapmsg.Observe).IsSet() {
+		return nil, nil, ErrObserveNotSupported
+	}
+
+	seq, err := c.nextSequenceNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	piv := encodePIV(seq)
+	ex := &Exchange{
+		kid:   c.SenderID,
+		piv:   piv,
+		nonce: c.nonce(c.SenderID, piv),
+	}
+
+	inner, outer := split(msg)
+	ciphertext := c.senderAEAD.Seal(nil, ex.nonce, plaintext(msg.Code, inner, msg.Payload), aad(ex.kid, ex.piv))
+
+	opt := optionValue{
+		piv:    piv,
+		kid:    c.SenderID,
+		hasKID: true,
+	}
+	if c.IDContext != nil {
+		opt.hasKIDContext = true
+		opt.kidContext = c.IDContext
+	}
+
+	return outerMessage(msg, coapmsg.POST, outer, opt.encode(), ciphertext), ex, nil
+}
+
+// UnprotectRequest verifies and decrypts a request message with the recipient context.
+// The returned Exchange is needed to protect the response.
+func (c *Context) UnprotectRequest(msg *coapmsg.Message) (*coapmsg.Message, *Exchange, error) {
+	optVal := msg.Options().Get(coapmsg.OSCORE)
+	if optVal.IsNotSet() {
+		return nil, nil, ErrNotProtected
+	}
+	opt, err := parseOptionValue(optVal.AsBytes())
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(opt.piv) == 0 || !opt.hasKID {
+		return nil, nil, ErrInvalidOption
+	}
+	if !bytes.Equal(opt.kid, c.RecipientID) {
+		return nil, nil, ErrContextNotFound
+	}
+
+	ex := &Exchange{
+		kid:   opt.kid,
+		piv:   opt.piv,
+		nonce: c.nonce(opt.kid, opt.piv),
+	}
+	p, err := c.recipientAEAD.Open(nil, ex.nonce, msg.Payload, aad(ex.kid, ex.piv))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := c.checkReplay(decodePIV(opt.piv)); err != nil {
+		return nil, nil, err
+	}
+
+	decrypted, err := parsePlaintext(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	return innerMessage(msg, decrypted), ex, nil
+}
+
+// ProtectResponse encrypts a response to the request of the exchange.
+// The response reuses the request nonce and carries no Partial IV.
+func (c *Context) ProtectResponse(msg *coapmsg.Message, ex *Exchange) (*coapmsg.Message, error) {
+	if msg.Options().Get(coapmsg.Observe).IsSet() {
+		return nil, ErrObserveNotSupported
+	}
+
+	inner, outer := split(msg)
+	ciphertext := c.senderAEAD.Seal(nil, ex.nonce, plaintext(msg.Code, inner, msg.Payload), aad(ex.kid, ex.piv))
+
+	return outerMessage(msg, coapmsg.Changed, outer, optionValue{}.encode(), ciphertext), nil
+}
+
+// UnprotectResponse verifies and decrypts the response to the request of the exchange.
+func (c *Context) UnprotectResponse(msg *coapmsg.Message, ex *Exchange) (*coapmsg.Message, error) {
+	optVal := msg.Options().Get(coapmsg.OSCORE)
+	if optVal.IsNotSet() {
+		return nil, ErrNotProtected
+	}
+	opt, err := parseOptionValue(optVal.AsBytes())
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := ex.nonce
+	if len(opt.piv) > 0 {
+		nonce = c.nonce(c.RecipientID, opt.piv)
+	}
+
+	p, err := c.recipientAEAD.Open(nil, nonce, msg.Payload, aad(ex.kid, ex.piv))
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := parsePlaintext(p)
+	if err != nil {
+		return nil, err
+	}
+	return innerMessage(msg, decrypted), nil
+}
@@ -0,0 +1,103 @@
+package oscore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+func newClientContext(t *testing.T) *Context {
+	c, err := NewContext(unhex(t, masterSecret), unhex(t, masterSalt), []byte{}, []byte{0x01}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func newServerContext(t *testing.T) *Context {
+	c, err := NewContext(unhex(t, masterSecret), unhex(t, masterSalt), []byte{0x01}, []byte{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func parse(t *testing.T, s string) *coapmsg.Message {
+	msg, err := coapmsg.ParseMessage(unhex(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &msg
+}
+
+// RFC 8613 Appendix C.4 and C.7
+func TestProtectRequestResponse(t *testing.T) {
+	client := newClientContext(t)
+	client.SetSenderSequenceNumber(20)
+	server := newServerContext(t)
+
+	req := parse(t, "44015d1f00003974396c6f63616c686f737483747631")
+	protectedReq, clientEx, err := client.ProtectRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedReq := "44025d1f00003974396c6f63616c686f7374620914ff612f1092f1776f1c1668b3825e"
+	if bin := protectedReq.MustMarshalBinary(); hex.EncodeToString(bin) != expectedReq {
+		t.Errorf("Expected protected request\n%s but got\n%x", expectedReq, bin)
+	}
+	if client.SenderSequenceNumber() != 21 {
+		t.Error("Expected sender sequence number to be incremented")
+	}
+
+	unprotectedReq, serverEx, err := server.UnprotectRequest(parse(t, expectedReq))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unprotectedReq.MustMarshalBinary(), req.MustMarshalBinary()) {
+		t.Errorf("Expected unprotected request %x but got %x", req.MustMarshalBinary(), unprotectedReq.MustMarshalBinary())
+	}
+
+	res := parse(t, "64455d1f00003974ff48656c6c6f20576f726c6421")
+	protectedRes, err := server.ProtectResponse(res, serverEx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedRes := "64445d1f0000397490ffdbaad1e9a7e7b2a813d3c31524378303cdafae119106"
+	if bin := protectedRes.MustMarshalBinary(); hex.EncodeToString(bin) != expectedRes {
+		t.Errorf("Expected protected response\n%s but got\n%x", expectedRes, bin)
+	}
+
+	unprotectedRes, err := client.UnprotectResponse(parse(t, expectedRes), clientEx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unprotectedRes.MustMarshalBinary(), res.MustMarshalBinary()) {
+		t.Errorf("Expected unprotected response %x but got %x", res.MustMarshalBinary(), unprotectedRes.MustMarshalBinary())
+	}
+
+	// The same request must not be accepted twice
+	if _, _, err := server.UnprotectRequest(parse(t, expectedReq)); err != ErrReplay {
+		t.Error("Expected ErrReplay but got", err)
+	}
+}
+
+func TestUnprotectRequestErrors(t *testing.T) {
+	server := newServerContext(t)
+
+	if _, _, err := server.UnprotectRequest(parse(t, "44015d1f00003974396c6f63616c686f737483747631")); err != ErrNotProtected {
+		t.Error("Expected ErrNotProtected but got", err)
+	}
+
+	// Modified ciphertext
+	if _, _, err := server.UnprotectRequest(parse(t, "44025d1f00003974396c6f63616c686f7374620914ff612f1092f1776f1c1668b3825f")); err != errOpen {
+		t.Error("Expected authentication error but got", err)
+	}
+
+	// Unknown kid
+	if _, _, err := server.UnprotectRequest(parse(t, "44025d1f00003974396c6f63616c686f737463091405ff612f1092f1776f1c1668b3825e")); err != ErrContextNotFound {
+		t.Error("Expected ErrContextNotFound but got", err)
+	}
+}
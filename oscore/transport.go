@@ -0,0 +1,153 @@
+package oscore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+var methodToCode = map[string]coapmsg.COAPCode{
+	"GET":    coapmsg.GET,
+	"POST":   coapmsg.POST,
+	"PUT":    coapmsg.PUT,
+	"DELETE": coapmsg.DELETE,
+}
+
+// Transport is a coap.RoundTripper that protects requests and
+// verifies responses with an OSCORE security context.
+//
+// Uri-Path, Uri-Query and all other class E options of the request are
+// encrypted. The outer request is a POST to the same scheme and host.
+type Transport struct {
+	Context *Context
+
+	// Transport is used to send the protected requests.
+	// If nil, coap.DefaultTransport is used.
+	Transport coap.RoundTripper
+}
+
+func (t *Transport) transport() coap.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return coap.DefaultTransport
+}
+
+func (t *Transport) RoundTrip(req *coap.Request) (*coap.Response, error) {
+	if req == nil || req.URL == nil {
+		return nil, errors.New("oscore: nil request or request URL")
+	}
+	if t.Context == nil {
+		return nil, errors.New("oscore: no security context")
+	}
+
+	msg, err := requestMessage(req)
+	if err != nil {
+		return nil, err
+	}
+
+	protected, ex, err := t.Context.ProtectRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	outerURL := *req.URL
+	outerURL.Path, outerURL.RawPath, outerURL.RawQuery = "", "", ""
+	outerReq := req.WithContext(req.Context())
+	outerReq.Method = "POST"
+	outerReq.URL = &outerURL
+	outerReq.Options = protected.Options()
+	outerReq.Body = ioutil.NopCloser(bytes.NewReader(protected.Payload))
+
+	res, err := t.transport().RoundTrip(outerReq)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	payload, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	resMsg := coapmsg.NewMessage()
+	resMsg.Code = res.Code
+	resMsg.SetOptions(res.Options)
+	resMsg.Payload = payload
+
+	if res.Options.Get(coapmsg.OSCORE).IsNotSet() {
+		// Errors of the OSCORE layer on the server (e.g. 4.01 Unauthorized)
+		// are not protected and returned as they are
+		if resMsg.Code.IsSuccess() {
+			return nil, ErrNotProtected
+		}
+		res.Body = ioutil.NopCloser(bytes.NewReader(payload))
+		return res, nil
+	}
+
+	inner, err := t.Context.UnprotectResponse(&resMsg, ex)
+	if err != nil {
+		return nil, err
+	}
+
+	return &coap.Response{
+		StatusCode: inner.Code.Number(),
+		Code:       inner.Code,
+		Status:     inner.Code.Dotted() + " " + inner.Code.String(),
+		Body:       ioutil.NopCloser(bytes.NewReader(inner.Payload)),
+		Options:    inner.Options(),
+		Request:    req,
+	}, nil
+}
+
+// requestMessage builds the unprotected request message from a client request.
+// Takes care of closing the request body
+func requestMessage(req *coap.Request) (*coapmsg.Message, error) {
+	defer func() {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+	}()
+
+	code, ok := methodToCode[req.Method]
+	if !ok {
+		return nil, fmt.Errorf("oscore: invalid method %q", req.Method)
+	}
+
+	msg := coapmsg.NewMessage()
+	msg.Code = code
+	msg.Token = req.Token
+	for id, vals := range req.Options {
+		msg.Options()[id] = append([]coapmsg.OptionValue{}, vals...)
+	}
+
+	path, err := url.PathUnescape(req.URL.EscapedPath())
+	if err != nil {
+		return nil, err
+	}
+	msg.SetPathString(path)
+	if msg.PathString() == "" {
+		msg.Options().Del(coapmsg.URIPath)
+	}
+
+	msg.Options().Del(coapmsg.URIQuery)
+	for _, q := range strings.Split(req.URL.RawQuery, "&") {
+		if q != "" {
+			msg.Options().Add(coapmsg.URIQuery, q)
+		}
+	}
+
+	if req.Body != nil {
+		msg.Payload, err = ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &msg, nil
+}
@@ -0,0 +1,112 @@
+package oscore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coap/coaptest"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+func TestTransportAndHandler(t *testing.T) {
+	var innerReq *coap.Request
+	app := coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		innerReq = r
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Options().Set(coapmsg.ContentFormat, coapmsg.TextPlain)
+		w.WriteCode(coapmsg.Changed)
+		w.Write([]byte("set " + string(body)))
+	})
+
+	rt := &coaptest.HandlerTransport{Handler: NewHandler(app, newServerContext(t))}
+	client := &coap.Client{Transport: &Transport{Context: newClientContext(t), Transport: rt}}
+
+	req, err := coap.NewRequest("PUT", "coap://localhost/actuators/valve?state=1", bytes.NewReader([]byte("open")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	if string(body) != "set open" {
+		t.Errorf("Unexpected response body %q", body)
+	}
+	if res.Code != coapmsg.Changed {
+		t.Error("Expected 2.04 Changed but got", res.Status)
+	}
+	if res.Options.Get(coapmsg.OSCORE).IsSet() {
+		t.Error("Expected OSCORE option to be removed from inner response")
+	}
+
+	if innerReq == nil {
+		t.Fatal("Inner handler not called")
+	}
+	if innerReq.Method != "PUT" || innerReq.URL.Path != "/actuators/valve" || innerReq.URL.RawQuery != "state=1" {
+		t.Errorf("Unexpected inner request %s %s", innerReq.Method, innerReq.URL)
+	}
+
+	// Nothing but Uri-Host and OSCORE is visible on the wire
+	sent := rt.Sent()[0]
+	if sent.Code != coapmsg.POST {
+		t.Error("Expected outer code POST but got", sent.Code)
+	}
+	for id := range sent.Options() {
+		if id != coapmsg.URIHost && id != coapmsg.OSCORE {
+			t.Error("Unexpected outer option", id)
+		}
+	}
+	if bytes.Contains(sent.Payload, []byte("open")) {
+		t.Error("Payload is not encrypted")
+	}
+}
+
+func TestHandlerRejectsUnprotected(t *testing.T) {
+	called := false
+	app := coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		called = true
+	})
+	h := NewHandler(app, newServerContext(t))
+
+	req := coapmsg.NewMessage()
+	req.Type = coapmsg.Confirmable
+	req.Code = coapmsg.GET
+	req.SetPathString("secret")
+
+	res := coap.ServeMessage(h, &req)
+	if res.Code != coapmsg.Unauthorized {
+		t.Error("Expected 4.01 but got", res.Code)
+	}
+	if called {
+		t.Error("Handler must not be called for unprotected requests")
+	}
+
+	h.AllowUnprotected = true
+	res = coap.ServeMessage(h, &req)
+	if !called || res.Code != coapmsg.Content {
+		t.Error("Expected unprotected request to be passed to handler")
+	}
+}
+
+func TestHandlerUnknownContext(t *testing.T) {
+	app := coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {})
+	rt := &coaptest.HandlerTransport{Handler: NewHandler(app, newServerContext(t))}
+
+	other, err := NewContext(unhex(t, masterSecret), nil, []byte{0x42}, []byte{0x01}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &coap.Client{Transport: &Transport{Context: other, Transport: rt}}
+
+	res, err := client.Get("coap://localhost/tv1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != coapmsg.Unauthorized {
+		t.Error("Expected unprotected 4.01 but got", res.Status)
+	}
+}
@@ -0,0 +1,182 @@
+// Package pcap writes and reads CoAP traffic in the pcapng capture
+// format so it can be analysed with Wireshark.
+//
+// A Writer can be installed as coap.PacketTap to capture all packets of
+// all transports:
+//
+//	f, _ := os.Create("uart.pcapng")
+//	w, _ := pcap.NewWriter(f, pcap.LinkTypeIPv4)
+//	coap.SetPacketTap(w)
+//
+// Since UART connections have no network addresses, packets are
+// wrapped into synthetic IPv4/UDP headers between HostAddr and DeviceAddr
+// on port 5683 where Wireshark dissects them as CoAP.
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+// LinkType is the link layer header type of the captured packets
+// See: http://www.tcpdump.org/linktypes.html
+type LinkType uint16
+
+const (
+	// LinkTypeEthernet is only supported for reading captures of real networks
+	LinkTypeEthernet LinkType = 1
+	// LinkTypeSLIP frames start with a 16 byte SLIP pseudo header that contains
+	// the direction, followed by the synthetic IPv4/UDP packet.
+	LinkTypeSLIP LinkType = 8
+	// LinkTypeUser0 frames contain the raw CoAP message. Configure Wireshark
+	// to dissect DLT User 0 (147) as "coap" to analyse them.
+	LinkTypeUser0 LinkType = 147
+	// LinkTypeIPv4 frames contain a synthetic IPv4/UDP packet with the CoAP message
+	LinkTypeIPv4 LinkType = 228
+)
+
+// CoAPPort is used as UDP source and destination port for synthetic headers
+const CoAPPort = 5683
+
+// Synthetic addresses for packets without network layer (e.g. UART)
+var (
+	HostAddr   = net.IPv4(127, 0, 0, 1).To4()
+	DeviceAddr = net.IPv4(127, 0, 0, 2).To4()
+)
+
+const (
+	slipHeaderLen = 16
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	udpHeaderLen  = 8
+	etherLen      = 14
+
+	ipProtoUDP = 17
+
+	slipDirIncoming = 0
+	slipDirOutgoing = 1
+)
+
+// Errors returned while reading captures
+var (
+	ErrInvalidFormat       = errors.New("pcap: invalid capture file format")
+	ErrUnsupportedLinkType = errors.New("pcap: unsupported link type")
+	ErrNotCoAP             = errors.New("pcap: packet is not a CoAP over UDP packet")
+)
+
+// encapsulate wraps the CoAP packet into the link layer frame
+func encapsulate(linkType LinkType, outgoing bool, packet []byte) ([]byte, error) {
+	switch linkType {
+	case LinkTypeUser0:
+		return packet, nil
+	case LinkTypeIPv4:
+		return buildIPv4UDP(outgoing, packet), nil
+	case LinkTypeSLIP:
+		frame := make([]byte, slipHeaderLen, slipHeaderLen+ipv4HeaderLen+udpHeaderLen+len(packet))
+		frame[0] = slipDirIncoming
+		if outgoing {
+			frame[0] = slipDirOutgoing
+		}
+		return append(frame, buildIPv4UDP(outgoing, packet)...), nil
+	}
+	return nil, ErrUnsupportedLinkType
+}
+
+func buildIPv4UDP(outgoing bool, packet []byte) []byte {
+	src, dst := DeviceAddr, HostAddr
+	if outgoing {
+		src, dst = HostAddr, DeviceAddr
+	}
+
+	total := ipv4HeaderLen + udpHeaderLen + len(packet)
+	b := make([]byte, total)
+	b[0] = 0x45 // Version 4, IHL 5
+	binary.BigEndian.PutUint16(b[2:], uint16(total))
+	b[8] = 64 // TTL
+	b[9] = ipProtoUDP
+	copy(b[12:16], src)
+	copy(b[16:20], dst)
+	binary.BigEndian.PutUint16(b[10:], ipv4Checksum(b[:ipv4HeaderLen]))
+
+	udp := b[ipv4HeaderLen:]
+	binary.BigEndian.PutUint16(udp[0:], CoAPPort)
+	binary.BigEndian.PutUint16(udp[2:], CoAPPort)
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpHeaderLen+len(packet)))
+	// UDP checksum 0 = not computed, valid for IPv4
+	copy(udp[udpHeaderLen:], packet)
+	return b
+}
+
+func ipv4Checksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+// decapsulate extracts the CoAP packet from a link layer frame.
+// For frames without direction information outgoing is always false.
+func decapsulate(linkType LinkType, frame []byte) (packet []byte, outgoing bool, err error) {
+	switch linkType {
+	case LinkTypeUser0:
+		return frame, false, nil
+	case LinkTypeIPv4:
+		packet, err = parseIPv4UDP(frame)
+		return
+	case LinkTypeSLIP:
+		if len(frame) < slipHeaderLen {
+			return nil, false, ErrNotCoAP
+		}
+		packet, err = parseIPv4UDP(frame[slipHeaderLen:])
+		return packet, frame[0] == slipDirOutgoing, err
+	case LinkTypeEthernet:
+		if len(frame) < etherLen {
+			return nil, false, ErrNotCoAP
+		}
+		switch binary.BigEndian.Uint16(frame[12:]) {
+		case 0x0800:
+			packet, err = parseIPv4UDP(frame[etherLen:])
+		case 0x86DD:
+			packet, err = parseIPv6UDP(frame[etherLen:])
+		default:
+			err = ErrNotCoAP
+		}
+		return
+	}
+	return nil, false, ErrUnsupportedLinkType
+}
+
+func parseIPv4UDP(b []byte) ([]byte, error) {
+	if len(b) < ipv4HeaderLen || b[0]>>4 != 4 || b[9] != ipProtoUDP {
+		return nil, ErrNotCoAP
+	}
+	ihl := int(b[0]&0x0f) * 4
+	if len(b) < ihl {
+		return nil, ErrNotCoAP
+	}
+	return parseUDP(b[ihl:])
+}
+
+func parseIPv6UDP(b []byte) ([]byte, error) {
+	// Extension headers are not supported
+	if len(b) < ipv6HeaderLen || b[0]>>4 != 6 || b[6] != ipProtoUDP {
+		return nil, ErrNotCoAP
+	}
+	return parseUDP(b[ipv6HeaderLen:])
+}
+
+func parseUDP(b []byte) ([]byte, error) {
+	if len(b) < udpHeaderLen {
+		return nil, ErrNotCoAP
+	}
+	length := int(binary.BigEndian.Uint16(b[4:]))
+	if length < udpHeaderLen || length > len(b) {
+		return nil, ErrNotCoAP
+	}
+	return b[udpHeaderLen:length], nil
+}
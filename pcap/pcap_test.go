@@ -0,0 +1,145 @@
+package pcap
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+func testMessages() []coapmsg.Message {
+	req := coapmsg.NewMessage()
+	req.Type = coapmsg.Confirmable
+	req.Code = coapmsg.GET
+	req.MessageID = 1
+	req.Token = []byte{0x73}
+	req.SetPathString("sensors/temperature")
+
+	res := coapmsg.NewAck(1)
+	res.Code = coapmsg.Content
+	res.Token = []byte{0x73}
+	res.Payload = []byte("22.5 C")
+
+	// Odd length to check the padding
+	rst := coapmsg.NewRst(2)
+
+	return []coapmsg.Message{req, res, rst}
+}
+
+func TestWriteRead(t *testing.T) {
+	for _, linkType := range []LinkType{LinkTypeIPv4, LinkTypeSLIP, LinkTypeUser0} {
+		buf := &bytes.Buffer{}
+		w, err := NewWriter(buf, linkType)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msgs := testMessages()
+		ts := time.Date(2017, 3, 1, 12, 0, 0, 123456000, time.UTC)
+		for i, msg := range msgs {
+			dir := coap.PacketIncoming
+			if i%2 == 0 {
+				dir = coap.PacketOutgoing
+			}
+			if err := w.WritePacket(ts, dir, msg.MustMarshalBinary()); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		r, err := NewReader(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, expected := range msgs {
+			msg, p, err := r.ReadMessage()
+			if err != nil {
+				t.Fatalf("LinkType %d: %s", linkType, err)
+			}
+			if !bytes.Equal(msg.MustMarshalBinary(), expected.MustMarshalBinary()) {
+				t.Errorf("LinkType %d: Message %d does not match", linkType, i)
+			}
+			if !p.Timestamp.Equal(ts) {
+				t.Errorf("LinkType %d: Expected timestamp %s but got %s", linkType, ts, p.Timestamp)
+			}
+			if (p.Direction == coap.PacketOutgoing) != (i%2 == 0) {
+				t.Errorf("LinkType %d: Unexpected direction %s of message %d", linkType, p.Direction, i)
+			}
+		}
+		if _, _, err := r.ReadMessage(); err != io.EOF {
+			t.Errorf("Expected EOF but got %v", err)
+		}
+	}
+}
+
+func TestWriterAsTap(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf, LinkTypeIPv4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := testMessages()[0]
+	w.TapPacket(coap.PacketOutgoing, msg.MustMarshalBinary())
+	if w.Err() != nil {
+		t.Fatal(w.Err())
+	}
+
+	msgs, err := ReadMessages(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].PathString() != "sensors/temperature" {
+		t.Errorf("Unexpected messages %v", msgs)
+	}
+}
+
+func TestReadClassicPcapEthernet(t *testing.T) {
+	msg := testMessages()[1]
+	ipPacket := buildIPv4UDP(false, msg.MustMarshalBinary())
+	frame := append([]byte{
+		0, 1, 2, 3, 4, 5, // dst MAC
+		6, 7, 8, 9, 10, 11, // src MAC
+		0x08, 0x00, // IPv4
+	}, ipPacket...)
+
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{
+		0xd4, 0xc3, 0xb2, 0xa1, // magic
+		2, 0, 4, 0, // version
+		0, 0, 0, 0, 0, 0, 0, 0, // zone, sigfigs
+		0xff, 0xff, 0, 0, // snaplen
+		1, 0, 0, 0, // ethernet
+	})
+	// A non CoAP frame that must be skipped
+	buf.Write([]byte{1, 0, 0, 0, 0, 0, 0, 0, 14, 0, 0, 0, 14, 0, 0, 0})
+	buf.Write(make([]byte, 14))
+	buf.Write([]byte{1, 0, 0, 0, 5, 0, 0, 0, byte(len(frame)), 0, 0, 0, byte(len(frame)), 0, 0, 0})
+	buf.Write(frame)
+
+	r, err := NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, p, err := r.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(parsed.Payload) != "22.5 C" {
+		t.Errorf("Unexpected payload %q", parsed.Payload)
+	}
+	if !p.Timestamp.Equal(time.Unix(1, 5000)) {
+		t.Errorf("Unexpected timestamp %s", p.Timestamp)
+	}
+}
+
+func TestUnsupportedLinkType(t *testing.T) {
+	if _, err := NewWriter(&bytes.Buffer{}, LinkTypeEthernet); err != ErrUnsupportedLinkType {
+		t.Error("Expected ErrUnsupportedLinkType but got", err)
+	}
+	if _, err := NewReader(bytes.NewReader([]byte{1, 2, 3, 4})); err != ErrInvalidFormat {
+		t.Error("Expected ErrInvalidFormat but got", err)
+	}
+}
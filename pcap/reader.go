@@ -0,0 +1,331 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// classic pcap magic numbers
+const (
+	pcapMagicMicros = 0xA1B2C3D4
+	pcapMagicNanos  = 0xA1B23C4D
+)
+
+// maxBlockLen protects against allocating huge buffers for corrupt files
+const maxBlockLen = 16 * 1024 * 1024
+
+// Packet is a single CoAP packet read from a capture
+type Packet struct {
+	Timestamp time.Time
+	Direction coap.PacketDirection
+	Data      []byte // The CoAP message without link layer headers
+}
+
+type iface struct {
+	linkType LinkType
+	tsUnit   time.Duration // Duration of one timestamp tick
+	tsPerSec uint64        // Ticks per second, used when tsUnit is < 1ns
+}
+
+// Reader reads CoAP packets from pcapng or classic pcap captures.
+// Frames that do not contain a CoAP over UDP packet are skipped.
+type Reader struct {
+	r      io.Reader
+	order  binary.ByteOrder
+	ng     bool
+	ifaces []iface
+}
+
+// NewReader detects the capture format by reading the file header
+func NewReader(r io.Reader) (*Reader, error) {
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+
+	pr := &Reader{r: r}
+
+	if binary.LittleEndian.Uint32(head) == blockSectionHeader {
+		pr.ng = true
+		if err := pr.readSectionHeader(); err != nil {
+			return nil, err
+		}
+		return pr, nil
+	}
+
+	// Classic pcap
+	var unit time.Duration
+	switch {
+	case binary.LittleEndian.Uint32(head) == pcapMagicMicros:
+		pr.order, unit = binary.LittleEndian, time.Microsecond
+	case binary.BigEndian.Uint32(head) == pcapMagicMicros:
+		pr.order, unit = binary.BigEndian, time.Microsecond
+	case binary.LittleEndian.Uint32(head) == pcapMagicNanos:
+		pr.order, unit = binary.LittleEndian, time.Nanosecond
+	case binary.BigEndian.Uint32(head) == pcapMagicNanos:
+		pr.order, unit = binary.BigEndian, time.Nanosecond
+	default:
+		return nil, ErrInvalidFormat
+	}
+
+	rest := make([]byte, 20)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, err
+	}
+	pr.ifaces = []iface{{
+		linkType: LinkType(pr.order.Uint32(rest[16:])),
+		tsUnit:   unit,
+	}}
+	return pr, nil
+}
+
+// readSectionHeader reads the remainder of a section header block
+// after the block type has been consumed
+func (r *Reader) readSectionHeader() error {
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return err
+	}
+	switch {
+	case binary.LittleEndian.Uint32(b[4:]) == byteOrderMagic:
+		r.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(b[4:]) == byteOrderMagic:
+		r.order = binary.BigEndian
+	default:
+		return ErrInvalidFormat
+	}
+	total := r.order.Uint32(b)
+	if total < 28 || total > maxBlockLen {
+		return ErrInvalidFormat
+	}
+	// Skip version, section length, options and trailing length
+	if _, err := io.CopyN(io.Discard, r.r, int64(total-12)); err != nil {
+		return err
+	}
+	// Interfaces are scoped by section
+	r.ifaces = nil
+	return nil
+}
+
+// ReadPacket returns the next CoAP packet. At the end of the capture io.EOF is returned.
+func (r *Reader) ReadPacket() (Packet, error) {
+	for {
+		var p Packet
+		var err error
+		if r.ng {
+			p, err = r.readBlock()
+		} else {
+			p, err = r.readRecord()
+		}
+		if err == ErrNotCoAP || err == errSkipBlock {
+			continue
+		}
+		return p, err
+	}
+}
+
+// ReadMessage returns the next parsed CoAP message with the packet it was parsed from
+func (r *Reader) ReadMessage() (coapmsg.Message, Packet, error) {
+	p, err := r.ReadPacket()
+	if err != nil {
+		return coapmsg.Message{}, p, err
+	}
+	msg, err := coapmsg.ParseMessage(p.Data)
+	return msg, p, err
+}
+
+// ReadMessages replays a whole capture into CoAP messages
+func ReadMessages(r io.Reader) ([]coapmsg.Message, error) {
+	pr, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	var msgs []coapmsg.Message
+	for {
+		msg, _, err := pr.ReadMessage()
+		if err == io.EOF {
+			return msgs, nil
+		}
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func (r *Reader) readRecord() (Packet, error) {
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(r.r, head); err != nil {
+		return Packet{}, err
+	}
+	capLen := r.order.Uint32(head[8:])
+	if capLen > maxBlockLen {
+		return Packet{}, ErrInvalidFormat
+	}
+	frame := make([]byte, capLen)
+	if _, err := io.ReadFull(r.r, frame); err != nil {
+		return Packet{}, unexpectedEOF(err)
+	}
+
+	ifc := r.ifaces[0]
+	ts := time.Unix(int64(r.order.Uint32(head[0:])), int64(r.order.Uint32(head[4:]))*int64(ifc.tsUnit))
+	return r.packet(ifc, ts, frame, 0)
+}
+
+// errSkipBlock is returned for blocks that do not contain packets
+var errSkipBlock = errors.New("pcap: skip block")
+
+func (r *Reader) readBlock() (Packet, error) {
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(r.r, head); err != nil {
+		return Packet{}, err
+	}
+	if binary.LittleEndian.Uint32(head) == blockSectionHeader {
+		// Next section, byte order might change
+		r.r = io.MultiReader(bytes.NewReader(head[4:]), r.r)
+		if err := r.readSectionHeader(); err != nil {
+			return Packet{}, unexpectedEOF(err)
+		}
+		return Packet{}, errSkipBlock
+	}
+
+	blockType := r.order.Uint32(head)
+	total := r.order.Uint32(head[4:])
+	if total < 12 || total%4 != 0 || total > maxBlockLen {
+		return Packet{}, ErrInvalidFormat
+	}
+	body := make([]byte, total-8)
+	if _, err := io.ReadFull(r.r, body); err != nil {
+		return Packet{}, unexpectedEOF(err)
+	}
+	body = body[:len(body)-4] // trailing block length
+
+	switch blockType {
+	case blockInterfaceDesc:
+		if len(body) < 8 {
+			return Packet{}, ErrInvalidFormat
+		}
+		ifc := iface{
+			linkType: LinkType(r.order.Uint16(body)),
+			tsUnit:   time.Microsecond,
+		}
+		r.parseOptions(body[8:], func(code uint16, val []byte) {
+			if code == optIfTsResol && len(val) == 1 {
+				ifc.tsUnit, ifc.tsPerSec = tsResolution(val[0])
+			}
+		})
+		r.ifaces = append(r.ifaces, ifc)
+		return Packet{}, errSkipBlock
+	case blockEnhancedPacket:
+		if len(body) < 20 {
+			return Packet{}, ErrInvalidFormat
+		}
+		id := r.order.Uint32(body)
+		if int(id) >= len(r.ifaces) {
+			return Packet{}, ErrInvalidFormat
+		}
+		ifc := r.ifaces[id]
+		ticks := uint64(r.order.Uint32(body[4:]))<<32 | uint64(r.order.Uint32(body[8:]))
+		capLen := int(r.order.Uint32(body[12:]))
+		padded := (capLen + 3) &^ 3
+		if 20+padded > len(body) {
+			return Packet{}, ErrInvalidFormat
+		}
+		frame := body[20 : 20+capLen]
+		var flags uint32
+		r.parseOptions(body[20+padded:], func(code uint16, val []byte) {
+			if code == optEpbFlags && len(val) == 4 {
+				flags = r.order.Uint32(val)
+			}
+		})
+		return r.packet(ifc, ifc.timestamp(ticks), frame, flags)
+	case blockSimplePacket:
+		if len(body) < 4 || len(r.ifaces) == 0 {
+			return Packet{}, ErrInvalidFormat
+		}
+		capLen := int(r.order.Uint32(body))
+		if 4+capLen > len(body) {
+			capLen = len(body) - 4
+		}
+		return r.packet(r.ifaces[0], time.Time{}, body[4:4+capLen], 0)
+	}
+	return Packet{}, errSkipBlock
+}
+
+func (r *Reader) packet(ifc iface, ts time.Time, frame []byte, flags uint32) (Packet, error) {
+	data, outgoing, err := decapsulate(ifc.linkType, frame)
+	if err != nil {
+		return Packet{}, err
+	}
+	switch flags & 3 {
+	case epbFlagInbound:
+		outgoing = false
+	case epbFlagOutbound:
+		outgoing = true
+	}
+	p := Packet{
+		Timestamp: ts,
+		Direction: coap.PacketIncoming,
+		Data:      data,
+	}
+	if outgoing {
+		p.Direction = coap.PacketOutgoing
+	}
+	return p, nil
+}
+
+func (r *Reader) parseOptions(b []byte, fn func(code uint16, val []byte)) {
+	for len(b) >= 4 {
+		code := r.order.Uint16(b)
+		length := int(r.order.Uint16(b[2:]))
+		if code == optEndOfOpt {
+			return
+		}
+		padded := (length + 3) &^ 3
+		if 4+padded > len(b) {
+			return
+		}
+		fn(code, b[4:4+length])
+		b = b[4+padded:]
+	}
+}
+
+// tsResolution decodes the if_tsresol option
+func tsResolution(v byte) (unit time.Duration, perSec uint64) {
+	exp := float64(v & 0x7f)
+	var ticks float64
+	if v&0x80 != 0 {
+		ticks = math.Pow(2, exp)
+	} else {
+		ticks = math.Pow(10, exp)
+	}
+	if ticks > 1e9 {
+		return 0, uint64(ticks)
+	}
+	return time.Duration(1e9 / ticks), 0
+}
+
+func (ifc iface) timestamp(ticks uint64) time.Time {
+	if ifc.tsPerSec != 0 {
+		sec := ticks / ifc.tsPerSec
+		frac := ticks % ifc.tsPerSec
+		return time.Unix(int64(sec), int64(float64(frac)*1e9/float64(ifc.tsPerSec)))
+	}
+	unitsPerSec := uint64(time.Second / ifc.tsUnit)
+	sec := ticks / unitsPerSec
+	return time.Unix(int64(sec), int64(ticks%unitsPerSec)*int64(ifc.tsUnit))
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
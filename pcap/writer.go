@@ -0,0 +1,130 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/trusch/coap-go/coap"
+)
+
+// pcapng block types
+const (
+	blockSectionHeader    = 0x0A0D0D0A
+	blockInterfaceDesc    = 0x00000001
+	blockSimplePacket     = 0x00000003
+	blockEnhancedPacket   = 0x00000006
+	byteOrderMagic        = 0x1A2B3C4D
+	optEndOfOpt           = 0
+	optIfTsResol          = 9
+	optEpbFlags           = 2
+	epbFlagInbound        = 1
+	epbFlagOutbound       = 2
+	defaultSnapLen        = 0 // No limit
+	sectionLengthUnknown  = 0xFFFFFFFFFFFFFFFF
+	microsecondResolution = 6
+)
+
+// Writer writes CoAP packets into a pcapng stream.
+// It implements coap.PacketTap and is safe for concurrent use.
+type Writer struct {
+	mu       sync.Mutex
+	w        io.Writer
+	linkType LinkType
+	err      error
+}
+
+var _ coap.PacketTap = &Writer{}
+
+// NewWriter writes the pcapng section header and a single interface
+// with the given link type. All packets are written for that interface.
+func NewWriter(w io.Writer, linkType LinkType) (*Writer, error) {
+	if _, err := encapsulate(linkType, false, nil); err != nil {
+		return nil, err
+	}
+
+	pw := &Writer{
+		w:        w,
+		linkType: linkType,
+	}
+
+	shb := make([]byte, 28)
+	binary.LittleEndian.PutUint32(shb[0:], blockSectionHeader)
+	binary.LittleEndian.PutUint32(shb[4:], uint32(len(shb)))
+	binary.LittleEndian.PutUint32(shb[8:], byteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[12:], 1) // Major version
+	binary.LittleEndian.PutUint16(shb[14:], 0) // Minor version
+	binary.LittleEndian.PutUint64(shb[16:], sectionLengthUnknown)
+	binary.LittleEndian.PutUint32(shb[24:], uint32(len(shb)))
+
+	idb := make([]byte, 32)
+	binary.LittleEndian.PutUint32(idb[0:], blockInterfaceDesc)
+	binary.LittleEndian.PutUint32(idb[4:], uint32(len(idb)))
+	binary.LittleEndian.PutUint16(idb[8:], uint16(linkType))
+	binary.LittleEndian.PutUint32(idb[12:], defaultSnapLen)
+	binary.LittleEndian.PutUint16(idb[16:], optIfTsResol)
+	binary.LittleEndian.PutUint16(idb[18:], 1)
+	idb[20] = microsecondResolution
+	// idb[24:28] is opt_endofopt
+	binary.LittleEndian.PutUint32(idb[28:], uint32(len(idb)))
+
+	if _, err := w.Write(append(shb, idb...)); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// WritePacket writes a single CoAP packet as enhanced packet block.
+func (w *Writer) WritePacket(ts time.Time, dir coap.PacketDirection, packet []byte) error {
+	frame, err := encapsulate(w.linkType, dir == coap.PacketOutgoing, packet)
+	if err != nil {
+		return err
+	}
+
+	padded := (len(frame) + 3) &^ 3
+	total := 28 + padded + 12 + 4
+	b := make([]byte, total)
+
+	micros := uint64(ts.UnixNano() / int64(time.Microsecond))
+	binary.LittleEndian.PutUint32(b[0:], blockEnhancedPacket)
+	binary.LittleEndian.PutUint32(b[4:], uint32(total))
+	binary.LittleEndian.PutUint32(b[8:], 0) // Interface ID
+	binary.LittleEndian.PutUint32(b[12:], uint32(micros>>32))
+	binary.LittleEndian.PutUint32(b[16:], uint32(micros))
+	binary.LittleEndian.PutUint32(b[20:], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(b[24:], uint32(len(frame)))
+	copy(b[28:], frame)
+
+	opts := b[28+padded:]
+	flags := uint32(epbFlagInbound)
+	if dir == coap.PacketOutgoing {
+		flags = epbFlagOutbound
+	}
+	binary.LittleEndian.PutUint16(opts[0:], optEpbFlags)
+	binary.LittleEndian.PutUint16(opts[2:], 4)
+	binary.LittleEndian.PutUint32(opts[4:], flags)
+	// opts[8:12] is opt_endofopt
+	binary.LittleEndian.PutUint32(b[total-4:], uint32(total))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	_, w.err = w.w.Write(b)
+	return w.err
+}
+
+// TapPacket implements coap.PacketTap. Write errors are
+// available via Err, after the first error no more packets are written.
+func (w *Writer) TapPacket(dir coap.PacketDirection, packet []byte) {
+	_ = w.WritePacket(time.Now(), dir, packet)
+}
+
+// Err returns the first error that occurred while writing packets
+func (w *Writer) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// DefaultMaxBodySize limits the size of HTTP response bodies forwarded
+// to CoAP clients
+const DefaultMaxBodySize = 64 * 1024
+
+// CoAPHandler is a CoAP-to-HTTP proxy (RFC 8075 describes the mapping
+// in the other direction, the same rules are applied in reverse).
+//
+// Requests must carry a Proxy-Uri option or a Proxy-Scheme option with
+// an "http" or "https" target, other targets are answered with 5.05
+// Proxying Not Supported. Options are mapped to HTTP headers and the HTTP
+// response back to a CoAP response: Content-Type to Content-Format,
+// Cache-Control max-age to Max-Age and entity tags to ETag options.
+type CoAPHandler struct {
+	// Client is used to send HTTP requests, if nil http.DefaultClient is used
+	Client *http.Client
+
+	// MaxBodySize limits HTTP response bodies, larger responses are
+	// answered with 5.02 Bad Gateway. If zero DefaultMaxBodySize is used.
+	MaxBodySize int64
+}
+
+func (h *CoAPHandler) client() *http.Client {
+	if h.Client != nil {
+		return h.Client
+	}
+	return http.DefaultClient
+}
+
+func (h *CoAPHandler) maxBodySize() int64 {
+	if h.MaxBodySize > 0 {
+		return h.MaxBodySize
+	}
+	return DefaultMaxBodySize
+}
+
+func (h *CoAPHandler) ServeCOAP(w coap.ResponseWriter, r *coap.Request) {
+	target, err := targetURL(r)
+	if err != nil {
+		log.WithError(err).Debug("Invalid proxy request")
+		w.WriteCode(coapmsg.BadOption)
+		return
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		w.WriteCode(coapmsg.ProxyingNotSupported)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteCode(coapmsg.BadRequest)
+		return
+	}
+	hreq, err := http.NewRequestWithContext(r.Context(), r.Method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		w.WriteCode(coapmsg.BadRequest)
+		return
+	}
+	setRequestHeader(hreq.Header, r.Options)
+
+	hres, err := h.client().Do(hreq)
+	if err != nil {
+		log.WithError(err).WithField("URL", target.String()).Warn("HTTP request failed")
+		if isTimeout(err) {
+			w.WriteCode(coapmsg.GatewayTimeout)
+		} else {
+			w.WriteCode(coapmsg.BadGateway)
+		}
+		return
+	}
+	defer hres.Body.Close()
+
+	payload, err := ioutil.ReadAll(io.LimitReader(hres.Body, h.maxBodySize()+1))
+	if err != nil || int64(len(payload)) > h.maxBodySize() {
+		w.WriteCode(coapmsg.BadGateway)
+		return
+	}
+
+	setResponseOptions(w.Options(), hres)
+	w.WriteCode(coapCode(hres.StatusCode, r.Method))
+	if len(payload) > 0 && hres.StatusCode != http.StatusNotModified {
+		w.Write(payload)
+	}
+}
+
+// setRequestHeader maps CoAP request options to HTTP headers
+func setRequestHeader(header http.Header, opts coapmsg.CoapOptions) {
+	if cf := opts.Get(coapmsg.ContentFormat); cf.IsSet() {
+		header.Set("Content-Type", contentType(cf.AsMediaType()))
+	}
+	if accept := opts.Get(coapmsg.Accept); accept.IsSet() {
+		header.Set("Accept", contentType(accept.AsMediaType()))
+	}
+	var ifMatch []string
+	for _, v := range opts[coapmsg.IfMatch] {
+		if v.Len() == 0 {
+			ifMatch = []string{"*"}
+			break
+		}
+		ifMatch = append(ifMatch, formatETag(v.AsBytes()))
+	}
+	if len(ifMatch) > 0 {
+		header.Set("If-Match", strings.Join(ifMatch, ", "))
+	}
+	if opts.Get(coapmsg.IfNoneMatch).IsSet() {
+		header.Set("If-None-Match", "*")
+	} else if etags := opts[coapmsg.ETag]; len(etags) > 0 {
+		var tags []string
+		for _, v := range etags {
+			tags = append(tags, formatETag(v.AsBytes()))
+		}
+		header.Set("If-None-Match", strings.Join(tags, ", "))
+	}
+}
+
+// setResponseOptions maps HTTP response headers to CoAP options
+func setResponseOptions(opts coapmsg.CoapOptions, hres *http.Response) {
+	if ct := hres.Header.Get("Content-Type"); ct != "" {
+		if mt, err := coapmsg.ParseMediaType(ct); err == nil {
+			opts.Set(coapmsg.ContentFormat, mt)
+		} else if mt, err := coapmsg.ParseMediaType(strings.Split(ct, ";")[0]); err == nil {
+			opts.Set(coapmsg.ContentFormat, mt)
+		}
+	}
+	if etags := parseETags(hres.Header.Get("ETag")); len(etags) == 1 {
+		opts.Set(coapmsg.ETag, etags[0])
+	}
+	if age, ok := cacheControlMaxAge(hres.Header.Get("Cache-Control")); ok {
+		opts.Set(coapmsg.MaxAge, age)
+	}
+	if loc := hres.Header.Get("Location"); loc != "" {
+		if u, err := hres.Request.URL.Parse(loc); err == nil {
+			for _, p := range strings.Split(strings.Trim(u.Path, "/"), "/") {
+				if p != "" {
+					opts.Add(coapmsg.LocationPath, p)
+				}
+			}
+			for _, q := range strings.Split(u.RawQuery, "&") {
+				if q != "" {
+					opts.Add(coapmsg.LocationQuery, q)
+				}
+			}
+		}
+	}
+}
+
+// cacheControlMaxAge returns the freshness of a HTTP response in seconds.
+// Responses that must not be cached get a Max-Age of 0.
+func cacheControlMaxAge(cc string) (uint32, bool) {
+	for _, directive := range strings.Split(cc, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-cache" || directive == "no-store":
+			return 0, true
+		case strings.HasPrefix(directive, "max-age="):
+			age, err := strconv.ParseUint(strings.TrimPrefix(directive, "max-age="), 10, 32)
+			if err == nil {
+				return uint32(age), true
+			}
+		}
+	}
+	return 0, false
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && urlErr.Timeout()
+}
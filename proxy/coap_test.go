@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+func proxyRequest(method coapmsg.COAPCode, proxyURI string, payload []byte) *coapmsg.Message {
+	msg := coapmsg.NewMessage()
+	msg.Type = coapmsg.Confirmable
+	msg.Code = method
+	msg.MessageID = 1
+	if proxyURI != "" {
+		msg.Options().Set(coapmsg.ProxyURI, proxyURI)
+	}
+	msg.Payload = payload
+	return &msg
+}
+
+func TestCoAPHandlerGet(t *testing.T) {
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("ETag", `"abcd"`)
+		w.Header().Set("Cache-Control", "public, max-age=120")
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+
+	req := proxyRequest(coapmsg.GET, srv.URL+"/status?verbose=1", nil)
+	req.Options().Set(coapmsg.Accept, coapmsg.AppJSON)
+	req.Options().Add(coapmsg.ETag, []byte{0x01})
+	res := coap.ServeMessage(&CoAPHandler{}, req)
+
+	if got == nil {
+		t.Fatal("HTTP server not called")
+	}
+	if got.URL.Path != "/status" || got.URL.RawQuery != "verbose=1" {
+		t.Error("Unexpected HTTP URL", got.URL)
+	}
+	if got.Header.Get("Accept") != "application/json" {
+		t.Error("Unexpected Accept header", got.Header.Get("Accept"))
+	}
+	if got.Header.Get("If-None-Match") != `"01"` {
+		t.Error("Unexpected If-None-Match header", got.Header.Get("If-None-Match"))
+	}
+
+	if res.Code != coapmsg.Content {
+		t.Error("Expected 2.05 but got", res.Code.Dotted())
+	}
+	opts := res.Options()
+	if opts.Get(coapmsg.ContentFormat).AsMediaType() != coapmsg.AppJSON {
+		t.Error("Expected Content-Format application/json")
+	}
+	if !bytes.Equal(opts.Get(coapmsg.ETag).AsBytes(), []byte{0xab, 0xcd}) {
+		t.Errorf("Unexpected ETag %x", opts.Get(coapmsg.ETag).AsBytes())
+	}
+	if v := opts.Get(coapmsg.MaxAge).AsUInt32(); v != 120 {
+		t.Error("Expected Max-Age 120 but got", v)
+	}
+	if string(res.Payload) != `{"ok":true}` {
+		t.Errorf("Unexpected payload %q", res.Payload)
+	}
+}
+
+func TestCoAPHandlerPost(t *testing.T) {
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		if r.Header.Get("Content-Type") != "application/cbor" {
+			t.Error("Unexpected Content-Type", r.Header.Get("Content-Type"))
+		}
+		w.Header().Set("Location", "/items/7?v=2")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	req := proxyRequest(coapmsg.POST, srv.URL+"/items", []byte{0xa0})
+	req.Options().Set(coapmsg.ContentFormat, coapmsg.AppCBOR)
+	res := coap.ServeMessage(&CoAPHandler{}, req)
+
+	if res.Code != coapmsg.Created {
+		t.Error("Expected 2.01 but got", res.Code.Dotted())
+	}
+	if !bytes.Equal(body, []byte{0xa0}) {
+		t.Errorf("Unexpected HTTP body %x", body)
+	}
+	var path []string
+	for _, p := range res.Options()[coapmsg.LocationPath] {
+		path = append(path, p.AsString())
+	}
+	if len(path) != 2 || path[0] != "items" || path[1] != "7" {
+		t.Error("Unexpected Location-Path", path)
+	}
+	if q := res.Options().Get(coapmsg.LocationQuery).AsString(); q != "v=2" {
+		t.Error("Unexpected Location-Query", q)
+	}
+}
+
+func TestCoAPHandlerErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		proxyURI string
+		code     coapmsg.COAPCode
+	}{
+		{srv.URL + "/x", coapmsg.NotFound},
+		{"coap+uart://any/x", coapmsg.ProxyingNotSupported},
+		{"", coapmsg.BadOption},
+		{"not a uri", coapmsg.BadOption},
+		{"http://127.0.0.1:1/x", coapmsg.BadGateway},
+	}
+	for _, test := range tests {
+		res := coap.ServeMessage(&CoAPHandler{}, proxyRequest(coapmsg.GET, test.proxyURI, nil))
+		if res.Code != test.code {
+			t.Errorf("Proxy-Uri %q: expected %s but got %s", test.proxyURI, test.code.Dotted(), res.Code.Dotted())
+		}
+	}
+}
+
+func TestCacheControlMaxAge(t *testing.T) {
+	tests := map[string]int64{
+		"max-age=10":         10,
+		"public, MAX-AGE=60": 60,
+		"no-store":           0,
+		"private":            -1,
+	}
+	for cc, exp := range tests {
+		age, ok := cacheControlMaxAge(cc)
+		if exp < 0 {
+			if ok {
+				t.Errorf("%q: expected no max-age", cc)
+			}
+			continue
+		}
+		if !ok || int64(age) != exp {
+			t.Errorf("%q: expected %d but got %d", cc, exp, age)
+		}
+	}
+}
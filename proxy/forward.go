@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// ForwardHandler is a CoAP forward proxy (RFC 7252, Section 5.7.2).
+//
+// Requests carrying a Proxy-Uri or Proxy-Scheme option are forwarded
+// with the transport registered for the scheme of the target URI, e.g.
+// a gateway can expose multiple serial buses behind one endpoint:
+//
+//	h := &proxy.ForwardHandler{
+//		Transports: map[string]coap.RoundTripper{
+//			coap.UartScheme: coap.DefaultTransport,
+//		},
+//	}
+//
+// The coap package has no unicast UDP transport, so only coap+uart
+// targets can be forwarded with its transports.
+//
+// Targets with "http" or "https" scheme are passed to HTTP if set.
+// Requests for other schemes, e.g. coap targets without a registered
+// transport, are answered with 5.05 Proxying Not Supported and a
+// diagnostic payload naming the scheme. The Observe option is not
+// forwarded, every request results in a single response.
+type ForwardHandler struct {
+	// Transports maps URI schemes to the transports used to forward requests
+	Transports map[string]coap.RoundTripper
+
+	// HTTP handles requests with http and https targets, e.g. a CoAPHandler
+	HTTP coap.Handler
+}
+
+// uriOptions are derived from the target URI and not forwarded
+var uriOptions = []coapmsg.OptionId{
+	coapmsg.URIHost, coapmsg.URIPort, coapmsg.URIPath, coapmsg.URIQuery,
+	coapmsg.ProxyURI, coapmsg.ProxyScheme, coapmsg.Observe,
+}
+
+func (h *ForwardHandler) ServeCOAP(w coap.ResponseWriter, r *coap.Request) {
+	target, err := targetURL(r)
+	if err != nil {
+		log.WithError(err).Debug("Invalid proxy request")
+		w.WriteCode(coapmsg.BadOption)
+		return
+	}
+
+	if (target.Scheme == "http" || target.Scheme == "https") && h.HTTP != nil {
+		h.HTTP.ServeCOAP(w, r)
+		return
+	}
+	rt := h.Transports[target.Scheme]
+	if rt == nil {
+		log.WithField("scheme", target.Scheme).Debug("Proxying not supported")
+		w.WriteCode(coapmsg.ProxyingNotSupported)
+		w.Write([]byte("no transport for " + target.Scheme + " targets"))
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteCode(coapmsg.BadRequest)
+		return
+	}
+	req, err := coap.NewRequest(r.Method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		w.WriteCode(coapmsg.BadOption)
+		return
+	}
+	req = req.WithContext(r.Context())
+	req.Confirmable = r.Confirmable
+	for id, values := range r.Options {
+		req.Options[id] = values
+	}
+	for _, id := range uriOptions {
+		req.Options.Del(id)
+	}
+
+	res, err := rt.RoundTrip(req)
+	if err != nil {
+		log.WithError(err).WithField("URL", target.String()).Warn("Failed to forward request")
+		if errors.Is(err, coap.ErrTimeout) {
+			w.WriteCode(coapmsg.GatewayTimeout)
+		} else {
+			w.WriteCode(coapmsg.BadGateway)
+		}
+		return
+	}
+	defer res.Body.Close()
+
+	payload, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		w.WriteCode(coapmsg.BadGateway)
+		return
+	}
+	for id, values := range res.Options {
+		w.Options()[id] = values
+	}
+	w.WriteCode(res.Code)
+	if len(payload) > 0 {
+		w.Write(payload)
+	}
+}
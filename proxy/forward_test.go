@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"testing"
+
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coap/coaptest"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+func TestForwardHandler(t *testing.T) {
+	var got *coap.Request
+	bus1 := &coaptest.HandlerTransport{Handler: coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		got = r
+		w.Options().Set(coapmsg.ContentFormat, coapmsg.TextPlain)
+		w.Write([]byte("22.5"))
+	})}
+	h := &ForwardHandler{
+		Transports: map[string]coap.RoundTripper{"coap+uart": bus1},
+	}
+
+	req := proxyRequest(coapmsg.GET, "coap+uart://bus1/temp?unit=C", nil)
+	req.Options().Set(coapmsg.Accept, coapmsg.TextPlain)
+	res := coap.ServeMessage(h, req)
+
+	if res.Code != coapmsg.Content || string(res.Payload) != "22.5" {
+		t.Fatalf("Unexpected response %s %q", res.Code.Dotted(), res.Payload)
+	}
+	if res.Options().Get(coapmsg.ContentFormat).IsNotSet() {
+		t.Error("Expected response options to be forwarded")
+	}
+	if got.URL.Path != "/temp" || got.URL.RawQuery != "unit=C" {
+		t.Error("Unexpected forwarded URL", got.URL)
+	}
+	if got.Options.Get(coapmsg.ProxyURI).IsSet() {
+		t.Error("Proxy-Uri must not be forwarded")
+	}
+	if got.Options.Get(coapmsg.Accept).IsNotSet() {
+		t.Error("Expected request options to be forwarded")
+	}
+}
+
+func TestForwardHandlerErrors(t *testing.T) {
+	failing := &coaptest.HandlerTransport{Err: fmt.Errorf("Failed to read ACK: %w", coap.ErrTimeout)}
+	h := &ForwardHandler{
+		Transports: map[string]coap.RoundTripper{"coap+uart": failing},
+	}
+
+	tests := []struct {
+		proxyURI string
+		code     coapmsg.COAPCode
+	}{
+		{"coap+uart://bus1/x", coapmsg.GatewayTimeout},
+		{"coap+tcp://host/x", coapmsg.ProxyingNotSupported},
+		{"http://host/x", coapmsg.ProxyingNotSupported},
+		{"", coapmsg.BadOption},
+	}
+	for _, test := range tests {
+		res := coap.ServeMessage(h, proxyRequest(coapmsg.GET, test.proxyURI, nil))
+		if res.Code != test.code {
+			t.Errorf("Proxy-Uri %q: expected %s but got %s", test.proxyURI, test.code.Dotted(), res.Code.Dotted())
+		}
+	}
+
+	h.HTTP = coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		w.WriteCode(coapmsg.Valid)
+	})
+	if res := coap.ServeMessage(h, proxyRequest(coapmsg.GET, "http://host/x", nil)); res.Code != coapmsg.Valid {
+		t.Error("Expected http targets to be passed to the HTTP handler")
+	}
+}
+
+func TestForwardHandlerUDPTarget(t *testing.T) {
+	h := &ForwardHandler{
+		Transports: map[string]coap.RoundTripper{coap.UartScheme: coap.DefaultTransport},
+	}
+	res := coap.ServeMessage(h, proxyRequest(coapmsg.GET, "coap://192.0.2.1/temp", nil))
+	if res.Code != coapmsg.ProxyingNotSupported {
+		t.Errorf("Expected 5.05 but got %s", res.Code.Dotted())
+	}
+	if string(res.Payload) != "no transport for coap targets" {
+		t.Errorf("Unexpected diagnostic payload %q", res.Payload)
+	}
+}
+
+func TestClientThroughForwardProxy(t *testing.T) {
+	bus := &coaptest.HandlerTransport{Handler: coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		w.Write([]byte("hello from " + r.URL.Path))
+	})}
+	gateway := &coaptest.HandlerTransport{Handler: &ForwardHandler{
+		Transports: map[string]coap.RoundTripper{"coap+uart": bus},
+	}}
+
+	proxyURL, _ := url.Parse("coap+uart://gateway")
+	client := coap.NewClient()
+	client.Transport = gateway
+	client.Proxy = coap.ProxyURL(proxyURL)
+
+	res, err := client.Get("coap+uart://bus2/greeting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.Code != coapmsg.Content || string(body) != "hello from /greeting" {
+		t.Errorf("Unexpected response %s %q", res.Status, body)
+	}
+}
@@ -0,0 +1,274 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// DefaultPrefix is the path prefix of the default HTTP-CoAP URI mapping
+const DefaultPrefix = "/hc/"
+
+// TargetURIParam is the query parameter carrying the target CoAP URI
+// when it is not appended to the path (RFC 8075, Section 5.4)
+const TargetURIParam = "target_uri"
+
+// HTTPHandler is an HTTP-to-CoAP proxy (RFC 8075).
+//
+// The target CoAP URI is the request path after Prefix, including the
+// query, e.g. "/hc/coap+uart://any/temperature?unit=C". An http.ServeMux
+// cleans the "//" of such paths and redirects, so the path form only
+// works when the handler is not mounted on a ServeMux. Otherwise the
+// target URI is passed escaped in the TargetURIParam query parameter,
+// e.g. "/hc/?target_uri=coap%2Buart%3A%2F%2Fany%2Ftemperature".
+//
+// HTTP headers are mapped to CoAP options: Content-Type to Content-Format,
+// Accept, If-Match and If-None-Match. CoAP responses are mapped back,
+// Max-Age becomes Cache-Control, ETag option values are hex encoded.
+//
+// GET requests accepting "text/event-stream" observe the resource and
+// stream all notifications as server-sent events until the HTTP client
+// disconnects or the CoAP server ends the observation.
+type HTTPHandler struct {
+	// Client is used to send CoAP requests, if nil coap.DefaultClient is used
+	Client *coap.Client
+
+	// Prefix is stripped from the request path to get the target URI.
+	// If empty DefaultPrefix is used.
+	Prefix string
+}
+
+func (h *HTTPHandler) client() *coap.Client {
+	if h.Client != nil {
+		return h.Client
+	}
+	return coap.DefaultClient
+}
+
+func (h *HTTPHandler) prefix() string {
+	if h.Prefix != "" {
+		return h.Prefix
+	}
+	return DefaultPrefix
+}
+
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target, err := h.target(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !coap.ValidMethod(r.Method) {
+		http.Error(w, "Method not supported by CoAP", http.StatusNotImplemented)
+		return
+	}
+
+	req, err := coap.NewRequest(r.Method, target.String(), r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req = req.WithContext(r.Context())
+	if err := setRequestOptions(req.Options, r.Header); err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	if r.Method == "GET" && strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		h.serveEvents(w, r, req)
+		return
+	}
+
+	res, err := h.client().Do(req)
+	if err != nil {
+		writeTransportError(w, err)
+		return
+	}
+	defer res.Body.Close()
+
+	payload, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		writeTransportError(w, err)
+		return
+	}
+	h.writeHeader(w.Header(), target, res)
+	w.WriteHeader(httpStatus(res.Code, len(payload) > 0))
+	w.Write(payload)
+}
+
+// target parses the CoAP URI from the request path or the
+// TargetURIParam query parameter
+func (h *HTTPHandler) target(r *http.Request) (*url.URL, error) {
+	if !strings.HasPrefix(r.URL.Path, h.prefix()) {
+		return nil, fmt.Errorf("%w: path must start with %s", ErrInvalidTarget, h.prefix())
+	}
+	raw := strings.TrimPrefix(r.URL.Path, h.prefix())
+	if raw == "" {
+		raw = r.URL.Query().Get(TargetURIParam)
+	} else if r.URL.RawQuery != "" {
+		raw += "?" + r.URL.RawQuery
+	}
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTarget, raw)
+	}
+	return u, nil
+}
+
+// setRequestOptions maps HTTP request headers to CoAP options
+func setRequestOptions(opts coapmsg.CoapOptions, header http.Header) error {
+	if ct := header.Get("Content-Type"); ct != "" {
+		mt, err := coapmsg.ParseMediaType(ct)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrUnsupportedType, ct)
+		}
+		opts.Set(coapmsg.ContentFormat, mt)
+	}
+	if mt, ok := parseAccept(header.Get("Accept")); ok {
+		opts.Set(coapmsg.Accept, mt)
+	}
+	if im := header.Get("If-Match"); im != "" {
+		if strings.TrimSpace(im) == "*" {
+			opts.Add(coapmsg.IfMatch, []byte{})
+		}
+		for _, etag := range parseETags(im) {
+			opts.Add(coapmsg.IfMatch, etag)
+		}
+	}
+	if inm := header.Get("If-None-Match"); inm != "" {
+		if strings.TrimSpace(inm) == "*" {
+			opts.Set(coapmsg.IfNoneMatch, []byte{})
+		}
+		// Entity tags are used to validate cached representations
+		for _, etag := range parseETags(inm) {
+			opts.Add(coapmsg.ETag, etag)
+		}
+	}
+	return nil
+}
+
+// writeHeader maps the options of a CoAP response to HTTP headers
+func (h *HTTPHandler) writeHeader(header http.Header, target *url.URL, res *coap.Response) {
+	if cf := res.Options.Get(coapmsg.ContentFormat); cf.IsSet() {
+		header.Set("Content-Type", contentType(cf.AsMediaType()))
+	}
+	if etag := res.Options.Get(coapmsg.ETag); etag.IsSet() {
+		header.Set("ETag", formatETag(etag.AsBytes()))
+	}
+	if res.Code.IsSuccess() {
+		header.Set("Cache-Control", "max-age="+strconv.Itoa(int(maxAge(res.Options).Seconds())))
+	}
+	if paths := res.Options[coapmsg.LocationPath]; len(paths) > 0 {
+		loc := url.URL{Scheme: target.Scheme, Host: target.Host}
+		for _, p := range paths {
+			loc.Path += "/" + p.AsString()
+		}
+		var query []string
+		for _, q := range res.Options[coapmsg.LocationQuery] {
+			query = append(query, q.AsString())
+		}
+		loc.RawQuery = strings.Join(query, "&")
+		header.Set("Location", h.prefix()+loc.String())
+	}
+}
+
+// serveEvents observes the resource and sends every notification
+// as server-sent event
+func (h *HTTPHandler) serveEvents(w http.ResponseWriter, r *http.Request, req *coap.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusNotImplemented)
+		return
+	}
+	req.Options.Set(coapmsg.Observe, 0)
+	res, err := h.client().Do(req)
+	if err != nil {
+		writeTransportError(w, err)
+		return
+	}
+	if !res.Code.IsSuccess() {
+		defer res.Body.Close()
+		payload, _ := ioutil.ReadAll(res.Body)
+		w.WriteHeader(httpStatus(res.Code, len(payload) > 0))
+		w.Write(payload)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		if err := writeEvent(w, res); err != nil {
+			return
+		}
+		flusher.Flush()
+		if res.Code.IsError() {
+			return
+		}
+
+		select {
+		case next, ok := <-res.Next():
+			if !ok {
+				return
+			}
+			res = next
+		case <-r.Context().Done():
+			if _, err := h.client().CancelObserve(res); err != nil {
+				log.WithError(err).Warn("Failed to cancel observe")
+			}
+			return
+		}
+	}
+}
+
+// writeEvent writes a notification as server-sent event.
+//
+// The event id is the Observe sequence number. Text payloads are sent
+// as data lines, binary payloads base64 encoded with event type "base64".
+// Error notifications have the event type "error" and the status as data.
+func writeEvent(w io.Writer, res *coap.Response) error {
+	payload, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	if obs := res.Options.Get(coapmsg.Observe); obs.IsSet() {
+		fmt.Fprintf(&b, "id: %d\n", obs.AsUInt32())
+	}
+	switch {
+	case res.Code.IsError():
+		b.WriteString("event: error\ndata: " + res.Status + "\n")
+	case utf8.Valid(payload):
+		for _, line := range strings.Split(string(payload), "\n") {
+			b.WriteString("data: " + line + "\n")
+		}
+	default:
+		b.WriteString("event: base64\ndata: " + base64.StdEncoding.EncodeToString(payload) + "\n")
+	}
+	b.WriteString("\n")
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// writeTransportError answers with 504 Gateway Timeout or 502 Bad Gateway
+func writeTransportError(w http.ResponseWriter, err error) {
+	status := http.StatusBadGateway
+	if errors.Is(err, coap.ErrTimeout) {
+		status = http.StatusGatewayTimeout
+	}
+	http.Error(w, err.Error(), status)
+}
@@ -0,0 +1,244 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coap/coaptest"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+func newTestHTTPHandler(h coap.Handler) (*HTTPHandler, *coaptest.HandlerTransport) {
+	tr := &coaptest.HandlerTransport{Handler: h}
+	client := coap.NewClient()
+	client.Transport = tr
+	return &HTTPHandler{Client: client}, tr
+}
+
+func TestHTTPHandlerGet(t *testing.T) {
+	var got *coap.Request
+	h, _ := newTestHTTPHandler(coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		got = r
+		w.Options().Set(coapmsg.ContentFormat, coapmsg.AppJSON)
+		w.Options().Set(coapmsg.ETag, []byte{0x0a, 0x0b})
+		w.Options().Set(coapmsg.MaxAge, 30)
+		w.Write([]byte(`{"t":22.5}`))
+	}))
+
+	req := httptest.NewRequest("GET", "http://gw/hc/coap+uart://any/temp?unit=C", nil)
+	req.Header.Set("Accept", "text/html;q=0.9, application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if got == nil {
+		t.Fatal("CoAP handler not called")
+	}
+	if got.URL.Path != "/temp" || got.URL.RawQuery != "unit=C" {
+		t.Error("Unexpected CoAP URL", got.URL)
+	}
+	if got.Options.Get(coapmsg.Accept).AsMediaType() != coapmsg.AppJSON {
+		t.Error("Expected Accept option application/json")
+	}
+
+	if rec.Code != http.StatusOK {
+		t.Error("Expected 200 but got", rec.Code)
+	}
+	exp := map[string]string{
+		"Content-Type":  "application/json",
+		"ETag":          `"0a0b"`,
+		"Cache-Control": "max-age=30",
+	}
+	for k, v := range exp {
+		if rec.Header().Get(k) != v {
+			t.Errorf("Expected header %s: %q but got %q", k, v, rec.Header().Get(k))
+		}
+	}
+	if rec.Body.String() != `{"t":22.5}` {
+		t.Error("Unexpected body", rec.Body.String())
+	}
+}
+
+func TestHTTPHandlerConditionalPut(t *testing.T) {
+	var got *coap.Request
+	var body []byte
+	h, _ := newTestHTTPHandler(coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		got = r
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteCode(coapmsg.PreconditionFailed)
+	}))
+
+	req := httptest.NewRequest("PUT", "http://gw/hc/coap+uart://any/config", strings.NewReader("x=1"))
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("If-Match", `"0a0b"`)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Error("Expected 412 but got", rec.Code)
+	}
+	if got.Method != "PUT" || string(body) != "x=1" {
+		t.Errorf("Unexpected CoAP request %s %q", got.Method, body)
+	}
+	if got.Options.Get(coapmsg.ContentFormat).AsMediaType() != coapmsg.TextPlain {
+		t.Error("Expected Content-Format text/plain")
+	}
+	if !bytes.Equal(got.Options.Get(coapmsg.IfMatch).AsBytes(), []byte{0x0a, 0x0b}) {
+		t.Errorf("Unexpected If-Match %x", got.Options.Get(coapmsg.IfMatch).AsBytes())
+	}
+}
+
+func TestHTTPHandlerCreatedLocation(t *testing.T) {
+	h, _ := newTestHTTPHandler(coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		w.Options().Add(coapmsg.LocationPath, "items")
+		w.Options().Add(coapmsg.LocationPath, "7")
+		w.WriteCode(coapmsg.Created)
+	}))
+
+	req := httptest.NewRequest("POST", "http://gw/hc/coap+uart://any/items", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Error("Expected 201 but got", rec.Code)
+	}
+	if loc := rec.Header().Get("Location"); loc != "/hc/coap+uart://any/items/7" {
+		t.Error("Unexpected Location", loc)
+	}
+}
+
+func TestHTTPHandlerErrors(t *testing.T) {
+	h, tr := newTestHTTPHandler(coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {}))
+
+	tests := []struct {
+		method, url, contentType string
+		status                   int
+	}{
+		{"GET", "http://gw/other/coap+uart://any/x", "", http.StatusBadRequest},
+		{"GET", "http://gw/hc/no-uri", "", http.StatusBadRequest},
+		{"PATCH", "http://gw/hc/coap+uart://any/x", "", http.StatusNotImplemented},
+		{"POST", "http://gw/hc/coap+uart://any/x", "application/x-unknown", http.StatusUnsupportedMediaType},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.url, nil)
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%s %s: expected %d but got %d", test.method, test.url, test.status, rec.Code)
+		}
+	}
+
+	tr.Err = fmt.Errorf("Failed to read ACK: %w", coap.ErrTimeout)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "http://gw/hc/coap+uart://any/x", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Error("Expected 504 for CoAP timeout but got", rec.Code)
+	}
+
+	tr.Err = errors.New("serial port gone")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "http://gw/hc/coap+uart://any/x", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Error("Expected 502 for transport error but got", rec.Code)
+	}
+}
+
+func TestHTTPHandlerServeMux(t *testing.T) {
+	var got *coap.Request
+	h, _ := newTestHTTPHandler(coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		got = r
+		w.Write([]byte("22.5"))
+	}))
+	mux := http.NewServeMux()
+	mux.Handle(DefaultPrefix, h)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	client := server.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	target := url.QueryEscape("coap+uart://any/temp?unit=C")
+	res, err := client.Get(server.URL + "/hc/?" + TargetURIParam + "=" + target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(body) != "22.5" {
+		t.Fatalf("Unexpected response %d %q", res.StatusCode, body)
+	}
+	if got.URL.Path != "/temp" || got.URL.RawQuery != "unit=C" {
+		t.Error("Unexpected CoAP URL", got.URL)
+	}
+
+	// The ServeMux redirects the path form to a cleaned path
+	got = nil
+	res, err = client.Get(server.URL + "/hc/coap+uart://any/temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMovedPermanently || got != nil {
+		t.Errorf("Expected ServeMux to redirect the path form but got %d", res.StatusCode)
+	}
+}
+
+func TestWriteEvent(t *testing.T) {
+	opts := coapmsg.CoapOptions{}
+	opts.Set(coapmsg.Observe, 12)
+	tests := []struct {
+		res *coap.Response
+		exp string
+	}{
+		{&coap.Response{Code: coapmsg.Content, Options: opts, Body: ioutil.NopCloser(strings.NewReader("a\nb"))},
+			"id: 12\ndata: a\ndata: b\n\n"},
+		{&coap.Response{Code: coapmsg.Content, Options: opts, Body: ioutil.NopCloser(bytes.NewReader([]byte{0xff, 0xfe}))},
+			"id: 12\nevent: base64\ndata: //4=\n\n"},
+		{&coap.Response{Code: coapmsg.NotFound, Status: "4.04 NotFound", Body: ioutil.NopCloser(strings.NewReader(""))},
+			"event: error\ndata: 4.04 NotFound\n\n"},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		if err := writeEvent(&b, test.res); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != test.exp {
+			t.Errorf("Expected event %q but got %q", test.exp, b.String())
+		}
+	}
+}
+
+func TestStatusMapping(t *testing.T) {
+	tests := []struct {
+		code       coapmsg.COAPCode
+		hasPayload bool
+		status     int
+	}{
+		{coapmsg.Content, true, http.StatusOK},
+		{coapmsg.Changed, false, http.StatusNoContent},
+		{coapmsg.Changed, true, http.StatusOK},
+		{coapmsg.Valid, false, http.StatusNotModified},
+		{coapmsg.Unauthorized, false, http.StatusForbidden},
+		{coapmsg.MethodNotAllowed, false, http.StatusBadRequest},
+		{coapmsg.TooManyRequests, false, http.StatusTooManyRequests},
+		{coapmsg.ProxyingNotSupported, false, http.StatusBadGateway},
+		{coapmsg.BuildCode(4, 31), false, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if got := httpStatus(test.code, test.hasPayload); got != test.status {
+			t.Errorf("%s: expected %d but got %d", test.code.Dotted(), test.status, got)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+// Package proxy implements cross-proxies between HTTP and CoAP
+// following RFC 8075.
+//
+// An HTTPHandler is an http.Handler that forwards HTTP requests to CoAP
+// servers using a coap.Client. The target CoAP URI is appended to the
+// handler prefix (default mapping of RFC 8075, Section 5.3):
+//
+//	http.ListenAndServe(":8080", &proxy.HTTPHandler{})
+//	// GET http://gateway/hc/coap+uart://any/temperature
+//
+// An http.ServeMux cleans the "//" of the target URI in the path and
+// redirects the request. Behind a ServeMux the target URI is passed in
+// the target_uri query parameter instead (RFC 8075, Section 5.4):
+//
+//	http.Handle("/hc/", &proxy.HTTPHandler{})
+//	// GET http://gateway/hc/?target_uri=coap%2Buart%3A%2F%2Fany%2Ftemperature
+//
+// A CoAPHandler is a coap.Handler that forwards CoAP requests carrying a
+// Proxy-Uri or Proxy-Scheme option to HTTP servers using net/http.
+//
+// A ForwardHandler is a CoAP forward proxy that dispatches requests to
+// the transport of the target URI scheme.
+package proxy
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+var log logrus.FieldLogger = logrus.StandardLogger()
+
+func SetLogger(logger logrus.FieldLogger) {
+	log = logger
+}
+
+// Errors returned when a proxy request can not be mapped
+var (
+	ErrNoProxyOption   = errors.New("proxy: request has no Proxy-Uri or Proxy-Scheme option")
+	ErrInvalidTarget   = errors.New("proxy: invalid target URI")
+	ErrUnsupportedType = errors.New("proxy: unsupported media type")
+)
+
+// DefaultMaxAge is used for Cache-Control when a CoAP response has no Max-Age option
+const DefaultMaxAge = 60 * time.Second
+
+// httpStatus maps a CoAP response code to a HTTP status code
+// (RFC 8075, Section 7)
+func httpStatus(code coapmsg.COAPCode, hasPayload bool) int {
+	switch code {
+	case coapmsg.Created:
+		return http.StatusCreated
+	case coapmsg.Deleted, coapmsg.Changed:
+		if hasPayload {
+			return http.StatusOK
+		}
+		return http.StatusNoContent
+	case coapmsg.Valid:
+		return http.StatusNotModified
+	case coapmsg.Content, coapmsg.Continue:
+		return http.StatusOK
+	case coapmsg.BadRequest, coapmsg.BadOption, coapmsg.MethodNotAllowed, coapmsg.RequestEntityIncomplete:
+		return http.StatusBadRequest
+	case coapmsg.Unauthorized, coapmsg.Forbidden:
+		return http.StatusForbidden
+	case coapmsg.NotFound:
+		return http.StatusNotFound
+	case coapmsg.NotAcceptable:
+		return http.StatusNotAcceptable
+	case coapmsg.Conflict:
+		return http.StatusConflict
+	case coapmsg.PreconditionFailed:
+		return http.StatusPreconditionFailed
+	case coapmsg.RequestEntityTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case coapmsg.UnsupportedMediaType:
+		return http.StatusUnsupportedMediaType
+	case coapmsg.UnprocessableEntity:
+		return http.StatusUnprocessableEntity
+	case coapmsg.TooManyRequests:
+		return http.StatusTooManyRequests
+	case coapmsg.NotImplemented:
+		return http.StatusNotImplemented
+	case coapmsg.BadGateway, coapmsg.ProxyingNotSupported, coapmsg.HopLimitReached:
+		return http.StatusBadGateway
+	case coapmsg.ServiceUnavailable:
+		return http.StatusServiceUnavailable
+	case coapmsg.GatewayTimeout:
+		return http.StatusGatewayTimeout
+	}
+	switch {
+	case code.IsSuccess():
+		return http.StatusOK
+	case code.IsClientError():
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// coapCode maps a HTTP status code to a CoAP response code
+// for a request with the given method (RFC 8075, Section 7)
+func coapCode(status int, method string) coapmsg.COAPCode {
+	switch status {
+	case http.StatusOK:
+		switch method {
+		case "GET":
+			return coapmsg.Content
+		case "DELETE":
+			return coapmsg.Deleted
+		}
+		return coapmsg.Changed
+	case http.StatusNoContent:
+		if method == "DELETE" {
+			return coapmsg.Deleted
+		}
+		return coapmsg.Changed
+	case http.StatusCreated:
+		return coapmsg.Created
+	case http.StatusNotModified:
+		return coapmsg.Valid
+	case http.StatusUnauthorized:
+		return coapmsg.Unauthorized
+	case http.StatusForbidden:
+		return coapmsg.Forbidden
+	case http.StatusNotFound:
+		return coapmsg.NotFound
+	case http.StatusMethodNotAllowed:
+		return coapmsg.MethodNotAllowed
+	case http.StatusNotAcceptable:
+		return coapmsg.NotAcceptable
+	case http.StatusConflict:
+		return coapmsg.Conflict
+	case http.StatusPreconditionFailed:
+		return coapmsg.PreconditionFailed
+	case http.StatusRequestEntityTooLarge:
+		return coapmsg.RequestEntityTooLarge
+	case http.StatusUnsupportedMediaType:
+		return coapmsg.UnsupportedMediaType
+	case http.StatusUnprocessableEntity:
+		return coapmsg.UnprocessableEntity
+	case http.StatusTooManyRequests:
+		return coapmsg.TooManyRequests
+	case http.StatusNotImplemented:
+		return coapmsg.NotImplemented
+	case http.StatusBadGateway:
+		return coapmsg.BadGateway
+	case http.StatusServiceUnavailable:
+		return coapmsg.ServiceUnavailable
+	case http.StatusGatewayTimeout:
+		return coapmsg.GatewayTimeout
+	}
+	switch {
+	case status >= 200 && status < 300:
+		return coapCode(http.StatusOK, method)
+	case status >= 400 && status < 500:
+		return coapmsg.BadRequest
+	case status >= 500:
+		return coapmsg.InternalServerError
+	}
+	// Redirects and informational responses can not be forwarded
+	return coapmsg.BadGateway
+}
+
+// contentType returns the HTTP Content-Type of a CoAP content format
+func contentType(mt coapmsg.MediaType) string {
+	if s := mt.String(); !strings.HasPrefix(s, "MediaType(") {
+		return s
+	}
+	return "application/octet-stream"
+}
+
+// parseAccept returns the first CoAP content format of a
+// HTTP Accept header that has a CoAP equivalent
+func parseAccept(accept string) (coapmsg.MediaType, bool) {
+	for _, part := range strings.Split(accept, ",") {
+		var params []string
+		for _, p := range strings.Split(part, ";") {
+			if !strings.HasPrefix(strings.TrimSpace(p), "q=") {
+				params = append(params, p)
+			}
+		}
+		if mt, err := coapmsg.ParseMediaType(strings.Join(params, ";")); err == nil {
+			return mt, true
+		}
+	}
+	return 0, false
+}
+
+// formatETag encodes an ETag option value as HTTP entity tag
+func formatETag(etag []byte) string {
+	return `"` + hex.EncodeToString(etag) + `"`
+}
+
+// parseETags decodes a list of HTTP entity tags. Tags that are no hex
+// encoded CoAP ETags are used as raw bytes if they are short enough.
+func parseETags(header string) [][]byte {
+	var tags [][]byte
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		t = strings.Trim(t, `"`)
+		if t == "" {
+			continue
+		}
+		if b, err := hex.DecodeString(t); err == nil && len(b) <= 8 {
+			tags = append(tags, b)
+		} else if len(t) <= 8 {
+			tags = append(tags, []byte(t))
+		}
+	}
+	return tags
+}
+
+// maxAge returns the Max-Age of a CoAP response
+func maxAge(opts coapmsg.CoapOptions) time.Duration {
+	v := opts.Get(coapmsg.MaxAge)
+	if v.IsNotSet() {
+		return DefaultMaxAge
+	}
+	return time.Duration(v.AsUInt32()) * time.Second
+}
+
+// targetURL returns the URI a CoAP proxy request is forwarded to.
+// It is taken from the Proxy-Uri option or built from the request URI
+// with the scheme of the Proxy-Scheme option (RFC 7252, Section 5.10.2).
+func targetURL(r *coap.Request) (*url.URL, error) {
+	if v := r.Options.Get(coapmsg.ProxyURI); v.IsSet() {
+		u, err := url.Parse(v.AsString())
+		if err != nil || !u.IsAbs() || u.Host == "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTarget, v.AsString())
+		}
+		return u, nil
+	}
+	if v := r.Options.Get(coapmsg.ProxyScheme); v.IsSet() {
+		u := *r.URL
+		u.Scheme = v.AsString()
+		if u.Host == "" {
+			return nil, fmt.Errorf("%w: missing Uri-Host", ErrInvalidTarget)
+		}
+		return &u, nil
+	}
+	return nil, ErrNoProxyOption
+}
@@ -0,0 +1,252 @@
+package rd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// RetryInterval is the time Endpoint.Run waits before a failed
+// registration is repeated
+const RetryInterval = 30 * time.Second
+
+// An Endpoint registers its links at a Resource Directory
+// (RFC 9176, Section 5.3).
+//
+// The registration is refreshed by Run before the lifetime expires.
+// An Endpoint is safe for concurrent use.
+type Endpoint struct {
+	// Client is used to send requests. If nil, coap.DefaultClient is used.
+	Client *coap.Client
+
+	// RD is the URL of the registration resource of the Resource
+	// Directory, e.g. coap+uart://any/rd
+	RD string
+
+	Name         string // Endpoint name (ep), required
+	Sector       string // Sector (d), optional
+	EndpointType string // Endpoint type (et), optional
+
+	// Base is the URI the links are relative to. If empty, the
+	// Resource Directory uses the source address of the request.
+	// Set when registering on behalf of another device.
+	Base string
+
+	// Lifetime of the registration. 0 = DefaultLifetime
+	Lifetime time.Duration
+
+	// Params are additional registration parameters, e.g. lwm2m=1.1
+	Params map[string]string
+
+	Links []Link
+
+	mu       sync.Mutex
+	location string // URL of the registration resource
+
+	after func(time.Duration) <-chan time.Time
+}
+
+// Location returns the URL of the registration resource or an empty
+// string if the endpoint is not registered.
+func (e *Endpoint) Location() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.location
+}
+
+func (e *Endpoint) client() *coap.Client {
+	if e.Client != nil {
+		return e.Client
+	}
+	return coap.DefaultClient
+}
+
+func (e *Endpoint) lifetime() time.Duration {
+	if e.Lifetime > 0 {
+		return e.Lifetime
+	}
+	return DefaultLifetime
+}
+
+// Register creates or replaces the registration of the endpoint
+func (e *Endpoint) Register() error {
+	query := []string{"ep=" + e.Name}
+	if e.Sector != "" {
+		query = append(query, "d="+e.Sector)
+	}
+	if e.EndpointType != "" {
+		query = append(query, "et="+e.EndpointType)
+	}
+	names := make([]string, 0, len(e.Params))
+	for name := range e.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		query = append(query, name+"="+e.Params[name])
+	}
+	query = append(query, e.updateQuery()...)
+
+	res, err := e.client().Post(e.RD+"?"+strings.Join(query, "&"), coapmsg.AppLinkFormat, strings.NewReader(FormatLinks(e.Links)))
+	if err != nil {
+		return fmt.Errorf("rd: registration failed: %w", err)
+	}
+	defer res.Body.Close()
+	if res.Code != coapmsg.Created {
+		return unexpectedResponse(res)
+	}
+
+	location, err := locationURL(e.RD, res.Options)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.location = location
+	e.mu.Unlock()
+	log.WithField("ep", e.Name).WithField("location", location).Info("Registered at Resource Directory")
+	return nil
+}
+
+// updateQuery returns the parameters sent with registrations and updates
+func (e *Endpoint) updateQuery() []string {
+	query := []string{"lt=" + strconv.Itoa(int(e.lifetime()/time.Second))}
+	if e.Base != "" {
+		query = append(query, "base="+e.Base)
+	}
+	return query
+}
+
+// Update refreshes the registration and resets its lifetime
+// (RFC 9176, Section 5.3.1). If the Resource Directory lost the
+// registration, ErrNotRegistered is returned.
+func (e *Endpoint) Update() error {
+	location := e.Location()
+	if location == "" {
+		return ErrNotRegistered
+	}
+	req, err := coap.NewRequest("POST", location+"?"+strings.Join(e.updateQuery(), "&"), nil)
+	if err != nil {
+		return err
+	}
+	res, err := e.client().Do(req)
+	if err != nil {
+		return fmt.Errorf("rd: registration update failed: %w", err)
+	}
+	defer res.Body.Close()
+	switch res.Code {
+	case coapmsg.Changed:
+		return nil
+	case coapmsg.NotFound:
+		e.mu.Lock()
+		e.location = ""
+		e.mu.Unlock()
+		return ErrNotRegistered
+	}
+	return unexpectedResponse(res)
+}
+
+// Deregister removes the registration (RFC 9176, Section 5.3.2)
+func (e *Endpoint) Deregister() error {
+	location := e.Location()
+	if location == "" {
+		return ErrNotRegistered
+	}
+	req, err := coap.NewRequest("DELETE", location, nil)
+	if err != nil {
+		return err
+	}
+	res, err := e.client().Do(req)
+	if err != nil {
+		return fmt.Errorf("rd: deregistration failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	e.mu.Lock()
+	e.location = ""
+	e.mu.Unlock()
+	if res.Code != coapmsg.Deleted && res.Code != coapmsg.NotFound {
+		return unexpectedResponse(res)
+	}
+	log.WithField("ep", e.Name).Info("Deregistered from Resource Directory")
+	return nil
+}
+
+// Run registers the endpoint and refreshes the registration before its
+// lifetime expires, until ctx is done. Failed registrations are repeated
+// after RetryInterval. When ctx is done the endpoint is deregistered
+// and the result of the deregistration is returned.
+func (e *Endpoint) Run(ctx context.Context) error {
+	for {
+		var err error
+		if e.Location() == "" {
+			err = e.Register()
+		} else if err = e.Update(); err == ErrNotRegistered {
+			err = e.Register()
+		}
+
+		wait := refreshInterval(e.lifetime())
+		if err != nil {
+			log.WithError(err).WithField("ep", e.Name).Warn("Resource Directory registration failed")
+			wait = RetryInterval
+		}
+
+		select {
+		case <-ctx.Done():
+			if e.Location() == "" {
+				return nil
+			}
+			return e.Deregister()
+		case <-e.wait(wait):
+		}
+	}
+}
+
+func (e *Endpoint) wait(d time.Duration) <-chan time.Time {
+	if e.after != nil {
+		return e.after(d)
+	}
+	return time.After(d)
+}
+
+// refreshInterval returns the time after which a registration with the
+// given lifetime is refreshed, leaving a margin for retransmissions.
+func refreshInterval(lt time.Duration) time.Duration {
+	margin := lt / 10
+	if margin > time.Minute {
+		margin = time.Minute
+	}
+	return lt - margin
+}
+
+// locationURL resolves the Location-Path of a response against the
+// URL the request was sent to
+func locationURL(rawurl string, opts coapmsg.CoapOptions) (string, error) {
+	var path []string
+	for _, p := range opts[coapmsg.LocationPath] {
+		path = append(path, p.AsString())
+	}
+	if len(path) == 0 {
+		return "", errors.New("rd: registration response without Location-Path")
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	return u.ResolveReference(&url.URL{Path: "/" + strings.Join(path, "/")}).String(), nil
+}
+
+func unexpectedResponse(res *coap.Response) error {
+	if err := res.Err(); err != nil {
+		return fmt.Errorf("rd: %w", err)
+	}
+	return fmt.Errorf("rd: unexpected response %s", res.Status)
+}
@@ -0,0 +1,127 @@
+package rd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+func TestEndpointRegistration(t *testing.T) {
+	s := NewServer()
+	ep := &Endpoint{
+		Client:   newTestClient(s),
+		RD:       "coap+uart://rd/rd",
+		Name:     "node1",
+		Sector:   "floor1",
+		Base:     "coap+uart://ttyUSB0",
+		Lifetime: 2 * time.Minute,
+		Links:    []Link{{Target: "/temp", Params: map[string]string{"rt": "temperature"}}},
+	}
+
+	if err := ep.Update(); err != ErrNotRegistered {
+		t.Error("Expected ErrNotRegistered but got", err)
+	}
+	if err := ep.Register(); err != nil {
+		t.Fatal(err)
+	}
+	if ep.Location() != "coap+uart://rd/rd/1" {
+		t.Error("Unexpected location", ep.Location())
+	}
+	regs := s.Registrations()
+	if len(regs) != 1 || regs[0].Sector != "floor1" || regs[0].Base != ep.Base || regs[0].Lifetime != ep.Lifetime {
+		t.Fatal("Unexpected registration", regs)
+	}
+	if len(regs[0].Links) != 1 || regs[0].Links[0].Param("rt") != "temperature" {
+		t.Error("Unexpected links", regs[0].Links)
+	}
+
+	if err := ep.Update(); err != nil {
+		t.Error(err)
+	}
+	if err := ep.Deregister(); err != nil {
+		t.Error(err)
+	}
+	if ep.Location() != "" || len(s.Registrations()) != 0 {
+		t.Error("Expected endpoint to be deregistered")
+	}
+}
+
+func TestEndpointRun(t *testing.T) {
+	s := NewServer()
+	waits := make(chan time.Duration)
+	tick := make(chan time.Time)
+	ep := &Endpoint{
+		Client:   newTestClient(s),
+		RD:       "coap+uart://rd/rd",
+		Name:     "node1",
+		Base:     "coap+uart://ttyUSB0",
+		Lifetime: time.Minute,
+		after: func(d time.Duration) <-chan time.Time {
+			waits <- d
+			return tick
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- ep.Run(ctx)
+	}()
+
+	if d := <-waits; d != 54*time.Second {
+		t.Error("Expected refresh after 54s but got", d)
+	}
+	if len(s.Registrations()) != 1 {
+		t.Fatal("Expected endpoint to be registered")
+	}
+
+	// The RD lost the registration, Run registers again
+	s.mu.Lock()
+	s.regs = map[string]*Registration{}
+	s.mu.Unlock()
+	tick <- time.Now()
+	<-waits
+	if regs := s.Registrations(); len(regs) != 1 || regs[0].Location != "/rd/2" {
+		t.Fatal("Expected endpoint to be registered again", regs)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	if len(s.Registrations()) != 0 {
+		t.Error("Expected endpoint to be deregistered on shutdown")
+	}
+}
+
+func TestEndpointRunRetry(t *testing.T) {
+	failing := coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		w.WriteCode(coapmsg.ServiceUnavailable)
+	})
+	waits := make(chan time.Duration, 1)
+	ep := &Endpoint{
+		Client: newTestClient(failing),
+		RD:     "coap+uart://rd/rd",
+		Name:   "node1",
+		after: func(d time.Duration) <-chan time.Time {
+			waits <- d
+			return nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- ep.Run(ctx)
+	}()
+	if d := <-waits; d != RetryInterval {
+		t.Error("Expected retry after RetryInterval but got", d)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Error("Expected no error without registration but got", err)
+	}
+}
@@ -0,0 +1,128 @@
+package rd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidLink is returned when a link-format document can not be parsed
+var ErrInvalidLink = errors.New("rd: invalid link format")
+
+// A Link is a single web link of a CoRE Link Format document
+// (RFC 6690), e.g. </sensors/temp>;rt="temperature";if="sensor".
+//
+// Params with an empty value are written as flags, e.g. ;obs
+type Link struct {
+	Target string
+	Params map[string]string
+}
+
+// Param returns the value of the link parameter with the given name
+func (l Link) Param(name string) string {
+	return l.Params[name]
+}
+
+// String returns the link in CoRE Link Format. Parameters are ordered
+// by name, numeric values are written without quotes.
+func (l Link) String() string {
+	s := "<" + l.Target + ">"
+	names := make([]string, 0, len(l.Params))
+	for name := range l.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := l.Params[name]
+		switch {
+		case v == "":
+			s += ";" + name
+		case isNumber(v):
+			s += ";" + name + "=" + v
+		default:
+			s += ";" + name + "=" + strconv.Quote(v)
+		}
+	}
+	return s
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 32)
+	return err == nil
+}
+
+// FormatLinks returns the links as CoRE Link Format document
+func FormatLinks(links []Link) string {
+	s := make([]string, len(links))
+	for i, l := range links {
+		s[i] = l.String()
+	}
+	return strings.Join(s, ",")
+}
+
+// ParseLinks parses a CoRE Link Format document
+func ParseLinks(s string) ([]Link, error) {
+	var links []Link
+	for _, part := range splitQuoted(s, ',') {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := splitQuoted(part, ';')
+		target := strings.TrimSpace(fields[0])
+		if len(target) < 2 || target[0] != '<' || target[len(target)-1] != '>' {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidLink, part)
+		}
+		l := Link{Target: target[1 : len(target)-1], Params: map[string]string{}}
+		for _, f := range fields[1:] {
+			name, value := strings.TrimSpace(f), ""
+			if i := strings.IndexByte(name, '='); i >= 0 {
+				name, value = strings.TrimSpace(name[:i]), strings.TrimSpace(name[i+1:])
+			}
+			if name == "" {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidLink, part)
+			}
+			if len(value) >= 2 && value[0] == '"' {
+				unquoted, err := strconv.Unquote(value)
+				if err != nil {
+					return nil, fmt.Errorf("%w: %q", ErrInvalidLink, part)
+				}
+				value = unquoted
+			}
+			l.Params[name] = value
+		}
+		links = append(links, l)
+	}
+	return links, nil
+}
+
+// splitQuoted splits s at every sep that is not inside a quoted string
+// or a link target
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, target, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			if !target {
+				quoted = !quoted
+			}
+		case '<':
+			target = target || !quoted
+		case '>':
+			target = false
+		case sep:
+			if !quoted && !target {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
@@ -0,0 +1,50 @@
+package rd
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseLinks(t *testing.T) {
+	links, err := ParseLinks(`</sensors/temp>;rt="temperature-c";if="sensor";ct=0;obs, </a,b>;title="x, y; z",</3/0>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 3 {
+		t.Fatal("Expected 3 links but got", len(links))
+	}
+	if links[0].Target != "/sensors/temp" || links[0].Param("rt") != "temperature-c" || links[0].Param("ct") != "0" {
+		t.Error("Unexpected link", links[0])
+	}
+	if _, ok := links[0].Params["obs"]; !ok {
+		t.Error("Expected obs flag")
+	}
+	if links[1].Target != "/a,b" || links[1].Param("title") != "x, y; z" {
+		t.Error("Unexpected link", links[1])
+	}
+	if links[2].Target != "/3/0" || len(links[2].Params) != 0 {
+		t.Error("Unexpected link", links[2])
+	}
+
+	if links, err := ParseLinks(""); err != nil || len(links) != 0 {
+		t.Error("Expected no links", links, err)
+	}
+	if _, err := ParseLinks("/no/brackets"); !errors.Is(err, ErrInvalidLink) {
+		t.Error("Expected ErrInvalidLink but got", err)
+	}
+}
+
+func TestFormatLinks(t *testing.T) {
+	links := []Link{
+		{Target: "/sensors/temp", Params: map[string]string{"rt": "temperature", "ct": "0", "obs": ""}},
+		{Target: "/3/0"},
+	}
+	s := FormatLinks(links)
+	if s != `</sensors/temp>;ct=0;obs;rt="temperature",</3/0>` {
+		t.Error("Unexpected link format", s)
+	}
+	parsed, err := ParseLinks(s)
+	if err != nil || len(parsed) != 2 || parsed[0].Param("rt") != "temperature" {
+		t.Error("Failed to parse formatted links", parsed, err)
+	}
+}
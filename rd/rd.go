@@ -0,0 +1,96 @@
+// Package rd implements the CoRE Resource Directory (RFC 9176).
+//
+// An Endpoint registers itself, or on behalf of a device, at a Resource
+// Directory and keeps the registration alive:
+//
+//	ep := &rd.Endpoint{
+//		RD:    "coap+uart://any/rd",
+//		Name:  "node1",
+//		Links: []rd.Link{{Target: "/sensors/temp", Params: map[string]string{"rt": "temperature"}}},
+//	}
+//	go ep.Run(ctx)
+//
+// A gateway registers each device it is connected to with its own
+// Endpoint and sets Base to the URI of the device, e.g.
+// coap+uart://ttyUSB0.
+//
+// A Server is an in-process Resource Directory that can be served by
+// any coap.Handler based server.
+package rd
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+var log logrus.FieldLogger = logrus.StandardLogger()
+
+func SetLogger(logger logrus.FieldLogger) {
+	log = logger
+}
+
+// DefaultLifetime of a registration when no lt parameter is given
+// (RFC 9176, Section 5)
+const DefaultLifetime = 90000 * time.Second
+
+// Paths of the Resource Directory interfaces served by Server
+const (
+	RegistrationPath   = "/rd"
+	EndpointLookupPath = "/rd-lookup/ep"
+	ResourceLookupPath = "/rd-lookup/res"
+	WellKnownCorePath  = "/.well-known/core"
+)
+
+// Resource types of the Resource Directory interfaces
+// used for discovery via /.well-known/core
+const (
+	RegistrationType   = "core.rd"
+	EndpointLookupType = "core.rd-lookup-ep"
+	ResourceLookupType = "core.rd-lookup-res"
+	EndpointType       = "core.rd-ep"
+)
+
+// ErrNotRegistered is returned by Endpoint.Update and
+// Endpoint.Deregister when the endpoint has no registration
+var ErrNotRegistered = errors.New("rd: endpoint is not registered")
+
+// queryParams returns the Uri-Query options of a request as map.
+// Options without "=" are mapped to an empty value.
+func queryParams(opts coapmsg.CoapOptions) map[string]string {
+	params := map[string]string{}
+	for _, q := range opts[coapmsg.URIQuery] {
+		name, value := q.AsString(), ""
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			name, value = name[:i], name[i+1:]
+		}
+		params[name] = value
+	}
+	return params
+}
+
+// matches reports whether a parameter value matches the lookup filter.
+// A filter ending with "*" matches every value with the given prefix
+// (RFC 9176, Section 7). Values like rt="temperature sensor" are
+// matched if any of the space separated values matches.
+func matches(filter, value string) bool {
+	if matchesValue(filter, value) {
+		return true
+	}
+	for _, v := range strings.Fields(value) {
+		if matchesValue(filter, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesValue(filter, value string) bool {
+	if prefix := strings.TrimSuffix(filter, "*"); prefix != filter {
+		return strings.HasPrefix(value, prefix)
+	}
+	return filter == value
+}
@@ -0,0 +1,487 @@
+package rd
+
+import (
+	"io/ioutil"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+// Registration is an endpoint registered at a Server
+type Registration struct {
+	Location     string // path of the registration resource, e.g. /rd/1
+	Endpoint     string // ep
+	Sector       string // d
+	Base         string // base URI the links are relative to
+	EndpointType string // et
+	Lifetime     time.Duration
+	Expires      time.Time
+	Links        []Link
+
+	// Params are additional registration parameters, e.g. lwm2m=1.1
+	Params map[string]string
+}
+
+// attr returns the value of an endpoint attribute used by lookups
+func (r *Registration) attr(name string) (string, bool) {
+	switch name {
+	case "ep":
+		return r.Endpoint, true
+	case "d":
+		return r.Sector, true
+	case "base":
+		return r.Base, true
+	case "et":
+		return r.EndpointType, true
+	case "lt":
+		return strconv.Itoa(int(r.Lifetime / time.Second)), true
+	case "href":
+		return r.Location, true
+	}
+	return "", false
+}
+
+// link returns the registration as link of an endpoint lookup
+func (r *Registration) link() Link {
+	l := Link{Target: r.Location, Params: map[string]string{
+		"ep":   r.Endpoint,
+		"base": r.Base,
+		"rt":   EndpointType,
+	}}
+	if r.Sector != "" {
+		l.Params["d"] = r.Sector
+	}
+	if r.EndpointType != "" {
+		l.Params["et"] = r.EndpointType
+	}
+	return l
+}
+
+// resourceLinks returns the links of the registration with targets
+// and anchors resolved against the base URI (RFC 9176, Section 6.3)
+func (r *Registration) resourceLinks() []Link {
+	base, err := url.Parse(r.Base)
+	if err != nil {
+		return nil
+	}
+	resolve := func(ref string) string {
+		u, err := url.Parse(ref)
+		if err != nil {
+			return ref
+		}
+		return base.ResolveReference(u).String()
+	}
+
+	links := make([]Link, len(r.Links))
+	for i, l := range r.Links {
+		params := make(map[string]string, len(l.Params)+1)
+		for k, v := range l.Params {
+			params[k] = v
+		}
+		params["anchor"] = strings.TrimSuffix(base.String(), "/")
+		if anchor := l.Params["anchor"]; anchor != "" {
+			params["anchor"] = resolve(anchor)
+		}
+		links[i] = Link{Target: resolve(l.Target), Params: params}
+	}
+	return links
+}
+
+// Server is an in-process Resource Directory (RFC 9176).
+//
+// It serves the registration interface at RegistrationPath, the lookup
+// interfaces at EndpointLookupPath and ResourceLookupPath and announces
+// them at WellKnownCorePath. Registrations are removed when their
+// lifetime expired.
+type Server struct {
+	mu     sync.Mutex
+	regs   map[string]*Registration // by location
+	lastID int
+
+	now func() time.Time
+}
+
+func NewServer() *Server {
+	return &Server{
+		regs: map[string]*Registration{},
+	}
+}
+
+func (s *Server) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// Registrations returns a copy of all registrations that did not
+// expire, ordered by registration time.
+func (s *Server) Registrations() []Registration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+
+	regs := make([]Registration, 0, len(s.regs))
+	for _, r := range s.sorted() {
+		regs = append(regs, *r)
+	}
+	return regs
+}
+
+// Expire removes all registrations with an expired lifetime.
+// Expired registrations are also removed on every request.
+func (s *Server) Expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+}
+
+func (s *Server) expire() {
+	now := s.clock()
+	for loc, r := range s.regs {
+		if !now.Before(r.Expires) {
+			log.WithField("ep", r.Endpoint).WithField("location", loc).Info("Registration expired")
+			delete(s.regs, loc)
+		}
+	}
+}
+
+// sorted returns the registrations ordered by registration time
+func (s *Server) sorted() []*Registration {
+	regs := make([]*Registration, 0, len(s.regs))
+	for _, r := range s.regs {
+		regs = append(regs, r)
+	}
+	sort.Slice(regs, func(i, j int) bool {
+		return registrationID(regs[i]) < registrationID(regs[j])
+	})
+	return regs
+}
+
+func registrationID(r *Registration) int {
+	id, _ := strconv.Atoi(strings.TrimPrefix(r.Location, RegistrationPath+"/"))
+	return id
+}
+
+func (s *Server) ServeCOAP(w coap.ResponseWriter, r *coap.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+
+	path := r.URL.Path
+	switch {
+	case path == RegistrationPath:
+		if r.Method != "POST" {
+			w.WriteCode(coapmsg.MethodNotAllowed)
+			return
+		}
+		s.register(w, r)
+	case strings.HasPrefix(path, RegistrationPath+"/"):
+		s.serveRegistration(w, r)
+	case path == EndpointLookupPath || path == ResourceLookupPath || path == WellKnownCorePath:
+		if r.Method != "GET" {
+			w.WriteCode(coapmsg.MethodNotAllowed)
+			return
+		}
+		s.lookup(w, r)
+	default:
+		w.WriteCode(coapmsg.NotFound)
+	}
+}
+
+// register handles the registration interface (RFC 9176, Section 5.3)
+func (s *Server) register(w coap.ResponseWriter, r *coap.Request) {
+	if cf := r.Options.Get(coapmsg.ContentFormat); cf.IsSet() && cf.AsMediaType() != coapmsg.AppLinkFormat {
+		w.WriteCode(coapmsg.UnsupportedMediaType)
+		return
+	}
+	params := queryParams(r.Options)
+	if params["ep"] == "" {
+		w.WriteCode(coapmsg.BadRequest)
+		return
+	}
+	lt, ok := lifetime(params)
+	if !ok {
+		w.WriteCode(coapmsg.BadRequest)
+		return
+	}
+	base := params["base"]
+	if base == "" {
+		if base, ok = remoteBase(r.RemoteAddr); !ok {
+			w.WriteCode(coapmsg.BadRequest)
+			return
+		}
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteCode(coapmsg.BadRequest)
+		return
+	}
+	links, err := ParseLinks(string(body))
+	if err != nil {
+		w.WriteCode(coapmsg.BadRequest)
+		return
+	}
+
+	reg := &Registration{
+		Endpoint:     params["ep"],
+		Sector:       params["d"],
+		Base:         base,
+		EndpointType: params["et"],
+		Lifetime:     lt,
+		Expires:      s.clock().Add(lt),
+		Links:        links,
+		Params:       map[string]string{},
+	}
+	for name, value := range params {
+		if _, ok := reg.attr(name); !ok && name != "lt" {
+			reg.Params[name] = value
+		}
+	}
+	// A registration with the same endpoint name and sector replaces
+	// the existing registration
+	for loc, old := range s.regs {
+		if old.Endpoint == reg.Endpoint && old.Sector == reg.Sector {
+			reg.Location = loc
+		}
+	}
+	if reg.Location == "" {
+		s.lastID++
+		reg.Location = RegistrationPath + "/" + strconv.Itoa(s.lastID)
+	}
+	s.regs[reg.Location] = reg
+	log.WithField("ep", reg.Endpoint).WithField("location", reg.Location).Info("Endpoint registered")
+
+	for _, p := range strings.Split(strings.TrimPrefix(reg.Location, "/"), "/") {
+		w.Options().Add(coapmsg.LocationPath, p)
+	}
+	w.WriteCode(coapmsg.Created)
+}
+
+// serveRegistration handles requests to a registration resource:
+// POST updates the registration, DELETE removes it and GET returns
+// the registered links (RFC 9176, Section 5.3.1 - 5.3.3).
+func (s *Server) serveRegistration(w coap.ResponseWriter, r *coap.Request) {
+	reg := s.regs[r.URL.Path]
+	if reg == nil {
+		w.WriteCode(coapmsg.NotFound)
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		params := queryParams(r.Options)
+		if _, ok := params["lt"]; ok {
+			lt, ok := lifetime(params)
+			if !ok {
+				w.WriteCode(coapmsg.BadRequest)
+				return
+			}
+			reg.Lifetime = lt
+		}
+		if base := params["base"]; base != "" {
+			reg.Base = base
+		}
+		// Updates with payload replace the links, as used by LwM2M
+		// clients when their objects changed
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteCode(coapmsg.BadRequest)
+			return
+		}
+		if len(body) > 0 {
+			links, err := ParseLinks(string(body))
+			if err != nil {
+				w.WriteCode(coapmsg.BadRequest)
+				return
+			}
+			reg.Links = links
+		}
+		reg.Expires = s.clock().Add(reg.Lifetime)
+		w.WriteCode(coapmsg.Changed)
+	case "DELETE":
+		delete(s.regs, reg.Location)
+		log.WithField("ep", reg.Endpoint).WithField("location", reg.Location).Info("Endpoint deregistered")
+		w.WriteCode(coapmsg.Deleted)
+	case "GET":
+		w.Options().Set(coapmsg.ContentFormat, coapmsg.AppLinkFormat)
+		w.Write([]byte(FormatLinks(reg.Links)))
+	default:
+		w.WriteCode(coapmsg.MethodNotAllowed)
+	}
+}
+
+// lifetime returns the lt parameter or DefaultLifetime
+func lifetime(params map[string]string) (time.Duration, bool) {
+	lt, ok := params["lt"]
+	if !ok {
+		return DefaultLifetime, true
+	}
+	sec, err := strconv.ParseUint(lt, 10, 32)
+	if err != nil || sec == 0 {
+		return 0, false
+	}
+	return time.Duration(sec) * time.Second, true
+}
+
+// remoteBase returns the base URI of an endpoint registering without the
+// base parameter (RFC 9176, Section 5.3): coap://host:port for UDP
+// endpoints and coap+uart://ttyUSB0 for a device on /dev/ttyUSB0
+func remoteBase(remoteAddr string) (string, bool) {
+	if port := strings.TrimPrefix(remoteAddr, "/dev/"); port != remoteAddr && port != "" {
+		return "coap+uart://" + port, true
+	}
+	if _, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return "coap://" + remoteAddr, true
+	}
+	return "", false
+}
+
+// lookup handles the lookup interfaces (RFC 9176, Section 6) and the
+// discovery of the Resource Directory via /.well-known/core
+func (s *Server) lookup(w coap.ResponseWriter, r *coap.Request) {
+	filters := queryParams(r.Options)
+	page, count, ok := paging(filters)
+	if !ok {
+		w.WriteCode(coapmsg.BadRequest)
+		return
+	}
+
+	var links []Link
+	switch r.URL.Path {
+	case EndpointLookupPath:
+		for _, reg := range s.sorted() {
+			if matchesEndpoint(reg, filters) {
+				links = append(links, reg.link())
+			}
+		}
+	case ResourceLookupPath:
+		for _, reg := range s.sorted() {
+			for _, l := range reg.resourceLinks() {
+				if matchesResource(reg, l, filters) {
+					links = append(links, l)
+				}
+			}
+		}
+	case WellKnownCorePath:
+		for _, l := range discoveryLinks() {
+			if matchesLink(l, filters) {
+				links = append(links, l)
+			}
+		}
+	}
+
+	if count >= 0 {
+		start := page * count
+		if start > len(links) {
+			start = len(links)
+		}
+		end := start + count
+		if end > len(links) {
+			end = len(links)
+		}
+		links = links[start:end]
+	}
+
+	w.Options().Set(coapmsg.ContentFormat, coapmsg.AppLinkFormat)
+	w.Write([]byte(FormatLinks(links)))
+}
+
+// paging removes the page and count parameters from the filters.
+// count is -1 when all results are requested.
+func paging(filters map[string]string) (page, count int, ok bool) {
+	count = -1
+	if c, set := filters["count"]; set {
+		n, err := strconv.ParseUint(c, 10, 31)
+		if err != nil {
+			return 0, 0, false
+		}
+		count = int(n)
+	}
+	if p, set := filters["page"]; set {
+		n, err := strconv.ParseUint(p, 10, 31)
+		if err != nil || count < 0 {
+			return 0, 0, false
+		}
+		page = int(n)
+	}
+	delete(filters, "count")
+	delete(filters, "page")
+	return page, count, true
+}
+
+// matchesEndpoint filters endpoints by their attributes. Filters on
+// other attributes match if any link of the endpoint matches.
+func matchesEndpoint(reg *Registration, filters map[string]string) bool {
+	for name, filter := range filters {
+		if value, ok := reg.attr(name); ok {
+			if !matches(filter, value) {
+				return false
+			}
+			continue
+		}
+		found := false
+		for _, l := range reg.Links {
+			if v, ok := l.Params[name]; ok && matches(filter, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesResource filters resources by link parameters, the resolved
+// target (href) and the attributes of the registering endpoint.
+func matchesResource(reg *Registration, l Link, filters map[string]string) bool {
+	for name, filter := range filters {
+		switch {
+		case name == "href":
+			if !matches(filter, l.Target) {
+				return false
+			}
+		case l.Params[name] != "":
+			if !matches(filter, l.Params[name]) {
+				return false
+			}
+		default:
+			value, ok := reg.attr(name)
+			if !ok || !matches(filter, value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func matchesLink(l Link, filters map[string]string) bool {
+	for name, filter := range filters {
+		value := l.Params[name]
+		if name == "href" {
+			value = l.Target
+		}
+		if !matches(filter, value) {
+			return false
+		}
+	}
+	return true
+}
+
+func discoveryLinks() []Link {
+	ct := strconv.Itoa(int(coapmsg.AppLinkFormat))
+	return []Link{
+		{Target: RegistrationPath, Params: map[string]string{"rt": RegistrationType, "ct": ct}},
+		{Target: EndpointLookupPath, Params: map[string]string{"rt": EndpointLookupType, "ct": ct}},
+		{Target: ResourceLookupPath, Params: map[string]string{"rt": ResourceLookupType, "ct": ct}},
+	}
+}
@@ -0,0 +1,197 @@
+package rd
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trusch/coap-go/coap"
+	"github.com/trusch/coap-go/coap/coaptest"
+	"github.com/trusch/coap-go/coapmsg"
+)
+
+func newTestClient(h coap.Handler) *coap.Client {
+	client := coap.NewClient()
+	client.Transport = &coaptest.HandlerTransport{Handler: h}
+	return client
+}
+
+// fakeClock is a manually advanced clock for lifetime tests
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.t
+}
+
+func get(t *testing.T, client *coap.Client, url string) (coapmsg.COAPCode, string) {
+	res, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	return res.Code, string(body)
+}
+
+func register(t *testing.T, client *coap.Client, query, links string) *coap.Response {
+	res, err := client.Post("coap+uart://rd/rd?"+query, coapmsg.AppLinkFormat, strings.NewReader(links))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestServerRegistration(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	s := NewServer()
+	s.now = clock.Now
+	client := newTestClient(s)
+
+	res := register(t, client, "ep=node1&lt=60&base=coap+uart://ttyUSB0", `</temp>;rt="temperature"`)
+	if res.Code != coapmsg.Created {
+		t.Fatal("Expected 2.01 but got", res.Status)
+	}
+	location, err := locationURL("coap+uart://rd/rd", res.Options)
+	if err != nil || location != "coap+uart://rd/rd/1" {
+		t.Fatal("Unexpected location", location, err)
+	}
+
+	regs := s.Registrations()
+	if len(regs) != 1 || regs[0].Endpoint != "node1" || regs[0].Lifetime != time.Minute || len(regs[0].Links) != 1 {
+		t.Fatal("Unexpected registrations", regs)
+	}
+
+	// Same endpoint name replaces the registration
+	res = register(t, client, "ep=node1&base=coap+uart://ttyUSB1", `</temp>,</hum>`)
+	if loc, _ := locationURL("coap+uart://rd/rd", res.Options); loc != location {
+		t.Error("Expected registration to be replaced but got", loc)
+	}
+	if regs := s.Registrations(); len(regs) != 1 || regs[0].Lifetime != DefaultLifetime || len(regs[0].Links) != 2 {
+		t.Error("Unexpected registrations", regs)
+	}
+
+	if code, links := get(t, client, location); code != coapmsg.Content || links != "</temp>,</hum>" {
+		t.Error("Unexpected registration resource", code.Dotted(), links)
+	}
+
+	// Missing ep and wrong content format
+	if res := register(t, client, "base=coap://x", ""); res.Code != coapmsg.BadRequest {
+		t.Error("Expected 4.00 but got", res.Status)
+	}
+	res, err = client.Post("coap+uart://rd/rd?ep=x&base=coap://x", coapmsg.AppJSON, strings.NewReader("{}"))
+	if err != nil || res.Code != coapmsg.UnsupportedMediaType {
+		t.Error("Expected 4.15 but got", res.Status, err)
+	}
+
+	req, _ := coap.NewRequest("DELETE", location, nil)
+	if res, err := client.Do(req); err != nil || res.Code != coapmsg.Deleted {
+		t.Error("Expected 2.02", err)
+	}
+	if regs := s.Registrations(); len(regs) != 0 {
+		t.Error("Expected registration to be removed", regs)
+	}
+	if code, _ := get(t, client, location); code != coapmsg.NotFound {
+		t.Error("Expected 4.04 but got", code.Dotted())
+	}
+}
+
+func TestServerRegistrationWithoutBase(t *testing.T) {
+	s := NewServer()
+	for _, test := range []struct {
+		remoteAddr string
+		base       string
+	}{
+		{"192.0.2.1:5683", "coap://192.0.2.1:5683"},
+		{"[2001:db8::1]:5683", "coap://[2001:db8::1]:5683"},
+		{"/dev/ttyUSB3", "coap+uart://ttyUSB3"},
+	} {
+		client := coap.NewClient()
+		client.Transport = &coaptest.HandlerTransport{Handler: s, RemoteAddr: test.remoteAddr}
+		if res := register(t, client, "ep=node1", ""); res.Code != coapmsg.Created {
+			t.Fatal("Expected 2.01 but got", res.Status)
+		}
+		if regs := s.Registrations(); len(regs) != 1 || regs[0].Base != test.base {
+			t.Errorf("Expected base %s for %s but got %v", test.base, test.remoteAddr, regs)
+		}
+	}
+
+	// The base of an unknown endpoint can not be derived
+	client := coap.NewClient()
+	client.Transport = &coaptest.HandlerTransport{Handler: s, RemoteAddr: "/dev/"}
+	if res := register(t, client, "ep=node2", ""); res.Code != coapmsg.BadRequest {
+		t.Error("Expected 4.00 but got", res.Status)
+	}
+}
+
+func TestServerLifetimeExpiry(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	s := NewServer()
+	s.now = clock.Now
+	client := newTestClient(s)
+
+	register(t, client, "ep=node1&lt=60&base=coap://a", "")
+	register(t, client, "ep=node2&lt=60&base=coap://b", "")
+
+	clock.t = clock.t.Add(59 * time.Second)
+	req, _ := coap.NewRequest("POST", "coap+uart://rd/rd/1", nil)
+	if res, err := client.Do(req); err != nil || res.Code != coapmsg.Changed {
+		t.Fatal("Expected 2.04", err)
+	}
+
+	clock.t = clock.t.Add(2 * time.Second)
+	regs := s.Registrations()
+	if len(regs) != 1 || regs[0].Endpoint != "node1" {
+		t.Fatal("Expected node2 to expire", regs)
+	}
+
+	clock.t = clock.t.Add(time.Minute)
+	s.Expire()
+	if regs := s.Registrations(); len(regs) != 0 {
+		t.Error("Expected all registrations to expire", regs)
+	}
+	req, _ = coap.NewRequest("POST", "coap+uart://rd/rd/1", nil)
+	if res, err := client.Do(req); err != nil || res.Code != coapmsg.NotFound {
+		t.Error("Expected 4.04 for update of expired registration", err)
+	}
+}
+
+func TestServerLookup(t *testing.T) {
+	s := NewServer()
+	client := newTestClient(s)
+
+	register(t, client, "ep=node1&d=floor1&et=sensor&base=coap+uart://ttyUSB0", `</temp>;rt="temperature";ct=0,</hum>;rt="humidity"`)
+	register(t, client, "ep=node2&d=floor2&base=coap+uart://ttyUSB1", `</temp>;rt="temperature",</light>;rt="light-lux";anchor="/lights"`)
+	register(t, client, "ep=gw&base=coap+uart://ttyUSB2", `</rd>;rt="core.rd"`)
+
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"/rd-lookup/ep?d=floor1", `</rd/1>;base="coap+uart://ttyUSB0";d="floor1";ep="node1";et="sensor";rt="core.rd-ep"`},
+		{"/rd-lookup/ep?ep=node*&rt=light*", `</rd/2>;base="coap+uart://ttyUSB1";d="floor2";ep="node2";rt="core.rd-ep"`},
+		{"/rd-lookup/ep?ep=unknown", ``},
+		{"/rd-lookup/res?rt=temperature", `<coap+uart://ttyUSB0/temp>;anchor="coap+uart://ttyUSB0";ct=0;rt="temperature",` +
+			`<coap+uart://ttyUSB1/temp>;anchor="coap+uart://ttyUSB1";rt="temperature"`},
+		{"/rd-lookup/res?ep=node2&href=coap+uart://ttyUSB1/l*", `<coap+uart://ttyUSB1/light>;anchor="coap+uart://ttyUSB1/lights";rt="light-lux"`},
+		{"/rd-lookup/res?count=2", `<coap+uart://ttyUSB0/temp>;anchor="coap+uart://ttyUSB0";ct=0;rt="temperature",` +
+			`<coap+uart://ttyUSB0/hum>;anchor="coap+uart://ttyUSB0";rt="humidity"`},
+		{"/rd-lookup/res?page=2&count=2", `<coap+uart://ttyUSB2/rd>;anchor="coap+uart://ttyUSB2";rt="core.rd"`},
+		{"/rd-lookup/res?page=3&count=2", ``},
+		{"/.well-known/core?rt=core.rd*", `</rd>;ct=40;rt="core.rd",</rd-lookup/ep>;ct=40;rt="core.rd-lookup-ep",</rd-lookup/res>;ct=40;rt="core.rd-lookup-res"`},
+	}
+	for _, test := range tests {
+		code, body := get(t, client, "coap+uart://rd"+test.url)
+		if code != coapmsg.Content {
+			t.Errorf("%s: Expected 2.05 but got %s", test.url, code.Dotted())
+		}
+		if body != test.expected {
+			t.Errorf("%s:\n got: %s\nwant: %s", test.url, body, test.expected)
+		}
+	}
+
+	if code, _ := get(t, client, "coap+uart://rd/rd-lookup/res?page=1"); code != coapmsg.BadRequest {
+		t.Error("Expected 4.00 for page without count but got", code.Dotted())
+	}
+}